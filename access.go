@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// requestInfo collects what the proxy did for an inbound request, for its
+// access log line.
+type requestInfo struct {
+	breaker  string
+	state    string
+	attempts int
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the requestInfo of the request ctx belongs to, or
+// nil if it is not access logged.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// accessLog logs a line per inbound request handled, or a sample of them.
+type accessLog struct {
+	mu  sync.Mutex
+	cfg LogConfig
+}
+
+func newAccessLog(cfg LogConfig) *accessLog {
+	return &accessLog{cfg: cfg}
+}
+
+// configure applies the access log settings of cfg.
+func (l *accessLog) configure(cfg LogConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
+
+// sampled reports whether a request answered with status is logged.
+// Server errors always are.
+func (l *accessLog) sampled(status int) bool {
+	l.mu.Lock()
+	cfg := l.cfg
+	l.mu.Unlock()
+	if !cfg.Access {
+		return false
+	}
+	return status >= http.StatusInternalServerError || cfg.AccessSample >= 1 || rand.Float64() < cfg.AccessSample
+}
+
+// handler logs the requests handled by next with their method, path,
+// status and duration, and the breaker they went through with its state
+// when they were handled and the retries they took.
+func (l *accessLog) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := clock.Now()
+		info := &requestInfo{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		if !l.sampled(rec.status) {
+			return
+		}
+
+		attrs := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", clock.Now().Sub(start),
+			"request_id", requestIDFrom(r.Context()),
+		}
+		if info.breaker != "" {
+			attrs = append(attrs, "breaker", info.breaker, "state", info.state, "retries", max(info.attempts-1, 0))
+		}
+		slog.Info("Request handled", attrs...)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(LogConfig{Format: LogText}, &buf))
+
+	target, _ := url.Parse("http://upstream.invalid")
+	b := newBreaker(BreakerConfig{Name: "Access Test", Timeout: time.Minute, ConsecutiveFailures: 5},
+		RetryConfig{Attempts: 3, Backoff: BackoffConstant, BackoffMin: time.Millisecond, BackoffMax: time.Millisecond})
+	calls := 0
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("simulated failure")
+		}
+		return &http.Response{StatusCode: http.StatusCreated, Body: http.NoBody}, nil
+	}
+	access := newAccessLog(LogConfig{Access: true, AccessSample: 1})
+	h := access.handler(http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil)))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+	want := `msg="Request handled" method=GET path=/api/orders status=201 duration=`
+	got := buf.String()
+	if !strings.Contains(got, want) || !strings.Contains(got, `breaker="Access Test" state=closed retries=2`) {
+		t.Fatalf("expected an access log line with the retries, got %q", got)
+	}
+
+	// Requests turned away before the proxy have no breaker
+	buf.Reset()
+	access.handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/none", nil))
+	if got := buf.String(); !strings.Contains(got, "status=404") || strings.Contains(got, "breaker=") {
+		t.Fatalf("expected an access log line without a breaker, got %q", got)
+	}
+
+	// Sampled out requests are not logged, unless they failed
+	buf.Reset()
+	access.configure(LogConfig{Access: true, AccessSample: 0})
+	failing := access.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	access.handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+	failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+	if got := buf.String(); strings.Count(got, "Request handled") != 1 || !strings.Contains(got, "status=502") {
+		t.Fatalf("expected only the failed request to be logged, got %q", got)
+	}
+
+	buf.Reset()
+	access.configure(LogConfig{Access: false, AccessSample: 1})
+	failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+	if buf.Len() != 0 {
+		t.Fatalf("expected no access log once disabled, got %q", buf.String())
+	}
+}
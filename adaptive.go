@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// baselineAlpha weighs each call in the slowly learned baseline.
+	baselineAlpha = 0.01
+	// recentAlpha weighs each call in the quickly moving recent average.
+	recentAlpha = 0.2
+)
+
+// adaptiveBaseline tracks exponentially weighted moving averages of the
+// error rate and latency of calls: a slow one learning the upstream's usual
+// behaviour and a fast one following its current behaviour.
+type adaptiveBaseline struct {
+	mu    sync.Mutex
+	stats adaptiveStats
+}
+
+// adaptiveStats is a snapshot of an adaptiveBaseline. Latencies are in
+// seconds.
+type adaptiveStats struct {
+	Calls             uint64
+	BaselineErrorRate float64
+	BaselineLatency   float64
+	RecentErrorRate   float64
+	RecentLatency     float64
+}
+
+func (a *adaptiveBaseline) record(success bool, latency time.Duration) {
+	failure := 1.0
+	if success {
+		failure = 0
+	}
+	seconds := latency.Seconds()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := &a.stats
+	if s.Calls == 0 {
+		s.BaselineErrorRate, s.RecentErrorRate = failure, failure
+		s.BaselineLatency, s.RecentLatency = seconds, seconds
+	}
+	s.Calls++
+	s.BaselineErrorRate += baselineAlpha * (failure - s.BaselineErrorRate)
+	s.BaselineLatency += baselineAlpha * (seconds - s.BaselineLatency)
+	s.RecentErrorRate += recentAlpha * (failure - s.RecentErrorRate)
+	s.RecentLatency += recentAlpha * (seconds - s.RecentLatency)
+}
+
+func (a *adaptiveBaseline) snapshot() adaptiveStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stats
+}
+
+// settle makes the recent averages start again from the baseline, so the
+// deviation that opened the breaker does not trip it again once closed.
+func (a *adaptiveBaseline) settle() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats.RecentErrorRate = a.stats.BaselineErrorRate
+	a.stats.RecentLatency = a.stats.BaselineLatency
+}
+
+// adaptiveTrip decides from s whether the upstream deviates enough from its
+// baseline for the breaker to open: its recent error rate exceeds the
+// baseline by AdaptiveMargin, or its recent latency is AdaptiveLatencyFactor
+// times the baseline, once at least MinRequests calls were seen.
+func adaptiveTrip(cfg BreakerConfig, s adaptiveStats) bool {
+	if s.Calls < uint64(cfg.MinRequests) {
+		return false
+	}
+	if s.RecentErrorRate-s.BaselineErrorRate > cfg.AdaptiveMargin {
+		return true
+	}
+	return cfg.AdaptiveLatencyFactor > 0 && s.BaselineLatency > 0 &&
+		s.RecentLatency > s.BaselineLatency*cfg.AdaptiveLatencyFactor
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestAdaptiveTrip(t *testing.T) {
+	cfg := BreakerConfig{MinRequests: 10, AdaptiveMargin: 0.2, AdaptiveLatencyFactor: 3}
+	for _, tc := range []struct {
+		name  string
+		stats adaptiveStats
+		trip  bool
+	}{
+		{"WarmingUp", adaptiveStats{Calls: 9, RecentErrorRate: 1}, false},
+		{"Usual", adaptiveStats{Calls: 100, BaselineErrorRate: 0.1, RecentErrorRate: 0.25, BaselineLatency: 0.1, RecentLatency: 0.2}, false},
+		{"Errors", adaptiveStats{Calls: 100, BaselineErrorRate: 0.1, RecentErrorRate: 0.35, BaselineLatency: 0.1, RecentLatency: 0.1}, true},
+		{"Latency", adaptiveStats{Calls: 100, BaselineLatency: 0.1, RecentLatency: 0.35}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := adaptiveTrip(cfg, tc.stats); got != tc.trip {
+				t.Fatalf("expected trip %v for %+v, got %v", tc.trip, tc.stats, got)
+			}
+		})
+	}
+}
+
+func TestAdaptiveBreaker(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:           "Adaptive Test",
+		Timeout:        time.Minute,
+		Trip:           TripAdaptive,
+		MinRequests:    20,
+		AdaptiveMargin: 0.3,
+	}, RetryConfig{})
+	succeed := func() (interface{}, error) { return nil, nil }
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	// A steady 10% error rate is learned as normal
+	for i := 0; i < 200; i++ {
+		if i%10 == 0 {
+			b.execute(b.current(), fail)
+		} else {
+			b.execute(b.current(), succeed)
+		}
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the usual error rate not to trip the breaker, got %s", state)
+	}
+
+	for i := 0; i < 5; i++ {
+		b.execute(b.current(), fail)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected a burst of errors to trip the breaker, got %s", state)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// registerAdminHandlers adds the operator endpoints controlling b to mux,
+// recording their use in audit, along with the endpoint querying it.
+func registerAdminHandlers(mux *http.ServeMux, b *breaker, audit *auditLog) {
+	mux.HandleFunc("POST /admin/breaker/open", func(w http.ResponseWriter, r *http.Request) {
+		from := auditState(b)
+		b.forceOpen()
+		audit.recordBreaker(requestActor(r), "force_open", b, from)
+		slog.Info("Circuit breaker forced open", "breaker", b.current().cfg.Name)
+		w.Write([]byte("Circuit breaker forced open\n"))
+	})
+	mux.HandleFunc("POST /admin/breaker/close", func(w http.ResponseWriter, r *http.Request) {
+		from := auditState(b)
+		b.forceClose()
+		audit.recordBreaker(requestActor(r), "force_close", b, from)
+		slog.Info("Circuit breaker forced closed", "breaker", b.current().cfg.Name)
+		w.Write([]byte("Circuit breaker forced closed\n"))
+	})
+	mux.HandleFunc("POST /admin/breaker/reset", func(w http.ResponseWriter, r *http.Request) {
+		from := auditState(b)
+		b.reset()
+		audit.recordBreaker(requestActor(r), "reset", b, from)
+		slog.Info("Circuit breaker reset", "breaker", b.current().cfg.Name)
+		w.Write([]byte("Circuit breaker reset\n"))
+	})
+	mux.Handle("GET /admin/audit", audit.handler())
+}
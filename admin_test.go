@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestAdminHandlers(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Admin Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+
+	mux := http.NewServeMux()
+	registerAdminHandlers(mux, b, nil)
+
+	post := func(t *testing.T, path string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d from %s, got %d", http.StatusOK, path, rec.Code)
+		}
+	}
+	succeed := func() (interface{}, error) { return "ok", nil }
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	t.Run("ForceOpen", func(t *testing.T) {
+		post(t, "/admin/breaker/open")
+
+		called := false
+		_, err := b.execute(b.current(), func() (interface{}, error) {
+			called = true
+			return nil, nil
+		})
+		if err != gobreaker.ErrOpenState {
+			t.Fatalf("expected %v, got %v", gobreaker.ErrOpenState, err)
+		}
+		if called {
+			t.Fatalf("expected call to be rejected without running")
+		}
+	})
+
+	t.Run("ForceClose", func(t *testing.T) {
+		post(t, "/admin/breaker/close")
+
+		for i := 0; i < 3; i++ {
+			b.execute(b.current(), fail)
+		}
+		if _, err := b.execute(b.current(), succeed); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if state := b.current().cb.State(); state != gobreaker.StateClosed {
+			t.Fatalf("expected failures not to trip a forced closed breaker, got %v", state)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		post(t, "/admin/breaker/reset")
+
+		// Without an override the breaker trips again
+		for i := 0; i < 2; i++ {
+			b.execute(b.current(), fail)
+		}
+		if state := b.current().cb.State(); state != gobreaker.StateOpen {
+			t.Fatalf("expected circuit breaker to be open, got %v", state)
+		}
+
+		post(t, "/admin/breaker/reset")
+		if state := b.current().cb.State(); state != gobreaker.StateClosed {
+			t.Fatalf("expected circuit breaker to be closed after reset, got %v", state)
+		}
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/breaker/open", nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+		}
+	})
+}
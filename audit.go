@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/peer"
+	"gopkg.in/yaml.v3"
+)
+
+// auditKept is how many of the latest audit entries are kept in memory to
+// be queried.
+const auditKept = 1000
+
+// auditEntry records an operator action: forcing a breaker open or closed,
+// resetting it or reloading the config. From and To are the breaker's
+// state, or the digest of the config, before and after the action.
+type auditEntry struct {
+	Time    time.Time `json:"timestamp"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Breaker string    `json:"breaker,omitempty"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// auditLog appends operator actions as JSON lines to a file, or to stdout,
+// and keeps the latest of them to be queried. A nil auditLog records
+// nothing.
+type auditLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	entries []auditEntry
+}
+
+// newAuditLog returns an audit log appending to cfg.File, or to stdout when
+// it is not set. The entries already in the file can be queried too.
+func newAuditLog(cfg AuditConfig) (*auditLog, error) {
+	if cfg.File == "" {
+		return &auditLog{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(cfg.File, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	a := &auditLog{w: f}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			a.keep(e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading audit log: %w", err)
+	}
+	return a, nil
+}
+
+func (a *auditLog) keep(e auditEntry) {
+	if len(a.entries) == auditKept {
+		a.entries = append(a.entries[:0], a.entries[1:]...)
+	}
+	a.entries = append(a.entries, e)
+}
+
+// record appends e, timestamping it.
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+	e.Time = clock.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keep(e)
+	if _, err := a.w.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write audit log", "action", e.Action, "actor", e.Actor, "error", err)
+	}
+}
+
+// recordBreaker records action, done by actor, on b, which changed its
+// state from the one given.
+func (a *auditLog) recordBreaker(actor, action string, b *breaker, from string) {
+	a.record(auditEntry{Actor: actor, Action: action, Breaker: b.current().cfg.Name, From: from, To: auditState(b)})
+}
+
+// query returns up to limit of the latest entries, oldest first, keeping
+// those whose actor, action and breaker match the ones given when not
+// empty.
+func (a *auditLog) query(limit int, actor, action, breaker string) []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var entries []auditEntry
+	for i := len(a.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := a.entries[i]
+		if (actor == "" || e.Actor == actor) && (action == "" || e.Action == action) && (breaker == "" || e.Breaker == breaker) {
+			entries = append(entries, e)
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// handler serves the latest entries as JSON, as many as the limit query
+// parameter asks for, filtered by the actor, action and breaker ones.
+func (a *auditLog) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := historyDefaultLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		entries := []auditEntry{}
+		if a != nil {
+			entries = append(entries, a.query(limit, q.Get("actor"), q.Get("action"), q.Get("breaker"))...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Entries []auditEntry `json:"entries"`
+		}{entries})
+	}
+}
+
+// auditState describes the state of b for the audit log, naming manual
+// overrides.
+func auditState(b *breaker) string {
+	if o := override(b.override.Load()); o != overrideNone {
+		return "forced " + o.String()
+	}
+	return b.current().cb.State().String()
+}
+
+// configDigest identifies cfg in the audit log without revealing the
+// secrets it may hold.
+func configDigest(cfg Config) string {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+type actorKey struct{}
+
+// withActor returns ctx carrying the identity of the operator acting.
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// requestActor names the operator making r: the subject of their token,
+// or their address when they have none.
+func requestActor(r *http.Request) string {
+	if actor, _ := r.Context().Value(actorKey{}).(string); actor != "" {
+		return actor
+	}
+	return clientIP(r, 0)
+}
+
+// grpcActor is requestActor for gRPC calls.
+func grpcActor(ctx context.Context) string {
+	if actor, _ := ctx.Value(actorKey{}).(string); actor != "" {
+		return actor
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return "unknown"
+}
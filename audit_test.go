@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(AuditConfig{File: path})
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	b := newBreaker(BreakerConfig{
+		Name:                "Audit Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+
+	mux := http.NewServeMux()
+	registerAdminHandlers(mux, b, audit)
+	v := &jwtVerifier{secret: []byte("secret")}
+	h := v.handler(mux)
+	token := signJWT(t, "HS256", map[string]interface{}{"sub": "alice"}, hs256("secret"))
+
+	post := func(path string) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	post("/admin/breaker/open")
+	post("/admin/breaker/reset")
+
+	// Entries are read back from the file on restart
+	audit, err = newAuditLog(AuditConfig{File: path})
+	if err != nil {
+		t.Fatalf("failed to reopen audit log: %v", err)
+	}
+	mux = http.NewServeMux()
+	registerAdminHandlers(mux, b, audit)
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit?actor=alice", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var body struct {
+		Entries []auditEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode audit log: %v", err)
+	}
+	if len(body.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", body.Entries)
+	}
+	open, reset := body.Entries[0], body.Entries[1]
+	if open.Action != "force_open" || open.Breaker != "Audit Test" || open.From != "closed" || open.To != "forced open" {
+		t.Fatalf("expected the breaker to be forced open by alice, got %+v", open)
+	}
+	if reset.Action != "reset" || reset.From != "forced open" || reset.To != "closed" {
+		t.Fatalf("expected the breaker to be reset by alice, got %+v", reset)
+	}
+
+	if got := audit.query(10, "", "reset", ""); len(got) != 1 {
+		t.Fatalf("expected 1 reset, got %+v", got)
+	}
+	if got := audit.query(10, "bob", "", ""); len(got) != 0 {
+		t.Fatalf("expected no entries by bob, got %+v", got)
+	}
+}
+
+func TestAuditActor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/admin/breaker/open", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if got := requestActor(req); got != "192.0.2.1" {
+		t.Fatalf("expected the client address without a token, got %q", got)
+	}
+	if got := requestActor(req.WithContext(withActor(req.Context(), "alice"))); got != "alice" {
+		t.Fatalf("expected the token's subject, got %q", got)
+	}
+}
+
+func TestConfigDigest(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Routes = []RouteConfig{{Path: "/api/users", Upstream: "https://users.example.com"}}
+	digest := configDigest(cfg)
+	if digest == "" || configDigest(cfg) != digest {
+		t.Fatalf("expected a stable digest, got %q", digest)
+	}
+	cfg.Breaker.ConsecutiveFailures++
+	if configDigest(cfg) == digest {
+		t.Fatalf("expected the digest to change with the config")
+	}
+}
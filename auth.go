@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultAPIKeyHeader is the header carrying API keys unless configured
+// otherwise.
+const defaultAPIKeyHeader = "X-API-Key"
+
+// apiKeys authenticates /api requests by the API key they carry, before
+// they take up any breaker, bulkhead or retry capacity. Keys are kept as
+// digests so looking one up takes the same time whatever it matches.
+type apiKeys struct {
+	name   string
+	header atomic.Value
+	keys   atomic.Pointer[map[[sha256.Size]byte]struct{}]
+}
+
+func newAPIKeys(cfg AuthConfig, name string) (*apiKeys, error) {
+	k := &apiKeys{name: name}
+	if err := k.configure(cfg); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// configure swaps in the keys of cfg, reading its KeysFile. The current
+// keys are kept if that fails.
+func (k *apiKeys) configure(cfg AuthConfig) error {
+	raw := slices.Clone(cfg.Keys)
+	if cfg.KeysFile != "" {
+		data, err := os.ReadFile(cfg.KeysFile)
+		if err != nil {
+			return fmt.Errorf("reading API keys: %w", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				raw = append(raw, line)
+			}
+		}
+	}
+	keys := make(map[[sha256.Size]byte]struct{}, len(raw))
+	for _, key := range raw {
+		keys[sha256.Sum256([]byte(key))] = struct{}{}
+	}
+	k.header.Store(cfg.Header)
+	k.keys.Store(&keys)
+	return nil
+}
+
+// apiKey is the API key a request carries, and the header it came in.
+type apiKey struct {
+	header string
+	value  string
+}
+
+type apiKeyKey struct{}
+
+// requestAPIKey returns the API key the request of ctx was let through
+// with, which the proxy does not forward upstream. Requests carry none
+// unless keys are checked, their keys being left to the upstream.
+func requestAPIKey(ctx context.Context) (apiKey, bool) {
+	key, ok := ctx.Value(apiKeyKey{}).(apiKey)
+	return key, ok
+}
+
+// handler answers requests without a valid key with 401 Unauthorized when
+// any key is configured, and passes the others to next, with their key
+// recorded in their context when checked.
+func (k *apiKeys) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := k.header.Load().(string)
+		value := r.Header.Get(header)
+		keys := *k.keys.Load()
+		if len(keys) > 0 {
+			if _, ok := keys[sha256.Sum256([]byte(value))]; !ok {
+				observeUnauthorized(k.name)
+				w.Header().Set("WWW-Authenticate", "ApiKey header="+header)
+				http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, apiKey{header: header, value: value}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
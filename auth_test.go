@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(file, []byte("# ops team\nfile-key\n\n"), 0o600); err != nil {
+		t.Fatalf("failed to write keys file: %v", err)
+	}
+	keys, err := newAPIKeys(AuthConfig{Header: "X-API-Key", Keys: []string{"config-key"}, KeysFile: file}, "Auth Test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	reached := 0
+	h := keys.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+	}))
+	call := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, key := range []string{"config-key", "file-key"} {
+		if code := call(key); code != http.StatusOK {
+			t.Fatalf("expected key %q to be accepted, got status %d", key, code)
+		}
+	}
+	for _, key := range []string{"", "wrong", "# ops team"} {
+		if code := call(key); code != http.StatusUnauthorized {
+			t.Fatalf("expected key %q to be refused, got status %d", key, code)
+		}
+	}
+	if reached != 2 {
+		t.Fatalf("expected only authenticated requests to reach the proxy, got %d", reached)
+	}
+
+	// Without keys every request goes through
+	if err := keys.configure(AuthConfig{Header: "X-API-Key"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if code := call(""); code != http.StatusOK {
+		t.Fatalf("expected requests to go through without keys, got status %d", code)
+	}
+
+	if err := keys.configure(AuthConfig{KeysFile: filepath.Join(t.TempDir(), "absent")}); err == nil {
+		t.Fatalf("expected error for a missing keys file, got none")
+	}
+}
+
+func TestAPIKeyNotForwarded(t *testing.T) {
+	keys, err := newAPIKeys(AuthConfig{Header: "X-Gateway-Key", Keys: []string{"gateway-key"}}, "Key Forwarding Test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var forwarded []string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		forwarded = append(forwarded, req.Header.Get("X-Gateway-Key"))
+		if shareable(req) {
+			t.Errorf("expected a request with an API key not to be shareable")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	target, _ := url.Parse("http://upstream.invalid/api")
+	b := newBreaker(BreakerConfig{Name: "Key Forwarding Test", Timeout: time.Minute}, RetryConfig{Attempts: 1})
+	h := keys.handler(http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+	req.Header.Set("X-Gateway-Key", "gateway-key")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the request to be proxied, got status %d", rec.Code)
+	}
+	if len(forwarded) != 1 || forwarded[0] != "" {
+		t.Fatalf("expected the API key not to be forwarded upstream, got %q", forwarded)
+	}
+}
+
+func TestAPIKeyLeftToUpstream(t *testing.T) {
+	keys, err := newAPIKeys(AuthConfig{Header: "X-API-Key"}, "Upstream Key Test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var forwarded string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		forwarded = req.Header.Get("X-API-Key")
+		if shareable(req) {
+			t.Errorf("expected a request with an API key not to be shareable")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	target, _ := url.Parse("http://upstream.invalid/api")
+	b := newBreaker(BreakerConfig{Name: "Upstream Key Test", Timeout: time.Minute}, RetryConfig{Attempts: 1})
+	h := keys.handler(http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil)))
+
+	// Without keys of its own the proxy forwards the key for the upstream
+	// to check
+	req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+	req.Header.Set("X-API-Key", "upstream-key")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || forwarded != "upstream-key" {
+		t.Fatalf("expected the API key to be forwarded, got status %d and %q", rec.Code, forwarded)
+	}
+}
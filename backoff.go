@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff computes the delay to wait before retrying after the given
+// attempt, counting from 0. prev is the delay waited after the previous
+// attempt, or zero after the first one. Implementations must be safe for
+// concurrent use.
+type Backoff interface {
+	Delay(attempt int, prev time.Duration) time.Duration
+}
+
+// BackoffStrategy names a Backoff implementation in the config.
+type BackoffStrategy string
+
+const (
+	BackoffConstant     BackoffStrategy = "constant"
+	BackoffLinear       BackoffStrategy = "linear"
+	BackoffExponential  BackoffStrategy = "exponential"
+	BackoffDecorrelated BackoffStrategy = "decorrelated"
+)
+
+// UnmarshalText rejects unknown strategy names.
+func (s *BackoffStrategy) UnmarshalText(text []byte) error {
+	switch strategy := BackoffStrategy(text); strategy {
+	case BackoffConstant, BackoffLinear, BackoffExponential, BackoffDecorrelated:
+		*s = strategy
+		return nil
+	default:
+		return fmt.Errorf("unknown backoff strategy %q", text)
+	}
+}
+
+// jitterSource is the random source backoff delays are spread with, and
+// the partial-open and slow-start breakers pick the calls they let through
+// with. It is safe for concurrent use.
+type jitterSource struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newJitterSource returns a source seeded with seed, or a random seed when
+// it is zero.
+func newJitterSource(seed int64) *jitterSource {
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	return &jitterSource{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (j *jitterSource) float64() float64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rng.Float64()
+}
+
+func (j *jitterSource) int63n(n int64) int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rng.Int63n(n)
+}
+
+// jitter spreads the backoff delays of every retry, seeded by
+// retry.jitter_seed at startup.
+var jitter = newJitterSource(0)
+
+// newBackoff returns the Backoff selected by cfg, defaulting to exponential,
+// drawing its jitter from rng.
+func newBackoff(cfg RetryConfig, rng *jitterSource) Backoff {
+	switch cfg.Backoff {
+	case BackoffConstant:
+		return constantBackoff{delay: cfg.BackoffMin}
+	case BackoffLinear:
+		return linearBackoff{step: cfg.BackoffMin, max: cfg.BackoffMax}
+	case BackoffDecorrelated:
+		return decorrelatedBackoff{base: cfg.BackoffMin, max: cfg.BackoffMax, rng: rng}
+	default:
+		return exponentialJitterBackoff{min: cfg.BackoffMin, max: cfg.BackoffMax, floor: cfg.JitterFloor, rng: rng}
+	}
+}
+
+// constantBackoff always waits the same delay.
+type constantBackoff struct {
+	delay time.Duration
+}
+
+func (b constantBackoff) Delay(int, time.Duration) time.Duration {
+	return b.delay
+}
+
+// linearBackoff waits one more step after every attempt, up to max.
+type linearBackoff struct {
+	step, max time.Duration
+}
+
+func (b linearBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	return min(b.step*time.Duration(attempt+1), b.max)
+}
+
+// exponentialJitterBackoff waits a random delay between the floor share of
+// an exponentially growing ceiling and the ceiling ("full jitter" when the
+// floor is zero).
+type exponentialJitterBackoff struct {
+	min, max time.Duration
+	floor    float64
+	rng      *jitterSource
+}
+
+func (b exponentialJitterBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	return exponentialBackoff(attempt, b.min, b.max, b.floor, b.rng)
+}
+
+// decorrelatedBackoff waits a random delay between base and three times the
+// previous delay, up to max ("decorrelated jitter").
+type decorrelatedBackoff struct {
+	base, max time.Duration
+	rng       *jitterSource
+}
+
+func (b decorrelatedBackoff) Delay(_ int, prev time.Duration) time.Duration {
+	prev = max(prev, b.base)
+	spread := int64(prev*3 - b.base)
+	if spread <= 0 {
+		return min(b.base, b.max)
+	}
+	return min(b.base+time.Duration(b.rng.int63n(spread)), b.max)
+}
+
+// exponentialBackoff returns a duration with an exponential backoff strategy,
+// drawn from rng between the floor share of the ceiling and the ceiling.
+func exponentialBackoff(attempt int, minDelay, maxDelay time.Duration, floor float64, rng *jitterSource) time.Duration {
+	min := float64(minDelay)
+	max := float64(maxDelay)
+	backoff := min * math.Pow(2, float64(attempt))
+	if backoff > max {
+		backoff = max
+	}
+	floor = math.Min(math.Max(floor, 0), 1)
+	jitter := (floor + (1-floor)*rng.float64()) * backoff
+	return time.Duration(jitter)
+}
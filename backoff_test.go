@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffStrategies(t *testing.T) {
+	cfg := RetryConfig{BackoffMin: 100 * time.Millisecond, BackoffMax: time.Second}
+
+	t.Run("Constant", func(t *testing.T) {
+		cfg.Backoff = BackoffConstant
+		b := newBackoff(cfg, newJitterSource(0))
+		for attempt := 0; attempt < 5; attempt++ {
+			if d := b.Delay(attempt, 0); d != 100*time.Millisecond {
+				t.Fatalf("expected constant delay of 100ms, got %v", d)
+			}
+		}
+	})
+
+	t.Run("Linear", func(t *testing.T) {
+		cfg.Backoff = BackoffLinear
+		b := newBackoff(cfg, newJitterSource(0))
+		for attempt, want := range []time.Duration{100, 200, 300} {
+			if d := b.Delay(attempt, 0); d != want*time.Millisecond {
+				t.Fatalf("expected %v for attempt %d, got %v", want*time.Millisecond, attempt, d)
+			}
+		}
+		if d := b.Delay(50, 0); d != time.Second {
+			t.Fatalf("expected delay capped at 1s, got %v", d)
+		}
+	})
+
+	t.Run("Exponential", func(t *testing.T) {
+		cfg.Backoff = BackoffExponential
+		b := newBackoff(cfg, newJitterSource(0))
+		for attempt := 0; attempt < 10; attempt++ {
+			ceiling := min(100*time.Millisecond<<attempt, time.Second)
+			if d := b.Delay(attempt, 0); d < 0 || d > ceiling {
+				t.Fatalf("expected delay within [0, %v] for attempt %d, got %v", ceiling, attempt, d)
+			}
+		}
+	})
+
+	t.Run("Decorrelated", func(t *testing.T) {
+		cfg.Backoff = BackoffDecorrelated
+		b := newBackoff(cfg, newJitterSource(0))
+		var prev time.Duration
+		for attempt := 0; attempt < 20; attempt++ {
+			d := b.Delay(attempt, prev)
+			upper := min(max(prev, 100*time.Millisecond)*3, time.Second)
+			if d < 100*time.Millisecond || d > upper {
+				t.Fatalf("expected delay within [100ms, %v], got %v", upper, d)
+			}
+			prev = d
+		}
+	})
+}
+
+func TestBackoffJitter(t *testing.T) {
+	cfg := RetryConfig{Backoff: BackoffExponential, BackoffMin: 100 * time.Millisecond, BackoffMax: 10 * time.Second}
+
+	t.Run("Seeded", func(t *testing.T) {
+		for _, strategy := range []BackoffStrategy{BackoffExponential, BackoffDecorrelated} {
+			cfg.Backoff = strategy
+			a, b := newBackoff(cfg, newJitterSource(42)), newBackoff(cfg, newJitterSource(42))
+			var prevA, prevB time.Duration
+			for attempt := 0; attempt < 10; attempt++ {
+				prevA, prevB = a.Delay(attempt, prevA), b.Delay(attempt, prevB)
+				if prevA != prevB {
+					t.Fatalf("expected %s delays from the same seed to match, got %v and %v", strategy, prevA, prevB)
+				}
+			}
+		}
+	})
+
+	t.Run("Floor", func(t *testing.T) {
+		cfg.Backoff = BackoffExponential
+		cfg.JitterFloor = 0.5
+		b := newBackoff(cfg, newJitterSource(0))
+		for i := 0; i < 100; i++ {
+			if d := b.Delay(2, 0); d < 200*time.Millisecond || d > 400*time.Millisecond {
+				t.Fatalf("expected delay within [200ms, 400ms], got %v", d)
+			}
+		}
+
+		cfg.JitterFloor = 1
+		if d := newBackoff(cfg, newJitterSource(0)).Delay(2, 0); d != 400*time.Millisecond {
+			t.Fatalf("expected a full floor to leave no jitter, got %v", d)
+		}
+	})
+}
+
+func TestBackoffStrategyUnmarshal(t *testing.T) {
+	var s BackoffStrategy
+	if err := s.UnmarshalText([]byte("linear")); err != nil || s != BackoffLinear {
+		t.Fatalf("expected linear strategy, got %q (%v)", s, err)
+	}
+	if err := s.UnmarshalText([]byte("fibonacci")); err == nil {
+		t.Fatalf("expected error for unknown strategy, got none")
+	}
+
+	path := writeConfigFile(t, "config.yaml", "retry:\n  backoff: quadratic\n")
+	if _, err := loadConfig(path); err == nil {
+		t.Fatalf("expected unknown strategy in config file to be rejected")
+	}
+
+	cfg := defaultConfig()
+	lookup := func(name string) (string, bool) {
+		if name == "CB_RETRY_BACKOFF" {
+			return "decorrelated", true
+		}
+		return "", false
+	}
+	if err := applyEnv(&cfg, lookup); err != nil || cfg.Retry.Backoff != BackoffDecorrelated {
+		t.Fatalf("expected decorrelated strategy from environment, got %q (%v)", cfg.Retry.Backoff, err)
+	}
+}
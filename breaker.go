@@ -0,0 +1,630 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// breaker is the circuit breaker guarding the upstream together with the
+// retry policy applied to calls made through it. Both can be swapped at
+// runtime with reload; calls already in flight finish with the policy they
+// started with. Operators can override the breaker's state by hand.
+type breaker struct {
+	policy   atomic.Pointer[breakerPolicy]
+	override atomic.Int32
+	// lastTransition is the time of the last state change in Unix nanoseconds.
+	lastTransition atomic.Int64
+	// tripCounts holds the counts last evaluated by ReadyToTrip.
+	tripCounts atomic.Pointer[gobreaker.Counts]
+	// recovered is when the breaker last closed from half-open in Unix
+	// nanoseconds, starting the slow-start ramp.
+	recovered atomic.Int64
+	// latencies feeds the hedge delay.
+	latencies latencyTracker
+	// shared, when set, shares the breaker's state with other replicas.
+	shared atomic.Pointer[sharedState]
+	// peer is the response to the last trip of a peer's breaker of the same
+	// name, if any.
+	peer atomic.Pointer[peerResponse]
+	// window is the maintenance window the breaker is in, if any.
+	window atomic.Pointer[activeWindow]
+	// shadow, when set, evaluates candidate settings on the same calls.
+	shadow atomic.Pointer[shadowBreaker]
+	// metrics reports whether the breaker's state and calls are observed,
+	// and logger, when set, replaces the default logger.
+	metrics bool
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	listeners []func(stateChange)
+	// rejected are called for every call the breaker turns away.
+	rejected []func(rejection)
+}
+
+// stateChange describes a transition of the breaker between two states.
+type stateChange struct {
+	Name string    `json:"name"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Time time.Time `json:"timestamp"`
+	// Counts is a snapshot of the counts that tripped the breaker when
+	// leaving the closed state, and zero otherwise since the circuit clears
+	// them before reporting the transition.
+	Counts breakerCounts `json:"counts"`
+}
+
+// rejection describes a call turned away by the breaker, for the reason
+// named by fallbackReason.
+type rejection struct {
+	Name   string    `json:"name"`
+	State  string    `json:"state"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"timestamp"`
+}
+
+// override is a manual override of the breaker state.
+type override int32
+
+const (
+	overrideNone override = iota
+	overrideOpen
+	overrideClosed
+)
+
+func (o override) String() string {
+	switch o {
+	case overrideOpen:
+		return "open"
+	case overrideClosed:
+		return "closed"
+	default:
+		return "none"
+	}
+}
+
+// breakerPolicy is an immutable snapshot of the breaker's settings.
+type breakerPolicy struct {
+	cfg   BreakerConfig
+	cb    *circuit
+	retry RetryConfig
+	// window holds the calls of the last Window when it is set.
+	window *rollingWindow
+	// baseline learns the upstream's behaviour for the adaptive policy.
+	baseline *adaptiveBaseline
+	// burn measures the error budget burn rate for the burn_rate policy.
+	burn *burnRate
+	// bulkhead bounds the calls in flight through the breaker.
+	bulkhead *bulkhead
+	// limiter adapts a bound on the calls in flight to the upstream.
+	limiter *concurrencyLimiter
+	// queue holds calls waiting for a half-open trial slot.
+	queue *halfOpenQueue
+}
+
+// tracksCalls reports whether p needs to see the outcome of every call.
+func (p *breakerPolicy) tracksCalls() bool {
+	return p.window != nil || p.baseline != nil || p.burn != nil || p.cfg.SlowCallThreshold > 0
+}
+
+// record feeds the outcome of a call to whatever p tracks calls with.
+func (p *breakerPolicy) record(success bool, latency time.Duration) {
+	if p.window != nil {
+		p.window.record(success, latency)
+	}
+	if p.baseline != nil {
+		p.baseline.record(success, latency)
+	}
+	if p.burn != nil {
+		p.burn.record(success)
+	}
+}
+
+// settle makes p start afresh once the breaker closed, rather than trip
+// again on the calls that opened it.
+func (p *breakerPolicy) settle() {
+	if p.window != nil {
+		p.window.reset()
+	}
+	if p.baseline != nil {
+		p.baseline.settle()
+	}
+	if p.burn != nil {
+		p.burn.reset()
+	}
+}
+
+func newBreaker(cfg BreakerConfig, retry RetryConfig) *breaker {
+	return buildBreaker(cfg.Name, withConfig(cfg), withRetry(retry))
+}
+
+// log returns the logger of the breaker, the default one unless set with
+// withLogger.
+func (b *breaker) log() *slog.Logger {
+	if b.logger != nil {
+		return b.logger
+	}
+	return slog.Default()
+}
+
+// current returns the policy in effect.
+func (b *breaker) current() *breakerPolicy {
+	return b.policy.Load()
+}
+
+// reload swaps in new settings. The underlying circuit breaker, and with it
+// its state and counts, is only replaced when the breaker settings changed,
+// the old one being stopped.
+func (b *breaker) reload(cfg BreakerConfig, retry RetryConfig) {
+	old := b.current()
+	if cfg != old.cfg {
+		b.changeState(func() {
+			old.cb.stop()
+			b.policy.Store(b.newPolicy(cfg, retry))
+		})
+		return
+	}
+	next := *old
+	next.retry = retry
+	b.policy.Store(&next)
+}
+
+// execute runs fn through the circuit breaker of p. A breaker forced open
+// rejects fn without running it, and one forced closed runs it without
+// recording the outcome. A half-open breaker relying on probes rejects fn
+// like an open one. An open breaker lets the PartialOpen share of calls
+// through without recording their outcome, which only the metrics see.
+// Calls wait up to BulkheadWait for one of MaxConcurrent slots and are
+// rejected with errBulkheadFull when none frees up, and with
+// errConcurrencyLimit beyond the adaptive concurrency limit. Calls beyond
+// the outbound rate limit are rejected with errOutboundLimit. A breaker in
+// DryRun mode runs the calls its state would reject, without recording
+// their outcome; only overrides, maintenance windows and the limits on
+// calls in flight are enforced.
+func (b *breaker) execute(p *breakerPolicy, fn func() (interface{}, error)) (interface{}, error) {
+	return b.executePriority(p, priorityNormal, fn)
+}
+
+// executePriority is execute for a call of the given priority. Low-priority
+// calls are shed while the breaker is half-open and never wait for a
+// bulkhead slot, and high-priority calls get freed slots first.
+func (b *breaker) executePriority(p *breakerPolicy, prio priority, fn func() (interface{}, error)) (interface{}, error) {
+	direct := false
+	switch override(b.override.Load()) {
+	case overrideOpen:
+		return nil, gobreaker.ErrOpenState
+	case overrideClosed:
+		direct = true
+	}
+	if !direct {
+		if err := b.window.Load().err(); err != nil {
+			return nil, err
+		}
+		var err error
+		if direct, err = b.admission(p, prio); err != nil {
+			if !p.cfg.DryRun {
+				return nil, err
+			}
+			b.wouldReject(p, err)
+			direct = true
+		}
+	}
+
+	if !outbound.take() {
+		return nil, errOutboundLimit
+	}
+	if !p.bulkhead.acquire(prio, p.cfg.BulkheadWait) {
+		return nil, errBulkheadFull
+	}
+	defer p.bulkhead.release()
+	if !p.limiter.acquire() {
+		return nil, errConcurrencyLimit
+	}
+	start := clock.Now()
+	result, err := b.call(p, direct, fn)
+	p.limiter.release(clock.Now().Sub(start), err)
+	return result, err
+}
+
+// admission decides whether the breaker lets a call of priority prio
+// through, returning the error it is rejected with if not. It is let through
+// directly, bypassing the breaker, when direct is true.
+func (b *breaker) admission(p *breakerPolicy, prio priority) (direct bool, err error) {
+	if b.shared.Load().isOpen() || b.peer.Load().opens() {
+		return false, gobreaker.ErrOpenState
+	}
+	switch p.cb.State() {
+	case gobreaker.StateHalfOpen:
+		if p.cfg.ProbePath != "" {
+			return false, gobreaker.ErrOpenState
+		}
+		if prio == priorityLow {
+			return false, errLowPriority
+		}
+	case gobreaker.StateOpen:
+		direct = p.cfg.PartialOpen > 0 && jitter.float64() < p.cfg.PartialOpen
+	}
+	if !direct && !b.admit(p) {
+		return false, errSlowStart
+	}
+	return direct, nil
+}
+
+// wouldReject accounts for a call a dry-running breaker let through although
+// it would have rejected it with err.
+func (b *breaker) wouldReject(p *breakerPolicy, err error) {
+	reason := fallbackReason(err)
+	if b.metrics {
+		observeDryRunRejection(p.cfg.Name, reason)
+	}
+	b.log().Debug("Dry run let through a call the breaker would have rejected", "breaker", p.cfg.Name,
+		"state", p.cb.State().String(), "reason", reason)
+}
+
+// call runs fn through the circuit of p, or directly when the
+// breaker is bypassed, recording the outcome for the trip policy.
+func (b *breaker) call(p *breakerPolicy, direct bool, fn func() (interface{}, error)) (interface{}, error) {
+	if direct {
+		return fn()
+	}
+	run := fn
+	shadow := b.shadow.Load()
+	if p.tracksCalls() || shadow != nil {
+		run = func() (interface{}, error) {
+			// Classify and record the outcome before the breaker evaluates it
+			start := clock.Now()
+			result, err := fn()
+			latency := clock.Now().Sub(start)
+			shadow.observe(p.cfg.Name, err, latency)
+			if !p.tracksCalls() {
+				return result, err
+			}
+			if err == nil && p.cfg.SlowCallThreshold > 0 && latency > p.cfg.SlowCallThreshold {
+				if b.metrics {
+					observeSlowCall(p.cfg.Name)
+				}
+				err = errSlowCall
+			}
+			p.record(isSuccessful(err), latency)
+			return result, err
+		}
+	}
+	result, err := p.cb.Execute(run)
+	if p.cfg.DryRun && (errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)) {
+		b.wouldReject(p, err)
+		return fn()
+	}
+	if errors.Is(err, gobreaker.ErrTooManyRequests) {
+		result, err = p.queue.wait(p.cb, run)
+	} else {
+		p.queue.wake()
+	}
+	b.shared.Load().record(err)
+	if errors.Is(err, errSlowCall) {
+		// The call did succeed, only the breaker counts it as a failure
+		return result, nil
+	}
+	return result, err
+}
+
+// errSlowCall marks a successful call that took longer than
+// SlowCallThreshold so that the breaker counts it as a failure.
+var errSlowCall = errors.New("upstream call was slow")
+
+// isSuccessful reports whether a call ending with err counts as a success.
+// A call abandoned because the client went away, or that failed to resolve
+// the upstream's host, says nothing about the upstream, so it is not
+// counted as a failure.
+func isSuccessful(err error) bool {
+	return err == nil || errors.Is(err, context.Canceled) || isDNSFailure(err)
+}
+
+// errSlowStart is returned for calls turned away while a recovered breaker
+// ramps traffic back up.
+var errSlowStart = errors.New("circuit breaker is ramping up traffic")
+
+// slowStartFloor is the share of calls let through right after the breaker
+// closes from half-open.
+const slowStartFloor = 0.1
+
+// admit decides whether a call may go through during the slow-start ramp
+// after the breaker closed from half-open. The share of calls let through
+// grows linearly from slowStartFloor to all of them over SlowStart.
+func (b *breaker) admit(p *breakerPolicy) bool {
+	recovered := b.recovered.Load()
+	if p.cfg.SlowStart <= 0 || recovered == 0 || p.cb.State() != gobreaker.StateClosed {
+		return true
+	}
+	elapsed := clock.Now().Sub(time.Unix(0, recovered))
+	if elapsed >= p.cfg.SlowStart {
+		return true
+	}
+	return jitter.float64() < max(slowStartFloor, float64(elapsed)/float64(p.cfg.SlowStart))
+}
+
+// isRejection reports whether err means the breaker refused a call without
+// attempting it.
+func isRejection(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) ||
+		errors.Is(err, errSlowStart) || errors.Is(err, errBulkheadFull) ||
+		errors.Is(err, errConcurrencyLimit) || errors.Is(err, errLowPriority) ||
+		errors.Is(err, errOutboundLimit) || errors.Is(err, errMaintenanceWindow)
+}
+
+// isOverCapacity reports whether err means a call was turned away for want
+// of capacity rather than for the upstream's health.
+func isOverCapacity(err error) bool {
+	return errors.Is(err, errBulkheadFull) || errors.Is(err, errConcurrencyLimit) || errors.Is(err, errOutboundLimit)
+}
+
+// reopenDelay estimates how long until the breaker lets calls through
+// again: the rest of the open timeout, or the whole timeout when forced open
+// since that only ends by hand, or the rest of the open state shared by
+// another replica, taken on a peer's trip or of a maintenance window. It is
+// zero when calls are not being rejected for being open.
+func (b *breaker) reopenDelay() time.Duration {
+	p := b.current()
+	switch {
+	case override(b.override.Load()) == overrideOpen:
+		return p.cfg.Timeout
+	case p.cb.State() == gobreaker.StateOpen:
+		return max(p.cfg.Timeout-b.sinceTransition(), 0)
+	case b.peer.Load().opens():
+		return b.peer.Load().until.Sub(clock.Now())
+	case b.window.Load() != nil:
+		return b.window.Load().until.Sub(clock.Now())
+	default:
+		return b.shared.Load().openFor()
+	}
+}
+
+// forceOpen rejects every call until the breaker is reset or forced closed.
+func (b *breaker) forceOpen() {
+	b.changeState(func() { b.override.Store(int32(overrideOpen)) })
+}
+
+// forceClose lets every call through until the breaker is reset or forced
+// open.
+func (b *breaker) forceClose() {
+	b.changeState(func() { b.override.Store(int32(overrideClosed)) })
+}
+
+// reset clears any manual override and replaces the circuit breaker with a
+// fresh, closed one, stopping the old one.
+func (b *breaker) reset() {
+	b.changeState(func() {
+		old := b.current()
+		old.cb.stop()
+		b.policy.Store(b.newPolicy(old.cfg, old.retry))
+		b.override.Store(int32(overrideNone))
+	})
+}
+
+// state returns the state the breaker acts in: that it is forced into, if
+// any, or else that of its circuit breaker.
+func (b *breaker) state() gobreaker.State {
+	switch override(b.override.Load()) {
+	case overrideOpen:
+		return gobreaker.StateOpen
+	case overrideClosed:
+		return gobreaker.StateClosed
+	default:
+		return b.current().cb.State()
+	}
+}
+
+// changeState runs change, which overrides the breaker or replaces its
+// circuit breaker, and reports the resulting transition, if any, to the
+// listeners as the circuit breaker reports its own.
+func (b *breaker) changeState(change func()) {
+	from, counts := b.state(), b.current().cb.Counts()
+	change()
+	b.markTransition()
+	to := b.state()
+	if to == from {
+		return
+	}
+	name := b.current().cfg.Name
+	b.shared.Load().transition(from, to)
+	c := stateChange{Name: name, From: from.String(), To: to.String(), Time: clock.Now()}
+	if from == gobreaker.StateClosed {
+		c.Counts = newBreakerCounts(counts)
+	}
+	b.log().Info("Circuit breaker state changed", "breaker", name, "from", c.From, "to", c.To,
+		"requests", c.Counts.Requests, "failures", c.Counts.TotalFailures)
+	b.notify(c)
+}
+
+// TripPolicy names the condition opening the breaker in the config.
+type TripPolicy string
+
+const (
+	// TripConsecutiveFailures opens the breaker once more than
+	// ConsecutiveFailures calls failed in a row.
+	TripConsecutiveFailures TripPolicy = "consecutive_failures"
+	// TripFailureRatio opens the breaker once more than FailureRatio of the
+	// calls since the counts were last cleared failed, out of at least
+	// MinRequests.
+	TripFailureRatio TripPolicy = "failure_ratio"
+	// TripAdaptive opens the breaker once the recent error rate or latency
+	// deviates from the baseline learned from past calls.
+	TripAdaptive TripPolicy = "adaptive"
+	// TripBurnRate opens the breaker once the error budget of the SLO burns
+	// too fast over both a long and a short window.
+	TripBurnRate TripPolicy = "burn_rate"
+)
+
+// UnmarshalText rejects unknown trip policy names.
+func (s *TripPolicy) UnmarshalText(text []byte) error {
+	switch policy := TripPolicy(text); policy {
+	case TripConsecutiveFailures, TripFailureRatio, TripAdaptive, TripBurnRate:
+		*s = policy
+		return nil
+	default:
+		return fmt.Errorf("unknown trip policy %q", text)
+	}
+}
+
+// tripPolicy returns the pure function deciding from the counts of the
+// closed state whether the breaker should open, defaulting to consecutive
+// failures.
+func tripPolicy(cfg BreakerConfig) func(gobreaker.Counts) bool {
+	switch cfg.Trip {
+	case TripFailureRatio:
+		return func(counts gobreaker.Counts) bool {
+			if counts.Requests == 0 || counts.Requests < cfg.MinRequests {
+				return false
+			}
+			return float64(counts.TotalFailures)/float64(counts.Requests) > cfg.FailureRatio
+		}
+	default:
+		return func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures > cfg.ConsecutiveFailures
+		}
+	}
+}
+
+// sinceTransition returns the time elapsed since the breaker last changed
+// state, was overridden or was replaced.
+func (b *breaker) sinceTransition() time.Duration {
+	return clock.Now().Sub(time.Unix(0, b.lastTransition.Load()))
+}
+
+// stateValue maps a breaker state name to the number reported by gauges:
+// 0 for closed, 1 for half-open and 2 for open.
+func stateValue(state string) float64 {
+	switch state {
+	case gobreaker.StateHalfOpen.String():
+		return 1
+	case gobreaker.StateOpen.String():
+		return 2
+	default:
+		return 0
+	}
+}
+
+// onStateChange registers fn to be called on every state transition. fn is
+// called synchronously by whatever caused it: a call through the breaker,
+// an operator's action or the timer ending the open state, with the lock of
+// the circuit held for transitions of the circuit. It must not block or
+// call back into the breaker.
+func (b *breaker) onStateChange(fn func(stateChange)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+func (b *breaker) notify(change stateChange) {
+	b.mu.Lock()
+	listeners := b.listeners
+	b.mu.Unlock()
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// onRejection registers fn to be called on every call the breaker turns
+// away. Like the state change listeners, fn must not block.
+func (b *breaker) onRejection(fn func(rejection)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejected = append(b.rejected, fn)
+}
+
+func (b *breaker) notifyRejection(p *breakerPolicy, err error) {
+	b.mu.Lock()
+	listeners := b.rejected
+	b.mu.Unlock()
+	if len(listeners) == 0 {
+		return
+	}
+	r := rejection{Name: p.cfg.Name, State: p.cb.State().String(), Reason: fallbackReason(err), Time: clock.Now()}
+	for _, fn := range listeners {
+		fn(r)
+	}
+}
+
+func (b *breaker) markTransition() {
+	b.lastTransition.Store(clock.Now().UnixNano())
+}
+
+// newPolicy returns a policy with a fresh circuit breaker configured by cfg.
+func (b *breaker) newPolicy(cfg BreakerConfig, retry RetryConfig) *breakerPolicy {
+	b.markTransition()
+	if b.metrics {
+		observeState(cfg.Name, gobreaker.StateClosed.String())
+	}
+	p := &breakerPolicy{cfg: cfg, retry: retry, window: newRollingWindow(cfg.Window), bulkhead: newBulkhead(cfg.MaxConcurrent), limiter: newConcurrencyLimiter(cfg), queue: newHalfOpenQueue(cfg)}
+	switch cfg.Trip {
+	case TripAdaptive:
+		p.baseline = &adaptiveBaseline{}
+	case TripBurnRate:
+		p.burn = newBurnRate(cfg)
+	}
+	p.cb = newCircuit(b.settings(p))
+	return p
+}
+
+// settings builds the gobreaker settings of p. When p has a window the trip
+// policy sees the totals of the window rather than those counted since the
+// last reset. The adaptive and burn_rate policies decide from what p
+// tracked instead of the counts.
+func (b *breaker) settings(p *breakerPolicy) gobreaker.Settings {
+	cfg, window := p.cfg, p.window
+	shouldTrip := tripPolicy(cfg)
+	switch {
+	case p.baseline != nil:
+		shouldTrip = func(gobreaker.Counts) bool { return adaptiveTrip(cfg, p.baseline.snapshot()) }
+	case p.burn != nil:
+		shouldTrip = func(gobreaker.Counts) bool {
+			long, short := p.burn.stats()
+			return burnRateTrip(cfg, long, short)
+		}
+	}
+	return gobreaker.Settings{
+		Name:        cfg.Name,
+		MaxRequests: cfg.MaxRequests,
+		Interval:    cfg.Interval,
+		Timeout:     cfg.Timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			if window != nil {
+				counts = window.stats().counts(counts)
+			}
+			trip := shouldTrip(counts)
+			if r := b.peer.Load(); !trip && r.tightens() && p.baseline == nil && p.burn == nil {
+				trip = tripPolicy(tighten(cfg, r.factor))(counts)
+			}
+			b.log().Debug("Trip policy evaluated", "breaker", cfg.Name, "policy", string(cfg.Trip), "requests", counts.Requests,
+				"failures", counts.TotalFailures, "consecutive_failures", counts.ConsecutiveFailures, "trip", trip)
+			if !trip {
+				return false
+			}
+			b.tripCounts.Store(&counts)
+			return true
+		},
+		IsSuccessful: isSuccessful,
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			b.markTransition()
+			if to == gobreaker.StateClosed {
+				p.settle()
+			}
+			if from == gobreaker.StateHalfOpen && to == gobreaker.StateClosed {
+				b.recovered.Store(clock.Now().UnixNano())
+			}
+			b.shared.Load().transition(from, to)
+			change := stateChange{Name: name, From: from.String(), To: to.String(), Time: clock.Now()}
+			if counts := b.tripCounts.Load(); counts != nil && from == gobreaker.StateClosed {
+				change.Counts = newBreakerCounts(*counts)
+			}
+			b.log().Info("Circuit breaker state changed", "breaker", name, "from", change.From, "to", change.To,
+				"requests", change.Counts.Requests, "failures", change.Counts.TotalFailures)
+			b.notify(change)
+		},
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakerReload(t *testing.T) {
+	cfg := BreakerConfig{
+		Name:                "Reload Test",
+		MaxRequests:         1,
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}
+	b := newBreaker(cfg, RetryConfig{Attempts: 5})
+
+	// Trip the breaker
+	for i := 0; i < 2; i++ {
+		b.current().cb.Execute(func() (interface{}, error) {
+			return nil, errors.New("simulated failure")
+		})
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected circuit breaker to be open, got %v", state)
+	}
+
+	t.Run("RetryOnly", func(t *testing.T) {
+		b.reload(cfg, RetryConfig{Attempts: 2})
+
+		if attempts := b.current().retry.Attempts; attempts != 2 {
+			t.Fatalf("expected 2 attempts after reload, got %d", attempts)
+		}
+		if state := b.current().cb.State(); state != gobreaker.StateOpen {
+			t.Fatalf("expected breaker state to survive a retry-only reload, got %v", state)
+		}
+	})
+
+	t.Run("BreakerSettings", func(t *testing.T) {
+		cfg.ConsecutiveFailures = 5
+		b.reload(cfg, RetryConfig{Attempts: 2})
+
+		if got := b.current().cfg.ConsecutiveFailures; got != 5 {
+			t.Fatalf("expected new trip threshold 5, got %d", got)
+		}
+		if state := b.current().cb.State(); state != gobreaker.StateClosed {
+			t.Fatalf("expected a fresh closed breaker after reload, got %v", state)
+		}
+	})
+}
+
+func TestSlowStart(t *testing.T) {
+	fake := useFakeClock(t)
+	b := newBreaker(BreakerConfig{
+		Name:                "Slow Start Test",
+		MaxRequests:         1,
+		Timeout:             10 * time.Millisecond,
+		ConsecutiveFailures: 0,
+		SlowStart:           time.Minute,
+	}, RetryConfig{})
+	succeed := func() (interface{}, error) { return nil, nil }
+
+	// Trip the breaker, then close it again from half-open
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	fake.Advance(10 * time.Millisecond)
+	if _, err := b.execute(b.current(), succeed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	admitted := 0
+	for i := 0; i < 1000; i++ {
+		_, err := b.execute(b.current(), succeed)
+		switch {
+		case err == nil:
+			admitted++
+		case !errors.Is(err, errSlowStart) || !isRejection(err):
+			t.Fatalf("expected calls to be turned away as a rejection, got %v", err)
+		}
+	}
+	if admitted < 50 || admitted > 200 {
+		t.Fatalf("expected about 10%% of calls right after recovering, got %d of 1000", admitted)
+	}
+
+	// Once the ramp is over every call goes through
+	fake.Advance(time.Minute)
+	for i := 0; i < 100; i++ {
+		if _, err := b.execute(b.current(), succeed); err != nil {
+			t.Fatalf("expected every call after the ramp to go through, got %v", err)
+		}
+	}
+}
+
+func TestPartialOpen(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Partial Open Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 0,
+		PartialOpen:         0.2,
+	}, RetryConfig{})
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	b.execute(b.current(), fail)
+	calls := 0
+	for i := 0; i < 1000; i++ {
+		b.execute(b.current(), func() (interface{}, error) {
+			calls++
+			return fail()
+		})
+	}
+	if calls < 120 || calls > 280 {
+		t.Fatalf("expected about 20%% of calls through while open, got %d of 1000", calls)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected the breaker to stay open, got %s", state)
+	}
+
+	b.forceOpen()
+	calls = 0
+	for i := 0; i < 100; i++ {
+		b.execute(b.current(), func() (interface{}, error) {
+			calls++
+			return nil, nil
+		})
+	}
+	if calls != 0 {
+		t.Fatalf("expected no calls through a breaker forced open, got %d", calls)
+	}
+}
+
+func TestTripPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    BreakerConfig
+		counts gobreaker.Counts
+		trip   bool
+	}{
+		{"ConsecutiveBelow", BreakerConfig{ConsecutiveFailures: 3}, gobreaker.Counts{ConsecutiveFailures: 3}, false},
+		{"ConsecutiveAbove", BreakerConfig{ConsecutiveFailures: 3}, gobreaker.Counts{ConsecutiveFailures: 4}, true},
+		{"RatioTooFewRequests", BreakerConfig{Trip: TripFailureRatio, FailureRatio: 0.5, MinRequests: 100}, gobreaker.Counts{Requests: 99, TotalFailures: 99}, false},
+		{"RatioBelow", BreakerConfig{Trip: TripFailureRatio, FailureRatio: 0.5, MinRequests: 100}, gobreaker.Counts{Requests: 100, TotalFailures: 50, ConsecutiveFailures: 50}, false},
+		{"RatioAbove", BreakerConfig{Trip: TripFailureRatio, FailureRatio: 0.5, MinRequests: 100}, gobreaker.Counts{Requests: 100, TotalFailures: 51}, true},
+		{"RatioNoRequests", BreakerConfig{Trip: TripFailureRatio}, gobreaker.Counts{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tripPolicy(tc.cfg)(tc.counts); got != tc.trip {
+				t.Fatalf("expected trip %v for %+v, got %v", tc.trip, tc.counts, got)
+			}
+		})
+	}
+
+	var policy TripPolicy
+	if err := policy.UnmarshalText([]byte("failure_ratio")); err != nil || policy != TripFailureRatio {
+		t.Fatalf("expected failure_ratio to be accepted, got %q, %v", policy, err)
+	}
+	if err := policy.UnmarshalText([]byte("sometimes")); err == nil {
+		t.Fatalf("expected an unknown trip policy to be rejected")
+	}
+}
+
+func TestSlowCalls(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Slow Call Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+		SlowCallThreshold:   10 * time.Millisecond,
+	}, RetryConfig{})
+	slow := func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return "answer", nil
+	}
+
+	result, err := b.execute(b.current(), slow)
+	if err != nil || result != "answer" {
+		t.Fatalf("expected a slow call to still return its result, got %v, %v", result, err)
+	}
+	if counts := b.current().cb.Counts(); counts.TotalFailures != 1 {
+		t.Fatalf("expected the slow call to count as a failure, got %+v", counts)
+	}
+	b.execute(b.current(), func() (interface{}, error) { return nil, nil })
+	b.execute(b.current(), slow)
+	b.execute(b.current(), slow)
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected consecutive slow calls to trip the breaker, got %s", state)
+	}
+}
+
+func TestBreakerDryRun(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Dry Run Test",
+		MaxRequests:         1,
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+		DryRun:              true,
+	}, RetryConfig{Attempts: 1})
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	for i := 0; i < 2; i++ {
+		b.execute(b.current(), fail)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected the breaker to track its state, got %v", state)
+	}
+	called := false
+	result, err := b.execute(b.current(), func() (interface{}, error) {
+		called = true
+		return "ok", nil
+	})
+	if err != nil || result != "ok" || !called {
+		t.Fatalf("expected the call to go through an open breaker, got %v, %v", result, err)
+	}
+	if !b.status().DryRun {
+		t.Fatalf("expected dry run to be reported")
+	}
+
+	// Overrides are still enforced
+	b.forceOpen()
+	if _, err := b.execute(b.current(), fail); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected a forced open breaker to reject calls, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// PeerAction names how a replica responds to a peer's breaker tripping.
+type PeerAction string
+
+const (
+	// PeerOpen opens the breaker of the same name for its Timeout.
+	PeerOpen PeerAction = "open"
+	// PeerTighten scales down the trip thresholds of the breaker of the same
+	// name by PeerThresholdFactor for its Timeout.
+	PeerTighten PeerAction = "tighten"
+)
+
+// UnmarshalText rejects unknown peer actions.
+func (a *PeerAction) UnmarshalText(text []byte) error {
+	switch action := PeerAction(text); action {
+	case PeerOpen, PeerTighten:
+		*a = action
+		return nil
+	default:
+		return fmt.Errorf("unknown peer action %q", text)
+	}
+}
+
+// peerResponse is a breaker's response to a peer's trip, in effect until
+// until.
+type peerResponse struct {
+	action PeerAction
+	factor float64
+	until  time.Time
+}
+
+// opens reports whether r rejects calls. It is false for a nil response.
+func (r *peerResponse) opens() bool {
+	return r != nil && r.action == PeerOpen && clock.Now().Before(r.until)
+}
+
+// tightens reports whether r scales down the trip thresholds.
+func (r *peerResponse) tightens() bool {
+	return r != nil && r.action == PeerTighten && clock.Now().Before(r.until)
+}
+
+// tighten returns cfg with its consecutive_failures and failure_ratio
+// thresholds scaled by factor.
+func tighten(cfg BreakerConfig, factor float64) BreakerConfig {
+	cfg.ConsecutiveFailures = uint32(float64(cfg.ConsecutiveFailures) * factor)
+	cfg.FailureRatio *= factor
+	cfg.MinRequests = uint32(float64(cfg.MinRequests) * factor)
+	return cfg
+}
+
+// peerTrip is the message announcing that a breaker tripped.
+type peerTrip struct {
+	Name     string    `json:"name"`
+	Instance string    `json:"instance"`
+	Time     time.Time `json:"timestamp"`
+}
+
+// peerBroadcast publishes the trips of the breakers of this replica on a
+// Redis channel and has the breakers of the same name respond to those of
+// its peers, so that the fleet backs off an upstream as soon as one
+// replica finds it failing.
+type peerBroadcast struct {
+	client   *redisClient
+	cfg      RedisConfig
+	channel  string
+	instance string
+
+	mu       sync.Mutex
+	breakers []*breaker
+}
+
+func newPeerBroadcast(client *redisClient, cfg RedisConfig) *peerBroadcast {
+	return &peerBroadcast{client: client, cfg: cfg, channel: cfg.KeyPrefix + "trips", instance: newRequestID()}
+}
+
+// watch publishes the trips of b and has b respond to those of peers.
+func (pb *peerBroadcast) watch(b *breaker) {
+	pb.mu.Lock()
+	pb.breakers = append(pb.breakers, b)
+	pb.mu.Unlock()
+	b.onStateChange(func(change stateChange) {
+		if change.From == gobreaker.StateClosed.String() && change.To == gobreaker.StateOpen.String() {
+			// Listeners must not block
+			go pb.publish(change)
+		}
+	})
+}
+
+func (pb *peerBroadcast) publish(change stateChange) {
+	data, err := json.Marshal(peerTrip{Name: change.Name, Instance: pb.instance, Time: change.Time})
+	if err != nil {
+		return
+	}
+	if _, err := pb.client.do("PUBLISH", pb.channel, string(data)); err != nil {
+		slog.Warn("Failed to broadcast breaker trip", "breaker", change.Name, "error", err)
+	}
+}
+
+// run applies the trips published by peers until ctx is done,
+// resubscribing whenever the connection fails.
+func (pb *peerBroadcast) run(ctx context.Context) {
+	backoff := newBackoff(RetryConfig{Backoff: BackoffExponential, BackoffMin: time.Second, BackoffMax: 30 * time.Second}, jitter)
+	var delay time.Duration
+	for attempt := 0; ; attempt++ {
+		start := clock.Now()
+		err := pb.client.subscribe(ctx, pb.channel, pb.receive)
+		if ctx.Err() != nil {
+			return
+		}
+		if clock.Now().Sub(start) > time.Minute {
+			// The subscription held up for a while, start backing off afresh
+			attempt, delay = 0, 0
+		}
+		slog.Warn("Lost the breaker trip broadcast, resubscribing", "error", err)
+		delay = backoff.Delay(attempt, delay)
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// receive applies a trip published by a peer to the breakers of the same
+// name.
+func (pb *peerBroadcast) receive(payload string) {
+	var trip peerTrip
+	if json.Unmarshal([]byte(payload), &trip) != nil || trip.Instance == pb.instance {
+		return
+	}
+	pb.mu.Lock()
+	breakers := pb.breakers
+	pb.mu.Unlock()
+	for _, b := range breakers {
+		p := b.current()
+		if p.cfg.Name != trip.Name || p.cb.State() != gobreaker.StateClosed {
+			continue
+		}
+		b.peer.Store(&peerResponse{action: pb.cfg.PeerAction, factor: pb.cfg.PeerThresholdFactor, until: clock.Now().Add(p.cfg.Timeout)})
+		slog.Info("Circuit breaker tripped on a peer", "breaker", trip.Name, "peer", trip.Instance, "action", string(pb.cfg.PeerAction))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestPeerBroadcast(t *testing.T) {
+	server := newFakeRedis(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		action PeerAction
+		// failures is how many failures in a row trip the peer's breaker
+		failures int
+	}{
+		{PeerOpen, 0},
+		{PeerTighten, 2},
+	} {
+		t.Run(string(tc.action), func(t *testing.T) {
+			cfg := RedisConfig{Addr: server.addr(), KeyPrefix: "test:" + string(tc.action) + ":", Timeout: time.Second, PeerAction: tc.action, PeerThresholdFactor: 0.25}
+			newReplica := func() (*peerBroadcast, *breaker) {
+				peers := newPeerBroadcast(newRedisClient(cfg), cfg)
+				b := newBreaker(BreakerConfig{Name: "Broadcast Test", Timeout: time.Minute, ConsecutiveFailures: 4}, RetryConfig{Attempts: 1})
+				peers.watch(b)
+				go peers.run(ctx)
+				return peers, b
+			}
+			_, tripped := newReplica()
+			_, peer := newReplica()
+			// Let both subscribe
+			time.Sleep(50 * time.Millisecond)
+
+			fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+			for i := 0; i < 5; i++ {
+				tripped.execute(tripped.current(), fail)
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for peer.peer.Load() == nil {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected the peer to hear of the trip")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if tripped.peer.Load() != nil {
+				t.Fatalf("expected a replica to ignore its own trips")
+			}
+			if got := peer.status().PeerAction; got != tc.action {
+				t.Fatalf("expected the peer to %s, got %q", tc.action, got)
+			}
+
+			for i := 0; i < tc.failures; i++ {
+				peer.execute(peer.current(), fail)
+			}
+			if _, err := peer.execute(peer.current(), func() (interface{}, error) { return nil, nil }); !errors.Is(err, gobreaker.ErrOpenState) {
+				t.Fatalf("expected the peer to open after %d failures, got %v", tc.failures, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errBulkheadFull is returned for calls turned away because the maximum
+// number of calls were in flight.
+var errBulkheadFull = errors.New("too many upstream calls in flight")
+
+// bulkhead is a semaphore bounding the calls in flight. Calls waiting for
+// a slot get it by priority, then in order of arrival, and low-priority
+// calls never wait. A nil bulkhead bounds nothing.
+type bulkhead struct {
+	size int
+
+	mu       sync.Mutex
+	inflight int
+	// waiting holds the channels of the calls waiting for a slot, by
+	// priority. A slot is handed over by closing the channel.
+	waiting [priorityHigh + 1]list.List
+}
+
+// newBulkhead returns a bulkhead of size slots, or nil when size is not
+// positive.
+func newBulkhead(size int) *bulkhead {
+	if size <= 0 {
+		return nil
+	}
+	return &bulkhead{size: size}
+}
+
+// acquire takes a slot, waiting up to wait for one to free up unless prio
+// is low, and reports whether it got one.
+func (b *bulkhead) acquire(prio priority, wait time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	if b.inflight < b.size {
+		b.inflight++
+		b.mu.Unlock()
+		return true
+	}
+	if prio == priorityLow || wait <= 0 {
+		b.mu.Unlock()
+		return false
+	}
+	granted := make(chan struct{})
+	e := b.waiting[prio].PushBack(granted)
+	b.mu.Unlock()
+
+	timer := clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-granted:
+		return true
+	case <-timer.C():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-granted:
+		// The slot was handed over as the wait ended
+		return true
+	default:
+		b.waiting[prio].Remove(e)
+		return false
+	}
+}
+
+// release frees a slot taken by acquire, handing it to the first of the
+// most important calls waiting.
+func (b *bulkhead) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for prio := priorityHigh; prio >= priorityLow; prio-- {
+		if e := b.waiting[prio].Front(); e != nil {
+			close(b.waiting[prio].Remove(e).(chan struct{}))
+			return
+		}
+	}
+	b.inflight--
+}
+
+// busy returns the number of slots taken.
+func (b *bulkhead) busy() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inflight
+}
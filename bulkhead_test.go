@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:           "Bulkhead Test",
+		MaxConcurrent:  2,
+		BulkheadWait:   10 * time.Millisecond,
+		BulkheadStatus: http.StatusServiceUnavailable,
+	}, RetryConfig{})
+
+	// Hold both slots with calls that block until released
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := b.execute(b.current(), func() (interface{}, error) {
+				started <- struct{}{}
+				<-release
+				return nil, nil
+			})
+			done <- err
+		}()
+		<-started
+	}
+
+	start := time.Now()
+	_, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil })
+	if !errors.Is(err, errBulkheadFull) || !isRejection(err) {
+		t.Fatalf("expected a full bulkhead to reject the call, got %v", err)
+	}
+	if waited := time.Since(start); waited < 10*time.Millisecond {
+		t.Fatalf("expected the call to wait for a slot, waited %v", waited)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected freed slots to admit calls, got %v", err)
+	}
+	if counts := b.current().cb.Counts(); counts.TotalFailures != 0 {
+		t.Fatalf("expected bulkhead rejections not to count as failures, got %d", counts.TotalFailures)
+	}
+}
+
+func TestBulkheadStatus(t *testing.T) {
+	release, done := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(release)
+		<-done
+	}()
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}
+	target, _ := url.Parse("http://upstream.test")
+
+	b := newBreaker(BreakerConfig{
+		Name:           "Bulkhead Status Test",
+		MaxConcurrent:  1,
+		BulkheadStatus: http.StatusServiceUnavailable,
+	}, RetryConfig{})
+	proxy := newProxy(&breakerTransport{b: b, target: target}, nil)
+
+	go func() {
+		defer close(done)
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	}()
+	for b.current().bulkhead.busy() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseCache is an http.RoundTripper keeping successful responses of
+// shareable requests made through next in memory, keyed by method and URL
+// and the tenant of the request, if any. With Cache set, repeated requests
+// are answered from it for CacheTTL without reaching the breaker at all.
+// With CacheMaxStale set, a copy is also served when the breaker rejects
+// the same request later or the upstream fails. The least recently used
+// entries are evicted to stay within CacheMaxBytes.
+type responseCache struct {
+	next http.RoundTripper
+	name string
+	cfg  ProxyConfig
+	// metrics tells whether hits, misses and stale responses are recorded,
+	// as it does for the breaker the cache is in front of.
+	metrics bool
+	// key, when set, replaces shareKey in telling requests apart.
+	key func(*http.Request) string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, most recently used first.
+	lru  *list.List
+	size int64
+}
+
+// cachedResponse is a response kept by responseCache.
+type cachedResponse struct {
+	key    string
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+func newResponseCache(next http.RoundTripper, name string, cfg ProxyConfig) *responseCache {
+	return &responseCache{next: next, name: name, cfg: cfg, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (c *responseCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !shareable(req) {
+		return c.next.RoundTrip(req)
+	}
+	key := shareKey(req)
+	if c.key != nil {
+		key = c.key(req)
+	}
+
+	if c.cfg.Cache && !hasDirective(req.Header, "no-cache") {
+		if entry, ok := c.lookup(key, c.cfg.CacheTTL); ok {
+			if c.metrics {
+				cacheCount.WithLabelValues(c.name, "hit").Inc()
+			}
+			return entry.response(req, false), nil
+		}
+		if c.metrics {
+			cacheCount.WithLabelValues(c.name, "miss").Inc()
+		}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		if resp.StatusCode == http.StatusOK && !hasDirective(resp.Header, "no-store") && !hasDirective(resp.Header, "private") {
+			resp.Body = c.store(key, resp)
+		}
+		return resp, nil
+	}
+	if c.cfg.CacheMaxStale <= 0 || errors.Is(err, context.Canceled) {
+		return resp, err
+	}
+
+	entry, ok := c.lookup(key, c.lifetime())
+	if !ok {
+		return resp, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if c.metrics {
+		staleCount.WithLabelValues(c.name).Inc()
+	}
+	return entry.response(req, true), nil
+}
+
+// store keeps a copy of resp under key unless its body is too large, and
+// returns the body to hand on in place of the one it consumed.
+func (c *responseCache) store(key string, resp *http.Response) io.ReadCloser {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.cfg.CacheMaxBody+1))
+	if err != nil || int64(len(body)) > c.cfg.CacheMaxBody {
+		// Hand on what was read followed by the rest of the body
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	}
+	resp.Body.Close()
+
+	entry := &cachedResponse{key: key, status: resp.StatusCode, header: resp.Header.Clone(), body: body, stored: clock.Now()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if entry.size() <= c.cfg.CacheMaxBytes {
+		c.entries[key] = c.lru.PushFront(entry)
+		c.size += entry.size()
+		for c.size > c.cfg.CacheMaxBytes {
+			c.remove(c.lru.Back())
+		}
+	}
+	return io.NopCloser(bytes.NewReader(body))
+}
+
+// lookup returns the entry stored under key if it is at most maxAge old.
+func (c *responseCache) lookup(key string, maxAge time.Duration) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cachedResponse)
+	age := clock.Now().Sub(entry.stored)
+	if age > c.lifetime() {
+		c.remove(elem)
+		return nil, false
+	}
+	if age > maxAge {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// remove drops elem from the cache. c.mu must be held.
+func (c *responseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedResponse)
+	delete(c.entries, entry.key)
+	c.size -= entry.size()
+}
+
+// lifetime is how long an entry may be served after it was stored.
+func (c *responseCache) lifetime() time.Duration {
+	return c.cfg.CacheTTL + c.cfg.CacheMaxStale
+}
+
+// size approximates the memory held by the entry in bytes.
+func (e *cachedResponse) size() int64 {
+	return int64(len(e.key) + len(e.body))
+}
+
+// response returns a copy of the entry as the answer to req, marked as
+// stale when it is served because the upstream is unavailable.
+func (e *cachedResponse) response(req *http.Request, stale bool) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.Itoa(int(clock.Now().Sub(e.stored).Seconds())))
+	if stale {
+		header.Set("X-Stale", "true")
+		header.Set("Warning", `111 - "Revalidation Failed"`)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// hasDirective reports whether the Cache-Control header holds directive.
+func hasDirective(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sony/gobreaker"
+)
+
+func TestStaleResponses(t *testing.T) {
+	var answer func() (*http.Response, error)
+	next := roundTripFunc(func(*http.Request) (*http.Response, error) { return answer() })
+	ok := func(body string) func() (*http.Response, error) {
+		return func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/plain"}}, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}
+	}
+	get := func(c *responseCache) (*http.Response, string, error) {
+		resp, err := c.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/items", nil))
+		if err != nil {
+			return nil, "", err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body), nil
+	}
+
+	c := newResponseCache(next, "Stale Test", ProxyConfig{CacheTTL: time.Minute, CacheMaxStale: time.Minute, CacheMaxBody: 1024, CacheMaxBytes: 1 << 20})
+	answer = ok("fresh")
+	if _, body, _ := get(c); body != "fresh" {
+		t.Fatalf("expected the upstream body, got %q", body)
+	}
+
+	for name, fail := range map[string]func() (*http.Response, error){
+		"Rejected": func() (*http.Response, error) { return nil, gobreaker.ErrOpenState },
+		"ServerError": func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(strings.NewReader("bad"))}, nil
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			answer = fail
+			resp, body, err := get(c)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || body != "fresh" {
+				t.Fatalf("expected the cached response, got %d %q", resp.StatusCode, body)
+			}
+			if resp.Header.Get("X-Stale") != "true" || resp.Header.Get("Warning") == "" || resp.Header.Get("Content-Type") != "text/plain" {
+				t.Fatalf("expected stale headers with the cached ones, got %v", resp.Header)
+			}
+		})
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		answer = func() (*http.Response, error) { return nil, context.Canceled }
+		if _, _, err := get(c); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected cancellation to be passed on, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		fake := useFakeClock(t)
+		c := newResponseCache(next, "Stale Test", ProxyConfig{CacheTTL: 10 * time.Millisecond, CacheMaxStale: 10 * time.Millisecond, CacheMaxBody: 1024, CacheMaxBytes: 1 << 20})
+		answer = ok("fresh")
+		get(c)
+		fake.Advance(30 * time.Millisecond)
+		answer = func() (*http.Response, error) { return nil, gobreaker.ErrOpenState }
+		if _, _, err := get(c); !errors.Is(err, gobreaker.ErrOpenState) {
+			t.Fatalf("expected an expired response not to be served, got %v", err)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		c := newResponseCache(next, "Stale Test", ProxyConfig{CacheTTL: time.Minute, CacheMaxStale: time.Minute, CacheMaxBody: 4, CacheMaxBytes: 1 << 20})
+		answer = ok("larger than four bytes")
+		if _, body, _ := get(c); body != "larger than four bytes" {
+			t.Fatalf("expected the whole body to be passed on, got %q", body)
+		}
+		answer = func() (*http.Response, error) { return nil, gobreaker.ErrOpenState }
+		if _, _, err := get(c); err == nil {
+			t.Fatalf("expected a large response not to be cached")
+		}
+	})
+}
+
+func TestResponseCache(t *testing.T) {
+	calls := 0
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		header := http.Header{}
+		if strings.HasSuffix(req.URL.Path, "/private") {
+			header.Set("Cache-Control", "private, max-age=60")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(req.URL.Path))}, nil
+	})
+	get := func(c *responseCache, path string, header http.Header) string {
+		req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid"+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := c.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("Fresh", func(t *testing.T) {
+		calls = 0
+		c := newResponseCache(next, "Cache Test", ProxyConfig{Cache: true, CacheTTL: time.Minute, CacheMaxBody: 1024, CacheMaxBytes: 1 << 20})
+		for i := 0; i < 3; i++ {
+			if body := get(c, "/items?page=1", nil); body != "/items" {
+				t.Fatalf("expected the cached body, got %q", body)
+			}
+		}
+		if calls != 1 {
+			t.Fatalf("expected repeated reads to be served from the cache, got %d upstream calls", calls)
+		}
+		get(c, "/items?page=2", nil)
+		get(c, "/items?page=1", http.Header{"Cache-Control": {"no-cache"}})
+		get(c, "/private", nil)
+		get(c, "/private", nil)
+		if calls != 5 {
+			t.Fatalf("expected other queries, no-cache requests and private responses to reach the upstream, got %d calls", calls)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		calls = 0
+		fake := useFakeClock(t)
+		c := newResponseCache(next, "Cache Test", ProxyConfig{Cache: true, CacheTTL: 10 * time.Millisecond, CacheMaxBody: 1024, CacheMaxBytes: 1 << 20})
+		get(c, "/items", nil)
+		fake.Advance(20 * time.Millisecond)
+		get(c, "/items", nil)
+		if calls != 2 {
+			t.Fatalf("expected an expired entry to be refreshed, got %d calls", calls)
+		}
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		calls = 0
+		// Each entry takes its key plus a 2-byte body, 31 bytes in all
+		c := newResponseCache(next, "Cache Test", ProxyConfig{Cache: true, CacheTTL: time.Minute, CacheMaxBody: 1024, CacheMaxBytes: 70})
+		get(c, "/a", nil)
+		get(c, "/b", nil)
+		get(c, "/a", nil) // /b is now the least recently used
+		get(c, "/c", nil)
+		if calls != 3 {
+			t.Fatalf("expected 3 upstream calls, got %d", calls)
+		}
+		get(c, "/a", nil)
+		get(c, "/c", nil)
+		if calls != 3 {
+			t.Fatalf("expected recently used entries to be kept, got %d calls", calls)
+		}
+		get(c, "/b", nil)
+		if calls != 4 {
+			t.Fatalf("expected the least recently used entry to be evicted, got %d calls", calls)
+		}
+		if c.size > 70 {
+			t.Fatalf("expected the cache to stay within 70 bytes, got %d", c.size)
+		}
+	})
+}
+
+func TestResponseCacheMetrics(t *testing.T) {
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	})
+	cacheCount.Reset()
+
+	for name, enabled := range map[string]bool{"Cache Metrics Test": true, "Quiet Cache Test": false} {
+		c := newResponseCache(next, name, ProxyConfig{Cache: true, CacheTTL: time.Minute, CacheMaxBody: 1024, CacheMaxBytes: 1 << 20})
+		c.metrics = enabled
+		for i := 0; i < 2; i++ {
+			resp, err := c.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/items", nil))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	if got := testutil.ToFloat64(cacheCount.WithLabelValues("Cache Metrics Test", "hit")); got != 1 {
+		t.Fatalf("expected 1 hit recorded, got %v", got)
+	}
+	if n := testutil.CollectAndCount(cacheCount); n != 2 {
+		t.Fatalf("expected only the hit and miss of the breaker with metrics, got %d series", n)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// canary sends synthetic probes to an upstream through its breaker while
+// the breaker is not closed, so that live requests are never the ones
+// testing whether the upstream recovered. Probes only go out while the
+// breaker's ProbePath is set and no override is in place.
+type canary struct {
+	b      *breaker
+	target *url.URL
+}
+
+// minProbeInterval keeps a misconfigured ProbeInterval from flooding the
+// upstream with probes.
+const minProbeInterval = 100 * time.Millisecond
+
+// run probes every ProbeInterval until ctx is done.
+func (c *canary) run(ctx context.Context) {
+	for {
+		if err := sleepContext(ctx, max(c.b.current().cfg.ProbeInterval, minProbeInterval)); err != nil {
+			return
+		}
+		c.probe(ctx)
+	}
+}
+
+// probe sends one probe through the breaker if it is not closed. While the
+// open timeout runs the breaker rejects it without calling the upstream.
+func (c *canary) probe(ctx context.Context) {
+	p := c.b.current()
+	if p.cfg.ProbePath == "" || override(c.b.override.Load()) != overrideNone || p.cb.State() == gobreaker.StateClosed {
+		return
+	}
+
+	p.cb.Execute(func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, p.cfg.ProbeTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.target.JoinPath(p.cfg.ProbePath).String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := callExternalAPI(req)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, &statusError{resp: resp}
+		}
+		return nil, nil
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestCanaryProbes(t *testing.T) {
+	fake := useFakeClock(t)
+	target, _ := url.Parse("http://upstream.invalid/api")
+	b := newBreaker(BreakerConfig{
+		Name:                "Canary Test",
+		MaxRequests:         2,
+		Timeout:             20 * time.Millisecond,
+		ConsecutiveFailures: 0,
+		ProbePath:           "/health",
+		ProbeTimeout:        time.Second,
+	}, RetryConfig{Attempts: 1})
+	c := &canary{b: b, target: target}
+
+	up := false
+	var probes []string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		probes = append(probes, req.URL.String())
+		if !up {
+			return nil, errors.New("simulated failure")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+	live := func() error {
+		_, err := b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+		return err
+	}
+
+	// No probes while closed
+	c.probe(context.Background())
+	if len(probes) != 0 {
+		t.Fatalf("expected no probe while closed, got %v", probes)
+	}
+
+	live()
+	c.probe(context.Background())
+	if len(probes) != 0 {
+		t.Fatalf("expected no probe to reach the upstream during the open timeout, got %v", probes)
+	}
+
+	// Once half-open, live requests stay rejected and a failed probe reopens
+	fake.Advance(20 * time.Millisecond)
+	if err := live(); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected live requests to be rejected while half-open, got %v", err)
+	}
+	if !b.isOpen() {
+		t.Fatalf("expected the half-open breaker to count as open for routing")
+	}
+	c.probe(context.Background())
+	if len(probes) != 1 || probes[0] != "http://upstream.invalid/api/health" {
+		t.Fatalf("expected a probe of the probe path, got %v", probes)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got %s", state)
+	}
+
+	// MaxRequests successful probes close it
+	up = true
+	fake.Advance(20 * time.Millisecond)
+	c.probe(context.Background())
+	c.probe(context.Background())
+	if state := b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected successful probes to close the breaker, got %s", state)
+	}
+}
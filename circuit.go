@@ -0,0 +1,221 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// circuit is the state machine of a breaker: gobreaker's, run on clock
+// rather than the wall clock. Timers of the clock end the open state after
+// Timeout and clear the counts of the closed state every Interval, and
+// every call checks the state against the time of the clock, so that tests
+// move breakers along by advancing a fake clock. It takes gobreaker's
+// settings and reports gobreaker's states, counts and errors.
+type circuit struct {
+	name          string
+	maxRequests   uint32
+	interval      time.Duration
+	timeout       time.Duration
+	readyToTrip   func(gobreaker.Counts) bool
+	isSuccessful  func(error) bool
+	onStateChange func(name string, from, to gobreaker.State)
+
+	mu         sync.Mutex
+	state      gobreaker.State
+	generation uint64
+	counts     gobreaker.Counts
+	// expiry is when the generation ends, never when zero, and timer ends
+	// it then.
+	expiry time.Time
+	timer  Timer
+	// stopped is set once the circuit is replaced.
+	stopped bool
+}
+
+// circuitTimeout is the open timeout of circuits whose settings have none,
+// as for gobreaker.
+const circuitTimeout = 60 * time.Second
+
+// newCircuit returns a closed circuit configured by st as gobreaker would
+// be.
+func newCircuit(st gobreaker.Settings) *circuit {
+	c := &circuit{
+		name:          st.Name,
+		maxRequests:   max(st.MaxRequests, 1),
+		interval:      max(st.Interval, 0),
+		timeout:       st.Timeout,
+		readyToTrip:   st.ReadyToTrip,
+		isSuccessful:  st.IsSuccessful,
+		onStateChange: st.OnStateChange,
+	}
+	if c.timeout <= 0 {
+		c.timeout = circuitTimeout
+	}
+	if c.readyToTrip == nil {
+		c.readyToTrip = func(counts gobreaker.Counts) bool { return counts.ConsecutiveFailures > 5 }
+	}
+	if c.isSuccessful == nil {
+		c.isSuccessful = func(err error) bool { return err == nil }
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.newGeneration(clock.Now())
+	return c
+}
+
+// State returns the current state of the circuit.
+func (c *circuit) State() gobreaker.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, _ := c.currentState(clock.Now())
+	return state
+}
+
+// Counts returns the counts of the current generation.
+func (c *circuit) Counts() gobreaker.Counts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts
+}
+
+// Execute runs req if the circuit lets it through, returning
+// gobreaker.ErrOpenState or gobreaker.ErrTooManyRequests otherwise. A panic
+// of req counts as a failure before going on.
+func (c *circuit) Execute(req func() (interface{}, error)) (interface{}, error) {
+	generation, err := c.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			c.afterRequest(generation, false)
+			panic(e)
+		}
+	}()
+	result, err := req()
+	c.afterRequest(generation, c.isSuccessful(err))
+	return result, err
+}
+
+func (c *circuit) beforeRequest() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, generation := c.currentState(clock.Now())
+	switch {
+	case state == gobreaker.StateOpen:
+		return generation, gobreaker.ErrOpenState
+	case state == gobreaker.StateHalfOpen && c.counts.Requests >= c.maxRequests:
+		return generation, gobreaker.ErrTooManyRequests
+	}
+	c.counts.Requests++
+	return generation, nil
+}
+
+// afterRequest counts the outcome of a request let through in generation
+// before, unless the generation ended in the meantime.
+func (c *circuit) afterRequest(before uint64, success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := clock.Now()
+	state, generation := c.currentState(now)
+	if generation != before {
+		return
+	}
+	if success {
+		c.counts.TotalSuccesses++
+		c.counts.ConsecutiveSuccesses++
+		c.counts.ConsecutiveFailures = 0
+		if state == gobreaker.StateHalfOpen && c.counts.ConsecutiveSuccesses >= c.maxRequests {
+			c.setState(gobreaker.StateClosed, now)
+		}
+		return
+	}
+	switch state {
+	case gobreaker.StateClosed:
+		c.counts.TotalFailures++
+		c.counts.ConsecutiveFailures++
+		c.counts.ConsecutiveSuccesses = 0
+		if c.readyToTrip(c.counts) {
+			c.setState(gobreaker.StateOpen, now)
+		}
+	case gobreaker.StateHalfOpen:
+		c.setState(gobreaker.StateOpen, now)
+	}
+}
+
+// stop retires the circuit once its breaker replaced it: its timer is
+// stopped and it neither sets new ones nor reports state changes, so that
+// it cannot move the breaker's listeners along after the fact.
+func (c *circuit) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	c.onStateChange = nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// expire ends generation, called by its timer once its expiry passed.
+func (c *circuit) expire(generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.currentState(clock.Now())
+	}
+}
+
+// currentState moves the circuit on when its generation expired by now,
+// returning the state and generation it is in.
+func (c *circuit) currentState(now time.Time) (gobreaker.State, uint64) {
+	if !c.expiry.IsZero() && !now.Before(c.expiry) {
+		switch c.state {
+		case gobreaker.StateClosed:
+			c.newGeneration(now)
+		case gobreaker.StateOpen:
+			c.setState(gobreaker.StateHalfOpen, now)
+		}
+	}
+	return c.state, c.generation
+}
+
+func (c *circuit) setState(state gobreaker.State, now time.Time) {
+	if c.state == state {
+		return
+	}
+	from := c.state
+	c.state = state
+	c.newGeneration(now)
+	if c.onStateChange != nil {
+		c.onStateChange(c.name, from, state)
+	}
+}
+
+// newGeneration clears the counts and sets when the state they are of
+// expires: after the interval when closed, after the timeout when open and
+// never when half-open.
+func (c *circuit) newGeneration(now time.Time) {
+	c.generation++
+	c.counts = gobreaker.Counts{}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	var d time.Duration
+	switch c.state {
+	case gobreaker.StateClosed:
+		d = c.interval
+	case gobreaker.StateOpen:
+		d = c.timeout
+	}
+	if d == 0 || c.stopped {
+		c.expiry = time.Time{}
+		return
+	}
+	c.expiry = now.Add(d)
+	generation := c.generation
+	c.timer = clock.AfterFunc(d, func() { c.expire(generation) })
+}
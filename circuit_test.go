@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestCircuitOnClock(t *testing.T) {
+	fake := useFakeClock(t)
+	var changes []string
+	c := newCircuit(gobreaker.Settings{
+		Name:        "Circuit Test",
+		MaxRequests: 1,
+		Interval:    time.Minute,
+		Timeout:     30 * time.Second,
+		ReadyToTrip: func(counts gobreaker.Counts) bool { return counts.ConsecutiveFailures > 1 },
+		OnStateChange: func(_ string, from, to gobreaker.State) {
+			changes = append(changes, from.String()+" -> "+to.String())
+		},
+	})
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	// The interval clears the counts of the closed state
+	c.Execute(fail)
+	fake.Advance(time.Minute)
+	if counts := c.Counts(); counts.Requests != 0 {
+		t.Fatalf("expected the counts cleared after the interval, got %+v", counts)
+	}
+
+	c.Execute(fail)
+	c.Execute(fail)
+	if _, err := c.Execute(fail); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected the open circuit to reject calls, got %v", err)
+	}
+
+	// The timer moves it to half-open once the timeout is over, without a
+	// call to notice
+	fake.Advance(30*time.Second - time.Nanosecond)
+	if len(changes) != 1 {
+		t.Fatalf("expected the circuit to stay open until the timeout, got %q", changes)
+	}
+	fake.Advance(time.Nanosecond)
+	if len(changes) != 2 || changes[1] != "open -> half-open" {
+		t.Fatalf("expected the timeout to move the circuit to half-open, got %q", changes)
+	}
+	if _, err := c.Execute(func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected the trial call through, got %v", err)
+	}
+	if state := c.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected a successful trial to close the circuit, got %s", state)
+	}
+}
+
+func TestResetStopsCircuit(t *testing.T) {
+	fake := useFakeClock(t)
+	b := buildBreaker("Reset Timer Test", withConsecutiveFailures(0), withTimeout(time.Minute), withInterval(time.Minute), withMetrics(false))
+	var changes []string
+	b.onStateChange(func(c stateChange) { changes = append(changes, c.From+" -> "+c.To) })
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	if len(changes) != 1 || changes[0] != "closed -> open" {
+		t.Fatalf("expected the breaker to trip, got %q", changes)
+	}
+
+	// The replaced circuit's timeout passing must not move the breaker on
+	b.reset()
+	fake.Advance(time.Minute)
+	if len(changes) != 2 || changes[1] != "open -> closed" {
+		t.Fatalf("expected only the reset to be reported, got %q", changes)
+	}
+	if state := b.state(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the reset breaker to stay closed, got %s", state)
+	}
+
+	// Replaced circuits leave no timers behind
+	for range 50 {
+		b.reset()
+	}
+	if n := fake.Waiting(); n != 1 {
+		t.Fatalf("expected only the interval timer of the current circuit, got %d timers", n)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when advanced, firing the
+// timers and tickers that come due.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a timer or, when period is set, a ticker of a fakeClock.
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	f      func()
+	at     time.Time
+	period time.Duration
+}
+
+// useFakeClock makes the proxy run on a fake clock until the test ends.
+func useFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	c := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	previous := clock
+	clock = c
+	t.Cleanup(func() { clock = previous })
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(&fakeTimer{c: make(chan time.Time, 1)}, d)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(&fakeTimer{c: make(chan time.Time, 1), period: d}, d)}
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(&fakeTimer{f: f}, d)
+}
+
+func (c *fakeClock) add(t *fakeTimer, d time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.clock = c
+	t.at = c.now.Add(d)
+	// Like time.Timer, fire at once when the time is already up
+	if d <= 0 && t.period == 0 {
+		if t.f != nil {
+			go t.f()
+		} else {
+			t.c <- c.now
+		}
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d, firing what comes due on the way
+// in order. AfterFunc functions have run by the time it returns.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.now = t.at
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+		if t.f != nil {
+			// Run it before returning, for tests to see what it did
+			c.mu.Unlock()
+			t.f()
+			c.mu.Lock()
+			continue
+		}
+		// Like time.Ticker, drop ticks a slow receiver misses
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Waiting returns how many timers and tickers are pending.
+func (c *fakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// waitFor waits for n timers or tickers to be pending on c, started by
+// goroutines the test runs, failing the test if they never are.
+func (c *fakeClock) waitFor(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); c.Waiting() < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d pending timers, got %d", n, c.Waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func TestFakeClock(t *testing.T) {
+	c := useFakeClock(t)
+	start := c.Now()
+
+	timer := clock.NewTimer(time.Minute)
+	ticker := clock.NewTicker(20 * time.Second)
+	fired := make(chan struct{})
+	clock.AfterFunc(30*time.Second, func() { close(fired) })
+
+	c.Advance(25 * time.Second)
+	select {
+	case now := <-ticker.C():
+		if want := start.Add(20 * time.Second); !now.Equal(want) {
+			t.Fatalf("expected a tick at %v, got %v", want, now)
+		}
+	default:
+		t.Fatal("expected the ticker to tick")
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("expected the timer not to fire early")
+	default:
+	}
+
+	c.Advance(35 * time.Second)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("expected the timer to fire")
+	}
+	<-fired
+	if got := clock.Now().Sub(start); got != time.Minute {
+		t.Fatalf("expected a minute to have passed, got %v", got)
+	}
+
+	if ticker.Stop(); c.Waiting() != 0 {
+		t.Fatalf("expected nothing pending, got %d", c.Waiting())
+	}
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Hour)
+		close(done)
+	}()
+	c.waitFor(t, 1)
+	c.Advance(time.Hour)
+	<-done
+}
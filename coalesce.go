@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescer is an http.RoundTripper collapsing concurrent identical reads
+// into a single call to next, keyed by method and URL and the tenant of the
+// request, if any. The shared response is held in memory and every caller
+// gets its own copy. A caller giving up does not cancel the shared call for
+// the others. Bodies over maxBody bytes are not held: they are streamed to
+// the caller whose call it was, and the others make calls of their own.
+type coalescer struct {
+	next    http.RoundTripper
+	name    string
+	maxBody int64
+	// metrics tells whether coalesced calls are recorded.
+	metrics bool
+	// key, when set, replaces shareKey in grouping requests.
+	key   func(*http.Request) string
+	group singleflight.Group
+}
+
+// sharedResponse is a response whose body has been read so that it can be
+// handed out more than once. The body of a stream was too large to read:
+// it is what was read of it, the rest being left in resp.
+type sharedResponse struct {
+	resp   *http.Response
+	body   []byte
+	stream bool
+}
+
+func (c *coalescer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !shareable(req) {
+		return c.next.RoundTrip(req)
+	}
+
+	key := shareKey(req)
+	if c.key != nil {
+		key = c.key(req)
+	}
+	led := false
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		led = true
+		resp, err := c.next.RoundTrip(req.WithContext(context.WithoutCancel(req.Context())))
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBody+1))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if int64(len(body)) > c.maxBody {
+			return &sharedResponse{resp: resp, body: body, stream: true}, nil
+		}
+		resp.Body.Close()
+		return &sharedResponse{resp: resp, body: body}, nil
+	})
+
+	select {
+	case <-req.Context().Done():
+		// A stream left to a caller gone is closed once it comes
+		go func() {
+			if res := <-ch; led && res.Err == nil && res.Val.(*sharedResponse).stream {
+				res.Val.(*sharedResponse).resp.Body.Close()
+			}
+		}()
+		return nil, req.Context().Err()
+	case res := <-ch:
+		if res.Err != nil {
+			if !led && c.metrics {
+				coalescedCount.WithLabelValues(c.name).Inc()
+			}
+			return nil, res.Err
+		}
+		shared := res.Val.(*sharedResponse)
+		if shared.stream {
+			if led {
+				resp := shared.resp
+				resp.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(shared.body), resp.Body), resp.Body}
+				return resp, nil
+			}
+			return c.next.RoundTrip(req)
+		}
+		if !led && c.metrics {
+			coalescedCount.WithLabelValues(c.name).Inc()
+		}
+		resp := *shared.resp
+		resp.Header = shared.resp.Header.Clone()
+		resp.Body = io.NopCloser(bytes.NewReader(shared.body))
+		resp.Request = req
+		return &resp, nil
+	}
+}
+
+// shareKey returns the key of the requests whose responses req may share:
+// its method and URL.
+func shareKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+// shareKey returns the key of the requests through t whose responses req
+// may share: its method and URL, and its tenant, whose responses are never
+// handed to other tenants.
+func (t *breakerTransport) shareKey(req *http.Request) string {
+	key := shareKey(req)
+	if t.tenants != nil {
+		if id, ok := t.tenants.tenantID(req); ok {
+			key += " " + id
+		}
+	}
+	return key
+}
+
+// shareable reports whether the response to req may be handed to other
+// requests: it is a safe read without a body or credentials, such as the
+// API key checked by the proxy or one left to the upstream to check.
+func shareable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.ContentLength != 0 {
+		return false
+	}
+	if _, ok := requestAPIKey(req.Context()); ok {
+		return false
+	}
+	for _, header := range []string{"Authorization", "Cookie", defaultAPIKeyHeader} {
+		if req.Header.Get(header) != "" {
+			return false
+		}
+	}
+	return true
+}
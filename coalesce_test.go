@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestCoalescer(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	c := &coalescer{name: "Coalesce Test", maxBody: 1024, next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		entered <- struct{}{}
+		<-release
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("shared"))}, nil
+	})}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		calls.Store(0)
+		var wg sync.WaitGroup
+		bodies := make([]string, 5)
+		get := func(i int, ctx context.Context) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid/items?id=1", nil).WithContext(ctx)
+			resp, err := c.RoundTrip(req)
+			if err != nil {
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		}
+
+		// The leader gives up while waiting, which must not fail the others
+		ctx, cancel := context.WithCancel(context.Background())
+		wg.Add(1)
+		go get(0, ctx)
+		<-entered
+		for i := 1; i < len(bodies); i++ {
+			wg.Add(1)
+			go get(i, context.Background())
+		}
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		close(release)
+		wg.Wait()
+
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("expected 1 upstream call, got %d", got)
+		}
+		for i, body := range bodies[1:] {
+			if body != "shared" {
+				t.Fatalf("expected request %d to get the shared body, got %q", i+2, body)
+			}
+		}
+	})
+
+	t.Run("NotCoalescable", func(t *testing.T) {
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodPost, "http://upstream.invalid/", strings.NewReader("x")),
+			func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)
+				req.Header.Set("Authorization", "Bearer token")
+				return req
+			}(),
+		} {
+			if shareable(req) {
+				t.Fatalf("expected %s request with headers %v not to be coalesced", req.Method, req.Header)
+			}
+		}
+		if !shareable(httptest.NewRequest(http.MethodHead, "http://upstream.invalid/", nil)) {
+			t.Fatalf("expected a plain HEAD request to be coalesced")
+		}
+	})
+}
+
+func TestCoalescerLargeBody(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	c := &coalescer{name: "Large Coalesce Test", maxBody: 4, next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if calls.Add(1) == 1 {
+			entered <- struct{}{}
+			<-release
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("too large to share"))}, nil
+	})}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	get := func(i int) {
+		defer wg.Done()
+		resp, err := c.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/export", nil))
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		bodies[i] = string(body)
+	}
+	wg.Add(1)
+	go get(0)
+	<-entered
+	for i := 1; i < len(bodies); i++ {
+		wg.Add(1)
+		go get(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// The body streams to the first caller and the others call for theirs
+	for i, body := range bodies {
+		if body != "too large to share" {
+			t.Fatalf("expected request %d to get the whole body, got %q", i+1, body)
+		}
+	}
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("expected every caller to make its own call, got %d calls", got)
+	}
+}
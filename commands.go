@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// version is the version of the binary, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// commands are the subcommands of the binary by name, each returning the
+// exit status.
+var commands map[string]func(args []string) int
+
+func init() {
+	commands = map[string]func(args []string) int{
+		"serve":        runServe,
+		"check-config": runCheckConfig,
+		"simulate":     runSimulate,
+		"loadtest":     runLoadtest,
+		"version":      runVersion,
+		"help":         runHelp,
+	}
+}
+
+const usage = `Usage: circuit-breaker-with-go [command] [flags]
+
+Commands:
+  serve         run the proxy, the default when no command is named
+  check-config  validate the config without starting anything
+  simulate      play the mock upstream's script against the breaker
+  loadtest      send requests at a steady rate through the breaker and report
+  version       print the version
+  help          print this help
+
+Run a command with -h for its flags.
+`
+
+// runCommand runs the subcommand named by the first of args, serve when
+// args start with a flag or are empty, returning the exit status.
+func runCommand(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+	return cmd(args)
+}
+
+func runHelp([]string) int {
+	fmt.Print(usage)
+	return 0
+}
+
+// runVersion prints the version with the commit it was built from, when
+// known.
+func runVersion([]string) int {
+	line := "circuit-breaker-with-go " + version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				line += " (" + s.Value + ")"
+			}
+		}
+		line += " " + info.GoVersion
+	}
+	fmt.Println(line)
+	return 0
+}
+
+// runCheckConfig validates the config file, layered with the environment
+// and setting flags as serve would, without starting the server or reading
+// Consul or etcd.
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to a YAML or JSON config file")
+	settings := registerSettingFlags(fs, defaultConfig())
+	fs.Parse(args)
+
+	if _, err := os.Stat(*configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := offlineConfig(*configPath, settings); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", *configPath)
+	return 0
+}
+
+// offlineConfig resolves the config as serve does from the file at path,
+// but for Consul and etcd, and validates it.
+func offlineConfig(path string, settings *settingFlags) (Config, error) {
+	file := &fileProvider{path: path}
+	if err := file.Load(context.Background()); err != nil {
+		return Config{}, err
+	}
+	cfg, err := layerConfig([]ConfigProvider{file, &profileProvider{}}, settings)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
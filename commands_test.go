@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	if status := runCommand([]string{"no-such-command"}); status != 2 {
+		t.Fatalf("expected an unknown command to exit 2, got %d", status)
+	}
+	if status := runCommand([]string{"version"}); status != 0 {
+		t.Fatalf("expected version to exit 0, got %d", status)
+	}
+
+	dir := t.TempDir()
+	valid, invalid := filepath.Join(dir, "valid.yaml"), filepath.Join(dir, "invalid.yaml")
+	os.WriteFile(valid, []byte("retry:\n  attempts: 2\n"), 0o644)
+	os.WriteFile(invalid, []byte("retry:\n  attempts: 0\n"), 0o644)
+	if status := runCommand([]string{"check-config", "--config", valid}); status != 0 {
+		t.Fatalf("expected a valid config to pass, got %d", status)
+	}
+	for _, args := range [][]string{
+		{"check-config", "--config", invalid},
+		{"check-config", "--config", valid, "--retry.attempts", "0"},
+		{"check-config", "--config", filepath.Join(dir, "missing.yaml")},
+	} {
+		if status := runCommand(args); status != 1 {
+			t.Fatalf("expected %v to fail, got %d", args, status)
+		}
+	}
+
+	// Settings are layered as serve does, for the errors to name them
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	settings := registerSettingFlags(fs, defaultConfig())
+	if err := fs.Parse([]string{"--breaker.failure_ratio", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := offlineConfig(valid, settings); err == nil || !strings.Contains(err.Error(), "breaker.failure_ratio") {
+		t.Fatalf("expected an error naming breaker.failure_ratio, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errConcurrencyLimit is returned for calls turned away because the
+// adaptive concurrency limit was reached.
+var errConcurrencyLimit = errors.New("upstream concurrency limit reached")
+
+// concurrencyBaselineAlpha weighs each call's latency in the baseline the
+// limiter compares later calls with.
+const concurrencyBaselineAlpha = 0.05
+
+// concurrencyLimiter bounds the calls in flight by a limit it adapts to the
+// upstream: the limit grows by one for every limit calls that succeed while
+// it is in use, and shrinks by ConcurrencyBackoff whenever a call fails or
+// takes more than ConcurrencyLatencyTolerance times the usual latency. A
+// nil limiter bounds nothing.
+type concurrencyLimiter struct {
+	name      string
+	min, max  float64
+	backoff   float64
+	tolerance float64
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	baseline float64
+}
+
+// newConcurrencyLimiter returns the limiter described by cfg, or nil when
+// AdaptiveConcurrency is off.
+func newConcurrencyLimiter(cfg BreakerConfig) *concurrencyLimiter {
+	if !cfg.AdaptiveConcurrency {
+		return nil
+	}
+	l := &concurrencyLimiter{
+		name:      cfg.Name,
+		min:       float64(max(cfg.ConcurrencyMin, 1)),
+		backoff:   cfg.ConcurrencyBackoff,
+		tolerance: cfg.ConcurrencyLatencyTolerance,
+	}
+	l.max = max(float64(cfg.ConcurrencyMax), l.min)
+	l.limit = min(max(float64(cfg.ConcurrencyInitial), l.min), l.max)
+	observeConcurrencyLimit(l.name, l.limit)
+	return l
+}
+
+// acquire reports whether another call may start, counting it in flight
+// if so.
+func (l *concurrencyLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// release ends a call started by acquire and adapts the limit to how it
+// went. Calls that never reached the upstream leave the limit alone.
+func (l *concurrencyLimiter) release(latency time.Duration, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inflight := l.inflight
+	l.inflight--
+	if isRejection(err) {
+		return
+	}
+
+	sample := float64(latency)
+	if l.baseline == 0 {
+		l.baseline = sample
+	}
+	limit := l.limit
+	switch {
+	case !isSuccessful(err) || sample > l.tolerance*l.baseline:
+		limit = max(limit*l.backoff, l.min)
+	case 2*inflight >= int(limit):
+		// Only grow a limit that is actually being used
+		limit = min(limit+1/limit, l.max)
+	}
+	l.baseline += concurrencyBaselineAlpha * (sample - l.baseline)
+	if limit != l.limit {
+		l.limit = limit
+		observeConcurrencyLimit(l.name, limit)
+	}
+}
+
+// current returns the current limit.
+func (l *concurrencyLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter(BreakerConfig{
+		Name:                        "Concurrency Limiter Test",
+		AdaptiveConcurrency:         true,
+		ConcurrencyInitial:          4,
+		ConcurrencyMin:              2,
+		ConcurrencyMax:              6,
+		ConcurrencyBackoff:          0.5,
+		ConcurrencyLatencyTolerance: 2,
+	})
+
+	for i := 0; i < 4; i++ {
+		if !l.acquire() {
+			t.Fatalf("expected call %d to fit the initial limit", i+1)
+		}
+	}
+	if l.acquire() {
+		t.Fatal("expected calls beyond the limit to be turned away")
+	}
+
+	// Fast successes under full use grow the limit up to the maximum
+	for i := 0; i < 100; i++ {
+		l.release(10*time.Millisecond, nil)
+		l.acquire()
+	}
+	if got := l.current(); got != 6 {
+		t.Fatalf("expected the limit to grow to 6, got %d", got)
+	}
+
+	// Failures and slow calls shrink it down to the minimum
+	l.release(10*time.Millisecond, errors.New("simulated failure"))
+	if got := l.current(); got != 3 {
+		t.Fatalf("expected a failure to halve the limit, got %d", got)
+	}
+	l.acquire()
+	l.release(time.Second, nil)
+	if got := l.current(); got != 2 {
+		t.Fatalf("expected a slow call to shrink the limit to its minimum, got %d", got)
+	}
+
+	// Rejected calls never reached the upstream
+	l.acquire()
+	l.release(time.Second, gobreaker.ErrOpenState)
+	if got := l.current(); got != 2 {
+		t.Fatalf("expected rejections to leave the limit alone, got %d", got)
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                        "Adaptive Concurrency Test",
+		AdaptiveConcurrency:         true,
+		ConcurrencyInitial:          1,
+		ConcurrencyMin:              1,
+		ConcurrencyMax:              1,
+		ConcurrencyBackoff:          0.9,
+		ConcurrencyLatencyTolerance: 2,
+	}, RetryConfig{})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := b.execute(b.current(), func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		done <- err
+	}()
+	<-started
+
+	_, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil })
+	if !errors.Is(err, errConcurrencyLimit) || !isRejection(err) {
+		t.Fatalf("expected the call to be turned away at the limit, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected calls to go through below the limit, got %v", err)
+	}
+}
@@ -0,0 +1,1069 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every runtime setting of the service.
+type Config struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// TLS serves ListenAddr over TLS when a certificate is configured.
+	TLS TLSConfig `yaml:"tls"`
+	// AdminAddr, when set, is the address /metrics, /status, /events, the
+	// /healthz and /readyz probes and the admin endpoints are served on
+	// instead of ListenAddr, keeping them away from the clients of /api.
+	AdminAddr string `yaml:"admin_addr"`
+	// GRPCAddr, when set, is the address the gRPC control plane described
+	// in control.proto is served on.
+	GRPCAddr string `yaml:"grpc_addr"`
+	// Upstream, like each of Upstreams, is an http or https URL, or
+	// unix:///path/to.sock for an HTTP server listening on a Unix socket.
+	Upstream string `yaml:"upstream"`
+	// UpstreamTLS holds the TLS settings of calls to every upstream.
+	UpstreamTLS UpstreamTLSConfig `yaml:"upstream_tls"`
+	// Transport holds the connection settings of calls to every upstream.
+	Transport TransportConfig `yaml:"transport"`
+	// WarmUp opens connections to the upstreams before reporting ready.
+	WarmUp WarmUpConfig `yaml:"warm_up"`
+	DNS    DNSConfig    `yaml:"dns"`
+	// Upstreams are further upstreams, each guarded by its own breaker named
+	// after the breaker and the upstream's host. With failover balance they
+	// take the traffic in order while the breakers before them are open.
+	Upstreams []string `yaml:"upstreams"`
+	// Routes are further paths, each proxied to its own upstream through its
+	// own breaker. Requests to other paths below /api go to Upstream.
+	Routes []RouteConfig `yaml:"routes"`
+	// Balance selects how calls are spread over Upstream and Upstreams.
+	Balance      Balance            `yaml:"balance"`
+	Outlier      OutlierConfig      `yaml:"outlier"`
+	HealthCheck  HealthCheckConfig  `yaml:"health_check"`
+	SelfCheck    SelfCheckConfig    `yaml:"self_check"`
+	Breaker      BreakerConfig      `yaml:"breaker"`
+	Retry        RetryConfig        `yaml:"retry"`
+	Shadow       ShadowConfig       `yaml:"shadow"`
+	Proxy        ProxyConfig        `yaml:"proxy"`
+	Auth         AuthConfig         `yaml:"auth"`
+	AdminAuth    AdminAuthConfig    `yaml:"admin_auth"`
+	Audit        AuditConfig        `yaml:"audit"`
+	RateLimit    RateLimitConfig    `yaml:"rate_limit"`
+	Outbound     OutboundConfig     `yaml:"outbound"`
+	Shed         ShedConfig         `yaml:"shed"`
+	Maintenance  MaintenanceConfig  `yaml:"maintenance"`
+	Faults       FaultConfig        `yaml:"faults"`
+	MockUpstream MockUpstreamConfig `yaml:"mock_upstream"`
+	Readiness    ReadinessConfig    `yaml:"readiness"`
+	Tenant       TenantConfig       `yaml:"tenant"`
+	Redis        RedisConfig        `yaml:"redis"`
+	Profiles     ProfilesConfig     `yaml:"profiles"`
+	Consul       ConsulConfig       `yaml:"consul"`
+	Etcd         EtcdConfig         `yaml:"etcd"`
+	Notify       NotifyConfig       `yaml:"notify"`
+	Log          LogConfig          `yaml:"log"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	// ShutdownGrace is how long requests in flight are given to finish on
+	// SIGTERM or SIGINT before their connections are closed.
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"`
+	// EventHistory is how many of the latest state changes and runs of
+	// rejections /events/history keeps. It is not reloaded.
+	EventHistory int `yaml:"event_history"`
+	// ReloadInterval is how often the config file is checked for changes,
+	// including the symlink swaps of a mounted Kubernetes ConfigMap.
+	// Zero disables polling; a SIGHUP always triggers a reload.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// LogConfig holds the logging settings.
+type LogConfig struct {
+	Format LogFormat `yaml:"format"`
+	// Level is the lowest level logged: debug, info, warn or error. Debug
+	// logs every upstream attempt, backoff and breaker decision.
+	Level slog.Level `yaml:"level"`
+	// Access logs a line per /api request. AccessSample is the share of
+	// requests, from 0 to 1, logged; server errors are always logged.
+	Access       bool    `yaml:"access"`
+	AccessSample float64 `yaml:"access_sample"`
+}
+
+// BreakerConfig holds the circuit breaker settings.
+type BreakerConfig struct {
+	Name        string        `yaml:"name"`
+	MaxRequests uint32        `yaml:"max_requests"`
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// Trip selects the condition opening the breaker.
+	Trip TripPolicy `yaml:"trip"`
+	// ConsecutiveFailures is the number of consecutive failures that must be
+	// exceeded before the breaker trips.
+	ConsecutiveFailures uint32 `yaml:"consecutive_failures"`
+	// FailureRatio is the share of failed calls, from 0 to 1, that must be
+	// exceeded out of at least MinRequests calls for the failure_ratio
+	// policy to trip the breaker. Calls are counted since the last state
+	// change or Interval reset, or over the last Window when it is set.
+	FailureRatio float64 `yaml:"failure_ratio"`
+	MinRequests  uint32  `yaml:"min_requests"`
+	// AdaptiveMargin and AdaptiveLatencyFactor set how far the recent error
+	// rate and latency may rise above the learned baseline before the
+	// adaptive policy trips the breaker, after MinRequests calls.
+	AdaptiveMargin        float64 `yaml:"adaptive_margin"`
+	AdaptiveLatencyFactor float64 `yaml:"adaptive_latency_factor"`
+	// SLOTarget is the share of successful calls promised, such as 0.999.
+	// The burn_rate policy trips the breaker when errors spend the budget
+	// it leaves more than BurnRate times faster than sustainable over both
+	// BurnLongWindow and BurnShortWindow. A BurnRate of 14.4 over an hour
+	// spends 2% of a 30-day budget.
+	SLOTarget       float64       `yaml:"slo_target"`
+	BurnRate        float64       `yaml:"burn_rate"`
+	BurnLongWindow  time.Duration `yaml:"burn_long_window"`
+	BurnShortWindow time.Duration `yaml:"burn_short_window"`
+	// Window, when set, makes trip decisions and the status count the calls
+	// of the last Window, in whole seconds, instead of those since gobreaker
+	// last cleared its counts.
+	Window time.Duration `yaml:"window"`
+	// SlowCallThreshold, when set, counts calls that succeed but take
+	// longer than it as failures, so the breaker also opens on a degrading
+	// upstream. Their responses are still used.
+	SlowCallThreshold time.Duration `yaml:"slow_call_threshold"`
+	// DryRun tracks the breaker's state and reports it as usual, but lets
+	// through the calls it would reject, counting them instead, so
+	// thresholds can be tuned before they are enforced.
+	DryRun bool `yaml:"dry_run"`
+	// RejectStatus is the status code answered when the breaker rejects a
+	// request, usually 429 or 503.
+	RejectStatus int `yaml:"reject_status"`
+	// MaxConcurrent bounds the upstream calls in flight through the breaker.
+	// Further calls wait up to BulkheadWait for one to finish and are then
+	// answered with BulkheadStatus. Requests marked high priority in their
+	// X-Request-Priority header get freed slots first and low-priority ones
+	// do not wait. Zero means no bound.
+	MaxConcurrent  int           `yaml:"max_concurrent"`
+	BulkheadWait   time.Duration `yaml:"bulkhead_wait"`
+	BulkheadStatus int           `yaml:"bulkhead_status"`
+	// AdaptiveConcurrency bounds the calls in flight by a limit between
+	// ConcurrencyMin and ConcurrencyMax, starting at ConcurrencyInitial,
+	// that grows while calls succeed and shrinks by ConcurrencyBackoff when
+	// one fails or takes more than ConcurrencyLatencyTolerance times the
+	// usual latency. Calls beyond it are answered with BulkheadStatus.
+	AdaptiveConcurrency         bool    `yaml:"adaptive_concurrency"`
+	ConcurrencyInitial          int     `yaml:"concurrency_initial"`
+	ConcurrencyMin              int     `yaml:"concurrency_min"`
+	ConcurrencyMax              int     `yaml:"concurrency_max"`
+	ConcurrencyBackoff          float64 `yaml:"concurrency_backoff"`
+	ConcurrencyLatencyTolerance float64 `yaml:"concurrency_latency_tolerance"`
+	// HalfOpenQueue, when above zero, lets up to that many calls wait for a
+	// trial slot once MaxRequests calls are in flight in the half-open state,
+	// instead of failing at once. They give up after HalfOpenQueueWait.
+	HalfOpenQueue     int           `yaml:"half_open_queue"`
+	HalfOpenQueueWait time.Duration `yaml:"half_open_queue_wait"`
+	// ProbePath, when set, keeps live requests out of the half-open state:
+	// every ProbeInterval while the breaker is not closed a synthetic GET
+	// of ProbePath below the upstream is sent through it instead, and only
+	// the probes decide whether it closes again.
+	ProbePath     string        `yaml:"probe_path"`
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+	ProbeTimeout  time.Duration `yaml:"probe_timeout"`
+	// SlowStart ramps traffic back up after the breaker closes from
+	// half-open: 10% of calls go through at first, growing linearly to all
+	// of them over SlowStart. The rest are rejected. Zero disables it.
+	SlowStart time.Duration `yaml:"slow_start"`
+	// PartialOpen is the share of calls, from 0 to 1, still let through
+	// while the breaker is open to keep measuring the upstream's health.
+	PartialOpen float64 `yaml:"partial_open"`
+	// SplitMethods guards writes, any calls but GET, HEAD and OPTIONS, with
+	// breakers of their own named after these with a " writes" suffix, so
+	// failing writes do not open the circuit for reads. Changing it takes
+	// effect after a restart.
+	SplitMethods bool `yaml:"split_methods"`
+}
+
+// RetryConfig holds the retry policy for upstream calls.
+type RetryConfig struct {
+	Attempts int `yaml:"attempts"`
+	// Backoff selects how the delay between attempts grows from BackoffMin
+	// up to BackoffMax.
+	Backoff    BackoffStrategy `yaml:"backoff"`
+	BackoffMin time.Duration   `yaml:"backoff_min"`
+	BackoffMax time.Duration   `yaml:"backoff_max"`
+	// JitterFloor is the share of the exponential backoff's ceiling its
+	// random delay never goes below, so that retries are not sent right
+	// away. JitterSeed seeds the random delays of all strategies, and the
+	// picks of the partial-open and slow-start breakers, for reproducible
+	// runs, or randomly when zero; it is not reloaded.
+	JitterFloor float64 `yaml:"jitter_floor"`
+	JitterSeed  int64   `yaml:"jitter_seed"`
+	// RetryAfterMax caps the delay honoured from an upstream Retry-After
+	// header on 429 and 503 responses.
+	RetryAfterMax time.Duration `yaml:"retry_after_max"`
+	// AttemptTimeout bounds each upstream call, from sending the request
+	// until its response body is read, and RequestTimeout the request as a
+	// whole including retries and backoff. Zero means no bound.
+	AttemptTimeout time.Duration `yaml:"attempt_timeout"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MaxElapsed bounds the total time spent on a request's attempts and
+	// backoff; no further attempt starts once waiting would exceed it. Zero
+	// means no bound.
+	MaxElapsed time.Duration `yaml:"max_elapsed"`
+	// BudgetRatio caps retries across the whole service at this fraction of
+	// the requests seen over BudgetWindow, plus BudgetMinPerSecond retries
+	// per second. Zero disables the budget.
+	BudgetRatio        float64       `yaml:"budget_ratio"`
+	BudgetMinPerSecond float64       `yaml:"budget_min_per_second"`
+	BudgetWindow       time.Duration `yaml:"budget_window"`
+	// RetryPOST allows retrying POST requests, which are otherwise only
+	// retried when they carry an Idempotency-Key header.
+	RetryPOST bool `yaml:"retry_post"`
+	// MaxBufferedBody is the largest request body, in bytes, kept in memory
+	// to be replayed on retries. Larger requests are not retried.
+	MaxBufferedBody int64 `yaml:"max_buffered_body"`
+	// Hedge sends a second copy of a retryable request when the first has
+	// not answered within the HedgePercentile of recent upstream latencies,
+	// or within HedgeDelay until enough latencies are known. The first
+	// successful answer is used and the other call canceled.
+	Hedge           bool          `yaml:"hedge"`
+	HedgePercentile float64       `yaml:"hedge_percentile"`
+	HedgeDelay      time.Duration `yaml:"hedge_delay"`
+}
+
+// OutlierConfig holds the settings of outlier detection over the upstreams.
+type OutlierConfig struct {
+	// FailureMargin ejects an upstream once its failure rate over the
+	// current Interval exceeds the average of its peers by this much, after
+	// at least MinRequests calls. Ejected upstreams get no calls for
+	// Ejection unless no other upstream is left. It is meant for the
+	// round_robin balance, where upstreams share the calls. Zero disables
+	// ejection.
+	FailureMargin float64       `yaml:"failure_margin"`
+	MinRequests   int           `yaml:"min_requests"`
+	Interval      time.Duration `yaml:"interval"`
+	Ejection      time.Duration `yaml:"ejection"`
+}
+
+// HealthCheckConfig holds the settings of the active health checks of the
+// upstreams.
+type HealthCheckConfig struct {
+	// Path is requested below every upstream each Interval. A status below
+	// 400 within Timeout passes. Health checks are disabled when empty.
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// An upstream turns unhealthy after UnhealthyThreshold failed checks in
+	// a row, and healthy again after HealthyThreshold passed ones.
+	HealthyThreshold   int `yaml:"healthy_threshold"`
+	UnhealthyThreshold int `yaml:"unhealthy_threshold"`
+}
+
+// WarmUpConfig holds the connections opened to every upstream at startup,
+// before /readyz reports ready.
+type WarmUpConfig struct {
+	// Connections is how many requests are sent to each upstream at once,
+	// leaving up to Transport.MaxIdleConnsPerHost connections idle. None
+	// are when zero.
+	Connections int `yaml:"connections"`
+	// Path is requested below every upstream.
+	Path string `yaml:"path"`
+	// Timeout bounds the whole warm-up.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// SelfCheckConfig holds the probes sent to every upstream at startup, to
+// catch misconfigured URLs before traffic arrives.
+type SelfCheckConfig struct {
+	// Probes is how many requests each upstream is sent, none when zero.
+	Probes int `yaml:"probes"`
+	// Path is requested below every upstream. Any answer but a server
+	// error within Timeout passes.
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Require refuses to start when no probe gets through to an upstream,
+	// probing once if Probes is zero. --require-upstream sets it.
+	Require bool `yaml:"require"`
+}
+
+// TLSConfig holds the TLS settings of the server.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files holding the server's certificate
+	// chain and private key. TLS is disabled when CertFile is empty.
+	CertFile   string     `yaml:"cert_file"`
+	KeyFile    string     `yaml:"key_file"`
+	MinVersion TLSVersion `yaml:"min_version"`
+	// CipherSuites restricts the cipher suites of TLS 1.2 and below to
+	// those named, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. The
+	// suites of TLS 1.3 cannot be configured.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+// RouteConfig maps a path to an upstream.
+type RouteConfig struct {
+	// Path is the prefix of the paths served, such as /api/users. It is
+	// replaced by the path of Upstream.
+	Path     string `yaml:"path"`
+	Upstream string `yaml:"upstream"`
+	// Breaker overrides settings of the top-level breaker for the route.
+	// The route's breaker is named after the top-level one and the path
+	// unless it is given a name.
+	Breaker yaml.Node `yaml:"breaker"`
+}
+
+// UpstreamTLSConfig holds the TLS settings of upstream calls, for
+// upstreams that require mutual TLS or use a private CA.
+type UpstreamTLSConfig struct {
+	// CertFile and KeyFile are PEM files holding the client certificate
+	// chain and private key presented to upstreams.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CAFile is a PEM bundle of the CAs trusted to sign upstream
+	// certificates instead of the system's.
+	CAFile string `yaml:"ca_file"`
+	// ServerName overrides the name upstream certificates are verified
+	// against and sent in SNI, which is otherwise the upstream's host.
+	ServerName string `yaml:"server_name"`
+}
+
+// TransportConfig holds the connection settings of upstream calls. Zero
+// timeouts and limits mean none.
+type TransportConfig struct {
+	// Protocol selects between HTTP/1.1 and HTTP/2: auto, http1 or h2c.
+	Protocol    UpstreamProtocol `yaml:"protocol"`
+	DialTimeout time.Duration    `yaml:"dial_timeout"`
+	// KeepAlive is the interval of TCP keep-alive probes; negative
+	// disables them.
+	KeepAlive           time.Duration `yaml:"keep_alive"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+	// ResponseHeaderTimeout bounds the wait for the response headers once
+	// the request is sent.
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	ExpectContinueTimeout time.Duration `yaml:"expect_continue_timeout"`
+	// MaxIdleConns and MaxIdleConnsPerHost bound the idle connections kept
+	// for reuse, in total and per upstream, for up to IdleConnTimeout.
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	// MaxConnsPerHost bounds the connections to each upstream, whether in
+	// use or idle. Further calls wait for one to free up.
+	MaxConnsPerHost int `yaml:"max_conns_per_host"`
+}
+
+// DNSConfig holds the caching of upstream host names. Failures to resolve
+// them are reported apart from upstream failures and never trip a breaker.
+type DNSConfig struct {
+	// TTL is how long resolved addresses are used before being resolved
+	// again. Zero disables caching.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxStale is how much longer expired addresses are used while the
+	// resolver fails.
+	MaxStale time.Duration `yaml:"max_stale"`
+}
+
+// ShedConfig holds the limits on the process's own resource use beyond
+// which /api requests are turned away. A zero limit is not enforced.
+type ShedConfig struct {
+	// MaxCPU is the share, from 0 to 1, of the CPU available to the process
+	// it may use.
+	MaxCPU float64 `yaml:"max_cpu"`
+	// MaxMemory is the size in bytes the heap may grow to.
+	MaxMemory     uint64 `yaml:"max_memory"`
+	MaxGoroutines uint64 `yaml:"max_goroutines"`
+	// Interval is how often resource use is sampled.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// enabled reports whether any limit is set.
+func (cfg ShedConfig) enabled() bool {
+	return cfg.MaxCPU > 0 || cfg.MaxMemory > 0 || cfg.MaxGoroutines > 0
+}
+
+// MaintenanceConfig holds the settings of the maintenance mode operators
+// turn on through POST /admin/maintenance/on.
+type MaintenanceConfig struct {
+	// Message answers /api requests when none is given with the request.
+	Message string `yaml:"message"`
+	// Windows are planned upstream downtimes, which breakers go through
+	// without tripping or alerting.
+	Windows []MaintenanceWindow `yaml:"windows"`
+	// Timezone is the time zone the schedules of Windows are read in.
+	Timezone Timezone `yaml:"timezone"`
+}
+
+// MaintenanceWindow is a planned downtime starting at every minute matched
+// by Schedule and lasting Duration.
+type MaintenanceWindow struct {
+	Schedule Schedule      `yaml:"schedule"`
+	Duration time.Duration `yaml:"duration"`
+	// Action is what breakers do during the window, open when empty.
+	Action WindowAction `yaml:"action"`
+	// Breakers names the breakers going through the window, all of them
+	// but tenants' when empty.
+	Breakers []string `yaml:"breakers"`
+}
+
+// MockUpstreamConfig scripts the fake upstream --mock-upstream proxies to
+// instead of Upstream, and the simulate and loadtest commands play against
+// the breaker, to demo and test breakers without a real one.
+type MockUpstreamConfig struct {
+	// Steps are played in turn, each answering its number of requests.
+	Steps []MockStep `yaml:"steps"`
+	// Loop starts the steps over after the last, which otherwise keeps
+	// answering once its requests are played.
+	Loop bool `yaml:"loop"`
+}
+
+// MockStep is a stretch of the mock upstream's script.
+type MockStep struct {
+	// Requests is how many requests the step answers, all that come when
+	// zero, which only the last step may be.
+	Requests int `yaml:"requests"`
+	// Status answers the requests, 200 when zero.
+	Status int `yaml:"status"`
+	// Latency delays the answers, ramping up or down to LatencyTo over the
+	// step's requests when LatencyTo is set.
+	Latency   time.Duration `yaml:"latency"`
+	LatencyTo time.Duration `yaml:"latency_to"`
+}
+
+// validate checks every step but the last has requests and answers with
+// an HTTP status.
+func (cfg MockUpstreamConfig) validate() error {
+	for i, step := range cfg.Steps {
+		if step.Requests < 0 || step.Requests == 0 && i < len(cfg.Steps)-1 {
+			return fmt.Errorf("mock upstream step %d needs a positive number of requests", i+1)
+		}
+		if step.Status != 0 && (step.Status < 100 || step.Status > 599) {
+			return fmt.Errorf("mock upstream step %d: status %d is not an HTTP status", i+1, step.Status)
+		}
+	}
+	return nil
+}
+
+// FaultConfig holds the faults injected into upstream calls to rehearse
+// breaker behaviour, for staging rather than production. They can be
+// turned on and off at runtime through /admin/faults.
+type FaultConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ErrorRate is the share, from 0 to 1, of calls failed with an error
+	// without reaching the upstream.
+	ErrorRate float64 `yaml:"error_rate"`
+	// StatusRate is the share of the other calls answered with Status
+	// without reaching the upstream.
+	StatusRate float64 `yaml:"status_rate"`
+	Status     int     `yaml:"status"`
+	// Latency is added to the LatencyRate share of calls.
+	Latency     time.Duration `yaml:"latency"`
+	LatencyRate float64       `yaml:"latency_rate"`
+}
+
+// validate checks the rates are shares and the status an HTTP one.
+func (cfg FaultConfig) validate() error {
+	for _, rate := range []float64{cfg.ErrorRate, cfg.StatusRate, cfg.LatencyRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("fault rate %v is not between 0 and 1", rate)
+		}
+	}
+	if cfg.Status < 100 || cfg.Status > 599 {
+		return fmt.Errorf("fault status %d is not an HTTP status", cfg.Status)
+	}
+	return nil
+}
+
+// ReadinessConfig lets /readyz report not ready while critical breakers
+// stay open, so that orchestrators route traffic to healthier replicas.
+type ReadinessConfig struct {
+	// OpenThreshold is how long a critical breaker may stay out of the
+	// closed state, open or probing half-open, before the process reports
+	// not ready. Zero leaves readiness to the listeners alone.
+	OpenThreshold time.Duration `yaml:"open_threshold"`
+	// Critical names the breakers that count, the main breaker when empty.
+	Critical []string `yaml:"critical"`
+}
+
+// AuthConfig holds the API keys /api requests must present. Requests are
+// not authenticated when there are none.
+type AuthConfig struct {
+	// Header names the request header carrying the key.
+	Header string   `yaml:"header"`
+	Keys   []string `yaml:"keys"`
+	// KeysFile names a file of further keys, one per line. Blank lines and
+	// lines starting with # are ignored.
+	KeysFile string `yaml:"keys_file"`
+}
+
+// AdminAuthConfig holds the keys verifying the JWTs that callers of the
+// /admin endpoints and of the gRPC control plane must present as bearer
+// tokens. Callers are not authenticated when no key is set. Changes take
+// effect after a restart.
+type AdminAuthConfig struct {
+	// JWTSecret verifies HS256 tokens.
+	JWTSecret string `yaml:"jwt_secret"`
+	// JWTPublicKeyFile names a PEM file holding the RSA public key verifying
+	// RS256 tokens.
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+	// JWTIssuer and JWTAudience, when set, must match the iss and aud
+	// claims of tokens.
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience"`
+}
+
+// AuditConfig holds the settings of the audit log of operator actions,
+// served at /admin/audit. Changes take effect after a restart.
+type AuditConfig struct {
+	// File is appended a JSON line per action. They go to stdout when it is
+	// not set.
+	File string `yaml:"file"`
+}
+
+// RedisConfig holds the Redis server replicas share the counts and open
+// state of their breakers through, by breaker name, and broadcast their
+// trips on. Changes take effect
+// after a restart.
+type RedisConfig struct {
+	// Addr is the host:port of the server; state is not shared when empty.
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// KeyPrefix starts the names of the keys used.
+	KeyPrefix string `yaml:"key_prefix"`
+	// SyncInterval is how often each breaker's calls are flushed and the
+	// shared state read back.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	// Timeout bounds connecting to the server and each exchange with it.
+	Timeout time.Duration `yaml:"timeout"`
+	// Broadcast publishes the trips of breakers to the other replicas,
+	// whose breakers of the same name respond with PeerAction for their
+	// Timeout. PeerThresholdFactor scales the trip thresholds of breakers
+	// tightened in response.
+	Broadcast           bool       `yaml:"broadcast"`
+	PeerAction          PeerAction `yaml:"peer_action"`
+	PeerThresholdFactor float64    `yaml:"peer_threshold_factor"`
+}
+
+// ShadowConfig holds candidate settings of the main breaker, evaluated on
+// the same calls by a shadow breaker that never rejects any.
+type ShadowConfig struct {
+	// Breaker holds the settings changed from the breaker section, like
+	// it. No shadow runs when it is empty.
+	Breaker yaml.Node `yaml:"breaker"`
+}
+
+// ProfilesConfig holds breaker and retry settings that take effect on a
+// schedule, such as stricter thresholds during business hours, over those
+// of the config file, Consul and etcd.
+type ProfilesConfig struct {
+	// Timezone is the time zone the schedules are read in.
+	Timezone Timezone `yaml:"timezone"`
+	// Schedule lists the profiles. The first whose schedule matches the
+	// current minute is in effect, none when no schedule does.
+	Schedule []ProfileConfig `yaml:"schedule"`
+}
+
+// ProfileConfig is a set of breaker and retry settings in effect during
+// every minute matched by Schedule, such as "* 9-17 * * MON-FRI" for
+// business hours.
+type ProfileConfig struct {
+	Name     string   `yaml:"name"`
+	Schedule Schedule `yaml:"schedule"`
+	// Breaker and Retry hold the settings changed, like the breaker and
+	// retry sections.
+	Breaker yaml.Node `yaml:"breaker"`
+	Retry   yaml.Node `yaml:"retry"`
+}
+
+// ConsulConfig holds the Consul KV key breaker and retry settings are read
+// from, over those of the config file, and watched for changes. Changes to
+// these settings take effect after a restart.
+type ConsulConfig struct {
+	// Addr is the URL of the Consul HTTP API.
+	Addr string `yaml:"addr"`
+	// Key holds a YAML or JSON document with breaker and retry sections;
+	// Consul is not used when it is empty.
+	Key   string `yaml:"key"`
+	Token string `yaml:"token"`
+	// Wait bounds each blocking query watching the key.
+	Wait time.Duration `yaml:"wait"`
+}
+
+// EtcdConfig holds the etcd key breaker and retry settings are read from,
+// over those of the config file and Consul, and watched for changes.
+// Changes to these settings take effect after a restart.
+type EtcdConfig struct {
+	// Endpoint is the URL of an etcd member's v3 JSON gateway.
+	Endpoint string `yaml:"endpoint"`
+	// Key holds a YAML or JSON document with breaker and retry sections;
+	// etcd is not used when it is empty.
+	Key      string `yaml:"key"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Timeout bounds each request but the watch.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// RateLimitConfig holds the limit on the /api requests of each client IP.
+type RateLimitConfig struct {
+	// Rate is the sustained number of requests per second a client may
+	// make, up to Burst at once. Zero disables the limit.
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+	// TrustForwarded identifies clients by their X-Forwarded-For header,
+	// for when the service runs behind TrustedProxies trusted proxies or
+	// load balancers, each appending the address it got the request from.
+	// The address appended by the outermost of them is the client's: the
+	// entries before it are whatever the client sent.
+	TrustForwarded bool `yaml:"trust_forwarded"`
+	TrustedProxies int  `yaml:"trusted_proxies"`
+}
+
+// OutboundConfig holds the cap on the rate of calls to the upstreams.
+type OutboundConfig struct {
+	// Rate is the number of calls per second, retries included, made to the
+	// upstreams altogether, up to Burst at once. Calls wait up to Wait for
+	// their turn and are answered with the BulkheadStatus of their breaker
+	// beyond it, without counting as failures. Zero disables the cap.
+	Rate  float64       `yaml:"rate"`
+	Burst int           `yaml:"burst"`
+	Wait  time.Duration `yaml:"wait"`
+}
+
+// TenantConfig holds how calls are split by tenant.
+type TenantConfig struct {
+	// Header, when set, names the request header identifying the tenant,
+	// such as X-API-Key. Each tenant's calls then go through breakers of its
+	// own, named after the breakers and the tenant, so one tenant opening
+	// its circuit does not block the others. Changing it takes effect after
+	// a restart.
+	Header string `yaml:"header"`
+	// Hash, on by default, identifies tenants by a digest of the header
+	// instead, keeping API keys out of breaker names, events and metric
+	// labels. Tenants identified by the API key header are always hashed.
+	Hash bool `yaml:"hash"`
+	// MaxTenants bounds the tenants given breakers of their own, at most
+	// maxTenants. Calls of further tenants go through the breakers of calls
+	// without the header.
+	MaxTenants int `yaml:"max_tenants"`
+}
+
+// ProxyConfig holds settings of the proxy in front of the breaker.
+type ProxyConfig struct {
+	// Coalesce collapses concurrent GET and HEAD requests for the same URL
+	// into a single upstream call whose response is shared, if its body is
+	// at most CacheMaxBody bytes. Requests carrying credentials are never
+	// coalesced.
+	Coalesce bool `yaml:"coalesce"`
+	// FallbackBody, when set, answers requests the breaker rejected or whose
+	// upstream calls all failed instead of the default error. It is a Go
+	// text/template given the breaker name, state, reason, error and
+	// Retry-After seconds. FallbackStatus overrides the status code, which
+	// is otherwise RejectStatus for rejections and 503 for failures.
+	FallbackBody        string `yaml:"fallback_body"`
+	FallbackContentType string `yaml:"fallback_content_type"`
+	FallbackStatus      int    `yaml:"fallback_status"`
+	// Cache answers repeated GET and HEAD requests from memory for CacheTTL
+	// after a successful response without calling the upstream.
+	Cache    bool          `yaml:"cache"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CacheMaxStale enables serving cached responses when the breaker
+	// rejects a request or the upstream fails. Copies stay usable for
+	// CacheTTL plus CacheMaxStale after they were stored and are marked
+	// with X-Stale and Warning headers.
+	CacheMaxStale time.Duration `yaml:"cache_max_stale"`
+	// Responses larger than CacheMaxBody bytes are not kept, and the least
+	// recently used are evicted beyond CacheMaxBytes in total.
+	CacheMaxBody  int64 `yaml:"cache_max_body"`
+	CacheMaxBytes int64 `yaml:"cache_max_bytes"`
+}
+
+// NotifyConfig holds the destinations notified of breaker state changes.
+type NotifyConfig struct {
+	WebhookURLs []string `yaml:"webhook_urls"`
+	// WebhookSecret signs webhook payloads with HMAC-SHA256 when set.
+	WebhookSecret string `yaml:"webhook_secret"`
+	// WebhookTimeout and WebhookRetries apply to every notification
+	// delivery, including Slack.
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"`
+	WebhookRetries int           `yaml:"webhook_retries"`
+	// SlackWebhookURL is a Slack incoming webhook notified when the circuit
+	// opens or recovers.
+	SlackWebhookURL string `yaml:"slack_webhook_url"`
+	// PagerDutyRoutingKey enables a PagerDuty alert once the circuit has
+	// been open for PagerDutyOpenFor. The alert resolves when it closes.
+	PagerDutyRoutingKey string        `yaml:"pagerduty_routing_key"`
+	PagerDutyOpenFor    time.Duration `yaml:"pagerduty_open_for"`
+	PagerDutyURL        string        `yaml:"pagerduty_url"`
+}
+
+// TracingConfig holds the OpenTelemetry trace export settings.
+type TracingConfig struct {
+	// Endpoint is the host:port of an OTLP/HTTP collector. Tracing is
+	// disabled when empty.
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// MetricsConfig holds the settings of metric sinks other than the
+// Prometheus /metrics endpoint.
+type MetricsConfig struct {
+	// OTLPEndpoint is the host:port of an OTLP/HTTP collector the metrics
+	// are pushed to every OTLPInterval. Pushing is disabled when empty.
+	OTLPEndpoint string        `yaml:"otlp_endpoint"`
+	OTLPInsecure bool          `yaml:"otlp_insecure"`
+	OTLPInterval time.Duration `yaml:"otlp_interval"`
+	// StatsDAddr is the host:port of a StatsD or Datadog agent. Sending is
+	// disabled when empty.
+	StatsDAddr   string   `yaml:"statsd_addr"`
+	StatsDPrefix string   `yaml:"statsd_prefix"`
+	StatsDTags   []string `yaml:"statsd_tags"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		ListenAddr:    ":8111",
+		Upstream:      "https://example.com/api",
+		Balance:       BalanceFailover,
+		ShutdownGrace: 30 * time.Second,
+		EventHistory:  1000,
+		Log: LogConfig{
+			Format:       LogText,
+			Access:       true,
+			AccessSample: 1,
+		},
+		TLS: TLSConfig{
+			MinVersion: tls.VersionTLS12,
+		},
+		// The same as http.DefaultTransport
+		Transport: TransportConfig{
+			Protocol:              ProtocolAuto,
+			DialTimeout:           30 * time.Second,
+			KeepAlive:             30 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   http.DefaultMaxIdleConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		HealthCheck: HealthCheckConfig{
+			Interval:           10 * time.Second,
+			Timeout:            2 * time.Second,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+		DNS: DNSConfig{
+			TTL:      30 * time.Second,
+			MaxStale: 5 * time.Minute,
+		},
+		Auth: AuthConfig{
+			Header: defaultAPIKeyHeader,
+		},
+		RateLimit: RateLimitConfig{
+			Burst:          20,
+			TrustedProxies: 1,
+		},
+		Consul: ConsulConfig{
+			Addr: "http://127.0.0.1:8500",
+			Wait: 5 * time.Minute,
+		},
+		Etcd: EtcdConfig{
+			Endpoint: "http://127.0.0.1:2379",
+			Timeout:  5 * time.Second,
+		},
+		Redis: RedisConfig{
+			KeyPrefix:           "circuit-breaker:",
+			SyncInterval:        time.Second,
+			Timeout:             time.Second,
+			PeerAction:          PeerOpen,
+			PeerThresholdFactor: 0.5,
+		},
+		Outbound: OutboundConfig{
+			Burst: 20,
+		},
+		WarmUp: WarmUpConfig{
+			Timeout: 10 * time.Second,
+		},
+		SelfCheck: SelfCheckConfig{
+			Timeout: 5 * time.Second,
+		},
+		Shed: ShedConfig{
+			Interval: time.Second,
+		},
+		MockUpstream: MockUpstreamConfig{
+			// Fail a few times, enough to trip the default breaker, then
+			// recover
+			Steps: []MockStep{
+				{Requests: 5, Status: http.StatusInternalServerError},
+				{},
+			},
+		},
+		Faults: FaultConfig{
+			Status: http.StatusServiceUnavailable,
+		},
+		Maintenance: MaintenanceConfig{
+			Message: "Down for maintenance",
+		},
+		Tenant: TenantConfig{
+			Hash:       true,
+			MaxTenants: 1000,
+		},
+		Outlier: OutlierConfig{
+			MinRequests: 10,
+			Interval:    10 * time.Second,
+			Ejection:    30 * time.Second,
+		},
+		Breaker: BreakerConfig{
+			Name:                        "API Circuit Breaker",
+			MaxRequests:                 5,
+			Interval:                    60 * time.Second,
+			Timeout:                     30 * time.Second,
+			Trip:                        TripConsecutiveFailures,
+			ConsecutiveFailures:         3,
+			FailureRatio:                0.5,
+			MinRequests:                 100,
+			AdaptiveMargin:              0.2,
+			AdaptiveLatencyFactor:       3,
+			SLOTarget:                   0.999,
+			BurnRate:                    14.4,
+			BurnLongWindow:              time.Hour,
+			BurnShortWindow:             5 * time.Minute,
+			RejectStatus:                http.StatusTooManyRequests,
+			BulkheadStatus:              http.StatusServiceUnavailable,
+			HalfOpenQueueWait:           time.Second,
+			ConcurrencyInitial:          20,
+			ConcurrencyMin:              1,
+			ConcurrencyMax:              1000,
+			ConcurrencyBackoff:          0.9,
+			ConcurrencyLatencyTolerance: 2,
+			ProbeInterval:               5 * time.Second,
+			ProbeTimeout:                2 * time.Second,
+		},
+		Retry: RetryConfig{
+			Attempts:           5,
+			Backoff:            BackoffExponential,
+			BackoffMin:         time.Second,
+			BackoffMax:         30 * time.Second,
+			JitterFloor:        0.1,
+			RetryAfterMax:      30 * time.Second,
+			AttemptTimeout:     10 * time.Second,
+			RequestTimeout:     time.Minute,
+			BudgetRatio:        0.2,
+			BudgetMinPerSecond: 10,
+			BudgetWindow:       10 * time.Second,
+			MaxBufferedBody:    1 << 20,
+			HedgePercentile:    0.95,
+			HedgeDelay:         time.Second,
+		},
+		Proxy: ProxyConfig{
+			FallbackContentType: "application/json",
+			CacheTTL:            time.Minute,
+			CacheMaxBody:        1 << 20,
+			CacheMaxBytes:       64 << 20,
+		},
+		Notify: NotifyConfig{
+			WebhookTimeout:   5 * time.Second,
+			WebhookRetries:   3,
+			PagerDutyOpenFor: 5 * time.Minute,
+			PagerDutyURL:     "https://events.pagerduty.com/v2/enqueue",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "circuit-breaker",
+			SampleRatio: 1,
+		},
+		Metrics: MetricsConfig{
+			OTLPInterval: 30 * time.Second,
+			StatsDPrefix: "circuit_breaker.",
+		},
+	}
+}
+
+// loadConfig reads the YAML or JSON file at path on top of the defaults.
+// Settings missing from the file keep their default value, and a missing
+// file yields the defaults. The config is left to validate once every
+// layer is applied.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	data, err := readConfigFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = parseConfig(path, data, &cfg)
+	return cfg, err
+}
+
+// readConfigFile returns the contents of the config file at path, nil when
+// there is none.
+func readConfigFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// parseConfig overlays data, the contents of the config file at path, onto
+// cfg. Nil data leaves cfg alone. Settings are checked one section at a
+// time, leaving how they fit together to validate.
+func parseConfig(path string, data []byte, cfg *Config) error {
+	if data == nil {
+		return nil
+	}
+	// YAML is a superset of JSON, so one decoder handles both formats.
+	// Unknown keys are rejected rather than silently ignored, catching
+	// misspelled settings.
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.validateRoutes(); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.Maintenance.validate(); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.Profiles.validate(); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.Faults.validate(); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.MockUpstream.validate(); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if _, err := cfg.Shadow.settings(cfg.Breaker); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+// decodeNode decodes node, an override kept as YAML, into v, rejecting
+// unknown keys as parseConfig does. yaml.Node.Decode offers no way to, so
+// the node is encoded again and read through a decoder that does.
+func decodeNode(node *yaml.Node, v interface{}) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// envPrefix prefixes every environment variable read by applyEnv.
+const envPrefix = "CB_"
+
+// applyEnv overrides cfg with the CB_* environment variables found through
+// lookup. Variable names are derived from the YAML keys, so breaker.timeout
+// is set with CB_BREAKER_TIMEOUT. CB_PORT is accepted as a shorthand for
+// listening on all interfaces on that port.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	if err := applyEnvStruct(reflect.ValueOf(cfg).Elem(), envPrefix, lookup); err != nil {
+		return err
+	}
+	if port, ok := lookup(envPrefix + "PORT"); ok {
+		cfg.ListenAddr = ":" + port
+	}
+	return nil
+}
+
+func applyEnvStruct(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(tag)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvStruct(field, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(field, raw); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromString parses raw into field according to the field's type.
+func setFromString(field reflect.Value, raw string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("MissingFile", func(t *testing.T) {
+		cfg, err := loadConfig(filepath.Join(t.TempDir(), "absent.yaml"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(cfg, defaultConfig()) {
+			t.Fatalf("expected defaults %+v, got %+v", defaultConfig(), cfg)
+		}
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", `
+upstream: "http://users.internal:8080"
+breaker:
+  timeout: 10s
+  consecutive_failures: 7
+retry:
+  attempts: 2
+`)
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if cfg.Upstream != "http://users.internal:8080" {
+			t.Fatalf("expected upstream to be overridden, got %q", cfg.Upstream)
+		}
+		if cfg.Breaker.Timeout != 10*time.Second || cfg.Breaker.ConsecutiveFailures != 7 {
+			t.Fatalf("expected breaker settings to be overridden, got %+v", cfg.Breaker)
+		}
+		if cfg.Retry.Attempts != 2 {
+			t.Fatalf("expected 2 retry attempts, got %d", cfg.Retry.Attempts)
+		}
+		// Settings absent from the file keep their defaults
+		if cfg.ListenAddr != defaultConfig().ListenAddr || cfg.Breaker.MaxRequests != defaultConfig().Breaker.MaxRequests {
+			t.Fatalf("expected unset settings to keep defaults, got %+v", cfg)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		path := writeConfigFile(t, "config.json", `{
+	"listen_addr": ":9000",
+	"retry": {
+		"backoff_min": "200ms",
+		"backoff_max": "5s"
+	}
+}`)
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if cfg.ListenAddr != ":9000" {
+			t.Fatalf("expected listen address :9000, got %q", cfg.ListenAddr)
+		}
+		if cfg.Retry.BackoffMin != 200*time.Millisecond || cfg.Retry.BackoffMax != 5*time.Second {
+			t.Fatalf("expected backoff bounds to be overridden, got %+v", cfg.Retry)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", "breaker: [")
+		if _, err := loadConfig(path); err == nil {
+			t.Fatalf("expected error, got none")
+		}
+	})
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"CB_UPSTREAM":                     "http://orders.internal",
+		"CB_PORT":                         "9090",
+		"CB_BREAKER_MAX_REQUESTS":         "10",
+		"CB_BREAKER_TIMEOUT":              "45s",
+		"CB_BREAKER_CONSECUTIVE_FAILURES": "8",
+		"CB_RETRY_ATTEMPTS":               "3",
+		"CB_RETRY_BACKOFF_MAX":            "2s",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg := defaultConfig()
+	if err := applyEnv(&cfg, lookup); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if cfg.Upstream != "http://orders.internal" {
+		t.Fatalf("expected upstream from environment, got %q", cfg.Upstream)
+	}
+	if cfg.ListenAddr != ":9090" {
+		t.Fatalf("expected listen address :9090, got %q", cfg.ListenAddr)
+	}
+	if cfg.Breaker.MaxRequests != 10 || cfg.Breaker.Timeout != 45*time.Second || cfg.Breaker.ConsecutiveFailures != 8 {
+		t.Fatalf("expected breaker settings from environment, got %+v", cfg.Breaker)
+	}
+	if cfg.Retry.Attempts != 3 || cfg.Retry.BackoffMax != 2*time.Second {
+		t.Fatalf("expected retry settings from environment, got %+v", cfg.Retry)
+	}
+	if cfg.Breaker.Interval != defaultConfig().Breaker.Interval {
+		t.Fatalf("expected unset interval to keep its default, got %v", cfg.Breaker.Interval)
+	}
+
+	env["CB_RETRY_ATTEMPTS"] = "many"
+	if err := applyEnv(&cfg, lookup); err == nil {
+		t.Fatalf("expected error for invalid CB_RETRY_ATTEMPTS, got none")
+	}
+}
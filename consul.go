@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// consulKV reads breaker and retry settings from a key of Consul's KV
+// store, a YAML or JSON document with breaker and retry sections like the
+// config file's, and watches it for changes with blocking queries.
+type consulKV struct {
+	cfg    ConsulConfig
+	client *http.Client
+	// value is the document last read, nil when the key does not exist.
+	value atomic.Pointer[[]byte]
+}
+
+func newConsulKV(cfg ConsulConfig) *consulKV {
+	// Blocking queries take up to Wait, plus the jitter Consul adds to it
+	return &consulKV{cfg: cfg, client: &http.Client{Timeout: cfg.Wait + cfg.Wait/16 + 10*time.Second}}
+}
+
+// fetch reads the key, blocking until its modify index moves past index
+// when index is not zero. It returns the value, nil when the key does not
+// exist, and the index to wait on next.
+func (c *consulKV) fetch(ctx context.Context, index uint64) ([]byte, uint64, error) {
+	q := url.Values{"raw": {""}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.FormatInt(c.cfg.Wait.Milliseconds(), 10)+"ms")
+	}
+	u := strings.TrimSuffix(c.cfg.Addr, "/") + "/v1/kv/" + strings.TrimPrefix(c.cfg.Key, "/") + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		value, err := io.ReadAll(resp.Body)
+		return value, next, err
+	case http.StatusNotFound:
+		return nil, next, nil
+	default:
+		return nil, 0, fmt.Errorf("reading consul key %s: %s", c.cfg.Key, resp.Status)
+	}
+}
+
+// Load reads the current value of the key.
+func (c *consulKV) Load(ctx context.Context) error {
+	value, _, err := c.fetch(ctx, 0)
+	if err != nil {
+		return err
+	}
+	c.value.Store(&value)
+	return nil
+}
+
+// Watch calls onChange whenever the value of the key changes, until ctx is
+// done, backing off while Consul cannot be reached.
+func (c *consulKV) Watch(ctx context.Context, onChange func(trigger string)) {
+	backoff := newBackoff(RetryConfig{Backoff: BackoffExponential, BackoffMin: time.Second, BackoffMax: 30 * time.Second}, jitter)
+	var delay time.Duration
+	var index uint64
+	failures := 0
+	for {
+		value, next, err := c.fetch(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("Failed to watch consul key", "key", c.cfg.Key, "error", err)
+			delay = backoff.Delay(failures, delay)
+			failures++
+			if sleepContext(ctx, delay) != nil {
+				return
+			}
+			continue
+		}
+		failures, delay = 0, 0
+		if next == 0 {
+			// Without an index queries do not block, so do not spin
+			if sleepContext(ctx, time.Second) != nil {
+				return
+			}
+		}
+		// Indexes going backwards mean the store was reset
+		if next < index {
+			next = 0
+		}
+		index = next
+		if current := c.value.Load(); current != nil && bytes.Equal(*current, value) && (*current == nil) == (value == nil) {
+			continue
+		}
+		c.value.Store(&value)
+		onChange("consul key change")
+	}
+}
+
+// Apply overlays the breaker and retry settings last read onto cfg.
+func (c *consulKV) Apply(cfg *Config) error {
+	var value []byte
+	if v := c.value.Load(); v != nil {
+		value = *v
+	}
+	return applyTuning(value, "consul key "+c.cfg.Key, cfg)
+}
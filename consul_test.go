@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves a single KV key, answering blocking queries once the
+// key changes.
+type fakeConsul struct {
+	mu      sync.Mutex
+	value   []byte
+	index   uint64
+	changed chan struct{}
+}
+
+func (f *fakeConsul) set(value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.value = []byte(value)
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != "token" {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+	if waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); waitIndex >= index {
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if f.value == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(f.value)
+}
+
+func TestConsulKV(t *testing.T) {
+	consul := &fakeConsul{index: 1, changed: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/circuit-breaker/config" {
+			http.NotFound(w, r)
+			return
+		}
+		consul.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	kv := newConsulKV(ConsulConfig{Addr: server.URL, Key: "circuit-breaker/config", Token: "token", Wait: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A missing key leaves the settings alone
+	if err := kv.Load(ctx); err != nil {
+		t.Fatalf("failed to load consul key: %v", err)
+	}
+	cfg := defaultConfig()
+	if err := kv.Apply(&cfg); err != nil || cfg.Breaker.ConsecutiveFailures != defaultConfig().Breaker.ConsecutiveFailures {
+		t.Fatalf("expected the defaults without a key, got %+v, %v", cfg.Breaker, err)
+	}
+
+	changes := make(chan struct{}, 10)
+	go kv.Watch(ctx, func(string) { changes <- struct{}{} })
+	consul.set("breaker:\n  consecutive_failures: 7\nretry:\n  attempts: 2\nlisten_addr: \":1\"\n")
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the change to be noticed")
+	}
+
+	cfg = defaultConfig()
+	if err := kv.Apply(&cfg); err != nil {
+		t.Fatalf("failed to apply consul key: %v", err)
+	}
+	if cfg.Breaker.ConsecutiveFailures != 7 || cfg.Retry.Attempts != 2 {
+		t.Fatalf("expected the breaker and retry settings of the key, got %+v, %+v", cfg.Breaker, cfg.Retry)
+	}
+	if cfg.Breaker.Name != defaultConfig().Breaker.Name || cfg.Retry.BackoffMax != defaultConfig().Retry.BackoffMax {
+		t.Fatalf("expected the settings missing from the key to be kept")
+	}
+	if cfg.ListenAddr != defaultConfig().ListenAddr {
+		t.Fatalf("expected only breaker and retry settings to be read from the key, got listen address %q", cfg.ListenAddr)
+	}
+
+	consul.set("breaker: [")
+	<-changes
+	if err := kv.Apply(&cfg); err == nil {
+		t.Fatalf("expected an invalid document to be reported")
+	}
+}
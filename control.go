@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// controlServer implements the BreakerControl gRPC service described in
+// control.proto. It only uses well-known message types, so it is
+// registered through a hand-written service description instead of
+// generated code.
+type controlServer struct {
+	// breakers are the breakers managed, the first being the primary one.
+	breakers []*breaker
+	events   *eventHub
+	audit    *auditLog
+}
+
+// controlServiceDesc describes the BreakerControl service of control.proto.
+var controlServiceDesc = grpc.ServiceDesc{
+	ServiceName: "circuitbreaker.v1.BreakerControl",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetState", Handler: controlHandler("GetState", (*controlServer).getState)},
+		{MethodName: "Trip", Handler: controlHandler("Trip", (*controlServer).trip)},
+		{MethodName: "Close", Handler: controlHandler("Close", (*controlServer).close)},
+		{MethodName: "Reset", Handler: controlHandler("Reset", (*controlServer).reset)},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "StreamEvents", Handler: streamEventsHandler, ServerStreams: true},
+	},
+	Metadata: "control.proto",
+}
+
+// registerControlServer registers the BreakerControl service managing
+// breakers on srv, recording the actions taken in audit.
+func registerControlServer(srv *grpc.Server, breakers []*breaker, events *eventHub, audit *auditLog) {
+	srv.RegisterService(&controlServiceDesc, &controlServer{breakers: breakers, events: events, audit: audit})
+}
+
+// controlHandler adapts a method of controlServer to a gRPC method handler.
+func controlHandler[Resp any](name string, method func(*controlServer, context.Context, *wrapperspb.StringValue) (Resp, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := new(wrapperspb.StringValue)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		s := srv.(*controlServer)
+		if interceptor == nil {
+			return method(s, ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/circuitbreaker.v1.BreakerControl/" + name}
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return method(s, ctx, req.(*wrapperspb.StringValue))
+		})
+	}
+}
+
+// breaker returns the breaker called name, or the primary one when name is
+// empty.
+func (s *controlServer) breaker(name string) (*breaker, error) {
+	if name == "" {
+		return s.breakers[0], nil
+	}
+	for _, b := range s.breakers {
+		if b.current().cfg.Name == name {
+			return b, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "no breaker named %q", name)
+}
+
+func (s *controlServer) getState(_ context.Context, req *wrapperspb.StringValue) (*structpb.Struct, error) {
+	b, err := s.breaker(req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	return toStruct(b.status())
+}
+
+func (s *controlServer) trip(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	b, err := s.breaker(req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	from := auditState(b)
+	b.forceOpen()
+	s.audit.recordBreaker(grpcActor(ctx), "force_open", b, from)
+	slog.Info("Circuit breaker forced open", "breaker", b.current().cfg.Name)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *controlServer) close(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	b, err := s.breaker(req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	from := auditState(b)
+	b.forceClose()
+	s.audit.recordBreaker(grpcActor(ctx), "force_close", b, from)
+	slog.Info("Circuit breaker forced closed", "breaker", b.current().cfg.Name)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *controlServer) reset(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	b, err := s.breaker(req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	from := auditState(b)
+	b.reset()
+	s.audit.recordBreaker(grpcActor(ctx), "reset", b, from)
+	slog.Info("Circuit breaker reset", "breaker", b.current().cfg.Name)
+	return &emptypb.Empty{}, nil
+}
+
+// streamEventsHandler streams state changes until the client goes away or
+// the event hub is closed.
+func streamEventsHandler(srv interface{}, stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(new(emptypb.Empty)); err != nil {
+		return err
+	}
+	s := srv.(*controlServer)
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			msg, err := toStruct(change)
+			if err != nil {
+				return err
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toStruct converts v to a Struct through its JSON form, so that gRPC
+// clients see the same fields as HTTP ones.
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestControlServer(t *testing.T) {
+	primary := newBreaker(BreakerConfig{Name: "Control Test", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{})
+	backup := newBreaker(BreakerConfig{Name: "Control Test backup", Timeout: time.Minute}, RetryConfig{})
+	events := newEventHub()
+	primary.onStateChange(events.publish)
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	registerControlServer(srv, []*breaker{primary, backup}, events, nil)
+	go srv.Serve(ln)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	const service = "/circuitbreaker.v1.BreakerControl/"
+
+	state := new(structpb.Struct)
+	if err := conn.Invoke(ctx, service+"GetState", wrapperspb.String(""), state); err != nil {
+		t.Fatal(err)
+	}
+	if name := state.Fields["name"].GetStringValue(); name != "Control Test" {
+		t.Fatalf("expected the primary breaker by default, got %q", name)
+	}
+
+	if err := conn.Invoke(ctx, service+"Trip", wrapperspb.String("Control Test backup"), new(emptypb.Empty)); err != nil {
+		t.Fatal(err)
+	}
+	if override := backup.status().Override; override != "open" {
+		t.Fatalf("expected the named breaker to be forced open, got %q", override)
+	}
+	if err := conn.Invoke(ctx, service+"Reset", wrapperspb.String("Control Test backup"), new(emptypb.Empty)); err != nil {
+		t.Fatal(err)
+	}
+	if override := backup.status().Override; override != "none" {
+		t.Fatalf("expected the reset to clear the override, got %q", override)
+	}
+
+	err = conn.Invoke(ctx, service+"Close", wrapperspb.String("missing"), new(emptypb.Empty))
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound for an unknown breaker, got %v", err)
+	}
+
+	// Stream the state change caused by tripping the primary breaker
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.NewStream(streamCtx, &controlServiceDesc.Streams[0], service+"StreamEvents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	// Wait for the subscription before tripping
+	for {
+		events.mu.Lock()
+		n := len(events.subs)
+		events.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		primary.execute(primary.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	}
+	change := new(structpb.Struct)
+	if err := stream.RecvMsg(change); err != nil {
+		t.Fatal(err)
+	}
+	if to := change.Fields["to"].GetStringValue(); to != "open" {
+		t.Fatalf("expected a change to open, got %v", change)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers through which callers announce when they stop waiting for an
+// answer. X-Request-Deadline holds an RFC 3339 time or Unix milliseconds,
+// grpc-timeout a duration of up to 8 digits followed by a unit: H, M, S,
+// m, u or n.
+const (
+	deadlineHeader    = "X-Request-Deadline"
+	grpcTimeoutHeader = "Grpc-Timeout"
+)
+
+var errInvalidDeadline = errors.New("invalid request deadline")
+
+// grpcTimeoutUnits maps the units of grpc-timeout to durations.
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// requestDeadline returns the deadline announced by the headers of req, if
+// any. X-Request-Deadline wins over grpc-timeout.
+func requestDeadline(req *http.Request, now time.Time) (time.Time, bool, error) {
+	if raw := req.Header.Get(deadlineHeader); raw != "" {
+		if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return time.UnixMilli(ms), true, nil
+		}
+		deadline, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return time.Time{}, false, errInvalidDeadline
+		}
+		return deadline, true, nil
+	}
+	if raw := req.Header.Get(grpcTimeoutHeader); raw != "" {
+		unit, ok := grpcTimeoutUnits[raw[len(raw)-1]]
+		n, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if !ok || err != nil || n < 0 || len(raw) > 9 {
+			return time.Time{}, false, errInvalidDeadline
+		}
+		return now.Add(time.Duration(n) * unit), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// deadlineHandler bounds the context of requests by the deadline their
+// caller announced, so that no attempt or backoff outlasts the caller's
+// patience. Requests with a malformed deadline are answered with 400 and
+// those whose deadline already passed with 504.
+func deadlineHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok, err := requestDeadline(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !time.Now().Before(deadline) {
+			http.Error(w, "Request deadline exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// propagateDeadline rewrites the deadline headers of an outgoing request to
+// the deadline of its context, passing on what is left of the caller's
+// time along with any tighter timeout of our own.
+func propagateDeadline(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok || req.Header.Get(deadlineHeader) == "" && req.Header.Get(grpcTimeoutHeader) == "" {
+		return
+	}
+	// Attempts share their headers with the request they were copied from
+	req.Header = req.Header.Clone()
+	if req.Header.Get(deadlineHeader) != "" {
+		req.Header.Set(deadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	}
+	if req.Header.Get(grpcTimeoutHeader) != "" {
+		ms := max(time.Until(deadline).Milliseconds(), 0)
+		req.Header.Set(grpcTimeoutHeader, strconv.FormatInt(ms, 10)+"m")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header, value string
+		want          time.Time
+		ok            bool
+		err           bool
+	}{
+		{"", "", time.Time{}, false, false},
+		{deadlineHeader, "2024-01-01T12:00:05Z", now.Add(5 * time.Second), true, false},
+		{deadlineHeader, "1704110405000", now.Add(5 * time.Second), true, false},
+		{deadlineHeader, "soon", time.Time{}, false, true},
+		{grpcTimeoutHeader, "250m", now.Add(250 * time.Millisecond), true, false},
+		{grpcTimeoutHeader, "2S", now.Add(2 * time.Second), true, false},
+		{grpcTimeoutHeader, "5x", time.Time{}, false, true},
+		{grpcTimeoutHeader, "123456789S", time.Time{}, false, true},
+		{grpcTimeoutHeader, "m", time.Time{}, false, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		got, ok, err := requestDeadline(req, now)
+		if !got.Equal(tt.want) || ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: %q = %v, %v, %v; want %v, %v, error %v", tt.header, tt.value, got, ok, err, tt.want, tt.ok, tt.err)
+		}
+	}
+}
+
+func TestDeadlineHandler(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	h := deadlineHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(grpcTimeoutHeader, "1S")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !hasDeadline || time.Until(deadline) > time.Second {
+		t.Fatalf("expected the context to carry the caller's deadline, got %v, %v", deadline, hasDeadline)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(deadlineHeader, time.Now().Add(-time.Second).Format(time.RFC3339))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504 for a passed deadline, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(deadlineHeader, "soon")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed deadline, got %d", rec.Code)
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Deadline Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{
+		Attempts:   100,
+		Backoff:    BackoffConstant,
+		BackoffMin: 40 * time.Millisecond,
+	})
+	transport := &breakerTransport{b: b}
+
+	calls := 0
+	var forwarded string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		calls++
+		forwarded = req.Header.Get(grpcTimeoutHeader)
+		return nil, errors.New("simulated failure")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil).WithContext(ctx)
+	req.Header.Set(grpcTimeoutHeader, "100m")
+	start := time.Now()
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("expected error, got none")
+	}
+
+	// Attempts start at 0ms, 40ms and 80ms; a fourth would start past the deadline
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts before the deadline, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected to give up before the deadline, took %v", elapsed)
+	}
+	if forwarded == "100m" || forwarded == "" {
+		t.Fatalf("expected the remaining time to be forwarded, got %q", forwarded)
+	}
+}
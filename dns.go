@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// dnsCache caches the addresses upstream hosts resolve to for TTL, so that
+// calls do not each depend on the resolver. Entries are refreshed on the
+// first lookup after they expire and, should the resolver fail, the
+// previous addresses keep being used for up to MaxStale longer.
+type dnsCache struct {
+	cfg    DNSConfig
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	group   singleflight.Group
+}
+
+type dnsEntry struct {
+	addrs    []net.IPAddr
+	resolved time.Time
+}
+
+func newDNSCache(cfg DNSConfig) *dnsCache {
+	return &dnsCache{cfg: cfg, lookup: net.DefaultResolver.LookupIPAddr, entries: make(map[string]dnsEntry)}
+}
+
+// resolve returns the addresses of host, from the cache while they are
+// fresh.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && clock.Now().Sub(entry.resolved) < c.cfg.TTL {
+		return entry.addrs, nil
+	}
+
+	// Callers share one lookup, which outlives any one of them
+	ch := c.group.DoChan(host, func() (interface{}, error) {
+		addrs, err := c.lookup(context.WithoutCancel(ctx), host)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.entries[host] = dnsEntry{addrs: addrs, resolved: clock.Now()}
+		c.mu.Unlock()
+		return addrs, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			if ok && clock.Now().Sub(entry.resolved) < c.cfg.TTL+c.cfg.MaxStale {
+				return entry.addrs, nil
+			}
+			return nil, res.Err
+		}
+		return res.Val.([]net.IPAddr), nil
+	}
+}
+
+// dial connects to addr like dial, resolving its host through c and trying
+// each of its addresses in turn.
+func (c *dnsCache) dial(ctx context.Context, network, addr string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(ctx, network, addr)
+	}
+	addrs, err := c.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	var errs []error
+	for _, ip := range addrs {
+		conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// isDNSFailure reports whether err is the failure to resolve an upstream's
+// host, which says nothing about the upstream itself.
+func isDNSFailure(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
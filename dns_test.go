@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newDNSCache(DNSConfig{TTL: 20 * time.Millisecond, MaxStale: 50 * time.Millisecond})
+	lookups := 0
+	var fail error
+	c.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if fail != nil {
+			return nil, fail
+		}
+		return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.resolve(ctx, "upstream.test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if lookups != 1 {
+		t.Fatalf("expected fresh addresses to come from the cache, got %d lookups", lookups)
+	}
+
+	// Expired addresses are refreshed, or kept while the resolver fails
+	fake.Advance(25 * time.Millisecond)
+	fail = &net.DNSError{Err: "server misbehaving", Name: "upstream.test", IsTemporary: true}
+	addrs, err := c.resolve(ctx, "upstream.test")
+	if err != nil || len(addrs) != 1 || lookups != 2 {
+		t.Fatalf("expected the stale addresses after a failed refresh, got %v, %v after %d lookups", addrs, err, lookups)
+	}
+
+	fake.Advance(50 * time.Millisecond)
+	if _, err := c.resolve(ctx, "upstream.test"); !isDNSFailure(err) {
+		t.Fatalf("expected the failure once the addresses are too stale, got %v", err)
+	}
+}
+
+func TestDNSCacheDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	cfg := defaultConfig()
+	transport, err := newUpstreamTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%s/", u.Port()), nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected the cached resolver to reach the upstream, got %v", err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)
+	if _, err := transport.RoundTrip(req); !isDNSFailure(err) {
+		t.Fatalf("expected a DNS failure, got %v", err)
+	}
+}
+
+func TestDNSFailuresDoNotTrip(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "DNS Failure Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{})
+	for i := 0; i < 5; i++ {
+		_, err := b.execute(b.current(), func() (interface{}, error) {
+			return nil, &net.DNSError{Err: "no such host", Name: "upstream.invalid", IsNotFound: true}
+		})
+		if !isDNSFailure(err) {
+			t.Fatalf("expected the DNS failure to be returned, got %v", err)
+		}
+	}
+	if state := b.current().cb.State().String(); state != "closed" {
+		t.Fatalf("expected DNS failures not to trip the breaker, got %s", state)
+	}
+	if isDNSFailure(errors.New("connection refused")) {
+		t.Fatal("expected other errors not to be DNS failures")
+	}
+}
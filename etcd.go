@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// etcdKV reads breaker and retry settings from a key of etcd, a YAML or
+// JSON document like the one kept in Consul, and watches it for changes.
+// It talks to etcd's v3 JSON gateway, so no client library is needed.
+type etcdKV struct {
+	cfg EtcdConfig
+	// client makes the watch request, which lasts as long as the watch;
+	// the others are bounded by cfg.Timeout.
+	client *http.Client
+	// value is the document last read, nil when the key does not exist,
+	// and revision the store revision it was read at.
+	value    atomic.Pointer[[]byte]
+	revision atomic.Int64
+}
+
+func newEtcdKV(cfg EtcdConfig) *etcdKV {
+	return &etcdKV{cfg: cfg, client: &http.Client{}}
+}
+
+// etcdInt is an int64 of the JSON gateway, which encodes them as strings.
+type etcdInt int64
+
+func (n *etcdInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*n = etcdInt(v)
+	return err
+}
+
+// etcdKeyValue is a key and its value, base64-encoded by the gateway.
+type etcdKeyValue struct {
+	Value       []byte  `json:"value"`
+	ModRevision etcdInt `json:"mod_revision"`
+}
+
+type etcdHeader struct {
+	Revision etcdInt `json:"revision"`
+}
+
+// post sends req as JSON to the gateway endpoint at path and decodes the
+// reply into resp, authenticating first when a user is configured.
+func (e *etcdKV) post(ctx context.Context, path string, req, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	r, err := e.request(ctx, path, req)
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s: %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// request builds a request of the gateway endpoint at path.
+func (e *etcdKV) request(ctx context.Context, path string, body interface{}) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.cfg.Endpoint, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.cfg.Username != "" {
+		token, err := e.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", token)
+	}
+	return req, nil
+}
+
+// authenticate returns a token for the configured user.
+func (e *etcdKV) authenticate(ctx context.Context) (string, error) {
+	data, _ := json.Marshal(map[string]string{"name": e.cfg.Username, "password": e.cfg.Password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.cfg.Endpoint, "/")+"/v3/auth/authenticate", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("etcd authentication: %s", resp.Status)
+	}
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return "", err
+	}
+	return auth.Token, nil
+}
+
+// Load reads the current value of the key.
+func (e *etcdKV) Load(ctx context.Context) error {
+	var resp struct {
+		Header etcdHeader     `json:"header"`
+		KVs    []etcdKeyValue `json:"kvs"`
+	}
+	if err := e.post(ctx, "/v3/kv/range", map[string]interface{}{"key": []byte(e.cfg.Key)}, &resp); err != nil {
+		return fmt.Errorf("reading etcd key %s: %w", e.cfg.Key, err)
+	}
+	var value []byte
+	if len(resp.KVs) > 0 {
+		value = resp.KVs[0].Value
+		if value == nil {
+			value = []byte{}
+		}
+	}
+	e.value.Store(&value)
+	e.revision.Store(int64(resp.Header.Revision))
+	return nil
+}
+
+// Apply overlays the breaker and retry settings last read onto cfg.
+func (e *etcdKV) Apply(cfg *Config) error {
+	var value []byte
+	if v := e.value.Load(); v != nil {
+		value = *v
+	}
+	return applyTuning(value, "etcd key "+e.cfg.Key, cfg)
+}
+
+// Watch calls onChange whenever the key is written or deleted, until ctx is
+// done, resuming from the last revision seen when the watch breaks.
+func (e *etcdKV) Watch(ctx context.Context, onChange func(trigger string)) {
+	backoff := newBackoff(RetryConfig{Backoff: BackoffExponential, BackoffMin: time.Second, BackoffMax: 30 * time.Second}, jitter)
+	var delay time.Duration
+	for attempt := 0; ; attempt++ {
+		start := clock.Now()
+		err := e.watch(ctx, onChange)
+		if ctx.Err() != nil {
+			return
+		}
+		if clock.Now().Sub(start) > time.Minute {
+			attempt, delay = 0, 0
+		}
+		slog.Warn("Lost the etcd watch, resuming", "key", e.cfg.Key, "error", err)
+		delay = backoff.Delay(attempt, delay)
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// watch runs one watch request until it breaks.
+func (e *etcdKV) watch(ctx context.Context, onChange func(trigger string)) error {
+	req, err := e.request(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(e.cfg.Key),
+			"start_revision": strconv.FormatInt(e.revision.Load()+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd watch: %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Header          etcdHeader `json:"header"`
+				Canceled        bool       `json:"canceled"`
+				CompactRevision etcdInt    `json:"compact_revision"`
+				Events          []struct {
+					Type string       `json:"type"`
+					KV   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("etcd watch: %s", msg.Error.Message)
+		}
+		result := msg.Result
+		if result.CompactRevision > 0 {
+			// The revisions missed are gone, read the key afresh
+			if err := e.Load(ctx); err != nil {
+				return err
+			}
+			onChange("etcd key change")
+			return fmt.Errorf("etcd watch: revision %d compacted", result.CompactRevision)
+		}
+		if result.Canceled {
+			return fmt.Errorf("etcd watch canceled")
+		}
+		for _, ev := range result.Events {
+			value := ev.KV.Value
+			if ev.Type == "DELETE" {
+				value = nil
+			} else if value == nil {
+				value = []byte{}
+			}
+			e.value.Store(&value)
+			e.revision.Store(int64(ev.KV.ModRevision))
+		}
+		if len(result.Events) > 0 {
+			onChange("etcd key change")
+		}
+	}
+}
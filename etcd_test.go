@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEtcd serves a single key through the v3 JSON gateway endpoints,
+// streaming an event to watches whenever it changes.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	value    []byte
+	revision int64
+	changed  chan struct{}
+}
+
+func (f *fakeEtcd) set(value []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.value = value
+	f.revision++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v3/auth/authenticate" {
+		var auth struct{ Name, Password string }
+		json.NewDecoder(r.Body).Decode(&auth)
+		if auth.Name != "root" || auth.Password != "secret" {
+			http.Error(w, `{"error":"authentication failed"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token"})
+		return
+	}
+	if r.Header.Get("Authorization") != "token" {
+		http.Error(w, `{"error":"invalid auth token"}`, http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		resp := map[string]interface{}{"header": map[string]string{"revision": strconv.FormatInt(f.revision, 10)}}
+		if f.value != nil {
+			resp["kvs"] = []map[string]interface{}{{"value": f.value, "mod_revision": strconv.FormatInt(f.revision, 10)}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/watch":
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+		w.(http.Flusher).Flush()
+		for {
+			f.mu.Lock()
+			changed := f.changed
+			f.mu.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			f.mu.Lock()
+			event := map[string]interface{}{"kv": map[string]interface{}{"value": f.value, "mod_revision": strconv.FormatInt(f.revision, 10)}}
+			if f.value == nil {
+				event["type"] = "DELETE"
+			}
+			f.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"events": []interface{}{event}}})
+			w.(http.Flusher).Flush()
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdKV(t *testing.T) {
+	etcd := &fakeEtcd{revision: 1, changed: make(chan struct{})}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	kv := newEtcdKV(EtcdConfig{Endpoint: server.URL, Key: "/circuit-breaker/config", Username: "root", Password: "secret", Timeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A missing key leaves the settings alone
+	if err := kv.Load(ctx); err != nil {
+		t.Fatalf("failed to load etcd key: %v", err)
+	}
+	cfg := defaultConfig()
+	if err := kv.Apply(&cfg); err != nil || cfg.Breaker.ConsecutiveFailures != defaultConfig().Breaker.ConsecutiveFailures {
+		t.Fatalf("expected the defaults without a key, got %+v, %v", cfg.Breaker, err)
+	}
+
+	changes := make(chan struct{}, 10)
+	go kv.Watch(ctx, func(string) { changes <- struct{}{} })
+	// Let the watch be created before writing
+	time.Sleep(100 * time.Millisecond)
+	etcd.set([]byte("breaker:\n  consecutive_failures: 7\nretry:\n  attempts: 2\nlisten_addr: \":1\"\n"))
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the change to be noticed")
+	}
+
+	cfg = defaultConfig()
+	if err := kv.Apply(&cfg); err != nil {
+		t.Fatalf("failed to apply etcd key: %v", err)
+	}
+	if cfg.Breaker.ConsecutiveFailures != 7 || cfg.Retry.Attempts != 2 {
+		t.Fatalf("expected the breaker and retry settings of the key, got %+v, %+v", cfg.Breaker, cfg.Retry)
+	}
+	if cfg.ListenAddr != defaultConfig().ListenAddr {
+		t.Fatalf("expected only breaker and retry settings to be read from the key, got listen address %q", cfg.ListenAddr)
+	}
+
+	// Deleting the key falls back to the settings underneath
+	etcd.set(nil)
+	<-changes
+	cfg = defaultConfig()
+	if err := kv.Apply(&cfg); err != nil || cfg.Breaker.ConsecutiveFailures != defaultConfig().Breaker.ConsecutiveFailures {
+		t.Fatalf("expected the defaults once the key is deleted, got %+v, %v", cfg.Breaker, err)
+	}
+}
+
+func TestEtcdKVAuthentication(t *testing.T) {
+	server := httptest.NewServer(&fakeEtcd{changed: make(chan struct{})})
+	defer server.Close()
+
+	kv := newEtcdKV(EtcdConfig{Endpoint: server.URL, Key: "/circuit-breaker/config", Username: "root", Password: "wrong", Timeout: time.Second})
+	if err := kv.Load(context.Background()); err == nil {
+		t.Fatalf("expected a failed authentication to be reported")
+	}
+}
+
+func TestRemoteProviders(t *testing.T) {
+	cfg := defaultConfig()
+	if providers := remoteProviders(cfg); len(providers) != 0 {
+		t.Fatalf("expected no remote providers without keys, got %d", len(providers))
+	}
+	cfg.Consul.Key = "circuit-breaker/config"
+	cfg.Etcd.Key = "/circuit-breaker/config"
+	providers := remoteProviders(cfg)
+	if len(providers) != 2 {
+		t.Fatalf("expected Consul and etcd providers, got %d", len(providers))
+	}
+	if _, ok := providers[1].(*etcdKV); !ok {
+		t.Fatalf("expected etcd to be layered over Consul, got %T", providers[1])
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventHeartbeat is how often an idle event stream sends a comment to keep
+// intermediaries from closing the connection.
+const eventHeartbeat = 15 * time.Second
+
+// eventHub fans breaker state changes out to connected subscribers.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan stateChange]struct{}
+	closed bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan stateChange]struct{})}
+}
+
+func (h *eventHub) subscribe() chan stateChange {
+	ch := make(chan stateChange, 16)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan stateChange) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// close ends every subscription, now and from then on, so that event
+// streams do not hold up a shutdown.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+		delete(h.subs, ch)
+	}
+}
+
+// publish sends change to every subscriber without blocking; subscribers
+// that have fallen behind miss the event.
+func (h *eventHub) publish(change stateChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// eventsHandler streams the state changes published on h as Server-Sent
+// Events until the client disconnects or h is closed.
+func eventsHandler(h *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ch := h.subscribe()
+		defer h.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := clock.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C():
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case change, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(change)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: state_change\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	}
+}
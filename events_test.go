@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandler(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Events Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+
+	hub := newEventHub()
+	b.onStateChange(hub.publish)
+
+	server := httptest.NewServer(eventsHandler(hub))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to connect to event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream content type, got %q", ct)
+	}
+
+	// Trip the breaker once the stream is subscribed
+	for i := 0; i < 2; i++ {
+		b.execute(b.current(), func() (interface{}, error) {
+			return nil, errors.New("simulated failure")
+		})
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for {
+		select {
+		case line := <-lines:
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			var change stateChange
+			if err := json.Unmarshal([]byte(data), &change); err != nil {
+				t.Fatalf("failed to decode event: %v", err)
+			}
+			if change.Name != "Events Test" || change.From != "closed" || change.To != "open" {
+				t.Fatalf("expected closed to open transition, got %+v", change)
+			}
+			if change.Counts.ConsecutiveFailures != 2 {
+				t.Fatalf("expected 2 consecutive failures in snapshot, got %+v", change.Counts)
+			}
+			return
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected a state change event")
+		}
+	}
+}
+
+func TestEventsHubClose(t *testing.T) {
+	hub := newEventHub()
+	server := httptest.NewServer(eventsHandler(hub))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to connect to event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	hub.close()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected closing the hub to end the stream")
+	}
+
+	if _, ok := <-hub.subscribe(); ok {
+		t.Fatal("expected subscriptions after closing to be closed")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"text/template"
+
+	"github.com/sony/gobreaker"
+)
+
+// FallbackFunc answers a request the breaker rejected or whose upstream
+// calls all failed, in place of the default error response. err is the
+// reason the request could not be proxied.
+type FallbackFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// fallbackData is what a fallback template is rendered with.
+type fallbackData struct {
+	Breaker string
+	State   string
+	// Reason is "open", "too_many_requests", "slow_start", "bulkhead_full",
+	// "concurrency_limit", "low_priority", "outbound_limit" or
+	// "maintenance" when the breaker rejected the request, "dns_failure"
+	// when the upstream's host could not be resolved and "upstream_error"
+	// when the upstream failed.
+	Reason string
+	Error  string
+	// RetryAfter is the number of seconds announced in Retry-After, or zero.
+	RetryAfter int
+}
+
+// newFallback returns the FallbackFunc described by cfg, rendering
+// FallbackBody as a template, or nil when no fallback is configured.
+func newFallback(cfg ProxyConfig, b *breaker) (FallbackFunc, error) {
+	if cfg.FallbackBody == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("fallback").Parse(cfg.FallbackBody)
+	if err != nil {
+		return nil, fmt.Errorf("parsing fallback body: %w", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		p := b.current()
+		data := fallbackData{
+			Breaker: p.cfg.Name,
+			State:   p.cb.State().String(),
+			Reason:  fallbackReason(err),
+			Error:   err.Error(),
+		}
+		status := http.StatusServiceUnavailable
+		if isOverCapacity(err) {
+			status = p.cfg.BulkheadStatus
+		} else if isRejection(err) {
+			status = p.cfg.RejectStatus
+		}
+		if isRejection(err) {
+			data.RetryAfter, _ = strconv.Atoi(w.Header().Get("Retry-After"))
+		}
+		if cfg.FallbackStatus != 0 {
+			status = cfg.FallbackStatus
+		}
+
+		if cfg.FallbackContentType != "" {
+			w.Header().Set("Content-Type", cfg.FallbackContentType)
+		}
+		w.WriteHeader(status)
+		if err := tmpl.Execute(w, data); err != nil {
+			slog.Error("Rendering fallback response", "breaker", data.Breaker, "error", err)
+		}
+	}, nil
+}
+
+func fallbackReason(err error) string {
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState):
+		return "open"
+	case errors.Is(err, gobreaker.ErrTooManyRequests):
+		return "too_many_requests"
+	case errors.Is(err, errSlowStart):
+		return "slow_start"
+	case errors.Is(err, errBulkheadFull):
+		return "bulkhead_full"
+	case errors.Is(err, errConcurrencyLimit):
+		return "concurrency_limit"
+	case errors.Is(err, errLowPriority):
+		return "low_priority"
+	case errors.Is(err, errOutboundLimit):
+		return "outbound_limit"
+	case errors.Is(err, errMaintenanceWindow):
+		return "maintenance"
+	case isDNSFailure(err):
+		return "dns_failure"
+	default:
+		return "upstream_error"
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFallback(t *testing.T) {
+	target, _ := url.Parse("http://upstream.invalid")
+	b := newBreaker(BreakerConfig{
+		Name:                "Fallback Test",
+		Timeout:             10 * time.Second,
+		ConsecutiveFailures: 0,
+		RejectStatus:        http.StatusTooManyRequests,
+	}, RetryConfig{Attempts: 1})
+	fallback, err := newFallback(ProxyConfig{
+		FallbackBody:        `{"breaker":"{{.Breaker}}","reason":"{{.Reason}}","retry_after":{{.RetryAfter}}}`,
+		FallbackContentType: "application/json",
+	}, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api := http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, fallback))
+
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("simulated failure")
+	}
+
+	// The first failure trips the breaker, the next request is rejected
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d for a failure, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if want := `{"breaker":"Fallback Test","reason":"upstream_error","retry_after":0}`; rec.Body.String() != want {
+		t.Fatalf("expected body %s, got %s", want, rec.Body)
+	}
+
+	b.forceOpen()
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d for a rejection, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("expected the configured content type, got %q", got)
+	}
+	if want := `{"breaker":"Fallback Test","reason":"open","retry_after":10}`; rec.Body.String() != want {
+		t.Fatalf("expected body %s, got %s", want, rec.Body)
+	}
+
+	t.Run("Status", func(t *testing.T) {
+		fallback, _ := newFallback(ProxyConfig{FallbackBody: "cached", FallbackStatus: http.StatusOK}, b)
+		rec := httptest.NewRecorder()
+		http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, fallback)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "cached" {
+			t.Fatalf("expected the configured status and body, got %d %q", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		if fallback, err := newFallback(ProxyConfig{}, b); fallback != nil || err != nil {
+			t.Fatalf("expected no fallback without a body, got %v", err)
+		}
+	})
+
+	t.Run("InvalidTemplate", func(t *testing.T) {
+		if _, err := newFallback(ProxyConfig{FallbackBody: "{{.Reason"}, b); err == nil {
+			t.Fatalf("expected an invalid template to be rejected")
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// errInjectedFault fails upstream calls picked by fault injection.
+var errInjectedFault = errors.New("injected fault")
+
+// faultInjector adds latency, errors and error statuses to upstream calls,
+// so breaker behaviour can be rehearsed without breaking the upstream. Its
+// settings come from the config and can be changed at runtime through the
+// admin endpoints.
+type faultInjector struct {
+	// name is the main breaker's, labelling metrics.
+	name string
+	cfg  atomic.Pointer[FaultConfig]
+}
+
+func newFaultInjector(cfg FaultConfig, name string) *faultInjector {
+	f := &faultInjector{name: name}
+	f.configure(cfg)
+	return f
+}
+
+func (f *faultInjector) configure(cfg FaultConfig) {
+	f.cfg.Store(&cfg)
+}
+
+// roundTrip returns next with faults injected into its calls.
+func (f *faultInjector) roundTrip(next func(*http.Request) (*http.Response, error)) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		cfg := f.cfg.Load()
+		if !cfg.Enabled {
+			return next(req)
+		}
+		if cfg.Latency > 0 && rand.Float64() < cfg.LatencyRate {
+			observeFault(f.name, "latency")
+			if err := sleepContext(req.Context(), cfg.Latency); err != nil {
+				return nil, err
+			}
+		}
+		if rand.Float64() < cfg.ErrorRate {
+			observeFault(f.name, "error")
+			return nil, errInjectedFault
+		}
+		if rand.Float64() < cfg.StatusRate {
+			observeFault(f.name, "status")
+			return &http.Response{
+				Status:     http.StatusText(cfg.Status),
+				StatusCode: cfg.Status,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+				Body:       io.NopCloser(strings.NewReader("Injected fault\n")),
+				Request:    req,
+			}, nil
+		}
+		return next(req)
+	}
+}
+
+// faultStatus is the JSON document served by GET /admin/faults.
+type faultStatus struct {
+	Enabled     bool    `json:"enabled"`
+	ErrorRate   float64 `json:"error_rate"`
+	StatusRate  float64 `json:"status_rate"`
+	Status      int     `json:"status"`
+	Latency     string  `json:"latency"`
+	LatencyRate float64 `json:"latency_rate"`
+}
+
+// registerFaultHandlers adds the operator endpoints controlling f to mux,
+// recording their use in audit. POST /admin/faults takes the settings of
+// the fault section, as JSON or YAML, and turns injection on; missing
+// settings keep their current value. POST /admin/faults/off turns it off.
+func registerFaultHandlers(mux *http.ServeMux, f *faultInjector, audit *auditLog) {
+	mux.HandleFunc("GET /admin/faults", func(w http.ResponseWriter, r *http.Request) {
+		cfg := f.cfg.Load()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(faultStatus{
+			Enabled:     cfg.Enabled,
+			ErrorRate:   cfg.ErrorRate,
+			StatusRate:  cfg.StatusRate,
+			Status:      cfg.Status,
+			Latency:     cfg.Latency.String(),
+			LatencyRate: cfg.LatencyRate,
+		})
+	})
+	mux.HandleFunc("POST /admin/faults", func(w http.ResponseWriter, r *http.Request) {
+		cfg := *f.cfg.Load()
+		if err := yaml.NewDecoder(r.Body).Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid fault settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cfg.Enabled = true
+		if err := cfg.validate(); err != nil {
+			http.Error(w, "Invalid fault settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		from := faultMode(f)
+		f.configure(cfg)
+		audit.record(auditEntry{Actor: requestActor(r), Action: "faults_on", From: from, To: faultMode(f)})
+		slog.Warn("Fault injection on", "error_rate", cfg.ErrorRate, "status_rate", cfg.StatusRate, "status", cfg.Status,
+			"latency", cfg.Latency, "latency_rate", cfg.LatencyRate)
+		w.Write([]byte("Fault injection on\n"))
+	})
+	mux.HandleFunc("POST /admin/faults/off", func(w http.ResponseWriter, r *http.Request) {
+		cfg := *f.cfg.Load()
+		cfg.Enabled = false
+		from := faultMode(f)
+		f.configure(cfg)
+		audit.record(auditEntry{Actor: requestActor(r), Action: "faults_off", From: from, To: faultMode(f)})
+		slog.Info("Fault injection off")
+		w.Write([]byte("Fault injection off\n"))
+	})
+}
+
+// faultMode names whether f injects faults, for the audit log.
+func faultMode(f *faultInjector) string {
+	if f.cfg.Load().Enabled {
+		return "injecting"
+	}
+	return "off"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultInjector(t *testing.T) {
+	f := newFaultInjector(FaultConfig{Status: http.StatusServiceUnavailable}, "Fault Test")
+	reached := 0
+	call := f.roundTrip(func(req *http.Request) (*http.Response, error) {
+		reached++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	req := httptest.NewRequest(http.MethodGet, "http://upstream/api", nil)
+
+	if resp, err := call(req); err != nil || resp.StatusCode != http.StatusOK || reached != 1 {
+		t.Fatalf("expected calls to pass with injection off, got %v, %v", resp, err)
+	}
+
+	f.configure(FaultConfig{Enabled: true, ErrorRate: 1, Status: http.StatusServiceUnavailable})
+	if _, err := call(req); !errors.Is(err, errInjectedFault) || reached != 1 {
+		t.Fatalf("expected an injected error without reaching the upstream, got %v", err)
+	}
+
+	f.configure(FaultConfig{Enabled: true, StatusRate: 1, Status: http.StatusBadGateway})
+	if resp, err := call(req); err != nil || resp.StatusCode != http.StatusBadGateway || reached != 1 {
+		t.Fatalf("expected an injected %d, got %v, %v", http.StatusBadGateway, resp, err)
+	}
+
+	f.configure(FaultConfig{Enabled: true, Latency: 50 * time.Millisecond, LatencyRate: 1, Status: http.StatusServiceUnavailable})
+	start := time.Now()
+	if resp, err := call(req); err != nil || resp.StatusCode != http.StatusOK || reached != 2 {
+		t.Fatalf("expected a delayed call to reach the upstream, got %v, %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected at least 50ms of injected latency, got %v", elapsed)
+	}
+}
+
+func TestFaultHandlers(t *testing.T) {
+	f := newFaultInjector(FaultConfig{Status: http.StatusServiceUnavailable}, "Fault Test")
+	mux := http.NewServeMux()
+	registerFaultHandlers(mux, f, nil)
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+	status := func(t *testing.T) faultStatus {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/faults", nil))
+		var s faultStatus
+		if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if rec := post("/admin/faults", `{"error_rate": 0.5, "latency": "2s", "latency_rate": 0.1}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	s := status(t)
+	if !s.Enabled || s.ErrorRate != 0.5 || s.Latency != "2s" || s.LatencyRate != 0.1 || s.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected injection on with the given settings, got %+v", s)
+	}
+
+	if rec := post("/admin/faults", `{"error_rate": 2}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for an invalid rate, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if s := status(t); s.ErrorRate != 0.5 {
+		t.Fatalf("expected invalid settings to be ignored, got %+v", s)
+	}
+
+	if rec := post("/admin/faults/off", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if s := status(t); s.Enabled || s.ErrorRate != 0.5 {
+		t.Fatalf("expected injection off keeping its settings, got %+v", s)
+	}
+}
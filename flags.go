@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// settingFlags are the command-line flags setting the config, one per
+// setting a string can express, named after its YAML key path like
+// --retry.attempts or --breaker.timeout. They are applied over the config
+// file, remote config and environment.
+type settingFlags struct {
+	// set holds the values of the flags given, by the name of the CB_*
+	// environment variable of the same setting.
+	set map[string]string
+}
+
+// settingFlag is the flag.Value of a setting.
+type settingFlag struct {
+	flags *settingFlags
+	// env is the name of the environment variable of the same setting.
+	env  string
+	typ  reflect.Type
+	def  string
+	bool bool
+}
+
+// registerSettingFlags adds the setting flags to fs, their defaults taken
+// from defaults.
+func registerSettingFlags(fs *flag.FlagSet, defaults Config) *settingFlags {
+	f := &settingFlags{set: make(map[string]string)}
+	f.register(fs, reflect.ValueOf(defaults), "", "")
+	return f
+}
+
+func (f *settingFlags) register(fs *flag.FlagSet, v reflect.Value, name, env string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		fieldName, fieldEnv := name+tag, env+strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			f.register(fs, field, fieldName+".", fieldEnv+"_")
+			continue
+		}
+		// Flags defined already, like --upstream, take the setting's place
+		if !settable(field.Type()) || fs.Lookup(fieldName) != nil {
+			continue
+		}
+		s := &settingFlag{flags: f, env: fieldEnv, typ: field.Type(), bool: field.Kind() == reflect.Bool}
+		if !field.IsZero() {
+			s.def = formatSetting(field)
+		}
+		fs.Var(s, fieldName, "sets "+fieldName+" of the config"+settingKind(field.Type())+", like "+envPrefix+fieldEnv)
+	}
+}
+
+// settingKind describes the values a setting of type t takes in the flag
+// usage, quoted for the flag package to name them.
+func settingKind(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return " to a `duration`"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return ""
+	case reflect.String:
+		return " to a `string`"
+	case reflect.Slice:
+		return " to a comma-separated `list`"
+	case reflect.Float64:
+		return " to a `number`"
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
+		return " to a `value`"
+	}
+	return " to an `integer`"
+}
+
+// settable reports whether setFromString parses settings of type t.
+func settable(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint32, reflect.Uint64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// formatSetting formats the value of a setting as its flag takes it.
+func formatSetting(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case []string:
+		return strings.Join(x, ",")
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		if err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// apply sets the settings of the flags given on cfg.
+func (f *settingFlags) apply(cfg *Config) error {
+	return applyEnvStruct(reflect.ValueOf(cfg).Elem(), "", func(name string) (string, bool) {
+		raw, ok := f.set[name]
+		return raw, ok
+	})
+}
+
+func (s *settingFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.def
+}
+
+// Set checks raw parses as the setting before keeping it.
+func (s *settingFlag) Set(raw string) error {
+	if err := setFromString(reflect.New(s.typ).Elem(), raw); err != nil {
+		return err
+	}
+	s.flags.set[s.env] = raw
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be turned on by naming them.
+func (s *settingFlag) IsBoolFlag() bool {
+	return s.bool
+}
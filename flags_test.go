@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSettingFlags(t *testing.T) {
+	parse := func(t *testing.T, args ...string) (*flag.FlagSet, *settingFlags, error) {
+		t.Helper()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.String("upstream", "", "shorthand")
+		settings := registerSettingFlags(fs, defaultConfig())
+		return fs, settings, fs.Parse(args)
+	}
+
+	fs, settings, err := parse(t,
+		"--retry.attempts=2",
+		"--breaker.timeout", "5s",
+		"--breaker.trip", "failure_ratio",
+		"--retry.retry_post",
+		"--upstreams", "http://a.invalid, http://b.invalid",
+		"--log.level", "debug",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	// The environment is overridden by the flags
+	if err := applyEnv(&cfg, func(name string) (string, bool) {
+		if name == "CB_RETRY_ATTEMPTS" {
+			return "7", true
+		}
+		return "", false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.apply(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Retry.Attempts != 2 || cfg.Breaker.Timeout != 5*time.Second || cfg.Breaker.Trip != TripFailureRatio ||
+		!cfg.Retry.RetryPOST || len(cfg.Upstreams) != 2 || cfg.Log.Level.String() != "DEBUG" {
+		t.Fatalf("expected the flags to be applied, got %+v, %+v, %v, %v", cfg.Breaker, cfg.Retry, cfg.Upstreams, cfg.Log.Level)
+	}
+	if cfg.Retry.BackoffMax != defaultConfig().Retry.BackoffMax {
+		t.Fatalf("expected settings without flags to be left alone, got %v", cfg.Retry.BackoffMax)
+	}
+
+	// Shorthand flags keep their names, and unsupported settings get none
+	if f := fs.Lookup("upstream"); f.Usage != "shorthand" {
+		t.Fatalf("expected --upstream to stay the shorthand, got %q", f.Usage)
+	}
+	if fs.Lookup("routes") != nil || fs.Lookup("shadow.breaker") != nil {
+		t.Fatal("expected no flags for settings a string cannot express")
+	}
+	if f := fs.Lookup("breaker.timeout"); f.DefValue != "30s" {
+		t.Fatalf("expected the default in the usage, got %q", f.DefValue)
+	}
+
+	if _, _, err := parse(t, "--retry.attempts=many"); err == nil || !strings.Contains(err.Error(), "retry.attempts") {
+		t.Fatalf("expected an invalid value to be rejected naming the flag, got %v", err)
+	}
+}
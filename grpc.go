@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcFailureCodes are the status codes showing the upstream failing, which
+// count as breaker failures like 5xx responses do. Other codes are answers
+// of a healthy upstream and are returned to the caller as they are.
+var grpcFailureCodes = map[codes.Code]bool{
+	codes.Unknown:           true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Internal:          true,
+	codes.Unavailable:       true,
+	codes.DataLoss:          true,
+}
+
+// grpcRetryableCodes are the failing codes worth retrying: those telling
+// the call was not processed and may succeed later.
+var grpcRetryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.ResourceExhausted: true,
+}
+
+// unaryClientInterceptor runs unary gRPC calls through b like HTTP calls
+// through breakerTransport: failing calls count towards the breaker and
+// are retried with the configured backoff within the retry budgets, and
+// attempts and requests are recorded in the same metrics. Calls the
+// breaker rejects fail with codes.Unavailable.
+func unaryClientInterceptor(b *breaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p := b.current()
+		start := clock.Now()
+		backoff := newBackoff(p.retry, jitter)
+		var delay time.Duration
+		retries.deposit()
+
+		var err error
+		attempts := 0
+		for i := 0; i < max(p.retry.Attempts, 1); i++ {
+			attempts++
+			err = b.grpcCall(ctx, b.current(), attempts, func() error {
+				return invoker(ctx, method, req, reply, cc, opts...)
+			})
+			if isRejection(err) || !grpcRetryableCodes[status.Code(err)] || i == p.retry.Attempts-1 {
+				break
+			}
+
+			delay = backoff.Delay(i, delay)
+			if p.retry.MaxElapsed > 0 && clock.Now().Sub(start)+delay > p.retry.MaxElapsed {
+				break
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				break
+			}
+			if !retries.withdraw() {
+				if b.metrics {
+					retryBudgetExhausted.WithLabelValues(p.cfg.Name).Inc()
+				}
+				break
+			}
+			if ctxErr := sleepContext(ctx, delay); ctxErr != nil {
+				err = status.FromContextError(ctxErr).Err()
+				break
+			}
+		}
+
+		if b.metrics {
+			observeRequest(p.cfg.Name, attempts, err)
+		}
+		return grpcError(err)
+	}
+}
+
+// streamClientInterceptor runs the opening of gRPC streams through b.
+// Streams cannot be replayed, so they are never retried, and failures
+// after a stream opened are left to the caller.
+func streamClientInterceptor(b *breaker) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		p := b.current()
+		var stream grpc.ClientStream
+		err := b.grpcCall(ctx, p, 1, func() error {
+			var err error
+			stream, err = streamer(ctx, desc, cc, method, opts...)
+			return err
+		})
+		if b.metrics {
+			observeRequest(p.cfg.Name, 1, err)
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return stream, nil
+	}
+}
+
+// grpcCall makes one attempt of a gRPC call through b, counting it as a
+// failure only when it ends with one of grpcFailureCodes.
+func (b *breaker) grpcCall(ctx context.Context, p *breakerPolicy, attempt int, call func() error) error {
+	var callErr error
+	_, err := b.observedExecute(ctx, p, priorityNormal, attempt, func() (interface{}, error) {
+		callErr = call()
+		if grpcFailureCodes[status.Code(callErr)] {
+			return nil, callErr
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	return callErr
+}
+
+// grpcError turns the rejections of the breaker into gRPC errors, leaving
+// the errors of the call itself as they are.
+func grpcError(err error) error {
+	if isRejection(err) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
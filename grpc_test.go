@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// scriptedHealthServer answers health checks with the queued errors, then
+// with success.
+type scriptedHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	errs  chan error
+	calls int
+}
+
+func (s *scriptedHealthServer) Check(context.Context, *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	s.calls++
+	select {
+	case err := <-s.errs:
+		return nil, err
+	default:
+		return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+	}
+}
+
+// dialScripted serves a scriptedHealthServer over an in-memory connection
+// and returns a health client whose calls go through b.
+func dialScripted(t *testing.T, b *breaker) (grpc_health_v1.HealthClient, *scriptedHealthServer) {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	health := &scriptedHealthServer{errs: make(chan error, 10)}
+	grpc_health_v1.RegisterHealthServer(srv, health)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(unaryClientInterceptor(b)),
+		grpc.WithStreamInterceptor(streamClientInterceptor(b)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn), health
+}
+
+func TestUnaryClientInterceptorRetries(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "gRPC Retry Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{Attempts: 3, Backoff: BackoffConstant, BackoffMin: time.Millisecond})
+	client, health := dialScripted(t, b)
+
+	health.errs <- status.Error(codes.Unavailable, "warming up")
+	health.errs <- status.Error(codes.Unavailable, "warming up")
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if health.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", health.calls)
+	}
+
+	// Answers of a healthy upstream are neither retried nor failures
+	health.calls = 0
+	health.errs <- status.Error(codes.NotFound, "unknown service")
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+	if health.calls != 1 {
+		t.Fatalf("expected NotFound not to be retried, got %d attempts", health.calls)
+	}
+	if failures := b.current().cb.Counts().TotalFailures; failures != 2 {
+		t.Fatalf("expected only the Unavailable answers to count as failures, got %d", failures)
+	}
+}
+
+func TestUnaryClientInterceptorTrips(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "gRPC Trip Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+	client, health := dialScripted(t, b)
+
+	for i := 0; i < 2; i++ {
+		health.errs <- status.Error(codes.Internal, "boom")
+		client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	}
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable || health.calls != 2 {
+		t.Fatalf("expected the open breaker to fail calls with Unavailable without sending them, got %v after %d calls", err, health.calls)
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	b := newBreaker(BreakerConfig{Name: "gRPC Stream Test", Timeout: time.Minute}, RetryConfig{})
+	b.forceOpen()
+	client, _ := dialScripted(t, b)
+
+	_, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the open breaker to refuse the stream, got %v", err)
+	}
+}
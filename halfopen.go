@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// halfOpenQueue holds calls turned away because every half-open trial slot
+// was taken, retrying them as trial calls finish until HalfOpenQueueWait
+// has passed. At most HalfOpenQueue calls wait at a time; the rest are
+// rejected right away. A nil queue holds nothing.
+type halfOpenQueue struct {
+	size    int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	waiting int
+	// woken is closed, and replaced, whenever a call finishes.
+	woken chan struct{}
+}
+
+// newHalfOpenQueue returns the queue described by cfg, or nil when
+// HalfOpenQueue is not positive.
+func newHalfOpenQueue(cfg BreakerConfig) *halfOpenQueue {
+	if cfg.HalfOpenQueue <= 0 {
+		return nil
+	}
+	return &halfOpenQueue{size: cfg.HalfOpenQueue, timeout: cfg.HalfOpenQueueWait, woken: make(chan struct{})}
+}
+
+// wait retries run through cb as trial slots free up. It gives up with
+// gobreaker.ErrTooManyRequests when the queue is full or the wait is over.
+func (q *halfOpenQueue) wait(cb *circuit, run func() (interface{}, error)) (interface{}, error) {
+	if q == nil {
+		return nil, gobreaker.ErrTooManyRequests
+	}
+	q.mu.Lock()
+	if q.waiting >= q.size {
+		q.mu.Unlock()
+		return nil, gobreaker.ErrTooManyRequests
+	}
+	q.waiting++
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+	}()
+
+	deadline := clock.NewTimer(q.timeout)
+	defer deadline.Stop()
+	for {
+		// Take the channel before trying so that a slot freed in between
+		// is not missed
+		q.mu.Lock()
+		woken := q.woken
+		q.mu.Unlock()
+
+		result, err := cb.Execute(run)
+		if !errors.Is(err, gobreaker.ErrTooManyRequests) {
+			q.wake()
+			return result, err
+		}
+		select {
+		case <-woken:
+		case <-deadline.C():
+			return nil, err
+		}
+	}
+}
+
+// wake lets the waiting calls try again after a call finished.
+func (q *halfOpenQueue) wake() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiting > 0 {
+		close(q.woken)
+		q.woken = make(chan struct{})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestHalfOpenQueue(t *testing.T) {
+	fake := useFakeClock(t)
+	b := newBreaker(BreakerConfig{
+		Name:                "Half-Open Queue Test",
+		MaxRequests:         1,
+		Timeout:             10 * time.Millisecond,
+		ConsecutiveFailures: 0,
+		HalfOpenQueue:       1,
+		HalfOpenQueueWait:   time.Second,
+	}, RetryConfig{})
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	fake.Advance(10 * time.Millisecond)
+
+	// Take the only trial slot with a call that blocks until released
+	release := make(chan struct{})
+	started := make(chan struct{})
+	trial := make(chan error)
+	go func() {
+		_, err := b.execute(b.current(), func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		trial <- err
+	}()
+	<-started
+
+	queued := make(chan error)
+	go func() {
+		_, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil })
+		queued <- err
+	}()
+	q := b.current().queue
+	for {
+		q.mu.Lock()
+		waiting := q.waiting
+		q.mu.Unlock()
+		if waiting > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so further calls fail at once
+	if _, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil }); !errors.Is(err, gobreaker.ErrTooManyRequests) {
+		t.Fatalf("expected calls beyond the queue to be rejected, got %v", err)
+	}
+
+	close(release)
+	if err := <-trial; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("expected the queued call to go through once the slot freed up, got %v", err)
+	}
+}
+
+func TestHalfOpenQueueTimeout(t *testing.T) {
+	fake := useFakeClock(t)
+	b := newBreaker(BreakerConfig{
+		Name:                "Half-Open Queue Timeout Test",
+		MaxRequests:         1,
+		Timeout:             10 * time.Millisecond,
+		ConsecutiveFailures: 0,
+		HalfOpenQueue:       1,
+		HalfOpenQueueWait:   20 * time.Millisecond,
+	}, RetryConfig{})
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	fake.Advance(10 * time.Millisecond)
+
+	release, done := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(release)
+		<-done
+	}()
+	started := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.execute(b.current(), func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+
+	queued := make(chan error)
+	go func() {
+		_, err := b.execute(b.current(), func() (interface{}, error) { return nil, nil })
+		queued <- err
+	}()
+	fake.waitFor(t, 1)
+	select {
+	case err := <-queued:
+		t.Fatalf("expected the call to wait in the queue, got %v", err)
+	default:
+	}
+	fake.Advance(20 * time.Millisecond)
+	if err := <-queued; !errors.Is(err, gobreaker.ErrTooManyRequests) {
+		t.Fatalf("expected the queued call to give up, got %v", err)
+	}
+}
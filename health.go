@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// healthChecker probes the health endpoint of an upstream in the
+// background. Upstreams it finds unhealthy only get calls when no healthy
+// upstream is left, and an open breaker is reset as soon as its upstream
+// is found healthy again, so recovery does not depend on live requests.
+type healthChecker struct {
+	cfg    HealthCheckConfig
+	b      *breaker
+	target *url.URL
+
+	mu      sync.Mutex
+	healthy bool
+	streak  int
+}
+
+func newHealthChecker(cfg HealthCheckConfig, b *breaker, target *url.URL) *healthChecker {
+	return &healthChecker{cfg: cfg, b: b, target: target.JoinPath(cfg.Path), healthy: true}
+}
+
+// run probes the upstream every interval until ctx is done.
+func (c *healthChecker) run(ctx context.Context) {
+	ticker := clock.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			c.record(c.probe(ctx))
+		}
+	}
+}
+
+// probe reports whether the health endpoint answers with a non-error
+// status within the timeout.
+func (c *healthChecker) probe(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := callExternalAPI(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusBadRequest
+}
+
+// record accounts for a probe result, changing the upstream's health after
+// HealthyThreshold or UnhealthyThreshold results in a row that disagree
+// with it.
+func (c *healthChecker) record(ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.b.current().cfg.Name
+
+	if ok == c.healthy {
+		c.streak = 0
+		return
+	}
+	c.streak++
+	threshold := c.cfg.UnhealthyThreshold
+	if ok {
+		threshold = c.cfg.HealthyThreshold
+	}
+	if c.streak < threshold {
+		return
+	}
+
+	c.healthy, c.streak = ok, 0
+	observeHealth(name, ok)
+	if !ok {
+		slog.Warn("Upstream is unhealthy", "breaker", name)
+		return
+	}
+	slog.Info("Upstream is healthy again", "breaker", name)
+	if c.b.isOpen() && override(c.b.override.Load()) == overrideNone {
+		c.b.reset()
+	}
+}
+
+// isHealthy reports whether the upstream passed its latest health checks.
+// A nil checker always reports healthy.
+func (c *healthChecker) isHealthy() bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.healthy
+}
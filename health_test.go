@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestHealthChecker(t *testing.T) {
+	target, _ := url.Parse("http://primary.invalid/api")
+	b := newBreaker(BreakerConfig{Name: "Health Test", Timeout: time.Minute, ConsecutiveFailures: 0}, RetryConfig{Attempts: 1})
+	c := newHealthChecker(HealthCheckConfig{
+		Path:               "/health",
+		Interval:           5 * time.Millisecond,
+		Timeout:            time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}, b, target)
+
+	up := false
+	var probed string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		probed = req.URL.String()
+		if !up {
+			return nil, errors.New("simulated failure")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+
+	if c.probe(context.Background()) || probed != "http://primary.invalid/api/health" {
+		t.Fatalf("expected a failed probe of the health endpoint, got %q", probed)
+	}
+
+	c.record(false)
+	c.record(false)
+	if !c.isHealthy() {
+		t.Fatalf("expected the upstream to stay healthy below the threshold")
+	}
+	c.record(false)
+	if c.isHealthy() {
+		t.Fatalf("expected the upstream to turn unhealthy after 3 failed checks")
+	}
+
+	// Trip the breaker, then let the checks find the upstream healthy again
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	if !b.isOpen() {
+		t.Fatalf("expected the breaker to be open")
+	}
+	up = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.run(ctx)
+	deadline := time.Now().Add(time.Second)
+	for !c.isHealthy() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !c.isHealthy() {
+		t.Fatalf("expected the upstream to turn healthy again")
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the recovered upstream's breaker to be reset, got %s", state)
+	}
+}
+
+func TestRouteAvoidsUnhealthy(t *testing.T) {
+	primary, _ := url.Parse("http://a.invalid/")
+	backup, _ := url.Parse("http://b.invalid/")
+	cfg := BreakerConfig{Name: "Route Health Test", Timeout: time.Minute, ConsecutiveFailures: 100}
+	b := newBreaker(cfg, RetryConfig{})
+	u := &upstream{target: backup, b: newBreaker(upstreamBreaker(cfg, backup), RetryConfig{})}
+	health := []*healthChecker{
+		newHealthChecker(HealthCheckConfig{UnhealthyThreshold: 1}, b, primary),
+		newHealthChecker(HealthCheckConfig{UnhealthyThreshold: 1}, u.b, backup),
+	}
+	tr := &breakerTransport{b: b, target: primary, backups: []*upstream{u}, health: health}
+
+	health[0].record(false)
+	if _, _, to := tr.route(); to != backup {
+		t.Fatalf("expected an unhealthy primary to be skipped, got %v", to)
+	}
+	health[1].record(false)
+	if _, _, to := tr.route(); to != nil {
+		t.Fatalf("expected the primary as a last resort, got %v", to)
+	}
+}
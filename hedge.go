@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is the number of recent upstream latencies kept to
+	// derive the hedge delay from.
+	latencySamples = 256
+	// minLatencySamples is the number of samples needed before the
+	// percentile is trusted over the configured HedgeDelay.
+	minLatencySamples = 20
+)
+
+// latencyTracker keeps the most recent upstream call latencies.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencySamples]time.Duration
+	n, next int
+}
+
+func (l *latencyTracker) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencySamples
+	l.n = min(l.n+1, latencySamples)
+}
+
+// percentile returns the q-th quantile (0 to 1) of the recorded latencies,
+// or false when too few have been recorded.
+func (l *latencyTracker) percentile(q float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := slices.Clone(l.samples[:l.n])
+	l.mu.Unlock()
+
+	if len(sorted) < minLatencySamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	i := int(q * float64(len(sorted)-1))
+	return sorted[max(0, min(i, len(sorted)-1))], true
+}
+
+// hedgeDelay returns how long to wait for a call before hedging it: the
+// configured percentile of recent latencies, or HedgeDelay until enough
+// latencies are known.
+func (b *breaker) hedgeDelay(p *breakerPolicy) time.Duration {
+	if d, ok := b.latencies.percentile(p.retry.HedgePercentile); ok {
+		return d
+	}
+	return p.retry.HedgeDelay
+}
+
+// hedgedCall calls the upstream with req and, if no answer arrived within
+// the hedge delay, sends a second identical request. The first successful
+// answer wins and the other call is canceled; a failed answer is only
+// returned once no call is left that could succeed. It runs inside a single
+// breaker execution, so a hedged call counts once towards the breaker, but
+// the hedge takes a bulkhead slot and an outbound token of its own like any
+// other upstream call, and is skipped when either is not to be had at once.
+// req must be idempotent with a replayable body.
+func (b *breaker) hedgedCall(p *breakerPolicy, req *http.Request) (*http.Response, error) {
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+
+	launch := func(hedge bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels = append(cancels, cancel)
+		out := req.WithContext(ctx)
+		if hedge && req.GetBody != nil {
+			out.Body, _ = req.GetBody()
+		}
+		go func() {
+			if hedge {
+				defer p.bulkhead.release()
+			}
+			start := clock.Now()
+			resp, err := tracedCall(out)
+			if err == nil {
+				b.latencies.record(clock.Now().Sub(start))
+			}
+			results <- hedgeResult{resp: resp, err: err, hedge: hedge}
+		}()
+	}
+	// finish cancels every call but the one res came from, whose context is
+	// released once its body is closed.
+	finish := func(res hedgeResult) (*http.Response, error) {
+		keep := 0
+		if res.hedge {
+			keep = 1
+		}
+		for i, cancel := range cancels {
+			if i != keep {
+				cancel()
+			}
+		}
+		if res.err != nil {
+			cancels[keep]()
+			return nil, res.err
+		}
+		res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: cancels[keep]}
+		return res.resp, nil
+	}
+
+	launch(false)
+	timer := clock.NewTimer(b.hedgeDelay(p))
+	defer timer.Stop()
+
+	pending := 1
+	for {
+		select {
+		case <-timer.C():
+			if !p.bulkhead.acquire(priorityLow, 0) {
+				b.hedgeSkipped(p)
+				continue
+			}
+			if !outbound.tryTake() {
+				p.bulkhead.release()
+				b.hedgeSkipped(p)
+				continue
+			}
+			pending++
+			if b.metrics {
+				hedgeCount.WithLabelValues(p.cfg.Name, "launched").Inc()
+			}
+			launch(true)
+		case res := <-results:
+			pending--
+			won := res.err == nil && res.resp.StatusCode < http.StatusInternalServerError
+			if !won && pending > 0 {
+				// The other call may still succeed
+				if res.resp != nil {
+					res.resp.Body.Close()
+				}
+				continue
+			}
+			if won && res.hedge && b.metrics {
+				hedgeCount.WithLabelValues(p.cfg.Name, "won").Inc()
+			}
+			if pending > 0 {
+				go discard(results, pending)
+			}
+			return finish(res)
+		}
+	}
+}
+
+// hedgeSkipped records a hedge not sent for lack of room under the limits.
+func (b *breaker) hedgeSkipped(p *breakerPolicy) {
+	slog.Debug("Not hedging, the call limits are reached", "breaker", p.cfg.Name)
+	if b.metrics {
+		hedgeCount.WithLabelValues(p.cfg.Name, "skipped").Inc()
+	}
+}
+
+// hedgeResult is the outcome of one of the calls made by hedgedCall.
+type hedgeResult struct {
+	resp  *http.Response
+	err   error
+	hedge bool
+}
+
+// discard closes the responses of the n calls still outstanding once
+// another has won.
+func discard(results <-chan hedgeResult, n int) {
+	for range n {
+		if res := <-results; res.resp != nil {
+			res.resp.Body.Close()
+		}
+	}
+}
+
+// cancelOnClose cancels a call's context once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
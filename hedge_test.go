@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	var l latencyTracker
+	for i := 1; i < minLatencySamples; i++ {
+		l.record(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := l.percentile(0.5); ok {
+		t.Fatalf("expected no percentile below %d samples", minLatencySamples)
+	}
+
+	for i := 1; i <= 100; i++ {
+		l.record(time.Duration(i) * time.Millisecond)
+	}
+	if got, _ := l.percentile(0.95); got < 90*time.Millisecond || got > 100*time.Millisecond {
+		t.Fatalf("expected p95 near 95ms, got %v", got)
+	}
+
+	// Old samples fall out of the window
+	for i := 0; i < latencySamples; i++ {
+		l.record(time.Second)
+	}
+	if got, _ := l.percentile(0.5); got != time.Second {
+		t.Fatalf("expected only recent samples to count, got %v", got)
+	}
+}
+
+func TestHedgedRequests(t *testing.T) {
+	newTransport := func() *breakerTransport {
+		return &breakerTransport{b: newBreaker(BreakerConfig{
+			Name:                "Hedge Test",
+			Timeout:             time.Minute,
+			ConsecutiveFailures: 100,
+		}, RetryConfig{
+			Attempts:        1,
+			Hedge:           true,
+			HedgePercentile: 0.95,
+			HedgeDelay:      20 * time.Millisecond,
+			MaxBufferedBody: 1024,
+		})}
+	}
+
+	// The first call hangs until canceled and later calls answer at once
+	var calls, canceled atomic.Int32
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if calls.Add(1) == 1 {
+			<-req.Context().Done()
+			canceled.Add(1)
+			return nil, req.Context().Err()
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(body)))}, nil
+	}
+
+	t.Run("SlowCall", func(t *testing.T) {
+		calls.Store(0)
+		tr := newTransport()
+		won := testutil.ToFloat64(hedgeCount.WithLabelValues("Hedge Test", "won"))
+
+		resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodPut, "http://upstream.invalid/", strings.NewReader("payload")))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "payload" {
+			t.Fatalf("expected the hedge to replay the body, got %q", body)
+		}
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("expected 2 upstream calls, got %d", got)
+		}
+		if got := testutil.ToFloat64(hedgeCount.WithLabelValues("Hedge Test", "won")); got != won+1 {
+			t.Fatalf("expected the hedge to be counted as won, got %v", got-won)
+		}
+		deadline := time.Now().Add(time.Second)
+		for canceled.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if canceled.Load() != 1 {
+			t.Fatalf("expected the losing call to be canceled")
+		}
+		if counts := tr.b.current().cb.Counts(); counts.Requests != 1 || counts.TotalSuccesses != 1 {
+			t.Fatalf("expected the hedged call to count once, got %+v", counts)
+		}
+	})
+
+	t.Run("AtBulkheadLimit", func(t *testing.T) {
+		calls.Store(0)
+		tr := newTransport()
+		cfg := tr.b.current().cfg
+		cfg.MaxConcurrent = 1
+		tr.b.reload(cfg, tr.b.current().retry)
+		skipped := testutil.ToFloat64(hedgeCount.WithLabelValues("Hedge Test", "skipped"))
+
+		// The call takes the only slot, leaving no room for a hedge
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil).WithContext(ctx)); err == nil {
+			t.Fatalf("expected the hanging call to fail")
+		}
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("expected no hedge beyond the bulkhead, got %d calls", got)
+		}
+		if got := testutil.ToFloat64(hedgeCount.WithLabelValues("Hedge Test", "skipped")); got != skipped+1 {
+			t.Fatalf("expected the hedge to be counted as skipped, got %v", got-skipped)
+		}
+	})
+
+	t.Run("NotIdempotent", func(t *testing.T) {
+		calls.Store(1) // answer the first call at once
+		newTransport().RoundTrip(httptest.NewRequest(http.MethodPost, "http://upstream.invalid/", strings.NewReader("payload")))
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("expected POST not to be hedged, got %d calls", got-1)
+		}
+	})
+
+	t.Run("FastCall", func(t *testing.T) {
+		calls.Store(1)
+		resp, err := newTransport().RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		time.Sleep(40 * time.Millisecond)
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("expected a fast call not to be hedged, got %d calls", got-1)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// historyDefaultLimit is how many events /events/history returns when no
+// limit is asked for.
+const historyDefaultLimit = 50
+
+// historyEvent is a state change or a run of rejections kept by
+// eventHistory.
+type historyEvent struct {
+	// Type is state_change or rejection.
+	Type string    `json:"type"`
+	Name string    `json:"name"`
+	Time time.Time `json:"timestamp"`
+	// From, To and Counts describe a state change.
+	From   string         `json:"from,omitempty"`
+	To     string         `json:"to,omitempty"`
+	Counts *breakerCounts `json:"counts,omitempty"`
+	// State, Reason and Rejections describe a run of rejections in a row
+	// by the same breaker for the same reason, the last at Last.
+	State      string     `json:"state,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	Rejections int        `json:"rejections,omitempty"`
+	Last       *time.Time `json:"last,omitempty"`
+}
+
+// eventHistory keeps the latest breaker events in a ring buffer so that
+// they can be looked back on after an incident. Rejections following one
+// another are folded into a single event so that they do not push the
+// state changes out.
+type eventHistory struct {
+	mu     sync.Mutex
+	events []historyEvent
+	// start is the index of the oldest event and n the number of events.
+	start, n int
+}
+
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{events: make([]historyEvent, max(size, 1))}
+}
+
+func (h *eventHistory) add(e historyEvent) {
+	if h.n < len(h.events) {
+		h.events[(h.start+h.n)%len(h.events)] = e
+		h.n++
+		return
+	}
+	h.events[h.start] = e
+	h.start = (h.start + 1) % len(h.events)
+}
+
+// newest returns the latest event, or nil when there is none.
+func (h *eventHistory) newest() *historyEvent {
+	if h.n == 0 {
+		return nil
+	}
+	return &h.events[(h.start+h.n-1)%len(h.events)]
+}
+
+// recordChange keeps change.
+func (h *eventHistory) recordChange(change stateChange) {
+	counts := change.Counts
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(historyEvent{
+		Type:   "state_change",
+		Name:   change.Name,
+		Time:   change.Time,
+		From:   change.From,
+		To:     change.To,
+		Counts: &counts,
+	})
+}
+
+// recordRejection keeps r, folding it into the latest event when that is
+// a run of the same rejections.
+func (h *eventHistory) recordRejection(r rejection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.newest(); e != nil && e.Type == "rejection" && e.Name == r.Name && e.State == r.State && e.Reason == r.Reason {
+		e.Rejections++
+		e.Last = &r.Time
+		return
+	}
+	h.add(historyEvent{
+		Type:       "rejection",
+		Name:       r.Name,
+		Time:       r.Time,
+		State:      r.State,
+		Reason:     r.Reason,
+		Rejections: 1,
+		Last:       &r.Time,
+	})
+}
+
+// recent returns up to limit of the latest events, oldest first.
+func (h *eventHistory) recent(limit int) []historyEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	limit = min(limit, h.n)
+	events := make([]historyEvent, limit)
+	for i := range events {
+		events[i] = h.events[(h.start+h.n-limit+i)%len(h.events)]
+	}
+	return events
+}
+
+// historyHandler serves the latest events kept by h as JSON, as many as
+// the limit query parameter asks for.
+func historyHandler(h *eventHistory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := historyDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Events []historyEvent `json:"events"`
+		}{h.recent(limit)})
+	}
+}
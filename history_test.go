@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventHistory(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "History Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+	history := newEventHistory(10)
+	b.onStateChange(history.recordChange)
+	b.onRejection(history.recordRejection)
+
+	fail := func() (interface{}, error) {
+		return nil, errors.New("simulated failure")
+	}
+	// Trip the breaker, then have it reject three calls
+	for i := 0; i < 5; i++ {
+		b.observedExecute(context.Background(), b.current(), priorityNormal, 1, fail)
+	}
+
+	rec := httptest.NewRecorder()
+	historyHandler(history).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events/history", nil))
+	var body struct {
+		Events []historyEvent `json:"events"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode history: %v", err)
+	}
+	if len(body.Events) != 2 {
+		t.Fatalf("expected a state change and a run of rejections, got %+v", body.Events)
+	}
+	change, rejected := body.Events[0], body.Events[1]
+	if change.Type != "state_change" || change.From != "closed" || change.To != "open" || change.Counts.TotalFailures != 2 {
+		t.Fatalf("expected the breaker to open after 2 failures, got %+v", change)
+	}
+	if rejected.Type != "rejection" || rejected.Reason != "open" || rejected.Rejections != 3 {
+		t.Fatalf("expected 3 rejections while open, got %+v", rejected)
+	}
+}
+
+func TestEventHistoryLimit(t *testing.T) {
+	history := newEventHistory(3)
+	for i := 0; i < 5; i++ {
+		history.recordChange(stateChange{Name: "Limit Test", To: string(rune('a' + i))})
+	}
+
+	if got := history.recent(10); len(got) != 3 || got[0].To != "c" || got[2].To != "e" {
+		t.Fatalf("expected the 3 latest events oldest first, got %+v", got)
+	}
+	if got := history.recent(1); len(got) != 1 || got[0].To != "e" {
+		t.Fatalf("expected the latest event, got %+v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	historyHandler(history).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events/history?limit=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for an invalid limit, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidToken is returned for bearer tokens that do not verify.
+var errInvalidToken = errors.New("invalid token")
+
+// jwtVerifier checks the JWTs authenticating operators. It accepts HS256
+// tokens when it has a secret and RS256 tokens when it has a public key,
+// never trusting a token's header to pick the kind of key.
+type jwtVerifier struct {
+	secret   []byte
+	key      *rsa.PublicKey
+	issuer   string
+	audience string
+}
+
+// newJWTVerifier returns the verifier of the tokens described by cfg, or nil
+// if cfg sets no key.
+func newJWTVerifier(cfg AdminAuthConfig) (*jwtVerifier, error) {
+	if cfg.JWTSecret == "" && cfg.JWTPublicKeyFile == "" {
+		return nil, nil
+	}
+	v := &jwtVerifier{issuer: cfg.JWTIssuer, audience: cfg.JWTAudience}
+	if cfg.JWTSecret != "" {
+		v.secret = []byte(cfg.JWTSecret)
+	}
+	if cfg.JWTPublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT public key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in %s", cfg.JWTPublicKeyFile)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWT public key: %w", err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("JWT public key in %s is not an RSA key", cfg.JWTPublicKeyFile)
+		}
+		v.key = rsaKey
+	}
+	return v, nil
+}
+
+// jwtClaims are the registered claims checked by jwtVerifier.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+// verify checks the signature and claims of token, returning its subject.
+func (v *jwtVerifier) verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && v.secret != nil:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return "", errInvalidToken
+		}
+	case header.Alg == "RS256" && v.key != nil:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(v.key, crypto.SHA256, digest[:], sig) != nil {
+			return "", errInvalidToken
+		}
+	default:
+		return "", fmt.Errorf("%w: unexpected algorithm %q", errInvalidToken, header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := float64(clock.Now().Unix())
+	if claims.ExpiresAt != nil && now >= *claims.ExpiresAt {
+		return "", fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return "", fmt.Errorf("%w: not valid yet", errInvalidToken)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return "", fmt.Errorf("%w: unexpected issuer", errInvalidToken)
+	}
+	if v.audience != "" && !hasAudience(claims.Audience, v.audience) {
+		return "", fmt.Errorf("%w: unexpected audience", errInvalidToken)
+	}
+	return claims.Subject, nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a JWT into v.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errInvalidToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// hasAudience reports whether the aud claim, a string or an array of
+// strings, includes audience.
+func hasAudience(aud json.RawMessage, audience string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	return json.Unmarshal(aud, &many) == nil && slices.Contains(many, audience)
+}
+
+// bearerToken returns the token of an Authorization header value.
+func bearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	return token, ok && strings.EqualFold(scheme, "Bearer") && token != ""
+}
+
+// handler answers requests without a valid bearer token with 401
+// Unauthorized, and passes the others to next with the token's subject as
+// their actor.
+func (v *jwtVerifier) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, err := "", errInvalidToken
+		if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+			subject, err = v.verify(token)
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid or missing token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withActor(r.Context(), subject)))
+	})
+}
+
+// authorize checks the bearer token in the metadata of a gRPC call,
+// returning ctx with the token's subject as actor.
+func (v *jwtVerifier) authorize(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if token, ok := bearerToken(authorization); ok {
+			if subject, err := v.verify(token); err == nil {
+				return withActor(ctx, subject), nil
+			}
+		}
+	}
+	return ctx, status.Error(codes.Unauthenticated, "invalid or missing token")
+}
+
+// serverOptions returns the options making a gRPC server authenticate
+// every call.
+func (v *jwtVerifier) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := v.authorize(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if _, err := v.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
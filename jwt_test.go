@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// signJWT returns a token with the given claims, signed with sign under alg.
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func([]byte) []byte) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	keyFile := filepath.Join(t.TempDir(), "admin.pub")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	rs256 := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return sig
+	}
+
+	v, err := newJWTVerifier(AdminAuthConfig{
+		JWTSecret:        "s3cret",
+		JWTPublicKeyFile: keyFile,
+		JWTIssuer:        "ops",
+		JWTAudience:      "circuit-breaker",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	now := useFakeClock(t).Now()
+	valid := map[string]interface{}{"iss": "ops", "aud": []string{"other", "circuit-breaker"}, "exp": now.Add(time.Minute).Unix()}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+
+	for name, tc := range map[string]struct {
+		token string
+		ok    bool
+	}{
+		"HS256":         {signJWT(t, "HS256", valid, hs256("s3cret")), true},
+		"RS256":         {signJWT(t, "RS256", valid, rs256), true},
+		"WrongSecret":   {signJWT(t, "HS256", valid, hs256("guess")), false},
+		"None":          {signJWT(t, "none", valid, func([]byte) []byte { return nil }), false},
+		"Expired":       {signJWT(t, "HS256", with("exp", now.Unix()), hs256("s3cret")), false},
+		"NotYetValid":   {signJWT(t, "HS256", with("nbf", now.Add(time.Minute).Unix()), hs256("s3cret")), false},
+		"WrongIssuer":   {signJWT(t, "HS256", with("iss", "someone"), hs256("s3cret")), false},
+		"WrongAudience": {signJWT(t, "HS256", with("aud", "other"), hs256("s3cret")), false},
+		"Malformed":     {"not.a-token", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := v.verify(tc.token); (err == nil) != tc.ok {
+				t.Fatalf("expected valid %v, got error %v", tc.ok, err)
+			}
+		})
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		h := v.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for auth, want := range map[string]int{
+			"":        http.StatusUnauthorized,
+			"Bearer ": http.StatusUnauthorized,
+			"Bearer " + signJWT(t, "HS256", valid, hs256("guess")):  http.StatusUnauthorized,
+			"Bearer " + signJWT(t, "HS256", valid, hs256("s3cret")): http.StatusOK,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/admin/breaker/open", nil)
+			req.Header.Set("Authorization", auth)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != want {
+				t.Fatalf("expected status %d for %q, got %d", want, auth, rec.Code)
+			}
+		}
+	})
+
+	t.Run("GRPC", func(t *testing.T) {
+		if _, err := v.authorize(context.Background()); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected Unauthenticated without a token, got %v", err)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+signJWT(t, "RS256", valid, rs256)))
+		if _, err := v.authorize(ctx); err != nil {
+			t.Fatalf("expected a valid token to be accepted, got %v", err)
+		}
+	})
+}
+
+func TestJWTVerifierDisabled(t *testing.T) {
+	v, err := newJWTVerifier(AdminAuthConfig{})
+	if v != nil || err != nil {
+		t.Fatalf("expected no verifier without keys, got %v, %v", v, err)
+	}
+	if _, err := newJWTVerifier(AdminAuthConfig{JWTPublicKeyFile: filepath.Join(t.TempDir(), "absent")}); err == nil {
+		t.Fatalf("expected error for a missing key file, got none")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// loadTest describes a run of the loadtest command.
+type loadTest struct {
+	// target is the URL requests are sent to.
+	target   string
+	rps      int
+	duration time.Duration
+}
+
+// loadReport is the outcome of a load test.
+type loadReport struct {
+	Requests  int
+	Succeeded int
+	Failed    int
+	Rejected  int
+	Trips     int
+	// latencies are those of the calls the breaker let through, sorted.
+	latencies []time.Duration
+}
+
+// successRate returns the share of requests that succeeded.
+func (r loadReport) successRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / float64(r.Requests)
+}
+
+// percentile returns the q-th quantile (0 to 1) of the latencies, zero
+// without any.
+func (r loadReport) percentile(q float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(q * float64(len(r.latencies)-1))
+	return r.latencies[max(0, min(i, len(r.latencies)-1))]
+}
+
+// runLoadtest sends requests at a steady rate through the breaker pipeline
+// serve sets up, fault injection included, and reports how the breaker
+// fared, for tuning its thresholds against a real or mock upstream.
+func runLoadtest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to a YAML or JSON config file")
+	target := fs.String("target", "", "URL to send requests to, defaulting to the upstream of the config")
+	useMock := fs.Bool("mock-upstream", false, "send requests to a built-in fake upstream scripted by the mock_upstream config section")
+	rps := fs.Int("rps", 50, "requests sent per second, whether or not earlier ones were answered")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send requests for")
+	settings := registerSettingFlags(fs, defaultConfig())
+	fs.Parse(args)
+
+	cfg, err := offlineConfig(*configPath, settings)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Logs go to stderr, keeping stdout for the report
+	slog.SetDefault(newLogger(cfg.Log, os.Stderr))
+	if cfg.Retry.JitterSeed != 0 {
+		jitter = newJitterSource(cfg.Retry.JitterSeed)
+	}
+
+	test := loadTest{target: *target, rps: *rps, duration: *duration}
+	if *useMock {
+		mock, err := startMockUpstream(cfg.MockUpstream)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "starting mock upstream:", err)
+			return 1
+		}
+		defer mock.close()
+		cfg.Upstream = mock.url
+		test.target = mock.url + "/api"
+	}
+	if test.target == "" {
+		test.target = cfg.Upstream
+	}
+	if _, err := loadtest(cfg, test, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// loadtest runs test through a breaker transport configured by cfg,
+// reporting the state changes as they happen and the totals at the end to
+// w. Requests are sent on schedule however slow the upstream, as clients
+// would, so the requests in flight pile up while it struggles.
+func loadtest(cfg Config, test loadTest, w io.Writer) (loadReport, error) {
+	if test.rps <= 0 {
+		return loadReport{}, errors.New("the rate must be positive")
+	}
+	if test.duration <= 0 {
+		return loadReport{}, errors.New("the duration must be positive")
+	}
+	target, err := parseUpstream(test.target)
+	if err != nil {
+		return loadReport{}, fmt.Errorf("invalid target: %w", err)
+	}
+	tmpl, err := http.NewRequest(http.MethodGet, test.target, nil)
+	if err != nil {
+		return loadReport{}, fmt.Errorf("invalid target: %w", err)
+	}
+	transport, err := newUpstreamTransport(cfg)
+	if err != nil {
+		return loadReport{}, err
+	}
+	defer transport.CloseIdleConnections()
+	callExternalAPI = newFaultInjector(cfg.Faults, cfg.Breaker.Name).roundTrip(transport.RoundTrip)
+	retries.configure(cfg.Retry)
+	outbound.configure(cfg.Outbound)
+
+	var (
+		mu     sync.Mutex
+		report loadReport
+	)
+	start := clock.Now()
+	onStateChange := func(c stateChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		if c.To == "open" {
+			report.Trips++
+		}
+		fmt.Fprintf(w, "%8v  breaker %s %s -> %s\n", clock.Now().Sub(start).Round(time.Millisecond), c.Name, c.From, c.To)
+	}
+	b := newBreaker(cfg.Breaker, cfg.Retry)
+	b.onStateChange(onStateChange)
+	var backups []*upstream
+	for _, raw := range cfg.Upstreams {
+		backup, err := parseUpstream(raw)
+		if err != nil {
+			return loadReport{}, fmt.Errorf("invalid upstream %q: %w", raw, err)
+		}
+		u := &upstream{target: backup, b: newBreaker(upstreamBreaker(cfg.Breaker, backup), cfg.Retry)}
+		u.b.onStateChange(onStateChange)
+		backups = append(backups, u)
+	}
+	api := &breakerTransport{
+		b:        b,
+		target:   target,
+		backups:  backups,
+		balance:  cfg.Balance,
+		outliers: newOutlierDetector(cfg.Outlier, len(backups)+1),
+	}
+
+	fmt.Fprintf(w, "Sending %d requests a second to %s for %v\n", test.rps, test.target, test.duration)
+	interval := max(time.Second/time.Duration(test.rps), time.Microsecond)
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	var wg sync.WaitGroup
+	for sent := 0; ; sent++ {
+		if sent > 0 {
+			<-ticker.C()
+		}
+		if clock.Now().Sub(start) >= test.duration {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			begin := clock.Now()
+			resp, err := api.RoundTrip(tmpl.Clone(context.Background()))
+			took := clock.Now().Sub(begin)
+			_, ok, rejected := callOutcome(resp, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Requests++
+			switch {
+			case ok:
+				report.Succeeded++
+			case rejected:
+				report.Rejected++
+			default:
+				report.Failed++
+			}
+			if !rejected {
+				report.latencies = append(report.latencies, took)
+			}
+		}()
+	}
+	wg.Wait()
+	slices.Sort(report.latencies)
+
+	fmt.Fprintf(w, "\n%d requests in %v\n", report.Requests, clock.Now().Sub(start).Round(time.Millisecond))
+	fmt.Fprintf(w, "  succeeded  %d (%.1f%%)\n", report.Succeeded, 100*report.successRate())
+	fmt.Fprintf(w, "  failed     %d\n", report.Failed)
+	fmt.Fprintf(w, "  rejected   %d by the breakers, which tripped %d times\n", report.Rejected, report.Trips)
+	fmt.Fprintf(w, "  latency    p50 %v  p90 %v  p99 %v  max %v\n", report.percentile(0.5).Round(time.Microsecond),
+		report.percentile(0.9).Round(time.Microsecond), report.percentile(0.99).Round(time.Microsecond),
+		report.percentile(1).Round(time.Microsecond))
+	return report, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoadtest(t *testing.T) {
+	defer func(call func(*http.Request) (*http.Response, error)) { callExternalAPI = call }(callExternalAPI)
+	mock, err := startMockUpstream(MockUpstreamConfig{Steps: []MockStep{
+		{Requests: 5, Status: http.StatusInternalServerError},
+		{Latency: time.Millisecond},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.close()
+	cfg := defaultConfig()
+	cfg.Upstream = mock.url
+	cfg.Breaker.Trip = TripConsecutiveFailures
+	cfg.Breaker.ConsecutiveFailures = 2
+	cfg.Breaker.Timeout = 100 * time.Millisecond
+	cfg.Retry.Attempts = 1
+
+	report, err := loadtest(cfg, loadTest{target: mock.url + "/api", rps: 200, duration: 500 * time.Millisecond}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests < 50 || report.Requests > 101 {
+		t.Fatalf("expected about 100 requests, got %d", report.Requests)
+	}
+	if report.Trips < 1 || report.Rejected == 0 || report.Failed == 0 || report.Succeeded == 0 {
+		t.Fatalf("expected the breaker to trip on the failures then recover, got %+v", report)
+	}
+	if report.Succeeded+report.Failed+report.Rejected != report.Requests || len(report.latencies) != report.Requests-report.Rejected {
+		t.Fatalf("expected every request accounted for, got %+v with %d latencies", report, len(report.latencies))
+	}
+	if p50, p99 := report.percentile(0.5), report.percentile(0.99); p50 <= 0 || p99 < p50 || report.percentile(1) < p99 {
+		t.Fatalf("expected ordered latency percentiles, got p50 %v p99 %v", p50, p99)
+	}
+	if rate := report.successRate(); rate <= 0 || rate >= 1 {
+		t.Fatalf("expected a success rate between 0 and 1, got %v", rate)
+	}
+
+	for _, test := range []loadTest{
+		{target: mock.url, rps: 0, duration: time.Second},
+		{target: mock.url, rps: 10},
+		{target: "ftp://%zz", rps: 10, duration: time.Second},
+	} {
+		if _, err := loadtest(cfg, test, io.Discard); err == nil {
+			t.Fatalf("expected %+v to be refused", test)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// LogFormat names how log lines are written.
+type LogFormat string
+
+const (
+	// LogText writes key=value pairs.
+	LogText LogFormat = "text"
+	// LogJSON writes a JSON object per line.
+	LogJSON LogFormat = "json"
+)
+
+// UnmarshalText rejects unknown log formats.
+func (f *LogFormat) UnmarshalText(text []byte) error {
+	switch format := LogFormat(text); format {
+	case LogText, LogJSON:
+		*f = format
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q", text)
+	}
+}
+
+// newLogger returns a logger writing to w as described by cfg.
+func newLogger(cfg LogConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Format == LogJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
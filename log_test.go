@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	newLogger(LogConfig{Format: LogJSON}, &buf).Info("Circuit breaker state changed", "breaker", "API", "to", "open")
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "Circuit breaker state changed" || line["breaker"] != "API" || line["to"] != "open" {
+		t.Fatalf("unexpected log line %v", line)
+	}
+
+	buf.Reset()
+	newLogger(LogConfig{Format: LogText}, &buf).Info("Config reloaded", "breaker", "API")
+	if got := buf.String(); !strings.Contains(got, `msg="Config reloaded" breaker=API`) {
+		t.Fatalf("unexpected text log line %q", got)
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	var f LogFormat
+	if err := f.UnmarshalText([]byte("json")); err != nil || f != LogJSON {
+		t.Fatalf("expected json, got %q, %v", f, err)
+	}
+	if err := f.UnmarshalText([]byte("xml")); err == nil {
+		t.Fatalf("expected error for an unknown format, got none")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(LogConfig{Format: LogText, Level: slog.LevelWarn}, &buf)
+	logger.Info("Config reloaded")
+	logger.Warn("Upstream is unhealthy")
+	if got := buf.String(); strings.Contains(got, "Config reloaded") || !strings.Contains(got, "Upstream is unhealthy") {
+		t.Fatalf("expected only lines at or above warn, got %q", got)
+	}
+
+	cfg, err := loadConfig(writeConfigFile(t, "config.yaml", "log: {level: debug}"))
+	if err != nil || cfg.Log.Level != slog.LevelDebug {
+		t.Fatalf("expected debug level, got %v, %v", cfg.Log.Level, err)
+	}
+	if defaultConfig().Log.Level != slog.LevelInfo {
+		t.Fatalf("expected info level by default")
+	}
+}
+
+func TestDebugLogsAttempts(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(LogConfig{Format: LogText, Level: slog.LevelDebug}, &buf))
+
+	b := newBreaker(BreakerConfig{Name: "Debug Test", Timeout: time.Minute, ConsecutiveFailures: 5},
+		RetryConfig{Attempts: 2, Backoff: BackoffConstant, BackoffMin: time.Millisecond, BackoffMax: time.Millisecond})
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("simulated failure")
+	}
+	transport := &breakerTransport{b: b}
+	if _, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)); err == nil {
+		t.Fatalf("expected the request to fail")
+	}
+
+	got := buf.String()
+	for _, want := range []string{
+		`msg="Upstream attempt" breaker="Debug Test" state=closed attempt=1`,
+		`decision=upstream_error`,
+		`msg="Backing off before retrying" breaker="Debug Test" attempt=1 delay=1ms`,
+		`msg="Trip policy evaluated" breaker="Debug Test"`,
+		`attempt=2`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected debug log to contain %q, got:\n%s", want, got)
+		}
+	}
+}
@@ -1,106 +1,540 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"math"
-	"math/rand"
+	"log/slog"
+	"net"
 	"net/http"
-	"time"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
 )
 
-var (
-	requestCount = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "request_count",
-			Help: "Number of requests.",
-		},
-		[]string{"state"},
-	)
-	callExternalAPI func() (int, error)
-)
+var callExternalAPI func(req *http.Request) (*http.Response, error)
 
-func init() {
-	prometheus.MustRegister(requestCount)
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
 }
 
-func defaultCallExternalAPI() (int, error) {
-	resp, err := http.Get("https://example.com/api")
-	if err != nil {
-		return 0, err
+// runServe runs the server until SIGTERM or SIGINT, returning the exit
+// status.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to a YAML or JSON config file")
+	listenAddr := fs.String("listen", "", "address to listen on (overrides the config file and environment)")
+	port := fs.String("port", "", "port to listen on on all interfaces, like --listen :PORT")
+	upstreamURL := fs.String("upstream", "", "URL of the upstream service to proxy /api to (overrides the config file and environment)")
+	logLevel := fs.String("log-level", "", "lowest level logged: debug, info, warn or error (overrides the config file and environment)")
+	debug := fs.Bool("debug", false, "log every upstream attempt, backoff and breaker decision, like --log-level debug")
+	requireUpstream := fs.Bool("require-upstream", false, "refuse to start unless every upstream answers the startup self-check")
+	useMock := fs.Bool("mock-upstream", false, "proxy /api to a built-in fake upstream scripted by the mock_upstream config section instead of --upstream")
+	settings := registerSettingFlags(fs, defaultConfig())
+	fs.Parse(args)
+
+	// resolveConfig layers the providers, the config file first, Consul KV
+	// and etcd once set up, then the profile in effect, the environment, the
+	// setting flags such as --retry.attempts and last the shorthand flags
+	// such as --listen and --port.
+	file := &fileProvider{path: *configPath}
+	var mock *mockUpstream
+	providers := []ConfigProvider{file, &profileProvider{}}
+	resolveConfig := func() (Config, error) {
+		cfg, err := layerConfig(providers, settings)
+		if err != nil {
+			return cfg, err
+		}
+		if *listenAddr != "" {
+			cfg.ListenAddr = *listenAddr
+		}
+		if *port != "" {
+			cfg.ListenAddr = ":" + *port
+		}
+		if *upstreamURL != "" {
+			cfg.Upstream = *upstreamURL
+		}
+		if mock != nil {
+			cfg.Upstream = mock.url
+		}
+		if *logLevel != "" {
+			if err := cfg.Log.Level.UnmarshalText([]byte(*logLevel)); err != nil {
+				return cfg, fmt.Errorf("invalid log level: %w", err)
+			}
+		}
+		if *debug {
+			cfg.Log.Level = slog.LevelDebug
+		}
+		if *requireUpstream {
+			cfg.SelfCheck.Require = true
+		}
+		if err := cfg.validate(); err != nil {
+			return cfg, fmt.Errorf("invalid config: %w", err)
+		}
+		return cfg, nil
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode, nil
-}
 
-// exponentialBackoff returns a duration with an exponential backoff strategy
-func exponentialBackoff(attempt int) time.Duration {
-	min := float64(time.Second)
-	max := float64(30 * time.Second)
-	backoff := min * math.Pow(2, float64(attempt))
-	if backoff > max {
-		backoff = max
+	if err := file.Load(context.Background()); err != nil {
+		slog.Error("Failed to load config", "error", err)
+		return 1
+	}
+	cfg, err := resolveConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+		return 1
 	}
-	jitter := rand.Float64() * backoff
-	return time.Duration(jitter)
-}
 
-func main() {
-	callExternalAPI = defaultCallExternalAPI
-
-	http.Handle("/metrics", promhttp.Handler())
-
-	settings := gobreaker.Settings{
-		Name:        "API Circuit Breaker",
-		MaxRequests: 5,
-		Interval:    60 * time.Second,
-		Timeout:     30 * time.Second,
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			// Increment failure count in Prometheus
-			requestCount.WithLabelValues("failure").Inc()
-			return counts.ConsecutiveFailures > 3
-		},
-		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			fmt.Printf("Circuit Breaker %s changed from %s to %s\n", name, from, to)
-			requestCount.WithLabelValues(to.String()).Inc()
-		},
-	}
-	cb := gobreaker.NewCircuitBreaker(settings)
-
-	http.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
-		// _, err := cb.Execute(func() (interface{}, error) {
-		// 	return callExternalAPI()
-		// })
-		var result interface{}
-		var err error
-		attempts := 5
-
-		for i := 0; i < attempts; i++ {
-			result, err = cb.Execute(func() (interface{}, error) {
-				return callExternalAPI()
-			})
-			if err == nil {
-				// Increment success count in Prometheus
-				requestCount.WithLabelValues("success").Inc()
-				break
+	slog.SetDefault(newLogger(cfg.Log, os.Stdout))
+
+	file.interval = cfg.ReloadInterval
+	// Changes to the file are rolled back unless the config they resolve to
+	// validates
+	file.valid = func() error {
+		_, err := resolveConfig()
+		return err
+	}
+	if remote := remoteProviders(cfg); len(remote) > 0 {
+		for _, p := range remote {
+			// Providers bound their own requests
+			if err := p.Load(context.Background()); err != nil {
+				slog.Error("Failed to read remote config", "error", err)
+				return 1
 			}
-			time.Sleep(exponentialBackoff(i))
 		}
+		providers = slices.Insert(providers, 1, remote...)
+		if cfg, err = resolveConfig(); err != nil {
+			slog.Error("Failed to load config", "error", err)
+			return 1
+		}
+	}
+
+	if cfg.Retry.JitterSeed != 0 {
+		jitter = newJitterSource(cfg.Retry.JitterSeed)
+	}
 
+	if *useMock {
+		if mock, err = startMockUpstream(cfg.MockUpstream); err != nil {
+			slog.Error("Failed to start mock upstream", "error", err)
+			return 1
+		}
+		defer mock.close()
+		cfg.Upstream = mock.url
+	}
+
+	target, err := parseUpstream(cfg.Upstream)
+	if err != nil {
+		slog.Error("Invalid upstream URL", "upstream", cfg.Upstream, "error", err)
+		return 1
+	}
+
+	// Stop on SIGTERM or SIGINT, draining requests in flight first
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
+	shutdownMetrics, err := setupOTLPMetrics(context.Background(), cfg.Metrics, cfg.Tracing.ServiceName)
+	if err != nil {
+		slog.Error("Failed to set up OTLP metrics", "error", err)
+		return 1
+	}
+	defer shutdownMetrics(context.Background())
+
+	if cfg.Metrics.StatsDAddr != "" {
+		statsd, err := newStatsdSink(cfg.Metrics.StatsDAddr, cfg.Metrics.StatsDPrefix, cfg.Metrics.StatsDTags)
 		if err != nil {
-			// Increment failure count in Prometheus
-			requestCount.WithLabelValues("failure").Inc()
-			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			slog.Error("Failed to set up StatsD", "error", err)
+			return 1
+		}
+		defer statsd.Close()
+		sink = statsd
+	}
+
+	transport, err := newUpstreamTransport(cfg)
+	if err != nil {
+		slog.Error("Invalid upstream transport settings", "error", err)
+		return 1
+	}
+	faults := newFaultInjector(cfg.Faults, cfg.Breaker.Name)
+	callExternalAPI = faults.roundTrip(transport.RoundTrip)
+
+	// Operational endpoints live on their own listener when AdminAddr is set
+	mux, adminMux := http.NewServeMux(), http.NewServeMux()
+	if cfg.AdminAddr == "" {
+		adminMux = mux
+	}
+	// OpenMetrics carries the request IDs of latency exemplars
+	adminMux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+
+	b := newBreaker(cfg.Breaker, cfg.Retry)
+	if err := configureShadow(b, cfg); err != nil {
+		slog.Error("Invalid shadow breaker", "error", err)
+		return 1
+	}
+	retries.configure(cfg.Retry)
+	outbound.configure(cfg.Outbound)
+
+	breakers := []*breaker{b}
+	var backups []*upstream
+	for _, raw := range cfg.Upstreams {
+		backup, err := parseUpstream(raw)
+		if err != nil {
+			slog.Error("Invalid upstream URL", "upstream", raw, "error", err)
+			return 1
+		}
+		u := &upstream{target: backup, b: newBreaker(upstreamBreaker(cfg.Breaker, backup), cfg.Retry)}
+		backups = append(backups, u)
+		breakers = append(breakers, u.b)
+	}
+
+	var health []*healthChecker
+	if cfg.HealthCheck.Path != "" {
+		health = append(health, newHealthChecker(cfg.HealthCheck, b, target))
+		for _, u := range backups {
+			health = append(health, newHealthChecker(cfg.HealthCheck, u.b, u.target))
+		}
+		for _, c := range health {
+			go c.run(ctx)
+		}
+	}
+	api := &breakerTransport{
+		b:        b,
+		target:   target,
+		backups:  backups,
+		balance:  cfg.Balance,
+		outliers: newOutlierDetector(cfg.Outlier, len(backups)+1),
+		health:   health,
+	}
+	if cfg.Breaker.SplitMethods {
+		api.splitWrites(cfg.Retry)
+	}
+	writes := api.writeUpstreams()
+
+	routes, err := newRoutes(cfg)
+	if err != nil {
+		slog.Error("Invalid route", "error", err)
+		return 1
+	}
+	for _, r := range routes {
+		breakers = append(breakers, r.t.b)
+		writes = append(writes, r.t.writeUpstreams()...)
+	}
+	for _, u := range writes {
+		breakers = append(breakers, u.b)
+	}
+
+	checks := []selfCheckTarget{{b.current().cfg.Name, target}}
+	for _, u := range backups {
+		checks = append(checks, selfCheckTarget{u.b.current().cfg.Name, u.target})
+	}
+	for _, r := range routes {
+		checks = append(checks, selfCheckTarget{r.t.b.current().cfg.Name, r.t.target})
+	}
+	if err := selfCheck(ctx, cfg.SelfCheck, checks); err != nil {
+		slog.Error("Upstream self-check failed", "error", err)
+		return 1
+	}
+
+	events := newEventHub()
+	adminMux.Handle("GET /events", eventsHandler(events))
+	history := newEventHistory(cfg.EventHistory)
+	adminMux.Handle("GET /events/history", historyHandler(history))
+	var redis *redisClient
+	var peers *peerBroadcast
+	if cfg.Redis.Addr != "" {
+		redis = newRedisClient(cfg.Redis)
+		defer redis.Close()
+		if cfg.Redis.Broadcast {
+			peers = newPeerBroadcast(redis, cfg.Redis)
+			go peers.run(ctx)
+		}
+	}
+	// Ready once every listener is up, whichever serves the probes
+	probes := newReadiness("http", "admin", "grpc", "upstream connections")
+	if cfg.AdminAddr == "" {
+		probes.pass("admin")
+	}
+	if cfg.GRPCAddr == "" {
+		probes.pass("grpc")
+	}
+	probes.configure(cfg.Readiness, cfg.Breaker.Name)
+	go func() {
+		targets := make([]*url.URL, len(checks))
+		for i, c := range checks {
+			targets[i] = c.target
+		}
+		warmUp(ctx, cfg.WarmUp, targets)
+		probes.pass("upstream connections")
+	}()
+	watch := func(b *breaker) {
+		b.onStateChange(events.publish)
+		b.onStateChange(probes.recordChange)
+		b.onStateChange(history.recordChange)
+		b.onRejection(history.recordRejection)
+		if redis != nil {
+			shareState(ctx, redis, cfg.Redis, b)
+		}
+		if peers != nil {
+			peers.watch(b)
+		}
+		if len(cfg.Notify.WebhookURLs) > 0 {
+			b.onStateChange(newWebhookNotifier(cfg.Notify).notify)
+		}
+		if cfg.Notify.SlackWebhookURL != "" {
+			b.onStateChange(newSlackNotifier(cfg.Notify).notify)
+		}
+		if cfg.Notify.PagerDutyRoutingKey != "" {
+			b.onStateChange(newPagerDutyNotifier(cfg.Notify).notify)
+		}
+	}
+	for _, b := range breakers {
+		watch(b)
+	}
+	if cfg.Tenant.Header != "" {
+		// Tenants' breakers are created on their first call
+		api.splitTenants(cfg.Tenant, watch)
+		for _, r := range routes {
+			r.t.splitTenants(cfg.Tenant, watch)
+		}
+	}
+
+	keys, err := newAPIKeys(cfg.Auth, cfg.Breaker.Name)
+	if err != nil {
+		slog.Error("Invalid API keys", "error", err)
+		return 1
+	}
+
+	downtime := newMaintenance(cfg.Maintenance, cfg.Breaker.Name)
+	go downtime.runWindows(ctx, breakers)
+	access := newAccessLog(cfg.Log)
+	limiter := newRateLimiter(cfg.RateLimit, cfg.Breaker.Name)
+	go limiter.run(ctx)
+
+	audit, err := newAuditLog(cfg.Audit)
+	if err != nil {
+		slog.Error("Invalid audit log", "error", err)
+		return 1
+	}
+	digest := configDigest(cfg)
+	// Reloads are triggered by the config file, Consul, etcd and profiles
+	var reloadMu sync.Mutex
+	file.invalid = func(trigger string, err error) {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		audit.record(auditEntry{Actor: trigger, Action: "reload", From: digest, To: digest, Error: err.Error()})
+	}
+	reload := func(trigger string) {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		newCfg, err := resolveConfig()
+		if err != nil {
+			slog.Warn("Config reload failed, keeping current settings", "error", err)
+			audit.record(auditEntry{Actor: trigger, Action: "reload", From: digest, To: digest, Error: err.Error()})
 			return
 		}
-		w.Write([]byte(fmt.Sprintf("Request succeeded: %v", result)))
-	})
+		slog.SetDefault(newLogger(newCfg.Log, os.Stdout))
+		b.reload(newCfg.Breaker, newCfg.Retry)
+		if err := configureShadow(b, newCfg); err != nil {
+			slog.Warn("Shadow breaker reload failed, keeping current settings", "error", err)
+		}
+		for _, u := range backups {
+			u.b.reload(upstreamBreaker(newCfg.Breaker, u.target), newCfg.Retry)
+		}
+		api.reloadWrites(newCfg.Breaker, newCfg.Retry)
+		api.reloadTenants()
+		reloadRoutes(routes, newCfg)
+		retries.configure(newCfg.Retry)
+		outbound.configure(newCfg.Outbound)
+		access.configure(newCfg.Log)
+		limiter.configure(newCfg.RateLimit)
+		downtime.configure(newCfg.Maintenance)
+		faults.configure(newCfg.Faults)
+		if mock != nil {
+			mock.configure(newCfg.MockUpstream)
+		}
+		probes.configure(newCfg.Readiness, newCfg.Breaker.Name)
+		if err := keys.configure(newCfg.Auth); err != nil {
+			slog.Warn("API keys reload failed, keeping current keys", "error", err)
+		}
+		if !slices.EqualFunc(newCfg.Routes, cfg.Routes, sameRoute) {
+			slog.Warn("Added, removed and moved routes take effect after a restart")
+		}
+		if newCfg.ListenAddr != cfg.ListenAddr || newCfg.AdminAddr != cfg.AdminAddr || newCfg.GRPCAddr != cfg.GRPCAddr || newCfg.Upstream != cfg.Upstream || !slices.Equal(newCfg.Upstreams, cfg.Upstreams) {
+			slog.Warn("Listen address and upstream changes take effect after a restart")
+		}
+		previous := digest
+		digest = configDigest(newCfg)
+		audit.record(auditEntry{Actor: trigger, Action: "reload", From: previous, To: digest})
+		slog.Info("Config reloaded")
+	}
+	for _, p := range providers {
+		go p.Watch(ctx, reload)
+	}
+
+	verifier, err := newJWTVerifier(cfg.AdminAuth)
+	if err != nil {
+		slog.Error("Invalid admin authentication settings", "error", err)
+		return 1
+	}
+	adminOps := http.NewServeMux()
+	registerAdminHandlers(adminOps, b, audit)
+	registerMaintenanceHandlers(adminOps, downtime, audit)
+	registerFaultHandlers(adminOps, faults, audit)
+	if verifier != nil {
+		adminMux.Handle("/admin/", verifier.handler(adminOps))
+	} else {
+		adminMux.Handle("/admin/", adminOps)
+	}
+	adminMux.Handle("GET /status", statusHandler(b))
+	adminMux.Handle("GET /healthz", livenessHandler())
+	adminMux.Handle("GET /readyz", readinessHandler(probes))
+
+	fallback, err := newFallback(cfg.Proxy, b)
+	if err != nil {
+		slog.Error("Invalid fallback", "error", err)
+		return 1
+	}
+	if cfg.Breaker.ProbePath != "" {
+		go (&canary{b: b, target: target}).run(ctx)
+		for _, u := range backups {
+			go (&canary{b: u.b, target: u.target}).run(ctx)
+		}
+	}
+	for _, r := range routes {
+		if r.t.b.current().cfg.ProbePath != "" {
+			go (&canary{b: r.t.b, target: r.t.target}).run(ctx)
+		}
+	}
+	for _, u := range writes {
+		if u.b.current().cfg.ProbePath != "" {
+			go (&canary{b: u.b, target: u.target}).run(ctx)
+		}
+	}
+
+	handlers := map[string]http.Handler{
+		"/api": proxyHandler(cfg.Proxy, "/api", api, fallback),
+	}
+	for _, r := range routes {
+		fallback, err := newFallback(cfg.Proxy, r.t.b)
+		if err != nil {
+			slog.Error("Invalid fallback", "error", err)
+			return 1
+		}
+		handlers[r.cfg.Path] = proxyHandler(cfg.Proxy, r.cfg.Path, r.t, fallback)
+	}
+	var shedder *loadShedder
+	if cfg.Shed.enabled() {
+		shedder = newLoadShedder(cfg.Shed, cfg.Breaker.Name)
+		go shedder.run(ctx)
+	}
+	for path, h := range handlers {
+		h = downtime.handler(h)
+		if shedder != nil {
+			h = shedder.handler(h)
+		}
+		h = keys.handler(h)
+		h = limiter.handler(h)
+		h = traceHandler(h)
+		// Recovered panics are logged with the request ID and the 500
+		// they turn into is in the access log
+		h = recoverHandler(cfg.Breaker.Name, h)
+		h = access.handler(h)
+		h = requestIDHandler(h)
+		mux.Handle(path, h)
+		mux.Handle(path+"/", h)
+	}
+
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		slog.Error("Server failed to start", "error", err)
+		return 1
+	}
+	probes.pass("http")
+	srv := &http.Server{Handler: recoverHandler(cfg.Breaker.Name, mux)}
+	srv.RegisterOnShutdown(events.close)
+	if cfg.TLS.CertFile != "" {
+		tlsConfig, err := cfg.TLS.serverConfig()
+		if err != nil {
+			slog.Error("Invalid TLS settings", "error", err)
+			return 1
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	var admin sync.WaitGroup
+	if cfg.AdminAddr != "" {
+		adminLn, err := net.Listen("tcp", cfg.AdminAddr)
+		if err != nil {
+			slog.Error("Admin server failed to start", "error", err)
+			return 1
+		}
+		probes.pass("admin")
+		adminSrv := &http.Server{Handler: recoverHandler(cfg.Breaker.Name, adminMux)}
+		adminSrv.RegisterOnShutdown(events.close)
+		slog.Info("Serving metrics and admin endpoints", "addr", cfg.AdminAddr)
+		admin.Add(1)
+		go func() {
+			defer admin.Done()
+			if err := serve(ctx, adminSrv, adminLn, cfg.ShutdownGrace); err != nil {
+				slog.Error("Admin server stopped", "error", err)
+			}
+		}()
+	}
+
+	if cfg.GRPCAddr != "" {
+		grpcLn, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			slog.Error("gRPC control plane failed to start", "error", err)
+			return 1
+		}
+		var opts []grpc.ServerOption
+		if verifier != nil {
+			opts = verifier.serverOptions()
+		}
+		probes.pass("grpc")
+		grpcSrv := grpc.NewServer(opts...)
+		registerControlServer(grpcSrv, breakers, events, audit)
+		slog.Info("Serving the gRPC control plane", "addr", cfg.GRPCAddr)
+		admin.Add(1)
+		go func() {
+			defer admin.Done()
+			if err := grpcSrv.Serve(grpcLn); err != nil {
+				slog.Error("gRPC control plane stopped", "error", err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			// End event streams first, they would hold up a graceful stop
+			events.close()
+			grpcSrv.GracefulStop()
+		}()
+	}
 
-	fmt.Println("Starting server on :8111...")
-	if err := http.ListenAndServe(":8111", nil); err != nil {
-		fmt.Printf("Server failed to start: %v\n", err)
+	slog.Info("Starting server", "addr", cfg.ListenAddr, "upstream", target.String(), "breaker", cfg.Breaker.Name)
+	go func() {
+		<-ctx.Done()
+		probes.stop()
+		slog.Info("Shutting down, draining requests in flight", "grace", cfg.ShutdownGrace)
+	}()
+	status := 0
+	if err := serve(ctx, srv, ln, cfg.ShutdownGrace); err != nil {
+		slog.Error("Server stopped", "error", err)
+		status = 1
 	}
+	admin.Wait()
+	return status
 }
@@ -11,6 +11,7 @@ import (
 )
 
 func TestCircuitBreakerV3(t *testing.T) {
+	fake := useFakeClock(t)
 	// Create a mock server to simulate external API
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -19,13 +20,11 @@ func TestCircuitBreakerV3(t *testing.T) {
 	defer server.Close()
 
 	// Replace callExternalAPI with a function that calls the mock server
-	callExternalAPI = func() (int, error) {
-		resp, err := http.Get(server.URL)
-		if err != nil {
-			return 0, err
-		}
-		defer resp.Body.Close()
-		return resp.StatusCode, nil
+	callExternalAPI = http.DefaultTransport.RoundTrip
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
 	}
 
 	// Configure circuit breaker settings for testing
@@ -39,11 +38,11 @@ func TestCircuitBreakerV3(t *testing.T) {
 		},
 	}
 
-	cb := gobreaker.NewCircuitBreaker(settings)
+	cb := newCircuit(settings)
 
 	t.Run("SuccessfulRequest", func(t *testing.T) {
 		_, err := cb.Execute(func() (interface{}, error) {
-			return callExternalAPI()
+			return callExternalAPI(req)
 		})
 		if err != nil {
 			t.Fatalf("expected no error, got %v", err)
@@ -53,13 +52,13 @@ func TestCircuitBreakerV3(t *testing.T) {
 	//Simulates consecutive failed requests and checks if the circuit breaker trips to the open state.
 	t.Run("FailedRequests", func(t *testing.T) {
 		// Override callExternalAPI to simulate failure
-		callExternalAPI = func() (int, error) {
-			return 0, errors.New("simulated failure")
+		callExternalAPI = func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("simulated failure")
 		}
 
 		for i := 0; i < 4; i++ {
 			_, err := cb.Execute(func() (interface{}, error) {
-				return callExternalAPI()
+				return callExternalAPI(req)
 			})
 			if err == nil {
 				t.Fatalf("expected error, got none")
@@ -76,13 +75,13 @@ func TestCircuitBreakerV3(t *testing.T) {
 	//then checks if it closes again after a successful request.
 	t.Run("RetryAfterTimeout", func(t *testing.T) {
 		// Simulate circuit breaker opening
-		callExternalAPI = func() (int, error) {
-			return 0, errors.New("simulated failure")
+		callExternalAPI = func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("simulated failure")
 		}
 
 		for i := 0; i < 4; i++ {
 			_, err := cb.Execute(func() (interface{}, error) {
-				return callExternalAPI()
+				return callExternalAPI(req)
 			})
 			if err == nil {
 				t.Fatalf("expected error, got none")
@@ -93,24 +92,17 @@ func TestCircuitBreakerV3(t *testing.T) {
 			t.Fatalf("expected circuit breaker to be open, got %v", cb.State())
 		}
 
-		// Wait for timeout duration
-		time.Sleep(settings.Timeout + 1*time.Second)
+		// Let the timeout pass
+		fake.Advance(settings.Timeout)
 
 		//After the timeout period,
 		//the circuit breaker should transition to the half-open state.
 
 		// Restore original callExternalAPI to simulate success
-		callExternalAPI = func() (int, error) {
-			resp, err := http.Get(server.URL)
-			if err != nil {
-				return 0, err
-			}
-			defer resp.Body.Close()
-			return resp.StatusCode, nil
-		}
+		callExternalAPI = http.DefaultTransport.RoundTrip
 
 		_, err := cb.Execute(func() (interface{}, error) {
-			return callExternalAPI()
+			return callExternalAPI(req)
 		})
 		if err != nil {
 			t.Fatalf("expected no error, got %v", err)
@@ -123,7 +115,7 @@ func TestCircuitBreakerV3(t *testing.T) {
 		//After verifying the half-open state, another successful request is simulated to ensure the circuit breaker transitions back to the closed state.
 		for i := 0; i < int(settings.MaxRequests); i++ {
 			_, err = cb.Execute(func() (interface{}, error) {
-				return callExternalAPI()
+				return callExternalAPI(req)
 			})
 			if err != nil {
 				t.Fatalf("expected no error, got %v", err)
@@ -141,15 +133,15 @@ func TestCircuitBreakerV3(t *testing.T) {
 			stateChanges = append(stateChanges, to)
 		}
 
-		cb = gobreaker.NewCircuitBreaker(settings)
+		cb = newCircuit(settings)
 
 		// Simulate failures to trip the circuit breaker
-		callExternalAPI = func() (int, error) {
-			return 0, errors.New("simulated failure")
+		callExternalAPI = func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("simulated failure")
 		}
 		for i := 0; i < 4; i++ {
 			_, err := cb.Execute(func() (interface{}, error) {
-				return callExternalAPI()
+				return callExternalAPI(req)
 			})
 			if err == nil {
 				t.Fatalf("expected error, got none")
@@ -175,15 +167,15 @@ func TestCircuitBreakerV3(t *testing.T) {
 			return counts.ConsecutiveFailures > 2 // Trip after 2 failures
 		}
 
-		cb = gobreaker.NewCircuitBreaker(settings)
+		cb = newCircuit(settings)
 
 		// Simulate failures
-		callExternalAPI = func() (int, error) {
-			return 0, errors.New("simulated failure")
+		callExternalAPI = func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("simulated failure")
 		}
 		for i := 0; i < 3; i++ {
 			_, err := cb.Execute(func() (interface{}, error) {
-				return callExternalAPI()
+				return callExternalAPI(req)
 			})
 			if err == nil {
 				t.Fatalf("expected error, got none")
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// maintenance is the maintenance mode operators turn on for planned
+// upstream downtime. While it is on, /api requests are answered with 503
+// and a message without reaching the breakers, so they neither count
+// toward their stats nor trip them.
+type maintenance struct {
+	// name is the main breaker's, labelling metrics.
+	name  string
+	cfg   atomic.Pointer[MaintenanceConfig]
+	state atomic.Pointer[maintenanceState]
+}
+
+// maintenanceState is the JSON document served by GET /admin/maintenance.
+type maintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+	// Until is when the downtime is planned to end, announced in
+	// Retry-After.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func newMaintenance(cfg MaintenanceConfig, name string) *maintenance {
+	m := &maintenance{name: name}
+	m.configure(cfg)
+	m.state.Store(&maintenanceState{})
+	return m
+}
+
+func (m *maintenance) configure(cfg MaintenanceConfig) {
+	m.cfg.Store(&cfg)
+}
+
+// enable turns maintenance mode on with message, the configured one when
+// empty, until the zero time for no planned end.
+func (m *maintenance) enable(message string, until time.Time) {
+	if message == "" {
+		message = m.cfg.Load().Message
+	}
+	now := clock.Now()
+	s := &maintenanceState{Enabled: true, Message: message, Since: &now}
+	if !until.IsZero() {
+		s.Until = &until
+	}
+	m.state.Store(s)
+}
+
+func (m *maintenance) disable() {
+	m.state.Store(&maintenanceState{})
+}
+
+// handler answers requests with 503 and the maintenance message instead of
+// passing them to next while maintenance mode is on.
+func (m *maintenance) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.state.Load()
+		if !s.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		observeMaintenance(m.name)
+		if s.Until != nil {
+			if seconds := int(s.Until.Sub(clock.Now()).Seconds()); seconds > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			}
+		}
+		http.Error(w, s.Message, http.StatusServiceUnavailable)
+	})
+}
+
+// registerMaintenanceHandlers adds the operator endpoints turning m on and
+// off to mux, recording their use in audit. POST /admin/maintenance/on
+// takes an optional JSON body with a message and an RFC 3339 until time.
+func registerMaintenanceHandlers(mux *http.ServeMux, m *maintenance, audit *auditLog) {
+	mux.HandleFunc("GET /admin/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.state.Load())
+	})
+	mux.HandleFunc("POST /admin/maintenance/on", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Message string    `json:"message"`
+			Until   time.Time `json:"until"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid maintenance request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		from := maintenanceMode(m)
+		m.enable(req.Message, req.Until)
+		audit.record(auditEntry{Actor: requestActor(r), Action: "maintenance_on", From: from, To: maintenanceMode(m)})
+		slog.Info("Maintenance mode on", "message", m.state.Load().Message)
+		w.Write([]byte("Maintenance mode on\n"))
+	})
+	mux.HandleFunc("POST /admin/maintenance/off", func(w http.ResponseWriter, r *http.Request) {
+		from := maintenanceMode(m)
+		m.disable()
+		audit.record(auditEntry{Actor: requestActor(r), Action: "maintenance_off", From: from, To: maintenanceMode(m)})
+		slog.Info("Maintenance mode off")
+		w.Write([]byte("Maintenance mode off\n"))
+	})
+}
+
+// maintenanceMode names whether m is on, for the audit log.
+func maintenanceMode(m *maintenance) string {
+	if m.state.Load().Enabled {
+		return "maintenance"
+	}
+	return "serving"
+}
+
+// WindowAction selects what a breaker does during a maintenance window.
+type WindowAction string
+
+const (
+	// WindowOpen rejects calls as an open breaker does, without counting
+	// toward its stats, changing its state or notifying anyone.
+	WindowOpen WindowAction = "open"
+	// WindowFallback answers requests with the fallback, or a 503 when
+	// there is none, as for planned downtime rather than a failure.
+	WindowFallback WindowAction = "fallback"
+)
+
+func (a *WindowAction) UnmarshalText(text []byte) error {
+	switch v := WindowAction(text); v {
+	case WindowOpen, WindowFallback:
+		*a = v
+		return nil
+	default:
+		return fmt.Errorf("unknown maintenance window action %q", text)
+	}
+}
+
+// errMaintenanceWindow rejects calls during a maintenance window with the
+// fallback action.
+var errMaintenanceWindow = errors.New("upstream in a maintenance window")
+
+// activeWindow is the maintenance window a breaker is in.
+type activeWindow struct {
+	action WindowAction
+	until  time.Time
+}
+
+// err returns the error calls are rejected with during the window, nil
+// outside of one. A nil window is outside of one.
+func (w *activeWindow) err() error {
+	switch {
+	case w == nil:
+		return nil
+	case w.action == WindowFallback:
+		return errMaintenanceWindow
+	default:
+		return gobreaker.ErrOpenState
+	}
+}
+
+// validate checks the maintenance windows are complete.
+func (cfg MaintenanceConfig) validate() error {
+	for _, w := range cfg.Windows {
+		if w.Schedule == "" || w.Duration <= 0 {
+			return fmt.Errorf("maintenance window %q needs a schedule and a positive duration", w.Schedule)
+		}
+	}
+	return nil
+}
+
+// runWindows puts breakers in and out of the configured maintenance windows
+// until ctx is done.
+func (m *maintenance) runWindows(ctx context.Context, breakers []*breaker) {
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		m.applyWindows(breakers, clock.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// applyWindows puts each of breakers in the window it is in at now, the one
+// ending last if several, or out of any.
+func (m *maintenance) applyWindows(breakers []*breaker, now time.Time) {
+	cfg := m.cfg.Load()
+	now = now.In(cfg.Timezone.location())
+	for _, b := range breakers {
+		name := b.current().cfg.Name
+		var active *activeWindow
+		for _, w := range cfg.Windows {
+			if len(w.Breakers) > 0 && !slices.Contains(w.Breakers, name) {
+				continue
+			}
+			schedule, err := parseSchedule(string(w.Schedule))
+			if err != nil {
+				continue
+			}
+			start, ok := schedule.lastStart(now, w.Duration)
+			if !ok {
+				continue
+			}
+			if until := start.Add(w.Duration); active == nil || until.After(active.until) {
+				active = &activeWindow{action: w.Action, until: until}
+				if active.action == "" {
+					active.action = WindowOpen
+				}
+			}
+		}
+		switch previous := b.window.Swap(active); {
+		case previous == nil && active != nil:
+			slog.Info("Maintenance window started", "breaker", name, "action", active.action, "until", active.until)
+		case previous != nil && active == nil:
+			slog.Info("Maintenance window ended", "breaker", name)
+		}
+	}
+}
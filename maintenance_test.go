@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := newMaintenance(MaintenanceConfig{Message: "Down for maintenance"}, "Maintenance Test")
+	mux := http.NewServeMux()
+	registerMaintenanceHandlers(mux, m, nil)
+	reached := 0
+	api := m.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+	}))
+	call := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		return rec
+	}
+	post := func(t *testing.T, path, body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d from %s, got %d: %s", http.StatusOK, path, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := call(); rec.Code != http.StatusOK || reached != 1 {
+		t.Fatalf("expected requests to pass outside maintenance, got %d", rec.Code)
+	}
+
+	post(t, "/admin/maintenance/on", "")
+	rec := call()
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "Down for maintenance") || reached != 1 {
+		t.Fatalf("expected the configured message without reaching the upstream, got %d %q", rec.Code, rec.Body)
+	}
+
+	until := time.Now().Add(time.Hour).Format(time.RFC3339)
+	post(t, "/admin/maintenance/on", `{"message": "Upgrading the database", "until": "`+until+`"}`)
+	rec = call()
+	if !strings.Contains(rec.Body.String(), "Upgrading the database") || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the given message and a Retry-After, got %q, %q", rec.Body, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+	var state maintenanceState
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil || !state.Enabled || state.Until == nil {
+		t.Fatalf("expected maintenance to be reported on until a time, got %+v, %v", state, err)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/maintenance/on", strings.NewReader("{")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid body to be refused, got %d", rec.Code)
+	}
+
+	post(t, "/admin/maintenance/off", "")
+	if rec := call(); rec.Code != http.StatusOK || reached != 2 {
+		t.Fatalf("expected requests to pass once maintenance is off, got %d", rec.Code)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	primary := newBreaker(BreakerConfig{Name: "Primary", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{Attempts: 1})
+	other := newBreaker(BreakerConfig{Name: "Other", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{Attempts: 1})
+	m := newMaintenance(MaintenanceConfig{
+		Timezone: "America/New_York",
+		Windows: []MaintenanceWindow{
+			{Schedule: "0 2 * * *", Duration: 2 * time.Hour},
+			{Schedule: "0 3 * * *", Duration: 2 * time.Hour, Action: WindowFallback, Breakers: []string{"Other"}},
+		},
+	}, "Primary")
+	breakers := []*breaker{primary, other}
+	newYork, _ := time.LoadLocation("America/New_York")
+	succeed := func() (interface{}, error) { return "ok", nil }
+
+	// 2:30am in New York
+	m.applyWindows(breakers, time.Date(2024, 6, 2, 2, 30, 0, 0, newYork).UTC())
+	if _, err := primary.execute(primary.current(), succeed); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected the breaker to reject calls during the window, got %v", err)
+	}
+	if state := primary.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the window to leave the breaker state alone, got %v", state)
+	}
+	if w := primary.status().MaintenanceWindow; w != WindowOpen {
+		t.Fatalf("expected the window to be reported, got %q", w)
+	}
+
+	// 3:30am, where the fallback window ending later wins for the other
+	m.applyWindows(breakers, time.Date(2024, 6, 2, 3, 30, 0, 0, newYork))
+	if _, err := other.execute(other.current(), succeed); !errors.Is(err, errMaintenanceWindow) || fallbackReason(err) != "maintenance" {
+		t.Fatalf("expected calls to go to the fallback, got %v", err)
+	}
+
+	m.applyWindows(breakers, time.Date(2024, 6, 2, 4, 30, 0, 0, newYork))
+	if _, err := primary.execute(primary.current(), succeed); err != nil {
+		t.Fatalf("expected calls through once the window is over, got %v", err)
+	}
+	if _, err := other.execute(other.current(), succeed); !errors.Is(err, errMaintenanceWindow) {
+		t.Fatalf("expected the other breaker still in its window, got %v", err)
+	}
+}
+
+func TestMaintenanceWindowValidation(t *testing.T) {
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "maintenance:\n  windows:\n    - schedule: \"0 2 * * *\"\n")); err == nil {
+		t.Fatalf("expected a window without a duration to be refused")
+	}
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "maintenance:\n  windows:\n    - schedule: \"0 2 * *\"\n      duration: 1h\n")); err == nil {
+		t.Fatalf("expected an invalid schedule to be refused")
+	}
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "maintenance:\n  windows:\n    - schedule: \"0 2 * * *\"\n      duration: 1h\n      action: reboot\n")); err == nil {
+		t.Fatalf("expected an unknown action to be refused")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "net/http"
+
+// isRead reports whether method only reads, which GET, HEAD and OPTIONS
+// requests do.
+func isRead(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// writesBreaker returns the settings of the breaker guarding writes when
+// they are split from reads: those of cfg under a name saying so.
+func writesBreaker(cfg BreakerConfig) BreakerConfig {
+	cfg.Name += " writes"
+	return cfg
+}
+
+// splitWrites gives t a twin for writes to the same upstreams, guarded by
+// breakers of its own so failing writes do not open the circuit for reads.
+// Both share the health checks and outlier detection of the upstreams.
+func (t *breakerTransport) splitWrites(retry RetryConfig) {
+	cfg := writesBreaker(t.b.current().cfg)
+	writes := &breakerTransport{
+		b:        buildBreaker(cfg.Name, withConfig(cfg), withRetry(retry), withMetrics(t.b.metrics)),
+		target:   t.target,
+		balance:  t.balance,
+		outliers: t.outliers,
+		health:   t.health,
+	}
+	if t.tenant != nil {
+		writes.tenant = &tenant{id: t.tenant.id, breaker: writesBreaker(BreakerConfig{Name: t.tenant.breaker}).Name, metrics: t.tenant.metrics}
+	}
+	for _, u := range t.backups {
+		backup := writesBreaker(u.b.current().cfg)
+		writes.backups = append(writes.backups, &upstream{
+			target: u.target,
+			b:      buildBreaker(backup.Name, withConfig(backup), withRetry(retry), withMetrics(u.b.metrics)),
+		})
+	}
+	t.writes = writes
+}
+
+// forMethod returns the transport calls with the given method go through.
+func (t *breakerTransport) forMethod(method string) *breakerTransport {
+	if t.writes == nil || isRead(method) {
+		return t
+	}
+	return t.writes
+}
+
+// reloadWrites applies cfg, the settings of the breaker guarding t's
+// reads, to the breakers guarding its writes if they are split.
+func (t *breakerTransport) reloadWrites(cfg BreakerConfig, retry RetryConfig) {
+	if t.writes == nil {
+		return
+	}
+	t.writes.b.reload(writesBreaker(cfg), retry)
+	for _, u := range t.writes.backups {
+		u.b.reload(writesBreaker(upstreamBreaker(cfg, u.target)), retry)
+	}
+}
+
+// writeUpstreams returns the breakers guarding t's writes with the
+// upstreams they guard, or nothing if writes are not split.
+func (t *breakerTransport) writeUpstreams() []*upstream {
+	if t.writes == nil {
+		return nil
+	}
+	ups := []*upstream{{b: t.writes.b, target: t.target}}
+	return append(ups, t.writes.backups...)
+}
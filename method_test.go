@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestSplitWrites(t *testing.T) {
+	target, _ := url.Parse("http://upstream.invalid/api")
+	backup, _ := url.Parse("http://backup.invalid/api")
+	cfg := BreakerConfig{Name: "Split Test", Timeout: time.Minute, ConsecutiveFailures: 1, RejectStatus: http.StatusTooManyRequests, SplitMethods: true}
+	retry := RetryConfig{Attempts: 1}
+	transport := &breakerTransport{
+		b:       newBreaker(cfg, retry),
+		target:  target,
+		backups: []*upstream{{target: backup, b: newBreaker(upstreamBreaker(cfg, backup), retry)}},
+	}
+	transport.splitWrites(retry)
+
+	// Writes fail while reads succeed
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		if !isRead(req.Method) {
+			return nil, errors.New("simulated failure")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	api := http.StripPrefix("/api", newProxy(transport, nil))
+	for range 4 {
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/orders", nil))
+	}
+
+	writes := transport.writeUpstreams()
+	if len(writes) != 2 || writes[0].b.current().cfg.Name != "Split Test writes" || writes[1].target != backup {
+		t.Fatalf("expected write breakers for both upstreams, got %+v", writes)
+	}
+	for _, u := range writes {
+		if state := u.b.current().cb.State(); state != gobreaker.StateOpen {
+			t.Fatalf("expected write breaker %s to be open, got %s", u.b.current().cfg.Name, state)
+		}
+	}
+	if state := transport.b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the read breaker to stay closed, got %s", state)
+	}
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected reads to go through, got status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/orders/1", nil))
+	if rec.Code != cfg.RejectStatus {
+		t.Fatalf("expected writes to be rejected, got status %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the open write breaker to set Retry-After")
+	}
+
+	cfg.Timeout = 2 * time.Minute
+	transport.reloadWrites(cfg, retry)
+	if got := writes[1].b.current().cfg; got.Timeout != 2*time.Minute || got.Name != "Split Test backup.invalid writes" {
+		t.Fatalf("expected reloaded write breaker settings, got %+v", got)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+// Every metric carries a breaker label so that series of different breakers
+// are never merged.
+var (
+	requestCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "request_count",
+			Help: "Number of requests.",
+		},
+		[]string{"breaker", "state"},
+	)
+	breakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Current breaker state (0=closed, 1=half-open, 2=open).",
+		},
+		[]string{"breaker"},
+	)
+	rejectedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_rejected_total",
+			Help: "Number of upstream calls rejected by the breaker without being attempted.",
+		},
+		[]string{"breaker", "reason"},
+	)
+	upstreamLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "upstream_call_duration_seconds",
+			Help:    "Duration of upstream call attempts.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"breaker", "outcome", "attempt"},
+	)
+	retryBudgetExhausted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "retry_budget_exhausted_total",
+			Help: "Number of retries skipped because the service-wide retry budget was spent.",
+		},
+		[]string{"breaker"},
+	)
+	requestAttempts = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "request_attempts",
+			Help:    "Number of upstream attempts made per proxied request.",
+			Buckets: prometheus.LinearBuckets(1, 1, 10),
+		},
+		[]string{"breaker", "outcome"},
+	)
+	hedgeCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hedged_requests_total",
+			Help: "Number of hedge requests sent, of those that answered first, and of those skipped at the call limits.",
+		},
+		[]string{"breaker", "result"},
+	)
+	coalescedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coalesced_requests_total",
+			Help: "Number of requests answered by sharing another request's upstream call.",
+		},
+		[]string{"breaker"},
+	)
+	cacheCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Number of cacheable requests answered from the response cache or not.",
+		},
+		[]string{"breaker", "result"},
+	)
+	ejectionCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_ejections_total",
+			Help: "Number of times an upstream was ejected for failing more than its peers.",
+		},
+		[]string{"breaker"},
+	)
+	upstreamHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upstream_healthy",
+			Help: "Whether the upstream passed its latest health checks (1) or not (0).",
+		},
+		[]string{"breaker"},
+	)
+	slowCallCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_slow_calls_total",
+			Help: "Number of successful upstream calls counted as failures for being slow.",
+		},
+		[]string{"breaker"},
+	)
+	bulkheadFullCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bulkhead_full_total",
+			Help: "Number of upstream calls turned away because too many were in flight.",
+		},
+		[]string{"breaker"},
+	)
+	concurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upstream_concurrency_limit",
+			Help: "Current adaptive limit on upstream calls in flight.",
+		},
+		[]string{"breaker"},
+	)
+	shedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_shed_total",
+			Help: "Number of requests turned away because the process was under resource pressure.",
+		},
+		[]string{"breaker", "resource"},
+	)
+	unauthorizedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "unauthorized_requests_total",
+			Help: "Number of requests turned away for lacking a valid API key.",
+		},
+		[]string{"breaker"},
+	)
+	rateLimitedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_requests_total",
+			Help: "Number of requests turned away for exceeding their client's rate limit.",
+		},
+		[]string{"breaker"},
+	)
+	dryRunRejectionCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_dry_run_would_reject_total",
+			Help: "Number of calls a dry-running breaker let through that it would have rejected, by reason.",
+		},
+		[]string{"breaker", "reason"},
+	)
+	shadowRejectionCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_shadow_would_reject_total",
+			Help: "Number of calls let through that the shadow breaker would have rejected.",
+		},
+		[]string{"breaker"},
+	)
+	shadowTripCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_shadow_trips_total",
+			Help: "Number of times the shadow breaker would have tripped.",
+		},
+		[]string{"breaker"},
+	)
+	faultCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "injected_faults_total",
+			Help: "Number of faults injected into upstream calls, by kind.",
+		},
+		[]string{"breaker", "fault"},
+	)
+	maintenanceCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "maintenance_rejected_total",
+			Help: "Number of requests answered with the maintenance message.",
+		},
+		[]string{"breaker"},
+	)
+	selfCheckCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_self_check_probes_total",
+			Help: "Number of startup self-check probes sent to the upstream, by result.",
+		},
+		[]string{"breaker", "result"},
+	)
+	panicCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "panic_total",
+			Help: "Number of requests whose handling panicked.",
+		},
+		[]string{"breaker"},
+	)
+	tenantRequestCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_requests_total",
+			Help: "Number of proxied requests per tenant by outcome.",
+		},
+		[]string{"breaker", "tenant", "outcome"},
+	)
+	tenantBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tenant_circuit_breaker_state",
+			Help: "Current state of a tenant's breaker (0=closed, 1=half-open, 2=open).",
+		},
+		[]string{"breaker", "tenant"},
+	)
+	staleCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stale_responses_total",
+			Help: "Number of requests answered from the cache because the upstream was unavailable.",
+		},
+		[]string{"breaker"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestCount,
+		breakerState,
+		rejectedCount,
+		upstreamLatency,
+		requestAttempts,
+		retryBudgetExhausted,
+		hedgeCount,
+		coalescedCount,
+		cacheCount,
+		staleCount,
+		ejectionCount,
+		upstreamHealthy,
+		slowCallCount,
+		bulkheadFullCount,
+		concurrencyLimit,
+		shedCount,
+		unauthorizedCount,
+		rateLimitedCount,
+		dryRunRejectionCount,
+		shadowRejectionCount,
+		shadowTripCount,
+		faultCount,
+		maintenanceCount,
+		selfCheckCount,
+		panicCount,
+		tenantRequestCount,
+		tenantBreakerState,
+	)
+}
+
+// The observe functions below are the only places metrics are recorded, so
+// the breaker's own callbacks stay free of side effects.
+
+// observedExecute runs fn through b like executePriority and records its outcome
+// and latency as the given attempt, counting from 1, of the request ctx
+// belongs to.
+func (b *breaker) observedExecute(ctx context.Context, p *breakerPolicy, prio priority, attempt int, fn func() (interface{}, error)) (interface{}, error) {
+	start := clock.Now()
+	result, err := b.executePriority(p, prio, fn)
+	if b.metrics {
+		observeAttempt(p.cfg.Name, attempt, err, clock.Now().Sub(start), requestIDFrom(ctx))
+	}
+	if isRejection(err) {
+		b.notifyRejection(p, err)
+	}
+	return result, err
+}
+
+// observeAttempt records the outcome and latency of one upstream attempt,
+// the latency with the ID of its request as exemplar if it has one.
+func observeAttempt(name string, attempt int, err error, latency time.Duration, requestID string) {
+	outcome := "success"
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "open").Inc()
+	case errors.Is(err, gobreaker.ErrTooManyRequests):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "too_many_requests").Inc()
+	case errors.Is(err, errSlowStart):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "slow_start").Inc()
+	case errors.Is(err, errBulkheadFull):
+		outcome = "rejected"
+		bulkheadFullCount.WithLabelValues(name).Inc()
+	case errors.Is(err, errConcurrencyLimit):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "concurrency_limit").Inc()
+	case errors.Is(err, errLowPriority):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "low_priority").Inc()
+	case errors.Is(err, errOutboundLimit):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "outbound_limit").Inc()
+	case errors.Is(err, errMaintenanceWindow):
+		outcome = "rejected"
+		rejectedCount.WithLabelValues(name, "maintenance").Inc()
+	case isDNSFailure(err):
+		outcome = "dns_failure"
+	case err != nil:
+		outcome = "failure"
+	}
+	observer := upstreamLatency.WithLabelValues(name, outcome, strconv.Itoa(attempt))
+	if requestID != "" {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(latency.Seconds(), prometheus.Labels{"request_id": requestID})
+	} else {
+		observer.Observe(latency.Seconds())
+	}
+
+	tags := []string{"breaker:" + name, "outcome:" + outcome}
+	sink.Count("attempts", 1, tags...)
+	sink.Timing("upstream.latency", latency, tags...)
+}
+
+// observeRequest records the final outcome of a proxied request and the
+// number of attempts it took once all its attempts are done. Requests
+// abandoned by the client are counted as canceled.
+func observeRequest(name string, attempts int, err error) {
+	outcome := requestOutcome(err)
+	requestCount.WithLabelValues(name, outcome).Inc()
+	requestAttempts.WithLabelValues(name, outcome).Observe(float64(attempts))
+}
+
+// observeUnauthorized records a request turned away for lacking a valid
+// API key.
+func observeUnauthorized(name string) {
+	unauthorizedCount.WithLabelValues(name).Inc()
+	sink.Count("unauthorized", 1, "breaker:"+name)
+}
+
+// observeRateLimited records a request turned away by the rate limit of
+// its client.
+func observeRateLimited(name string) {
+	rateLimitedCount.WithLabelValues(name).Inc()
+	sink.Count("rate_limited", 1, "breaker:"+name)
+}
+
+// observeDryRunRejection records a call the dry-running breaker called name
+// would have rejected for reason.
+func observeDryRunRejection(name, reason string) {
+	dryRunRejectionCount.WithLabelValues(name, reason).Inc()
+	sink.Count("dry_run.would_reject", 1, "breaker:"+name, "reason:"+reason)
+}
+
+// observeShadowRejection records a call the shadow of the breaker called
+// name would have rejected.
+func observeShadowRejection(name string) {
+	shadowRejectionCount.WithLabelValues(name).Inc()
+	sink.Count("shadow.would_reject", 1, "breaker:"+name)
+}
+
+// observeShadowTrip records a trip of the shadow of the breaker called name.
+func observeShadowTrip(name string) {
+	shadowTripCount.WithLabelValues(name).Inc()
+	sink.Count("shadow.trips", 1, "breaker:"+name)
+}
+
+// observeFault records a fault injected into an upstream call.
+func observeFault(name, fault string) {
+	faultCount.WithLabelValues(name, fault).Inc()
+	sink.Count("faults", 1, "breaker:"+name, "fault:"+fault)
+}
+
+// observeMaintenance records a request answered with the maintenance
+// message.
+func observeMaintenance(name string) {
+	maintenanceCount.WithLabelValues(name).Inc()
+	sink.Count("maintenance", 1, "breaker:"+name)
+}
+
+// observeSelfCheck records a startup self-check probe of the upstream.
+func observeSelfCheck(name string, passed bool) {
+	result := "passed"
+	if !passed {
+		result = "failed"
+	}
+	selfCheckCount.WithLabelValues(name, result).Inc()
+	sink.Count("self_check.probes", 1, "breaker:"+name, "result:"+result)
+}
+
+// observePanic records a request whose handling panicked.
+func observePanic(name string) {
+	panicCount.WithLabelValues(name).Inc()
+	sink.Count("panics", 1, "breaker:"+name)
+}
+
+// requestOutcome names the outcome of a proxied request that ended with
+// err.
+func requestOutcome(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case err != nil:
+		return "failure"
+	default:
+		return "success"
+	}
+}
+
+// observeTenantRequest records the final outcome of a proxied request of
+// tenant through its breaker standing in for the one called name.
+func observeTenantRequest(name, tenant string, err error) {
+	outcome := requestOutcome(err)
+	tenantRequestCount.WithLabelValues(name, tenant, outcome).Inc()
+	sink.Count("tenant.requests", 1, "breaker:"+name, "tenant:"+tenant, "outcome:"+outcome)
+}
+
+// observeTenantState records the state tenant's breaker standing in for
+// the one called name is now in.
+func observeTenantState(name, tenant, state string) {
+	tenantBreakerState.WithLabelValues(name, tenant).Set(stateValue(state))
+	sink.Gauge("tenant.state", stateValue(state), "breaker:"+name, "tenant:"+tenant)
+}
+
+// observeStateChange records a state transition reported by gobreaker.
+func observeStateChange(change stateChange) {
+	requestCount.WithLabelValues(change.Name, change.To).Inc()
+	observeState(change.Name, change.To)
+}
+
+// observeState records the state the breaker called name is now in.
+func observeState(name, state string) {
+	breakerState.WithLabelValues(name).Set(stateValue(state))
+	sink.Gauge("state", stateValue(state), "breaker:"+name)
+}
+
+// observeSlowCall records a call counted as a failure for being slow.
+func observeSlowCall(name string) {
+	slowCallCount.WithLabelValues(name).Inc()
+	sink.Count("slow_calls", 1, "breaker:"+name)
+}
+
+// observeHealth records a change of an upstream's health.
+func observeHealth(name string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	upstreamHealthy.WithLabelValues(name).Set(value)
+	sink.Gauge("upstream.healthy", value, "breaker:"+name)
+}
+
+// observeConcurrencyLimit records a new adaptive concurrency limit.
+func observeConcurrencyLimit(name string, limit float64) {
+	concurrencyLimit.WithLabelValues(name).Set(limit)
+	sink.Gauge("concurrency.limit", limit, "breaker:"+name)
+}
+
+// observeShed records a request turned away under pressure on resource.
+func observeShed(name, resource string) {
+	shedCount.WithLabelValues(name, resource).Inc()
+	sink.Count("shed", 1, "breaker:"+name, "resource:"+resource)
+}
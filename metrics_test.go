@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakerStateGauge(t *testing.T) {
+	fake := useFakeClock(t)
+	b := newBreaker(BreakerConfig{
+		Name:                "Gauge Test",
+		MaxRequests:         1,
+		Timeout:             50 * time.Millisecond,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+
+	expectState := func(t *testing.T, want float64) {
+		t.Helper()
+		if got := testutil.ToFloat64(breakerState.WithLabelValues("Gauge Test")); got != want {
+			t.Fatalf("expected circuit_breaker_state %v, got %v", want, got)
+		}
+	}
+
+	expectState(t, 0)
+
+	for i := 0; i < 2; i++ {
+		b.execute(b.current(), func() (interface{}, error) {
+			return nil, errors.New("simulated failure")
+		})
+	}
+	expectState(t, 2)
+
+	fake.Advance(50 * time.Millisecond)
+	b.current().cb.State()
+	expectState(t, 1)
+
+	b.forceOpen()
+	expectState(t, 2)
+
+	b.reset()
+	expectState(t, 0)
+}
+
+func TestUpstreamLatencyHistogram(t *testing.T) {
+	upstreamLatency.Reset()
+
+	observeAttempt("Latency Test", 1, errors.New("simulated failure"), 20*time.Millisecond, "")
+	observeAttempt("Latency Test", 2, nil, 10*time.Millisecond, "")
+	observeAttempt("Latency Test", 3, gobreaker.ErrOpenState, 0, "")
+	observeAttempt("Latency Test", 4, errMaintenanceWindow, 0, "")
+
+	for _, tc := range []struct{ outcome, attempt string }{
+		{"failure", "1"},
+		{"success", "2"},
+		{"rejected", "3"},
+		{"rejected", "4"},
+	} {
+		var m dto.Metric
+		if err := upstreamLatency.WithLabelValues("Latency Test", tc.outcome, tc.attempt).(prometheus.Metric).Write(&m); err != nil {
+			t.Fatalf("failed to read histogram: %v", err)
+		}
+		if n := m.GetHistogram().GetSampleCount(); n != 1 {
+			t.Fatalf("expected one %s sample for attempt %s, got %d", tc.outcome, tc.attempt, n)
+		}
+	}
+	if n := testutil.CollectAndCount(upstreamLatency); n != 4 {
+		t.Fatalf("expected 4 latency series, got %d", n)
+	}
+}
+
+func TestRejectedCounter(t *testing.T) {
+	rejectedCount.Reset()
+
+	observeAttempt("Rejected Test", 1, errors.New("simulated failure"), 0, "")
+	observeAttempt("Rejected Test", 1, gobreaker.ErrOpenState, 0, "")
+	observeAttempt("Rejected Test", 2, gobreaker.ErrOpenState, 0, "")
+	observeAttempt("Rejected Test", 1, gobreaker.ErrTooManyRequests, 0, "")
+	observeAttempt("Rejected Test", 1, errMaintenanceWindow, 0, "")
+
+	if got := testutil.ToFloat64(rejectedCount.WithLabelValues("Rejected Test", "open")); got != 2 {
+		t.Fatalf("expected 2 rejections while open, got %v", got)
+	}
+	if got := testutil.ToFloat64(rejectedCount.WithLabelValues("Rejected Test", "too_many_requests")); got != 1 {
+		t.Fatalf("expected 1 rejection while half-open, got %v", got)
+	}
+	if got := testutil.ToFloat64(rejectedCount.WithLabelValues("Rejected Test", "maintenance")); got != 1 {
+		t.Fatalf("expected 1 rejection in a maintenance window, got %v", got)
+	}
+
+	// Another breaker's rejections are counted in their own series
+	observeAttempt("Other Breaker", 1, gobreaker.ErrOpenState, 0, "")
+	if got := testutil.ToFloat64(rejectedCount.WithLabelValues("Rejected Test", "open")); got != 2 {
+		t.Fatalf("expected rejections of other breakers not to be merged, got %v", got)
+	}
+}
+
+func TestRequestCountReflectsOutcomes(t *testing.T) {
+	requestCount.Reset()
+
+	b := newBreaker(BreakerConfig{
+		Name:                "Outcome Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 5,
+	}, RetryConfig{Attempts: 3, BackoffMin: time.Millisecond, BackoffMax: time.Millisecond})
+	transport := &breakerTransport{b: b}
+
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("simulated failure")
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatalf("expected error, got none")
+	}
+
+	// Three failed attempts make one failed request
+	if got := testutil.ToFloat64(requestCount.WithLabelValues("Outcome Test", "failure")); got != 1 {
+		t.Fatalf("expected 1 failed request, got %v", got)
+	}
+	if got := testutil.ToFloat64(requestCount.WithLabelValues("Outcome Test", "success")); got != 0 {
+		t.Fatalf("expected no successful requests, got %v", got)
+	}
+
+	var m dto.Metric
+	if err := requestAttempts.WithLabelValues("Outcome Test", "failure").(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	if h := m.GetHistogram(); h.GetSampleCount() != 1 || h.GetSampleSum() != 3 {
+		t.Fatalf("expected one request with 3 attempts, got %d requests with %v attempts", h.GetSampleCount(), h.GetSampleSum())
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	b := buildBreaker("Quiet Test", withConsecutiveFailures(0), withTimeout(time.Minute), withMetrics(false))
+	collectors := []prometheus.Collector{upstreamLatency, rejectedCount, requestCount, breakerState}
+	before := make([]int, len(collectors))
+	for i, c := range collectors {
+		before[i] = testutil.CollectAndCount(c)
+	}
+
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+	b.observedExecute(context.Background(), b.current(), priorityNormal, 1, fail)
+	if _, err := b.observedExecute(context.Background(), b.current(), priorityNormal, 1, fail); !isRejection(err) {
+		t.Fatalf("expected the open breaker to reject the call, got %v", err)
+	}
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("simulated failure")
+	}
+	transport := &breakerTransport{b: b}
+	if _, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)); err == nil {
+		t.Fatalf("expected error, got none")
+	}
+
+	for i, c := range collectors {
+		if got := testutil.CollectAndCount(c); got != before[i] {
+			t.Fatalf("expected a breaker without metrics to record none, got %d series instead of %d", got, before[i])
+		}
+	}
+}
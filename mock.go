@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// mockUpstream is the fake upstream started by --mock-upstream. It answers
+// every request, probes included, as the next step of its script says.
+type mockUpstream struct {
+	url string
+	srv *http.Server
+
+	mu  sync.Mutex
+	cfg MockUpstreamConfig
+	// served counts the requests answered since the script last started.
+	served int
+}
+
+// mockAnswer is the JSON body the mock upstream answers with.
+type mockAnswer struct {
+	Mock    bool   `json:"mock"`
+	Request int    `json:"request"`
+	Step    int    `json:"step"`
+	Latency string `json:"latency"`
+}
+
+// startMockUpstream serves a mock upstream scripted by cfg on a free port of
+// the loopback interface.
+func startMockUpstream(cfg MockUpstreamConfig) (*mockUpstream, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	m := &mockUpstream{url: "http://" + ln.Addr().String()}
+	m.configure(cfg)
+	m.srv = &http.Server{Handler: m}
+	go m.srv.Serve(ln)
+	slog.Info("Started mock upstream", "url", m.url, "steps", len(cfg.Steps), "loop", cfg.Loop)
+	return m, nil
+}
+
+// configure replaces the script and starts it over.
+func (m *mockUpstream) configure(cfg MockUpstreamConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	m.served = 0
+}
+
+func (m *mockUpstream) close() {
+	m.srv.Close()
+}
+
+// next returns the number of the next request and the step answering it,
+// both from 1, with its latency. Without steps every request gets a 200 at
+// once.
+func (m *mockUpstream) next() (int, int, MockStep, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.served
+	m.served++
+	request := m.served
+	steps := m.cfg.Steps
+	if len(steps) == 0 {
+		return request, 0, MockStep{}, 0
+	}
+	if m.cfg.Loop {
+		total := 0
+		for _, step := range steps {
+			total += step.Requests
+		}
+		// A last step taking every request leaves nothing to loop over
+		if steps[len(steps)-1].Requests > 0 {
+			n %= total
+		}
+	}
+	for i, step := range steps {
+		if step.Requests == 0 || n < step.Requests {
+			return request, i + 1, step, step.latency(n)
+		}
+		n -= step.Requests
+	}
+	// Past the script, the last step keeps answering at the end of its ramp
+	last := steps[len(steps)-1]
+	return request, len(steps), last, last.latency(last.Requests - 1)
+}
+
+// latency returns the delay of the step's nth request, from 0.
+func (step MockStep) latency(n int) time.Duration {
+	if step.LatencyTo == 0 || step.Requests < 2 {
+		return step.Latency
+	}
+	return step.Latency + (step.LatencyTo-step.Latency)*time.Duration(n)/time.Duration(step.Requests-1)
+}
+
+func (m *mockUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, number, step, latency := m.next()
+	if err := sleepContext(r.Context(), latency); err != nil {
+		return
+	}
+	status := step.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(mockAnswer{Mock: true, Request: request, Step: number, Latency: latency.String()})
+}
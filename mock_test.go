@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMockUpstream(t *testing.T) {
+	m, err := startMockUpstream(MockUpstreamConfig{
+		Steps: []MockStep{
+			{Requests: 2, Status: http.StatusInternalServerError},
+			{},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+	get := func(t *testing.T) (int, mockAnswer) {
+		t.Helper()
+		resp, err := http.Get(m.url + "/api")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var answer mockAnswer
+		if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, answer
+	}
+
+	for i, want := range []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK, http.StatusOK} {
+		if status, answer := get(t); status != want || answer.Request != i+1 {
+			t.Fatalf("expected request %d to get %d, got %d %+v", i+1, want, status, answer)
+		}
+	}
+
+	m.configure(MockUpstreamConfig{Steps: []MockStep{{Requests: 1, Status: http.StatusServiceUnavailable}}})
+	if status, answer := get(t); status != http.StatusServiceUnavailable || answer.Request != 1 {
+		t.Fatalf("expected a reconfigured script to start over, got %d %+v", status, answer)
+	}
+}
+
+func TestMockUpstreamScript(t *testing.T) {
+	m := &mockUpstream{}
+	m.configure(MockUpstreamConfig{
+		Loop: true,
+		Steps: []MockStep{
+			{Requests: 1, Status: http.StatusInternalServerError},
+			{Requests: 3, Latency: 100 * time.Millisecond, LatencyTo: 300 * time.Millisecond},
+		},
+	})
+	want := []struct {
+		step    int
+		latency time.Duration
+	}{
+		{1, 0},
+		{2, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{2, 300 * time.Millisecond},
+		{1, 0},
+		{2, 100 * time.Millisecond},
+	}
+	for i, w := range want {
+		if _, step, _, latency := m.next(); step != w.step || latency != w.latency {
+			t.Fatalf("request %d: expected step %d after %v, got step %d after %v", i+1, w.step, w.latency, step, latency)
+		}
+	}
+
+	m.configure(MockUpstreamConfig{
+		Steps: []MockStep{{Requests: 2, Latency: time.Second, LatencyTo: 2 * time.Second}},
+	})
+	for i, w := range []time.Duration{time.Second, 2 * time.Second, 2 * time.Second} {
+		if _, step, _, latency := m.next(); step != 1 || latency != w {
+			t.Fatalf("request %d: expected the last step to keep answering after %v, got step %d after %v", i+1, w, step, latency)
+		}
+	}
+}
+
+func TestMockUpstreamConfigValidate(t *testing.T) {
+	if err := defaultConfig().MockUpstream.validate(); err != nil {
+		t.Fatalf("expected the default script to be valid, got %v", err)
+	}
+	if err := (MockUpstreamConfig{Steps: []MockStep{{}, {Status: 200}}}).validate(); err == nil {
+		t.Fatal("expected an error for an endless step before the last")
+	}
+	if err := (MockUpstreamConfig{Steps: []MockStep{{Status: 700}}}).validate(); err == nil {
+		t.Fatal("expected an error for an invalid status")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Bounds of the backoff between notification delivery attempts.
+const (
+	notifyBackoffMin = 250 * time.Millisecond
+	notifyBackoffMax = 5 * time.Second
+)
+
+// webhookNotifier posts every breaker state change as JSON to the configured
+// webhook URLs.
+type webhookNotifier struct {
+	urls    []string
+	secret  string
+	retries int
+	client  *http.Client
+}
+
+func newWebhookNotifier(cfg NotifyConfig) *webhookNotifier {
+	return &webhookNotifier{
+		urls:    cfg.WebhookURLs,
+		secret:  cfg.WebhookSecret,
+		retries: cfg.WebhookRetries,
+		client:  &http.Client{Timeout: cfg.WebhookTimeout},
+	}
+}
+
+// notify delivers change to every webhook in the background.
+func (n *webhookNotifier) notify(change stateChange) {
+	body, err := json.Marshal(change)
+	if err != nil {
+		slog.Error("Failed to encode webhook payload", "breaker", change.Name, "error", err)
+		return
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	if n.secret != "" {
+		header.Set("X-Signature-256", "sha256="+sign(n.secret, body))
+	}
+
+	for _, url := range n.urls {
+		go func(url string) {
+			if err := postWithRetry(n.client, url, header, body, n.retries); err != nil {
+				slog.Warn("Failed to deliver webhook", "breaker", change.Name, "url", url, "error", err)
+			}
+		}(url)
+	}
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body keyed with secret.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWithRetry posts body to url, retrying with exponential backoff up to
+// retries more times until the receiver answers with a 2xx status.
+func postWithRetry(client *http.Client, url string, header http.Header, body []byte, retries int) error {
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			clock.Sleep(exponentialBackoff(i-1, notifyBackoffMin, notifyBackoffMax, 0, jitter))
+		}
+
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header = header.Clone()
+
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return err
+}
+
+// slackNotifier posts a message to a Slack incoming webhook when the circuit
+// opens or recovers.
+type slackNotifier struct {
+	url     string
+	retries int
+	client  *http.Client
+}
+
+func newSlackNotifier(cfg NotifyConfig) *slackNotifier {
+	return &slackNotifier{
+		url:     cfg.SlackWebhookURL,
+		retries: cfg.WebhookRetries,
+		client:  &http.Client{Timeout: cfg.WebhookTimeout},
+	}
+}
+
+// notify posts change to Slack in the background. Transitions to half-open
+// are not reported.
+func (n *slackNotifier) notify(change stateChange) {
+	text := slackMessage(change)
+	if text == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		slog.Error("Failed to encode Slack message", "breaker", change.Name, "error", err)
+		return
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	go func() {
+		if err := postWithRetry(n.client, n.url, header, body, n.retries); err != nil {
+			slog.Warn("Failed to notify Slack", "breaker", change.Name, "error", err)
+		}
+	}()
+}
+
+// slackMessage formats change as Slack mrkdwn, or returns "" for transitions
+// that are not worth a message.
+func slackMessage(change stateChange) string {
+	switch change.To {
+	case "open":
+		msg := fmt.Sprintf(":rotating_light: Circuit *%s* opened (%s → %s)", change.Name, change.From, change.To)
+		if c := change.Counts; c.Requests > 0 {
+			msg += fmt.Sprintf(" after %d consecutive failures (%d of %d requests failed)",
+				c.ConsecutiveFailures, c.TotalFailures, c.Requests)
+		}
+		return msg + "."
+	case "closed":
+		return fmt.Sprintf(":white_check_mark: Circuit *%s* recovered (%s → %s).", change.Name, change.From, change.To)
+	default:
+		return ""
+	}
+}
+
+// pagerDutyNotifier raises a PagerDuty incident through the Events API v2
+// when the circuit has not closed for a while after opening, and resolves it
+// once the circuit closes again.
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+	openFor    time.Duration
+	retries    int
+	client     *http.Client
+
+	mu        sync.Mutex
+	timer     Timer
+	triggered bool
+}
+
+func newPagerDutyNotifier(cfg NotifyConfig) *pagerDutyNotifier {
+	return &pagerDutyNotifier{
+		url:        cfg.PagerDutyURL,
+		routingKey: cfg.PagerDutyRoutingKey,
+		openFor:    cfg.PagerDutyOpenFor,
+		retries:    cfg.WebhookRetries,
+		client:     &http.Client{Timeout: cfg.WebhookTimeout},
+	}
+}
+
+// pagerDutyEvent is an Events API v2 request.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string        `json:"summary"`
+	Source        string        `json:"source"`
+	Severity      string        `json:"severity"`
+	Timestamp     time.Time     `json:"timestamp"`
+	CustomDetails breakerCounts `json:"custom_details"`
+}
+
+// notify arms the alert when the circuit leaves the closed state and
+// disarms or resolves it when the circuit closes.
+func (n *pagerDutyNotifier) notify(change stateChange) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch {
+	case change.From == "closed" && n.timer == nil:
+		n.timer = clock.AfterFunc(n.openFor, func() { n.trigger(change) })
+	case change.To == "closed":
+		if n.timer != nil {
+			n.timer.Stop()
+			n.timer = nil
+		}
+		if n.triggered {
+			n.triggered = false
+			go n.send(pagerDutyEvent{EventAction: "resolve", DedupKey: dedupKey(change.Name)})
+		}
+	}
+}
+
+func (n *pagerDutyNotifier) trigger(change stateChange) {
+	n.mu.Lock()
+	if n.timer == nil {
+		// The circuit closed while the timer fired
+		n.mu.Unlock()
+		return
+	}
+	n.triggered = true
+	n.mu.Unlock()
+
+	n.send(pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    dedupKey(change.Name),
+		Payload: &pagerDutyPayload{
+			Summary:       fmt.Sprintf("Circuit %s has been open for more than %s", change.Name, n.openFor),
+			Source:        change.Name,
+			Severity:      "critical",
+			Timestamp:     change.Time,
+			CustomDetails: change.Counts,
+		},
+	})
+}
+
+func (n *pagerDutyNotifier) send(event pagerDutyEvent) {
+	event.RoutingKey = n.routingKey
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode PagerDuty event", "dedup_key", event.DedupKey, "error", err)
+		return
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	if err := postWithRetry(n.client, n.url, header, body, n.retries); err != nil {
+		slog.Warn("Failed to send PagerDuty event", "dedup_key", event.DedupKey, "action", event.EventAction, "error", err)
+	}
+}
+
+// dedupKey identifies the PagerDuty incident of the breaker called name.
+func dedupKey(name string) string {
+	return "circuit-breaker/" + name
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan stateChange, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first delivery to exercise the retry
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Signature-256"), "sha256="+sign("s3cret", body); got != want {
+			t.Errorf("expected signature %q, got %q", want, got)
+		}
+
+		var change stateChange
+		if err := json.Unmarshal(body, &change); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		received <- change
+	}))
+	defer server.Close()
+
+	n := newWebhookNotifier(NotifyConfig{
+		WebhookURLs:    []string{server.URL},
+		WebhookSecret:  "s3cret",
+		WebhookTimeout: time.Second,
+		WebhookRetries: 2,
+	})
+	n.notify(stateChange{Name: "Webhook Test", From: "closed", To: "open", Time: time.Now()})
+
+	select {
+	case change := <-received:
+		if change.Name != "Webhook Test" || change.To != "open" {
+			t.Fatalf("expected transition to open, got %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected webhook to be delivered")
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("expected 2 delivery attempts, got %d", n)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	received := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+		received <- msg.Text
+	}))
+	defer server.Close()
+
+	n := newSlackNotifier(NotifyConfig{
+		SlackWebhookURL: server.URL,
+		WebhookTimeout:  time.Second,
+	})
+
+	// Half-open transitions are not posted
+	n.notify(stateChange{Name: "Slack Test", From: "open", To: "half-open"})
+	n.notify(stateChange{
+		Name:   "Slack Test",
+		From:   "closed",
+		To:     "open",
+		Counts: breakerCounts{Requests: 10, TotalFailures: 6, ConsecutiveFailures: 4},
+	})
+
+	select {
+	case text := <-received:
+		want := ":rotating_light: Circuit *Slack Test* opened (closed → open) after 4 consecutive failures (6 of 10 requests failed)."
+		if text != want {
+			t.Fatalf("expected message %q, got %q", want, text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Slack message to be posted")
+	}
+
+	select {
+	case text := <-received:
+		t.Fatalf("expected no further messages, got %q", text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPagerDutyNotifier(t *testing.T) {
+	events := make(chan pagerDutyEvent, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		events <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	newNotifier := func() *pagerDutyNotifier {
+		return newPagerDutyNotifier(NotifyConfig{
+			PagerDutyRoutingKey: "routing-key",
+			PagerDutyOpenFor:    50 * time.Millisecond,
+			PagerDutyURL:        server.URL,
+			WebhookTimeout:      time.Second,
+		})
+	}
+	expectEvent := func(t *testing.T, action string) pagerDutyEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.EventAction != action {
+				t.Fatalf("expected %s event, got %+v", action, event)
+			}
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s event", action)
+		}
+		return pagerDutyEvent{}
+	}
+
+	t.Run("TriggerAndResolve", func(t *testing.T) {
+		n := newNotifier()
+		n.notify(stateChange{Name: "PD Test", From: "closed", To: "open"})
+
+		event := expectEvent(t, "trigger")
+		if event.RoutingKey != "routing-key" || event.DedupKey != "circuit-breaker/PD Test" {
+			t.Fatalf("expected routing and dedup keys to be set, got %+v", event)
+		}
+
+		n.notify(stateChange{Name: "PD Test", From: "open", To: "half-open"})
+		n.notify(stateChange{Name: "PD Test", From: "half-open", To: "closed"})
+		if event := expectEvent(t, "resolve"); event.DedupKey != "circuit-breaker/PD Test" {
+			t.Fatalf("expected resolve for the same incident, got %+v", event)
+		}
+	})
+
+	t.Run("RecoveredBeforeThreshold", func(t *testing.T) {
+		n := newNotifier()
+		n.notify(stateChange{Name: "PD Test", From: "closed", To: "open"})
+		n.notify(stateChange{Name: "PD Test", From: "half-open", To: "closed"})
+
+		select {
+		case event := <-events:
+			t.Fatalf("expected no events, got %+v", event)
+		case <-time.After(150 * time.Millisecond):
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// breakerOption configures a breaker built with buildBreaker.
+type breakerOption func(*breakerOptions)
+
+// breakerOptions collects the settings of a breaker before buildBreaker
+// builds it.
+type breakerOptions struct {
+	cfg     BreakerConfig
+	retry   RetryConfig
+	metrics bool
+	logger  *slog.Logger
+}
+
+// buildBreaker returns a breaker named name with the default breaker and
+// retry settings of the config, changed by opts in order. Its state and
+// calls are reported to the metrics and logged through the default logger
+// unless the options say otherwise.
+func buildBreaker(name string, opts ...breakerOption) *breaker {
+	defaults := defaultConfig()
+	o := breakerOptions{cfg: defaults.Breaker, retry: defaults.Retry, metrics: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.cfg.Name = name
+	b := &breaker{metrics: o.metrics, logger: o.logger}
+	if b.metrics {
+		b.onStateChange(observeStateChange)
+	}
+	b.policy.Store(b.newPolicy(o.cfg, o.retry))
+	return b
+}
+
+// withConfig replaces every breaker setting by those of cfg, but for the
+// name given to buildBreaker.
+func withConfig(cfg BreakerConfig) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg = cfg
+	}
+}
+
+// withMaxRequests sets how many trial calls the half-open breaker lets
+// through.
+func withMaxRequests(n uint32) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg.MaxRequests = n
+	}
+}
+
+// withInterval sets how often the closed breaker clears its counts, never
+// when zero.
+func withInterval(d time.Duration) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg.Interval = d
+	}
+}
+
+// withTimeout sets how long the breaker stays open before going half-open.
+func withTimeout(d time.Duration) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg.Timeout = d
+	}
+}
+
+// withTripPolicy selects the condition opening the breaker. Its thresholds
+// keep their defaults unless set with withConsecutiveFailures,
+// withFailureRatio or withConfig.
+func withTripPolicy(policy TripPolicy) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg.Trip = policy
+	}
+}
+
+// withConsecutiveFailures trips the breaker once more than n calls failed
+// in a row.
+func withConsecutiveFailures(n uint32) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg.Trip = TripConsecutiveFailures
+		o.cfg.ConsecutiveFailures = n
+	}
+}
+
+// withFailureRatio trips the breaker once more than ratio of at least
+// minRequests calls failed.
+func withFailureRatio(ratio float64, minRequests uint32) breakerOption {
+	return func(o *breakerOptions) {
+		o.cfg.Trip = TripFailureRatio
+		o.cfg.FailureRatio = ratio
+		o.cfg.MinRequests = minRequests
+	}
+}
+
+// withRetry sets the retry policy applied to calls through the breaker.
+func withRetry(retry RetryConfig) breakerOption {
+	return func(o *breakerOptions) {
+		o.retry = retry
+	}
+}
+
+// withMetrics sets whether the breaker reports its state and calls to the
+// metrics, which it does by default.
+func withMetrics(enabled bool) breakerOption {
+	return func(o *breakerOptions) {
+		o.metrics = enabled
+	}
+}
+
+// withLogger logs the breaker's state changes and decisions to logger
+// rather than the default logger.
+func withLogger(logger *slog.Logger) breakerOption {
+	return func(o *breakerOptions) {
+		o.logger = logger
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sony/gobreaker"
+)
+
+func TestBuildBreaker(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		b := buildBreaker("Options Default Test")
+		p, defaults := b.current(), defaultConfig()
+		want := defaults.Breaker
+		want.Name = "Options Default Test"
+		if p.cfg != want || p.retry != defaults.Retry {
+			t.Fatalf("expected the default settings, got %+v and %+v", p.cfg, p.retry)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		var logs bytes.Buffer
+		b := buildBreaker("Options Test",
+			withMaxRequests(2),
+			withInterval(0),
+			withTimeout(time.Minute),
+			withConsecutiveFailures(1),
+			withRetry(RetryConfig{Attempts: 2}),
+			withMetrics(false),
+			withLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		)
+		p := b.current()
+		if p.cfg.MaxRequests != 2 || p.cfg.Timeout != time.Minute || p.cfg.Trip != TripConsecutiveFailures ||
+			p.cfg.ConsecutiveFailures != 1 || p.retry.Attempts != 2 {
+			t.Fatalf("expected the options to be applied, got %+v and %+v", p.cfg, p.retry)
+		}
+
+		for i := 0; i < 2; i++ {
+			b.execute(p, func() (interface{}, error) {
+				return nil, errors.New("simulated failure")
+			})
+		}
+		if state := p.cb.State(); state != gobreaker.StateOpen {
+			t.Fatalf("expected the breaker to trip, got %v", state)
+		}
+		if !strings.Contains(logs.String(), "Circuit breaker state changed") {
+			t.Fatalf("expected the state change in the given logger, got %q", logs.String())
+		}
+		if hasBreakerState("Options Test") {
+			t.Fatal("expected no state metric without metrics")
+		}
+	})
+
+	t.Run("FailureRatio", func(t *testing.T) {
+		b := buildBreaker("Options Ratio Test", withConfig(BreakerConfig{Name: "ignored", Timeout: time.Minute}), withFailureRatio(0.5, 10))
+		p := b.current()
+		if p.cfg.Name != "Options Ratio Test" || p.cfg.Trip != TripFailureRatio || p.cfg.FailureRatio != 0.5 || p.cfg.MinRequests != 10 {
+			t.Fatalf("expected the failure ratio over the given config, got %+v", p.cfg)
+		}
+		if !hasBreakerState("Options Ratio Test") {
+			t.Fatal("expected a state metric by default")
+		}
+	})
+}
+
+// hasBreakerState reports whether circuit_breaker_state has a series for
+// the breaker name.
+func hasBreakerState(name string) bool {
+	ch := make(chan prometheus.Metric, 1024)
+	breakerState.Collect(ch)
+	close(ch)
+	for m := range ch {
+		var metric dto.Metric
+		m.Write(&metric)
+		for _, label := range metric.Label {
+			if label.GetName() == "breaker" && label.GetValue() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
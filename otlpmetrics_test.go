@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPMetrics(t *testing.T) {
+	names := make(chan []string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Errorf("expected export to /v1/metrics, got %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+
+		var req colmetricpb.ExportMetricsServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("failed to decode export request: %v", err)
+		}
+		var got []string
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					got = append(got, m.Name)
+				}
+			}
+		}
+		names <- got
+	}))
+	defer collector.Close()
+
+	requestCount.WithLabelValues("OTLP Test", "success").Inc()
+
+	shutdown, err := setupOTLPMetrics(context.Background(), MetricsConfig{
+		OTLPEndpoint: strings.TrimPrefix(collector.URL, "http://"),
+		OTLPInsecure: true,
+		OTLPInterval: 50 * time.Millisecond,
+	}, "otlp-test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer shutdown(context.Background())
+
+	select {
+	case got := <-names:
+		for _, name := range got {
+			if name == "request_count" {
+				return
+			}
+		}
+		t.Fatalf("expected request_count to be exported, got %v", got)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected metrics to be pushed to the collector")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errOutboundLimit is returned for calls turned away because upstream calls
+// are already made at the outbound rate limit.
+var errOutboundLimit = errors.New("outbound rate limit reached")
+
+// outbound caps the rate of calls to the upstreams, retries included, across
+// every breaker.
+var outbound = &outboundLimiter{}
+
+// outboundLimiter is a token bucket shared by every upstream call. A call
+// finding it empty reserves the next token if it comes within Wait, and is
+// turned away otherwise. A Rate of zero disables it.
+type outboundLimiter struct {
+	mu     sync.Mutex
+	cfg    OutboundConfig
+	bucket tokenBucket
+}
+
+// configure applies the limits of cfg, keeping the tokens saved up.
+func (l *outboundLimiter) configure(cfg OutboundConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.Rate <= 0 {
+		l.bucket = tokenBucket{tokens: float64(cfg.Burst), last: clock.Now()}
+	}
+	l.cfg = cfg
+}
+
+// take takes a token for a call, waiting for it up to Wait, and reports
+// whether it got one.
+func (l *outboundLimiter) take() bool {
+	wait, ok := l.reserve(-1)
+	if ok && wait > 0 {
+		clock.Sleep(wait)
+	}
+	return ok
+}
+
+// tryTake takes a token for a call only if one is there at once, for calls
+// better skipped than delayed.
+func (l *outboundLimiter) tryTake() bool {
+	_, ok := l.reserve(0)
+	return ok
+}
+
+// reserve reserves a token coming within maxWait, Wait when negative,
+// returning how long until it comes.
+func (l *outboundLimiter) reserve(maxWait time.Duration) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.Rate <= 0 {
+		return 0, true
+	}
+	if maxWait < 0 {
+		maxWait = l.cfg.Wait
+	}
+	now := clock.Now()
+	b := &l.bucket
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.cfg.Rate, float64(l.cfg.Burst))
+	b.last = now
+	wait := time.Duration((1 - b.tokens) / l.cfg.Rate * float64(time.Second))
+	if wait > maxWait {
+		return 0, false
+	}
+	// Tokens may go negative, reserving them for the calls waiting
+	b.tokens--
+	return wait, true
+}
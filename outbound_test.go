@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutboundLimiter(t *testing.T) {
+	fake := useFakeClock(t)
+	l := &outboundLimiter{}
+	l.configure(OutboundConfig{Rate: 10, Burst: 2})
+
+	if !l.take() || !l.take() {
+		t.Fatalf("expected calls within the burst to go through")
+	}
+	if l.take() {
+		t.Fatalf("expected a call beyond the burst to be turned away without waiting")
+	}
+	fake.Advance(100 * time.Millisecond)
+	if !l.take() {
+		t.Fatalf("expected a call to go through once a token came back")
+	}
+
+	// Calls may wait for their turn
+	l.configure(OutboundConfig{Rate: 100, Burst: 1, Wait: time.Second})
+	took := make(chan bool)
+	go func() { took <- l.take() }()
+	fake.waitFor(t, 1)
+	select {
+	case <-took:
+		t.Fatalf("expected the call to wait for its token")
+	default:
+	}
+	fake.Advance(10 * time.Millisecond)
+	if !<-took {
+		t.Fatalf("expected a call to wait for its token")
+	}
+
+	l.configure(OutboundConfig{})
+	for range 10 {
+		if !l.take() {
+			t.Fatalf("expected no cap once disabled")
+		}
+	}
+}
+
+func TestOutboundLimitRejects(t *testing.T) {
+	defer outbound.configure(OutboundConfig{})
+	outbound.configure(OutboundConfig{Rate: 0.001, Burst: 1})
+
+	b := newBreaker(BreakerConfig{Name: "Outbound Test", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{})
+	p := b.current()
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return nil, nil
+	}
+	if _, err := b.execute(p, fn); err != nil {
+		t.Fatalf("expected the first call to go through, got %v", err)
+	}
+	for range 3 {
+		if _, err := b.execute(p, fn); !errors.Is(err, errOutboundLimit) || !isRejection(err) {
+			t.Fatalf("expected calls beyond the cap to be rejected, got %v", err)
+		}
+	}
+	if calls != 1 || p.cb.Counts().TotalFailures != 0 {
+		t.Fatalf("expected rejected calls neither to run nor to count as failures, got %d calls and %+v", calls, p.cb.Counts())
+	}
+}
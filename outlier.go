@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// outlierDetector ejects upstreams whose failure rate stands out from that
+// of their peers, independently of their breakers. Upstreams are identified
+// by their index as returned by breakerTransport.route.
+type outlierDetector struct {
+	cfg OutlierConfig
+
+	mu    sync.Mutex
+	hosts []outlierStats
+	// windowStart is when the current counting interval began.
+	windowStart time.Time
+}
+
+// outlierStats holds the calls made to an upstream in the current interval.
+type outlierStats struct {
+	requests, failures int
+	ejectedUntil       time.Time
+}
+
+// newOutlierDetector returns a detector over n upstreams, or nil when cfg
+// disables ejection or there is no peer to compare with.
+func newOutlierDetector(cfg OutlierConfig, n int) *outlierDetector {
+	if cfg.FailureMargin <= 0 || n < 2 {
+		return nil
+	}
+	return &outlierDetector{cfg: cfg, hosts: make([]outlierStats, n), windowStart: clock.Now()}
+}
+
+// record counts a call to the upstream at index i, named name, and ejects
+// it if it became an outlier. A nil detector records nothing.
+func (d *outlierDetector) record(i int, name string, failed bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := clock.Now()
+	if now.Sub(d.windowStart) > d.cfg.Interval {
+		for i := range d.hosts {
+			d.hosts[i].requests, d.hosts[i].failures = 0, 0
+		}
+		d.windowStart = now
+	}
+	h := &d.hosts[i]
+	h.requests++
+	if !failed {
+		return
+	}
+	h.failures++
+
+	if h.requests < d.cfg.MinRequests || now.Before(h.ejectedUntil) || !d.isOutlier(i) {
+		return
+	}
+	// Always leave at least one upstream in rotation
+	ejected := 0
+	for _, peer := range d.hosts {
+		if now.Before(peer.ejectedUntil) {
+			ejected++
+		}
+	}
+	if ejected >= len(d.hosts)-1 {
+		return
+	}
+	h.ejectedUntil = now.Add(d.cfg.Ejection)
+	h.requests, h.failures = 0, 0
+	ejectionCount.WithLabelValues(name).Inc()
+	slog.Warn("Ejecting upstream", "breaker", name, "for", d.cfg.Ejection)
+}
+
+// isOutlier reports whether the failure rate of the upstream at index i
+// exceeds the average rate of the peers that had calls by the margin.
+// d.mu must be held.
+func (d *outlierDetector) isOutlier(i int) bool {
+	var peerRates float64
+	peers := 0
+	for j, peer := range d.hosts {
+		if j == i || peer.requests == 0 {
+			continue
+		}
+		peerRates += float64(peer.failures) / float64(peer.requests)
+		peers++
+	}
+	if peers == 0 {
+		return false
+	}
+	rate := float64(d.hosts[i].failures) / float64(d.hosts[i].requests)
+	return rate-peerRates/float64(peers) >= d.cfg.FailureMargin
+}
+
+// ejected reports whether the upstream at index i is currently ejected. A
+// nil detector never ejects.
+func (d *outlierDetector) ejected(i int) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return clock.Now().Before(d.hosts[i].ejectedUntil)
+}
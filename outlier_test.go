@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutlierEjection(t *testing.T) {
+	primary, _ := url.Parse("http://a.invalid/")
+	cfg := BreakerConfig{Name: "Outlier Test", Timeout: time.Minute, ConsecutiveFailures: 100}
+	retry := RetryConfig{Attempts: 1}
+	b := newBreaker(cfg, retry)
+	var backups []*upstream
+	for _, raw := range []string{"http://b.invalid/", "http://c.invalid/"} {
+		target, _ := url.Parse(raw)
+		backups = append(backups, &upstream{target: target, b: newBreaker(upstreamBreaker(cfg, target), retry)})
+	}
+	outliers := newOutlierDetector(OutlierConfig{FailureMargin: 0.5, MinRequests: 3, Interval: time.Minute, Ejection: time.Minute}, 3)
+	tr := &breakerTransport{b: b, target: primary, backups: backups, balance: BalanceRoundRobin, outliers: outliers}
+
+	hosts := map[string]int{}
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		hosts[req.URL.Host]++
+		status := http.StatusOK
+		if req.URL.Host == "c.invalid" {
+			status = http.StatusBadGateway
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	for i := 0; i < 30; i++ {
+		resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.invalid/", nil))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	if hosts["c.invalid"] != 3 {
+		t.Fatalf("expected the failing upstream to be ejected after 3 calls, got %d", hosts["c.invalid"])
+	}
+	if !outliers.ejected(2) || outliers.ejected(0) || outliers.ejected(1) {
+		t.Fatalf("expected only the failing upstream to be ejected")
+	}
+	if got := testutil.ToFloat64(ejectionCount.WithLabelValues("Outlier Test c.invalid")); got != 1 {
+		t.Fatalf("expected 1 ejection to be counted, got %v", got)
+	}
+
+	t.Run("LastUpstream", func(t *testing.T) {
+		d := newOutlierDetector(OutlierConfig{FailureMargin: 0.1, MinRequests: 1, Interval: time.Minute, Ejection: time.Minute}, 2)
+		d.record(1, "Outlier Test", false)
+		d.record(0, "Outlier Test", true)
+		d.record(1, "Outlier Test", true)
+		d.record(1, "Outlier Test", true)
+		if !d.ejected(0) || d.ejected(1) {
+			t.Fatalf("expected the last upstream in rotation not to be ejected")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		if d := newOutlierDetector(OutlierConfig{}, 3); d != nil || d.ejected(0) {
+			t.Fatalf("expected no detector without a failure margin")
+		}
+	})
+}
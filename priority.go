@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// priorityHeader carries the priority clients give a request: "high",
+// "normal" or "low". Requests without it are normal.
+const priorityHeader = "X-Request-Priority"
+
+// priority orders requests competing for the breaker's limited capacity.
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+)
+
+// errLowPriority is returned for low-priority calls shed while the breaker
+// is half-open, leaving its trial calls to more important requests.
+var errLowPriority = errors.New("low-priority request shed")
+
+// requestPriority returns the priority req asks for.
+func requestPriority(req *http.Request) priority {
+	switch strings.ToLower(strings.TrimSpace(req.Header.Get(priorityHeader))) {
+	case "high":
+		return priorityHigh
+	case "low":
+		return priorityLow
+	default:
+		return priorityNormal
+	}
+}
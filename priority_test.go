@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestPriority(t *testing.T) {
+	tests := map[string]priority{
+		"":        priorityNormal,
+		"high":    priorityHigh,
+		" HIGH ":  priorityHigh,
+		"low":     priorityLow,
+		"normal":  priorityNormal,
+		"unknown": priorityNormal,
+	}
+	for value, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(priorityHeader, value)
+		if got := requestPriority(req); got != want {
+			t.Errorf("requestPriority(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestPriorityHalfOpen(t *testing.T) {
+	fake := useFakeClock(t)
+	b := newBreaker(BreakerConfig{
+		Name:                "Priority Half-Open Test",
+		MaxRequests:         1,
+		Timeout:             10 * time.Millisecond,
+		ConsecutiveFailures: 0,
+	}, RetryConfig{})
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	fake.Advance(10 * time.Millisecond)
+
+	succeed := func() (interface{}, error) { return nil, nil }
+	if _, err := b.executePriority(b.current(), priorityLow, succeed); !errors.Is(err, errLowPriority) || !isRejection(err) {
+		t.Fatalf("expected low-priority calls to be shed while half-open, got %v", err)
+	}
+	if _, err := b.executePriority(b.current(), priorityHigh, succeed); err != nil {
+		t.Fatalf("expected a high-priority call to be admitted, got %v", err)
+	}
+	if _, err := b.executePriority(b.current(), priorityLow, succeed); err != nil {
+		t.Fatalf("expected low-priority calls once closed, got %v", err)
+	}
+}
+
+func TestBulkheadPriority(t *testing.T) {
+	bh := newBulkhead(1)
+	if !bh.acquire(priorityNormal, 0) {
+		t.Fatal("expected a free slot")
+	}
+	if bh.acquire(priorityLow, time.Second) {
+		t.Fatal("expected low-priority calls not to wait")
+	}
+
+	// Queue a normal call before a high-priority one
+	order := make(chan priority, 2)
+	for _, prio := range []priority{priorityNormal, priorityHigh} {
+		go func() {
+			if bh.acquire(prio, time.Second) {
+				order <- prio
+			}
+		}()
+		for {
+			bh.mu.Lock()
+			n := bh.waiting[prio].Len()
+			bh.mu.Unlock()
+			if n == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	bh.release()
+	if got := <-order; got != priorityHigh {
+		t.Fatalf("expected the high-priority call to get the slot first, got %v", got)
+	}
+	bh.release()
+	if got := <-order; got != priorityNormal {
+		t.Fatalf("expected the normal call next, got %v", got)
+	}
+	bh.release()
+	if got := bh.busy(); got != 0 {
+		t.Fatalf("expected every slot to be free, got %d taken", got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// readiness tracks what /readyz reports on: whether each listener is up, which
+// happens once the config is loaded, whether the process is shutting down
+// and how long critical breakers have been open.
+type readiness struct {
+	mu sync.Mutex
+	// pending holds the checks not passed yet, in the order expected.
+	pending  []string
+	stopping bool
+	// cfg and critical, the breakers named by cfg or the main breaker,
+	// decide whether breakers left open degrade readiness.
+	cfg      ReadinessConfig
+	critical []string
+	// opened holds when each breaker last left the closed state, for those
+	// not closed since.
+	opened map[string]time.Time
+}
+
+// newReadiness returns a readiness expecting checks to pass before it is ready.
+func newReadiness(checks ...string) *readiness {
+	return &readiness{pending: checks, opened: make(map[string]time.Time)}
+}
+
+// configure applies cfg, main naming the main breaker.
+func (rd *readiness) configure(cfg ReadinessConfig, main string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.cfg = cfg
+	rd.critical = cfg.Critical
+	if len(rd.critical) == 0 {
+		rd.critical = []string{main}
+	}
+}
+
+// recordChange keeps track of how long breakers have been open.
+func (rd *readiness) recordChange(c stateChange) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	switch {
+	case c.To == gobreaker.StateClosed.String():
+		delete(rd.opened, c.Name)
+	case c.From == gobreaker.StateClosed.String():
+		rd.opened[c.Name] = c.Time
+	}
+}
+
+// pass marks check as passed.
+func (rd *readiness) pass(check string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.pending = slices.DeleteFunc(rd.pending, func(c string) bool { return c == check })
+}
+
+// stop reports the process is shutting down, so that no new traffic is
+// routed to it while requests in flight drain.
+func (rd *readiness) stop() {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.stopping = true
+}
+
+// notReady returns why the process is not ready to serve, nothing when it
+// is.
+func (rd *readiness) notReady() []string {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	var reasons []string
+	if rd.stopping {
+		reasons = append(reasons, "shutting down")
+	}
+	for _, check := range rd.pending {
+		reasons = append(reasons, check+" not up")
+	}
+	if rd.cfg.OpenThreshold > 0 {
+		for _, name := range rd.critical {
+			if opened, ok := rd.opened[name]; ok && clock.Now().Sub(opened) > rd.cfg.OpenThreshold {
+				reasons = append(reasons, fmt.Sprintf("breaker %s open for %s", name, clock.Now().Sub(opened).Round(time.Second)))
+			}
+		}
+	}
+	return reasons
+}
+
+// probeStatus is the JSON document served by /healthz and /readyz.
+type probeStatus struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// livenessHandler serves /healthz, which answers as long as the process can
+// serve requests at all, whatever the state of its breakers.
+func livenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(probeStatus{Status: "ok"})
+	}
+}
+
+// readinessHandler serves /readyz, which answers 503 with the reasons until
+// the config is loaded and every listener is up, again once shutting down,
+// and, when configured, while a critical breaker has been open too long.
+func readinessHandler(rd *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		reasons := rd.notReady()
+		if len(reasons) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(probeStatus{Status: "not ready", Reasons: reasons})
+			return
+		}
+		json.NewEncoder(w).Encode(probeStatus{Status: "ready"})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestReadiness(t *testing.T) {
+	probes := newReadiness("http", "admin")
+	check := func(t *testing.T, code int, reasons ...string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		readinessHandler(probes).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != code {
+			t.Fatalf("expected status %d, got %d", code, rec.Code)
+		}
+		var status probeStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("failed to decode readiness: %v", err)
+		}
+		if !slices.Equal(status.Reasons, reasons) {
+			t.Fatalf("expected reasons %q, got %q", reasons, status.Reasons)
+		}
+	}
+
+	check(t, http.StatusServiceUnavailable, "http not up", "admin not up")
+	probes.pass("http")
+	check(t, http.StatusServiceUnavailable, "admin not up")
+	probes.pass("admin")
+	check(t, http.StatusOK)
+	probes.stop()
+	check(t, http.StatusServiceUnavailable, "shutting down")
+}
+
+func TestLiveness(t *testing.T) {
+	rec := httptest.NewRecorder()
+	livenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the process to be reported alive, got %d", rec.Code)
+	}
+}
+
+func TestReadinessOpenBreaker(t *testing.T) {
+	probes := newReadiness()
+	probes.configure(ReadinessConfig{OpenThreshold: time.Minute}, "Main")
+	open := func(name string, at time.Time) {
+		probes.recordChange(stateChange{Name: name, From: gobreaker.StateClosed.String(), To: gobreaker.StateOpen.String(), Time: at})
+	}
+
+	open("Main", time.Now().Add(-30*time.Second))
+	open("Other", time.Now().Add(-time.Hour))
+	if reasons := probes.notReady(); len(reasons) != 0 {
+		t.Fatalf("expected only the main breaker to count, and not before the threshold, got %q", reasons)
+	}
+
+	// Probing half-open does not restart the clock
+	open("Main", time.Now().Add(-2*time.Minute))
+	probes.recordChange(stateChange{Name: "Main", From: gobreaker.StateOpen.String(), To: gobreaker.StateHalfOpen.String(), Time: time.Now()})
+	if reasons := probes.notReady(); len(reasons) != 1 || !strings.HasPrefix(reasons[0], "breaker Main open for 2m") {
+		t.Fatalf("expected the main breaker open too long, got %q", reasons)
+	}
+
+	probes.recordChange(stateChange{Name: "Main", From: gobreaker.StateHalfOpen.String(), To: gobreaker.StateClosed.String(), Time: time.Now()})
+	if reasons := probes.notReady(); len(reasons) != 0 {
+		t.Fatalf("expected readiness back once the breaker closed, got %q", reasons)
+	}
+
+	probes.configure(ReadinessConfig{OpenThreshold: time.Minute, Critical: []string{"Other"}}, "Main")
+	if reasons := probes.notReady(); len(reasons) != 1 {
+		t.Fatalf("expected the configured critical breaker to count, got %q", reasons)
+	}
+	probes.configure(ReadinessConfig{Critical: []string{"Other"}}, "Main")
+	if reasons := probes.notReady(); len(reasons) != 0 {
+		t.Fatalf("expected no degradation without a threshold, got %q", reasons)
+	}
+}
+
+func TestReadinessManualTransitions(t *testing.T) {
+	fake := useFakeClock(t)
+	probes := newReadiness()
+	probes.configure(ReadinessConfig{OpenThreshold: time.Minute}, "Main")
+	history := newEventHistory(10)
+	b := buildBreaker("Main", withConsecutiveFailures(0), withTimeout(time.Hour), withMetrics(false))
+	b.onStateChange(probes.recordChange)
+	b.onStateChange(history.recordChange)
+	hub := newEventHub()
+	b.onStateChange(hub.publish)
+	events := hub.subscribe()
+	ready := func(t *testing.T, want bool) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		readinessHandler(probes).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if got := rec.Code == http.StatusOK; got != want {
+			t.Fatalf("expected ready %v, got %d %s", want, rec.Code, rec.Body)
+		}
+	}
+	last := func(t *testing.T, from, to string) {
+		t.Helper()
+		if e := history.newest(); e == nil || e.From != from || e.To != to {
+			t.Fatalf("expected a change from %s to %s in the history, got %+v", from, to, e)
+		}
+	}
+
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("boom") })
+	fake.Advance(2 * time.Minute)
+	ready(t, false)
+	b.reset()
+	ready(t, true)
+	last(t, "open", "closed")
+	var streamed []string
+	for len(events) > 0 {
+		c := <-events
+		streamed = append(streamed, c.From+" -> "+c.To)
+	}
+	if !slices.Equal(streamed, []string{"closed -> open", "open -> closed"}) {
+		t.Fatalf("expected the trip and the reset streamed, got %q", streamed)
+	}
+
+	b.forceOpen()
+	last(t, "closed", "open")
+	fake.Advance(2 * time.Minute)
+	ready(t, false)
+	b.forceClose()
+	ready(t, true)
+	last(t, "open", "closed")
+
+	// Changing nothing reports nothing
+	n := len(history.recent(10))
+	b.forceClose()
+	b.reload(b.current().cfg, b.current().retry)
+	if len(history.recent(10)) != n {
+		t.Fatalf("expected no change reported, got %+v", history.recent(10))
+	}
+
+	// New settings replace an open circuit breaker with a closed one
+	b.reset()
+	b.execute(b.current(), func() (interface{}, error) { return nil, errors.New("boom") })
+	cfg := b.current().cfg
+	cfg.Timeout = 2 * time.Hour
+	b.reload(cfg, b.current().retry)
+	last(t, "open", "closed")
+	ready(t, true)
+}
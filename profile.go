@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// profileProvider applies the breaker and retry settings of the profile in
+// effect, the first of the schedule matching the current minute, over
+// those of the other providers. It triggers a reload whenever another
+// profile comes into effect.
+type profileProvider struct {
+	mu sync.Mutex
+	// cfg is the profiles last applied, and active the name of the one in
+	// effect then, empty for none.
+	cfg    ProfilesConfig
+	active string
+}
+
+// Load does nothing: profiles are part of the config the other providers
+// read.
+func (p *profileProvider) Load(context.Context) error {
+	return nil
+}
+
+func (p *profileProvider) Apply(cfg *Config) error {
+	profile := cfg.Profiles.active(clock.Now())
+	p.mu.Lock()
+	p.cfg = cfg.Profiles
+	if profile != nil {
+		p.active = profile.Name
+	} else {
+		p.active = ""
+	}
+	p.mu.Unlock()
+	if profile == nil {
+		return nil
+	}
+	return profile.apply(cfg)
+}
+
+// Watch checks which profile is in effect every second, calling onChange
+// when it is no longer the one last applied.
+func (p *profileProvider) Watch(ctx context.Context, onChange func(trigger string)) {
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		p.mu.Lock()
+		from, profile := p.active, p.cfg.active(clock.Now())
+		p.mu.Unlock()
+		to := ""
+		if profile != nil {
+			to = profile.Name
+		}
+		if to != from {
+			slog.Info("Switching config profile", "from", from, "to", to)
+			onChange("profile schedule")
+		}
+	}
+}
+
+// active returns the profile in effect at t, nil for none.
+func (cfg ProfilesConfig) active(t time.Time) *ProfileConfig {
+	t = t.In(cfg.Timezone.location())
+	for i, profile := range cfg.Schedule {
+		schedule, err := parseSchedule(string(profile.Schedule))
+		if err == nil && schedule.matches(t) {
+			return &cfg.Schedule[i]
+		}
+	}
+	return nil
+}
+
+// validate checks every profile names a schedule and applies cleanly.
+func (cfg ProfilesConfig) validate() error {
+	for _, profile := range cfg.Schedule {
+		if profile.Schedule == "" {
+			return fmt.Errorf("profile %q needs a schedule", profile.Name)
+		}
+		scratch := defaultConfig()
+		if err := profile.apply(&scratch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply overlays the settings of the profile onto cfg.
+func (profile *ProfileConfig) apply(cfg *Config) error {
+	if !profile.Breaker.IsZero() {
+		if err := decodeNode(&profile.Breaker, &cfg.Breaker); err != nil {
+			return fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+	}
+	if !profile.Retry.IsZero() {
+		if err := decodeNode(&profile.Retry, &cfg.Retry); err != nil {
+			return fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+	}
+	return nil
+}
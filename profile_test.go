@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	cfg, err := loadConfig(writeConfigFile(t, "config.yaml", `
+breaker:
+  consecutive_failures: 10
+retry:
+  attempts: 4
+profiles:
+  timezone: Asia/Tokyo
+  schedule:
+    - name: business-hours
+      schedule: "* 9-17 * * MON-FRI"
+      breaker:
+        consecutive_failures: 3
+    - name: nights
+      schedule: "* 0-5 * * *"
+      retry:
+        attempts: 1
+`))
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if configDigest(cfg) == "" {
+		t.Fatalf("expected a digest of the config with profiles")
+	}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+
+	tests := []struct {
+		at       time.Time
+		profile  string
+		failures uint32
+		attempts int
+	}{
+		// 2024-06-03 is a Monday
+		{time.Date(2024, 6, 3, 10, 0, 0, 0, tokyo), "business-hours", 3, 4},
+		{time.Date(2024, 6, 3, 2, 0, 0, 0, tokyo), "nights", 10, 1},
+		{time.Date(2024, 6, 2, 10, 0, 0, 0, tokyo), "", 10, 4},
+	}
+	for _, tt := range tests {
+		profile := cfg.Profiles.active(tt.at.UTC())
+		name := ""
+		if profile != nil {
+			name = profile.Name
+		}
+		if name != tt.profile {
+			t.Fatalf("expected profile %q at %v, got %q", tt.profile, tt.at, name)
+		}
+		applied := cfg
+		if profile != nil {
+			if err := profile.apply(&applied); err != nil {
+				t.Fatalf("failed to apply profile: %v", err)
+			}
+		}
+		if applied.Breaker.ConsecutiveFailures != tt.failures || applied.Retry.Attempts != tt.attempts {
+			t.Fatalf("expected %d failures and %d attempts under %q, got %d and %d", tt.failures, tt.attempts, tt.profile, applied.Breaker.ConsecutiveFailures, applied.Retry.Attempts)
+		}
+		if applied.Breaker.Name != cfg.Breaker.Name {
+			t.Fatalf("expected the settings missing from the profile to be kept")
+		}
+	}
+}
+
+func TestProfileProvider(t *testing.T) {
+	// A profile in effect every minute comes into effect on the first check
+	p := &profileProvider{}
+	cfg := defaultConfig()
+	if err := p.Apply(&cfg); err != nil {
+		t.Fatalf("failed to apply profiles: %v", err)
+	}
+	cfg.Profiles.Schedule = []ProfileConfig{{Name: "always", Schedule: "* * * * *"}}
+	p.mu.Lock()
+	p.cfg = cfg.Profiles
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan string, 1)
+	go p.Watch(ctx, func(trigger string) {
+		select {
+		case changes <- trigger:
+		default:
+		}
+	})
+	select {
+	case <-changes:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the profile coming into effect to trigger a reload")
+	}
+}
+
+func TestProfileValidation(t *testing.T) {
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "profiles:\n  schedule:\n    - name: nameless\n")); err == nil {
+		t.Fatalf("expected a profile without a schedule to be refused")
+	}
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "profiles:\n  schedule:\n    - schedule: \"* * * * *\"\n      breaker:\n        timeout: soon\n")); err == nil {
+		t.Fatalf("expected invalid profile settings to be refused")
+	}
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "profiles:\n  schedule:\n    - schedule: \"* * * * *\"\n      retry:\n        atempts: 2\n")); err == nil || !strings.Contains(err.Error(), "atempts") {
+		t.Fatalf("expected a misspelled profile setting to be named, got %v", err)
+	}
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "profiles:\n  timezone: Nowhere/Special\n")); err == nil {
+		t.Fatalf("expected an unknown time zone to be refused")
+	}
+}
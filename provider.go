@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigProvider is a source of settings layered over the defaults, each
+// over the ones before it: the config file, then Consul KV and etcd, which
+// hold breaker and retry settings only.
+type ConfigProvider interface {
+	// Load reads the provider's current settings.
+	Load(ctx context.Context) error
+	// Apply overlays the settings last read onto cfg.
+	Apply(cfg *Config) error
+	// Watch calls onChange, naming what triggered it, whenever the settings
+	// may have changed, until ctx is done.
+	Watch(ctx context.Context, onChange func(trigger string))
+}
+
+// layerConfig resolves the config from the defaults, each of providers in
+// turn, the CB_* environment variables and the setting flags.
+func layerConfig(providers []ConfigProvider, settings *settingFlags) (Config, error) {
+	cfg := defaultConfig()
+	for _, p := range providers {
+		if err := p.Apply(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	if err := settings.apply(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// fileProvider is the config file at path, reread on SIGHUP and, when
+// interval is positive, whenever it changes. A file that fails to parse, or
+// with which the resolved config fails to validate, is rolled back: the
+// last good contents stay in effect, for the reloads other providers
+// trigger too, until the file is fixed.
+type fileProvider struct {
+	path     string
+	interval time.Duration
+	// invalid is called instead of the reload when the file turns invalid.
+	invalid func(trigger string, err error)
+	// valid, when set, validates the config resolved with the contents just
+	// read, all layers applied.
+	valid func() error
+
+	mu sync.Mutex
+	// good is the contents last read that parsed and validated, nil when
+	// there is no file.
+	good []byte
+}
+
+// Load reads the file, keeping the last good contents when it is invalid.
+func (f *fileProvider) Load(context.Context) error {
+	data, err := readConfigFile(f.path)
+	if err != nil {
+		return err
+	}
+	cfg := defaultConfig()
+	if err := parseConfig(f.path, data, &cfg); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	previous := f.good
+	f.good = data
+	f.mu.Unlock()
+	if f.valid != nil {
+		if err := f.valid(); err != nil {
+			f.mu.Lock()
+			f.good = previous
+			f.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fileProvider) Apply(cfg *Config) error {
+	f.mu.Lock()
+	data := f.good
+	f.mu.Unlock()
+	return parseConfig(f.path, data, cfg)
+}
+
+func (f *fileProvider) Watch(ctx context.Context, onChange func(trigger string)) {
+	watchConfig(ctx, f.path, f.interval, func(trigger string) {
+		if err := f.Load(ctx); err != nil {
+			slog.Warn("Config file is invalid, rolled back to the last good one", "path", f.path, "error", err)
+			if f.invalid != nil {
+				f.invalid(trigger, err)
+			}
+			return
+		}
+		onChange(trigger)
+	})
+}
+
+// remoteProviders returns the providers of cfg besides the config file.
+func remoteProviders(cfg Config) []ConfigProvider {
+	var providers []ConfigProvider
+	if cfg.Consul.Key != "" {
+		providers = append(providers, newConsulKV(cfg.Consul))
+	}
+	if cfg.Etcd.Key != "" {
+		providers = append(providers, newEtcdKV(cfg.Etcd))
+	}
+	return providers
+}
+
+// applyTuning overlays the breaker and retry sections of the YAML or JSON
+// document value, read from key, onto cfg. A nil value leaves cfg alone.
+// The document is read as a whole config, so that unknown keys are rejected
+// as in the config file, but its other sections are ignored.
+func applyTuning(value []byte, key string, cfg *Config) error {
+	if value == nil {
+		return nil
+	}
+	doc := defaultConfig()
+	doc.Breaker, doc.Retry = cfg.Breaker, cfg.Retry
+	dec := yaml.NewDecoder(bytes.NewReader(value))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing %s: %w", key, err)
+	}
+	cfg.Breaker, cfg.Retry = doc.Breaker, doc.Retry
+	return nil
+}
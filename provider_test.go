@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileProviderRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("retry:\n  attempts: 2\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	file := &fileProvider{path: path, interval: 10 * time.Millisecond}
+	if err := file.Load(context.Background()); err != nil {
+		t.Fatalf("failed to load config file: %v", err)
+	}
+
+	invalid := make(chan error, 10)
+	reloads := make(chan string, 10)
+	file.invalid = func(_ string, err error) { invalid <- err }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go file.Watch(ctx, func(trigger string) { reloads <- trigger })
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("retry: [\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	select {
+	case <-invalid:
+	case <-reloads:
+		t.Fatalf("expected an invalid file not to trigger a reload")
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the invalid file to be reported")
+	}
+
+	// Other providers' reloads still get the last good file
+	cfg := defaultConfig()
+	if err := file.Apply(&cfg); err != nil || cfg.Retry.Attempts != 2 {
+		t.Fatalf("expected the last good file to be applied, got %d attempts, %v", cfg.Retry.Attempts, err)
+	}
+
+	if err := os.WriteFile(path, []byte("retry:\n  attempts: 3\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the fixed file to trigger a reload")
+	}
+	cfg = defaultConfig()
+	if err := file.Apply(&cfg); err != nil || cfg.Retry.Attempts != 3 {
+		t.Fatalf("expected the fixed file to be applied, got %d attempts, %v", cfg.Retry.Attempts, err)
+	}
+}
+
+func TestConfigValidatedAfterLayers(t *testing.T) {
+	// The file alone has no upstream, which the environment provides
+	path := writeConfigFile(t, "config.yaml", "upstream: \"\"\nretry:\n  attempts: 2\n")
+	t.Setenv("CB_UPSTREAM", "http://orders.internal")
+	cfg, err := offlineConfig(path, &settingFlags{})
+	if err != nil {
+		t.Fatalf("expected the layered config to be valid, got %v", err)
+	}
+	if cfg.Upstream != "http://orders.internal" || cfg.Retry.Attempts != 2 {
+		t.Fatalf("expected the file and the environment to be layered, got %q and %d attempts", cfg.Upstream, cfg.Retry.Attempts)
+	}
+
+	// Contents with which the resolved config is invalid are rolled back
+	file := &fileProvider{path: path}
+	if err := file.Load(context.Background()); err != nil {
+		t.Fatalf("failed to load config file: %v", err)
+	}
+	file.valid = func() error {
+		cfg, err := layerConfig([]ConfigProvider{file}, &settingFlags{})
+		if err != nil {
+			return err
+		}
+		return cfg.validate()
+	}
+	if err := os.WriteFile(path, []byte("retry:\n  attempts: 0\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	if err := file.Load(context.Background()); err == nil {
+		t.Fatalf("expected the invalid config to be reported")
+	}
+	cfg = defaultConfig()
+	if err := file.Apply(&cfg); err != nil || cfg.Retry.Attempts != 2 {
+		t.Fatalf("expected the last good file to be applied, got %d attempts, %v", cfg.Retry.Attempts, err)
+	}
+}
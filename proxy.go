@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newProxy returns a reverse proxy that forwards requests to t.target,
+// making every upstream call through t. Requests that cannot be proxied are
+// answered by fallback when it is not nil. It expects the mount prefix to
+// have been stripped from the request path. The API key of requests is for
+// the proxy alone and is not forwarded.
+func newProxy(t *breakerTransport, fallback FallbackFunc) *httputil.ReverseProxy {
+	target := t.target
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			if r.In.URL.Path == "" {
+				// Requests for the mount point itself go to the upstream path as-is.
+				r.Out.URL.Path = target.Path
+				r.Out.URL.RawPath = target.RawPath
+			}
+			r.SetXForwarded()
+			if key, ok := requestAPIKey(r.In.Context()); ok {
+				r.Out.Header.Del(key.header)
+			}
+		},
+		Transport: t,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			b := t.pick(r).b
+			if isRejection(err) {
+				// Tell clients when the breaker may let them through again,
+				// rounding up and waiting at least a second.
+				seconds := int(math.Ceil(b.reopenDelay().Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+			}
+			if fallback != nil {
+				fallback(w, r, err)
+				return
+			}
+			if isOverCapacity(err) {
+				http.Error(w, "Too many requests in flight", b.current().cfg.BulkheadStatus)
+				return
+			}
+			if errors.Is(err, errMaintenanceWindow) {
+				http.Error(w, "Upstream under maintenance", http.StatusServiceUnavailable)
+				return
+			}
+			if isRejection(err) {
+				http.Error(w, "Circuit breaker open", b.current().cfg.RejectStatus)
+				return
+			}
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		},
+	}
+}
+
+// breakerTransport is an http.RoundTripper that executes each upstream call
+// through the circuit breaker, retrying retryable failures with the
+// configured backoff. 5xx responses count as breaker failures. Retryable
+// calls may be hedged, counting once towards the breaker. Each attempt is
+// bounded by AttemptTimeout and the request as a whole by RequestTimeout.
+// Retrying stops as soon as the request's context is done or would be
+// before the next attempt, the breaker rejects a call, the retry time budget
+// is spent or the service-wide retry budget is exhausted. The calls of each
+// tenant, and writes, may be split off to transports with breakers of their
+// own.
+type breakerTransport struct {
+	b *breaker
+	// target is the upstream requests are addressed to, and backups the
+	// further upstreams calls may be moved to, each with its own breaker.
+	target  *url.URL
+	backups []*upstream
+	// balance selects how calls are spread over the upstreams, defaulting
+	// to failover from target to backups.
+	balance Balance
+	// next is the round-robin position.
+	next atomic.Uint64
+	// outliers ejects upstreams failing more than their peers when not nil.
+	outliers *outlierDetector
+	// health holds the health checks of the upstreams by index, if any.
+	health []*healthChecker
+	// writes, when not nil, carries the calls that are not reads instead.
+	writes *breakerTransport
+	// tenants, when not nil, carry the calls of each tenant instead, and
+	// tenant is the tenant whose calls t carries if any.
+	tenants *tenants
+	tenant  *tenant
+}
+
+// pick returns the transport req goes through: that of its tenant, if it
+// has one, for its method.
+func (t *breakerTransport) pick(req *http.Request) *breakerTransport {
+	if t.tenants != nil {
+		if id, ok := t.tenants.tenantID(req); ok {
+			if to := t.tenants.transport(id); to != nil {
+				return to.forMethod(req.Method)
+			}
+		}
+	}
+	return t.forMethod(req.Method)
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if to := t.pick(req); to != t {
+		return to.RoundTrip(req)
+	}
+	p := t.b.current()
+	start := clock.Now()
+	info := requestInfoFrom(req.Context())
+	if info != nil {
+		info.breaker, info.state = p.cfg.Name, p.cb.State().String()
+	}
+	var result interface{}
+	attempts := 0
+	backoff := newBackoff(p.retry, jitter)
+	var delay time.Duration
+	retries.deposit()
+
+	// Work on a copy since buffering the body must not modify the caller's
+	// request.
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if p.retry.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.retry.RequestTimeout)
+	}
+	req = req.WithContext(ctx)
+	maxAttempts := p.retry.Attempts
+	retryable, err := prepareRetries(req, p.retry)
+	if err != nil {
+		cancel()
+		if t.b.metrics {
+			observeRequest(p.cfg.Name, 0, err)
+		}
+		return nil, err
+	}
+	if !retryable {
+		maxAttempts = 1
+	}
+	prio := requestPriority(req)
+
+	var resp *http.Response
+	for i := 0; i < maxAttempts; i++ {
+		attempts++
+		upstream, b, to := t.route()
+		bp := b.current()
+		ctx, span := tracer().Start(req.Context(), "upstream attempt", trace.WithAttributes(
+			attribute.Int("retry.attempt", i+1),
+			attribute.String("breaker.name", bp.cfg.Name),
+			attribute.String("breaker.state", bp.cb.State().String()),
+		))
+		attemptCtx, cancelAttempt := ctx, context.CancelFunc(func() {})
+		if p.retry.AttemptTimeout > 0 {
+			attemptCtx, cancelAttempt = context.WithTimeout(ctx, p.retry.AttemptTimeout)
+		}
+		out := req.WithContext(attemptCtx)
+		if to != nil {
+			retarget(out, t.target, to)
+		}
+		propagateDeadline(out)
+		if i > 0 && req.GetBody != nil {
+			// Replay the buffered body consumed by the previous attempt
+			out.Body, _ = req.GetBody()
+		}
+		attemptStart := clock.Now()
+		result, err = b.observedExecute(req.Context(), bp, prio, i+1, func() (interface{}, error) {
+			call := tracedCall
+			if retryable && p.retry.Hedge {
+				call = func(req *http.Request) (*http.Response, error) {
+					return b.hedgedCall(bp, req)
+				}
+			}
+			resp, err := call(out)
+			if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+				return resp, &statusError{resp: resp}
+			}
+			return resp, err
+		})
+		if !isRejection(err) && !errors.Is(err, context.Canceled) {
+			t.outliers.record(upstream, bp.cfg.Name, err != nil)
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if slog.Default().Enabled(req.Context(), slog.LevelDebug) {
+			decision := "success"
+			if err != nil {
+				decision = fallbackReason(err)
+			}
+			slog.Debug("Upstream attempt", "breaker", bp.cfg.Name, "state", bp.cb.State().String(), "attempt", i+1,
+				"latency", clock.Now().Sub(attemptStart), "decision", decision, "request_id", requestIDFrom(req.Context()), "error", err)
+		}
+
+		resp, _ = result.(*http.Response)
+		if resp != nil {
+			// The body is read after the attempt returns
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancelAttempt}
+		} else {
+			cancelAttempt()
+		}
+		if !shouldRetry(resp, err) || i == maxAttempts-1 {
+			break
+		}
+
+		delay = backoff.Delay(i, delay)
+		if resp != nil {
+			// The upstream may have said when to try again
+			if requested, ok := retryAfter(resp, p.retry.RetryAfterMax); ok {
+				delay = requested
+			}
+		}
+		if p.retry.MaxElapsed > 0 && clock.Now().Sub(start)+delay > p.retry.MaxElapsed {
+			// Waiting for another attempt would exceed the retry time budget
+			slog.Debug("Not retrying, the retry time budget would be exceeded", "breaker", p.cfg.Name, "delay", delay)
+			break
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+			// The caller would have given up before the next attempt
+			slog.Debug("Not retrying, the request's deadline comes first", "breaker", p.cfg.Name, "delay", delay)
+			break
+		}
+		if !retries.withdraw() {
+			if t.b.metrics {
+				retryBudgetExhausted.WithLabelValues(p.cfg.Name).Inc()
+			}
+			slog.Debug("Not retrying, the retry budget is exhausted", "breaker", p.cfg.Name)
+			break
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+		slog.Debug("Backing off before retrying", "breaker", p.cfg.Name, "attempt", i+1, "delay", delay)
+		if ctxErr := sleepContext(req.Context(), delay); ctxErr != nil {
+			err = ctxErr
+			break
+		}
+	}
+
+	if t.b.metrics {
+		observeRequest(p.cfg.Name, attempts, err)
+	}
+	if info != nil {
+		info.attempts = attempts
+	}
+	if err != nil {
+		slog.Warn("Upstream request failed", "breaker", p.cfg.Name, "state", p.cb.State().String(),
+			"attempts", attempts, "latency", clock.Now().Sub(start), "request_id", requestIDFrom(req.Context()), "error", err)
+	}
+	if t.tenant != nil && t.tenant.metrics {
+		observeTenantRequest(t.tenant.breaker, t.tenant.id, err)
+	}
+	if resp != nil {
+		// Relay the upstream's last answer to the client, even an error status
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+	cancel()
+	return nil, err
+}
+
+// isThrottled reports whether resp asks to be retried later, which 429 and
+// 503 responses do.
+func isThrottled(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the delay requested by the Retry-After header of resp
+// capped at limit, and whether the header held a valid delay.
+func retryAfter(resp *http.Response, limit time.Duration) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, limit), true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return min(max(date.Sub(clock.Now()), 0), limit), true
+	}
+	return 0, false
+}
+
+// sleepContext pauses for d, returning ctx's error early if ctx is done
+// first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
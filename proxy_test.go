@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestProxy(t *testing.T) {
+	// Create a mock upstream that echoes the path it was asked for
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	target, err := url.Parse(server.URL + "/base")
+	if err != nil {
+		t.Fatalf("failed to parse upstream URL: %v", err)
+	}
+
+	b := newBreaker(BreakerConfig{
+		Name:                "Proxy Test",
+		Timeout:             5 * time.Second,
+		ConsecutiveFailures: 3,
+	}, RetryConfig{Attempts: 1})
+	api := http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil))
+
+	t.Run("ForwardsToUpstream", func(t *testing.T) {
+		callExternalAPI = http.DefaultTransport.RoundTrip
+
+		for path, want := range map[string]string{
+			"/api":       "/base",
+			"/api/users": "/base/users",
+		} {
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d for %s, got %d", http.StatusOK, path, rec.Code)
+			}
+			body, _ := io.ReadAll(rec.Body)
+			if string(body) != want {
+				t.Fatalf("expected upstream path %q for %s, got %q", want, path, body)
+			}
+		}
+	})
+
+	t.Run("UpstreamFailure", func(t *testing.T) {
+		callExternalAPI = func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("simulated failure")
+		}
+
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+		}
+	})
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Cancel Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 10,
+	}, RetryConfig{Attempts: 5, BackoffMin: 10 * time.Second, BackoffMax: 10 * time.Second})
+	transport := &breakerTransport{b: b}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The client disconnects while the first attempt is in flight
+	calls := 0
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		calls++
+		cancel()
+		return nil, errors.New("simulated failure")
+	}
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil).WithContext(ctx)
+	_, err := transport.RoundTrip(req)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected retries to stop when the client disconnects, took %v", elapsed)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 upstream call before the disconnect, got %d", calls)
+	}
+}
+
+func TestRetryStopsWhenOpen(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Fail Fast Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 5, BackoffMin: time.Millisecond, BackoffMax: time.Millisecond})
+	transport := &breakerTransport{b: b}
+
+	calls := 0
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("simulated failure")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)
+	_, err := transport.RoundTrip(req)
+
+	// The second failure trips the breaker and the third attempt is rejected
+	if !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected %v, got %v", gobreaker.ErrOpenState, err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 upstream calls before failing fast, got %d", calls)
+	}
+
+	calls = 0
+	if _, err := transport.RoundTrip(req); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected %v, got %v", gobreaker.ErrOpenState, err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no upstream calls while open, got %d", calls)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header string
+		delay  time.Duration
+		ok     bool
+	}{
+		{"Missing", "", 0, false},
+		{"Seconds", "5", 5 * time.Second, true},
+		{"Zero", "0", 0, true},
+		{"Capped", "120", time.Minute, true},
+		{"Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Minute, true},
+		{"PastDate", "Mon, 01 Jan 2001 00:00:00 GMT", 0, true},
+		{"Invalid", "soon", 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			delay, ok := retryAfter(resp, time.Minute)
+			if delay != tc.delay || ok != tc.ok {
+				t.Fatalf("expected (%v, %v), got (%v, %v)", tc.delay, tc.ok, delay, ok)
+			}
+		})
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Retry-After Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 5,
+	}, RetryConfig{Attempts: 2, BackoffMin: time.Minute, BackoffMax: time.Minute, RetryAfterMax: time.Minute})
+	transport := &breakerTransport{b: b}
+
+	calls := 0
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": {"0"}},
+				Body:       http.NoBody,
+			}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+
+	// The upstream's zero Retry-After replaces the minute-long backoff
+	start := time.Now()
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected Retry-After to override the backoff, took %v", elapsed)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestRejectedResponse(t *testing.T) {
+	target, _ := url.Parse("http://upstream.invalid")
+	b := newBreaker(BreakerConfig{
+		Name:                "Reject Test",
+		Timeout:             10 * time.Second,
+		ConsecutiveFailures: 0,
+		RejectStatus:        http.StatusServiceUnavailable,
+	}, RetryConfig{Attempts: 1})
+	api := http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil))
+
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("simulated failure")
+	}
+
+	// The first failure trips the breaker, the next request is rejected
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Header().Get("Retry-After") != "" {
+		t.Fatalf("expected no Retry-After on an upstream failure, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected configured status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" && got != "9" {
+		t.Fatalf("expected Retry-After of the remaining open timeout, got %q", got)
+	}
+
+	cfg := b.current().cfg
+	cfg.RejectStatus = http.StatusTooManyRequests
+	b.reload(cfg, b.current().retry)
+	b.forceOpen()
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("expected Retry-After of the full timeout when forced open, got %q", got)
+	}
+}
+
+func TestRetryTimeBudget(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Budget Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{
+		Attempts:   100,
+		Backoff:    BackoffConstant,
+		BackoffMin: 40 * time.Millisecond,
+		MaxElapsed: 100 * time.Millisecond,
+	})
+	transport := &breakerTransport{b: b}
+
+	calls := 0
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("simulated failure")
+	}
+
+	start := time.Now()
+	if _, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil)); err == nil {
+		t.Fatalf("expected error, got none")
+	}
+
+	// Attempts start at 0ms, 40ms and 80ms; a fourth would start past 100ms
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts within the budget, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected retrying to stop within the budget, took %v", elapsed)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Attempt Timeout Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{
+		Attempts:       3,
+		Backoff:        BackoffConstant,
+		AttemptTimeout: 20 * time.Millisecond,
+	})
+	transport := &breakerTransport{b: b}
+
+	// The first attempts hang until they time out, the last one answers
+	calls := 0
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls < 3 {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok")), Request: req}, nil
+	}
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+	if err != nil {
+		t.Fatalf("expected the last attempt to succeed, got %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("expected to read the body after the attempt returned, got %q, %v", body, err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected timed out attempts to be retried, got %d calls", calls)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Request Timeout Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{
+		Attempts:       100,
+		Backoff:        BackoffConstant,
+		BackoffMin:     10 * time.Millisecond,
+		RequestTimeout: 50 * time.Millisecond,
+	})
+	transport := &breakerTransport{b: b}
+
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	start := time.Now()
+	_, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected retrying to stop at the request timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweep is how often clients with a full bucket are forgotten.
+const rateLimitSweep = time.Minute
+
+// rateLimiter limits the /api requests of each client IP with a token
+// bucket, so that a single client cannot exhaust the retry budget or the
+// upstream's capacity for everyone else.
+type rateLimiter struct {
+	name string
+
+	mu      sync.Mutex
+	cfg     RateLimitConfig
+	clients map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens of one client as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig, name string) *rateLimiter {
+	return &rateLimiter{name: name, cfg: cfg, clients: make(map[string]*tokenBucket)}
+}
+
+// configure applies the limits of cfg to the clients' next requests.
+func (l *rateLimiter) configure(cfg RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
+
+// config returns the limits in effect.
+func (l *rateLimiter) config() RateLimitConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// take spends a token of client if it has one, returning the tokens it has
+// left.
+func (l *rateLimiter) take(client string) (bool, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clock.Now()
+	bucket, found := l.clients[client]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(l.cfg.Burst), last: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens = min(bucket.tokens+now.Sub(bucket.last).Seconds()*l.cfg.Rate, float64(l.cfg.Burst))
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, bucket.tokens
+	}
+	bucket.tokens--
+	return true, bucket.tokens
+}
+
+// sweep forgets the clients whose bucket has filled up again, which are no
+// different from clients never seen.
+func (l *rateLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clock.Now()
+	for client, bucket := range l.clients {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.cfg.Rate >= float64(l.cfg.Burst) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// run sweeps the clients every rateLimitSweep until ctx is done.
+func (l *rateLimiter) run(ctx context.Context) {
+	ticker := clock.NewTicker(rateLimitSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			l.sweep()
+		}
+	}
+}
+
+// clientIP returns the address identifying the client making r. Behind
+// the given number of trusted proxies, it is the address the outermost of
+// them appended to X-Forwarded-For, counted from the right since clients
+// can write any entries they like before it.
+func clientIP(r *http.Request, proxies int) string {
+	if proxies > 0 {
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(header, ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					entries = append(entries, entry)
+				}
+			}
+		}
+		if len(entries) > 0 {
+			return entries[max(len(entries)-proxies, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handler answers requests beyond their client's limit with 429 Too Many
+// Requests, and passes the others to next. Responses carry the client's
+// limit in X-RateLimit-* headers, X-RateLimit-Reset being the seconds until
+// the client may burst again.
+func (l *rateLimiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := l.config()
+		if cfg.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		proxies := 0
+		if cfg.TrustForwarded {
+			proxies = max(cfg.TrustedProxies, 1)
+		}
+		ok, tokens := l.take(clientIP(r, proxies))
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(cfg.Burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(cfg.Burst)-tokens)/cfg.Rate))))
+		if !ok {
+			observeRateLimited(l.name)
+			h.Set("Retry-After", strconv.Itoa(max(int(math.Ceil((1-tokens)/cfg.Rate)), 1)))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	fake := useFakeClock(t)
+	l := newRateLimiter(RateLimitConfig{Rate: 2, Burst: 3}, "Rate Limit Test")
+	h := l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 3 {
+		rec := call("10.0.0.1:1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d within the burst to pass, got status %d", i, rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(2-i) {
+			t.Fatalf("expected %d requests remaining, got %s", 2-i, got)
+		}
+	}
+	rec := call("10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the client to be limited, got status %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" || rec.Header().Get("X-RateLimit-Reset") != "2" || rec.Header().Get("X-RateLimit-Limit") != "3" {
+		t.Fatalf("unexpected rate limit headers %v", rec.Header())
+	}
+	if rec := call("10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("expected other clients to pass, got status %d", rec.Code)
+	}
+
+	// Tokens come back at Rate per second
+	fake.Advance(500 * time.Millisecond)
+	if rec := call("10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a refilled token to pass, got status %d", rec.Code)
+	}
+
+	fake.Advance(time.Hour)
+	l.sweep()
+	if len(l.clients) != 0 {
+		t.Fatalf("expected idle clients to be forgotten, got %d", len(l.clients))
+	}
+
+	l.configure(RateLimitConfig{})
+	if rec := call("10.0.0.1:1234"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Fatalf("expected no limit once disabled, got status %d and headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.RemoteAddr = "192.0.2.1:4000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+	if ip := clientIP(req, 0); ip != "192.0.2.1" {
+		t.Fatalf("expected the peer address, got %s", ip)
+	}
+	if ip := clientIP(req, 1); ip != "198.51.100.2" {
+		t.Fatalf("expected the address the trusted proxy appended, got %s", ip)
+	}
+	if ip := clientIP(req, 2); ip != "203.0.113.7" {
+		t.Fatalf("expected the address the outermost of two proxies appended, got %s", ip)
+	}
+	if ip := clientIP(req, 3); ip != "203.0.113.7" {
+		t.Fatalf("expected the first address when fewer proxies forwarded, got %s", ip)
+	}
+}
+
+func TestRateLimitForwardedSpoofing(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{Rate: 1, Burst: 2, TrustForwarded: true, TrustedProxies: 1}, "Spoofing Test")
+	h := l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	passed := 0
+	for i := range 10 {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		// A made-up address per request, then the one the proxy appended
+		req.Header.Add("X-Forwarded-For", "203.0.113."+strconv.Itoa(i))
+		req.Header.Add("X-Forwarded-For", "198.51.100.2")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK {
+			passed++
+		}
+	}
+	if passed != 2 {
+		t.Fatalf("expected spoofed addresses not to get fresh buckets, %d of 10 requests passed a burst of 2", passed)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverHandler answers requests whose handling panicked with 500 Internal
+// Server Error, logging the panic with its stack, instead of letting the
+// panic take the connection down. http.ErrAbortHandler, which aborts a
+// response on purpose, is let through.
+func recoverHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			observePanic(name)
+			slog.Error("Recovered from panic", "method", r.Method, "path", r.URL.Path,
+				"request_id", requestIDFrom(r.Context()), "panic", v, "stack", string(debug.Stack()))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
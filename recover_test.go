@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(LogConfig{Format: LogText}, &buf))
+
+	h := recoverHandler("Recover Test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if got := testutil.ToFloat64(panicCount.WithLabelValues("Recover Test")); got != 1 {
+		t.Fatalf("expected 1 panic counted, got %v", got)
+	}
+	if got := buf.String(); !strings.Contains(got, "panic=boom") || !strings.Contains(got, "recover_test.go") {
+		t.Fatalf("expected the panic to be logged with its stack, got %q", got)
+	}
+}
+
+func TestRecoverHandlerLogsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(LogConfig{Format: LogText}, &buf))
+
+	h := requestIDHandler(recoverHandler("Recover Test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+	req.Header.Set(requestIDHeader, "req-42")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if got := buf.String(); !strings.Contains(got, "request_id=req-42") {
+		t.Fatalf("expected the panic to be logged with the request ID, got %q", got)
+	}
+}
+
+func TestRecoverHandlerAbort(t *testing.T) {
+	h := recoverHandler("Recover Test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("expected http.ErrAbortHandler to be let through, got %v", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisClient is a minimal client of the Redis protocol (RESP2), enough
+// for the few commands sharing breaker state needs. Commands are sent one
+// batch at a time over a single connection, which is dialled on first use
+// and again after any error.
+type redisClient struct {
+	cfg RedisConfig
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// errRedisNil is the reply to commands finding no value.
+var errRedisNil = errors.New("redis: nil")
+
+func newRedisClient(cfg RedisConfig) *redisClient {
+	return &redisClient{cfg: cfg}
+}
+
+// dial connects to the server, authenticating and selecting the database
+// when configured.
+func (c *redisClient) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", c.cfg.Addr, c.cfg.Timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	var setup [][]string
+	if c.cfg.Password != "" {
+		setup = append(setup, []string{"AUTH", c.cfg.Password})
+	}
+	if c.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.cfg.DB)})
+	}
+	if len(setup) > 0 {
+		conn.SetDeadline(time.Now().Add(c.cfg.Timeout))
+		replies, err := roundTrip(conn, r, setup)
+		if err == nil {
+			err = firstError(replies)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("setting up redis connection: %w", err)
+		}
+	}
+	return conn, r, nil
+}
+
+// do sends cmd and returns its reply.
+func (c *redisClient) do(cmd ...string) (interface{}, error) {
+	replies, err := c.pipeline([][]string{cmd})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends cmds in one go and returns their replies in order, error
+// replies among them. The error returned is that of the connection.
+func (c *redisClient) pipeline(cmds [][]string) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, r, err := c.dial()
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.r = conn, r
+	}
+	c.conn.SetDeadline(time.Now().Add(c.cfg.Timeout))
+	replies, err := roundTrip(c.conn, c.r, cmds)
+	if err != nil {
+		// The connection is in an unknown state
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+		return nil, err
+	}
+	return replies, nil
+}
+
+// subscribe calls handle with every message published on channel, over a
+// connection of its own, until ctx is done or the connection fails.
+func (c *redisClient) subscribe(ctx context.Context, channel string, handle func(payload string)) error {
+	conn, r, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(c.cfg.Timeout))
+	if _, err := conn.Write(appendCommand(nil, []string{"SUBSCRIBE", channel})); err != nil {
+		return err
+	}
+	// Messages come whenever they are published
+	for first := true; ; first = false {
+		reply, err := readReply(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err, ok := reply.(redisError); ok {
+			return err
+		}
+		if first {
+			conn.SetDeadline(time.Time{})
+		}
+		if msg, ok := reply.([]interface{}); ok && len(msg) == 3 && msg[0] == "message" {
+			payload, _ := msg[2].(string)
+			handle(payload)
+		}
+	}
+}
+
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.r = nil, nil
+	return err
+}
+
+// roundTrip writes cmds to w and reads a reply to each from r.
+func roundTrip(w io.Writer, r *bufio.Reader, cmds [][]string) ([]interface{}, error) {
+	var buf []byte
+	for _, cmd := range cmds {
+		buf = appendCommand(buf, cmd)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// appendCommand appends cmd to buf as an array of bulk strings.
+func appendCommand(buf []byte, cmd []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(cmd)), 10)
+	buf = append(buf, "\r\n"...)
+	for _, arg := range cmd {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readReply reads one reply from r: a string for simple and bulk strings,
+// an int64 for integers, a []interface{} for arrays, errRedisNil for nil
+// replies and a redisError for error replies. Only connection and protocol
+// errors are returned as errors.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return errRedisNil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return errRedisNil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+// firstError returns the first error reply among replies, if any.
+func firstError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(redisError); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// replyInt returns reply as an integer, parsing bulk strings, and zero for
+// nil replies.
+func replyInt(reply interface{}) int64 {
+	switch v := reply.(type) {
+	case int64:
+		return v
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	default:
+		return 0
+	}
+}
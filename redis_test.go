@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory server of the few Redis commands the client
+// sends.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	strings  map[string]string
+	hashes   map[string]map[string]string
+	expires  map[string]time.Time
+	subs     map[string][]net.Conn
+	password string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, strings: map[string]string{}, hashes: map[string]map[string]string{}, expires: map[string]time.Time{}, subs: map[string][]net.Conn{}, password: password}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		cmd := make([]string, len(items))
+		for i, item := range items {
+			cmd[i], _ = item.(string)
+		}
+		if len(cmd) > 0 && strings.ToUpper(cmd[0]) == "AUTH" {
+			authed = len(cmd) == 2 && cmd[1] == f.password
+			if !authed {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+		} else if !authed {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		if len(cmd) == 2 && strings.ToUpper(cmd[0]) == "SUBSCRIBE" {
+			f.mu.Lock()
+			f.subs[cmd[1]] = append(f.subs[cmd[1]], conn)
+			f.mu.Unlock()
+			conn.Write([]byte("*3\r\n" + bulk("subscribe") + bulk(cmd[1]) + ":1\r\n"))
+			continue
+		}
+		conn.Write([]byte(f.exec(cmd)))
+	}
+}
+
+// expire drops key if it expired. It expects f.mu to be held.
+func (f *fakeRedis) expire(key string) {
+	if at, ok := f.expires[key]; ok && !time.Now().Before(at) {
+		delete(f.strings, key)
+		delete(f.hashes, key)
+		delete(f.expires, key)
+	}
+}
+
+func (f *fakeRedis) exec(cmd []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(cmd) > 1 {
+		f.expire(cmd[1])
+	}
+	switch strings.ToUpper(cmd[0]) {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.strings[cmd[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "SET":
+		_, exists := f.strings[cmd[1]]
+		var px time.Duration
+		for i := 3; i < len(cmd); i++ {
+			switch strings.ToUpper(cmd[i]) {
+			case "NX":
+				if exists {
+					return "$-1\r\n"
+				}
+			case "PX":
+				ms, _ := strconv.Atoi(cmd[i+1])
+				px = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		f.strings[cmd[1]] = cmd[2]
+		delete(f.expires, cmd[1])
+		if px > 0 {
+			f.expires[cmd[1]] = time.Now().Add(px)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range cmd[1:] {
+			_, s := f.strings[key]
+			_, h := f.hashes[key]
+			if s || h {
+				n++
+			}
+			delete(f.strings, key)
+			delete(f.hashes, key)
+			delete(f.expires, key)
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "HINCRBY":
+		h := f.hash(cmd[1])
+		n, _ := strconv.Atoi(h[cmd[2]])
+		delta, _ := strconv.Atoi(cmd[3])
+		h[cmd[2]] = strconv.Itoa(n + delta)
+		return fmt.Sprintf(":%d\r\n", n+delta)
+	case "HSET":
+		h := f.hash(cmd[1])
+		for i := 2; i+1 < len(cmd); i += 2 {
+			h[cmd[i]] = cmd[i+1]
+		}
+		return ":1\r\n"
+	case "HMGET":
+		h := f.hashes[cmd[1]]
+		out := fmt.Sprintf("*%d\r\n", len(cmd)-2)
+		for _, field := range cmd[2:] {
+			if v, ok := h[field]; ok {
+				out += bulk(v)
+			} else {
+				out += "$-1\r\n"
+			}
+		}
+		return out
+	case "PEXPIRE":
+		ms, _ := strconv.Atoi(cmd[2])
+		f.expires[cmd[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case "PUBLISH":
+		for _, sub := range f.subs[cmd[1]] {
+			sub.Write([]byte("*3\r\n" + bulk("message") + bulk(cmd[1]) + bulk(cmd[2])))
+		}
+		return fmt.Sprintf(":%d\r\n", len(f.subs[cmd[1]]))
+	case "PTTL":
+		_, s := f.strings[cmd[1]]
+		_, h := f.hashes[cmd[1]]
+		if !s && !h {
+			return ":-2\r\n"
+		}
+		at, ok := f.expires[cmd[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(at).Milliseconds())
+	default:
+		return "-ERR unknown command '" + cmd[0] + "'\r\n"
+	}
+}
+
+func (f *fakeRedis) hash(key string) map[string]string {
+	h, ok := f.hashes[key]
+	if !ok {
+		h = map[string]string{}
+		f.hashes[key] = h
+	}
+	return h
+}
+
+func bulk(v string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+}
+
+func TestRedisClient(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	c := newRedisClient(RedisConfig{Addr: server.addr(), Password: "secret", DB: 2, Timeout: time.Second})
+	defer c.Close()
+
+	if _, err := c.do("SET", "key", "value with spaces"); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+	if got, err := c.do("GET", "key"); err != nil || got != "value with spaces" {
+		t.Fatalf("expected the value set, got %v, %v", got, err)
+	}
+	if _, err := c.do("GET", "missing"); err != errRedisNil {
+		t.Fatalf("expected %v for a missing key, got %v", errRedisNil, err)
+	}
+	if _, err := c.do("NOPE"); err == nil || !strings.HasPrefix(err.Error(), "ERR") {
+		t.Fatalf("expected an error reply, got %v", err)
+	}
+
+	replies, err := c.pipeline([][]string{{"HINCRBY", "hash", "n", "2"}, {"HMGET", "hash", "n", "m"}})
+	if err != nil {
+		t.Fatalf("failed to run pipeline: %v", err)
+	}
+	fields := replies[1].([]interface{})
+	if replyInt(replies[0]) != 2 || replyInt(fields[0]) != 2 || fields[1] != errRedisNil {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+
+	wrong := newRedisClient(RedisConfig{Addr: server.addr(), Password: "guess", Timeout: time.Second})
+	if _, err := wrong.do("GET", "key"); err == nil {
+		t.Fatalf("expected a wrong password to be refused")
+	}
+}
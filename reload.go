@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// watchConfig calls reload whenever the process receives SIGHUP and, when
+// interval is positive, whenever the file at path changes, naming what
+// triggered it. It returns when ctx is done.
+func watchConfig(ctx context.Context, path string, interval time.Duration, reload func(trigger string)) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	last := statConfig(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			last = statConfig(path)
+			reload("SIGHUP")
+		case <-tick:
+			if version := statConfig(path); version != last {
+				last = version
+				reload("config file change")
+			}
+		}
+	}
+}
+
+// configVersion identifies a version of the config file.
+type configVersion struct {
+	// target is the file path resolves to. A Kubernetes ConfigMap is
+	// mounted as a symlink through ..data, which the kubelet swaps
+	// atomically to a new directory on every update; the new file may
+	// well have the same size and modification time.
+	target  string
+	modTime time.Time
+	size    int64
+}
+
+// statConfig returns the version of the file at path, or the zero version if
+// it cannot be read.
+func statConfig(path string) configVersion {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return configVersion{}
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return configVersion{}
+	}
+	return configVersion{target: target, modTime: info.ModTime(), size: info.Size()}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("retry:\n  attempts: 1\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := make(chan struct{}, 10)
+	go watchConfig(ctx, path, 10*time.Millisecond, func(string) {
+		reloads <- struct{}{}
+	})
+	// Give the watcher time to record the modification time and register
+	// for SIGHUP
+	time.Sleep(50 * time.Millisecond)
+
+	waitForReload := func(t *testing.T) {
+		t.Helper()
+		select {
+		case <-reloads:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected config to be reloaded")
+		}
+	}
+
+	t.Run("FileChange", func(t *testing.T) {
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("failed to touch config file: %v", err)
+		}
+		waitForReload(t)
+	})
+
+	t.Run("SIGHUP", func(t *testing.T) {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatalf("failed to send SIGHUP: %v", err)
+		}
+		waitForReload(t)
+	})
+}
+
+// TestWatchConfigMapSwap mounts the config the way the kubelet mounts a
+// ConfigMap and swaps it the way the kubelet updates one.
+func TestWatchConfigMapSwap(t *testing.T) {
+	dir := t.TempDir()
+	stamp := time.Now().Add(-time.Hour)
+	writeVersion := func(t *testing.T, name, contents string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatalf("failed to create version directory: %v", err)
+		}
+		path := filepath.Join(dir, name, "config.yaml")
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+		// Same size and modification time as the previous version
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatalf("failed to touch config file: %v", err)
+		}
+	}
+	writeVersion(t, "..v1", "retry:\n  attempts: 1\n")
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("failed to link data directory: %v", err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+		t.Fatalf("failed to link config file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan string, 10)
+	go watchConfig(ctx, path, 10*time.Millisecond, func(trigger string) {
+		reloads <- trigger
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	writeVersion(t, "..v2", "retry:\n  attempts: 2\n")
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatalf("failed to link data directory: %v", err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("failed to swap data directory: %v", err)
+	}
+	select {
+	case trigger := <-reloads:
+		if trigger != "config file change" {
+			t.Fatalf("expected a config file change, got %q", trigger)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the swap to trigger a reload")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestIDHeader carries the ID correlating a request across services.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs accepted from clients, so that they fit
+// in exemplars: Prometheus caps exemplar labels at 128 runes in total,
+// request_id taking 10 of them.
+const maxRequestIDLength = prometheus.ExemplarMaxRunes - len("request_id")
+
+type requestIDKey struct{}
+
+// requestIDFrom returns the ID of the request ctx belongs to, or an empty
+// string.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id is an acceptable request ID: up to
+// maxRequestIDLength printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDHandler gives every request an ID, keeping a valid one sent by
+// the client in X-Request-ID. The ID is forwarded to the upstream, echoed
+// in the response and available to next through the request's context.
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r = r.Clone(r.Context())
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRequestIDHandler(t *testing.T) {
+	var seen, forwarded string
+	h := requestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, forwarded = requestIDFrom(r.Context()), r.Header.Get(requestIDHeader)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set(requestIDHeader, "client-id-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if seen != "client-id-1" || forwarded != "client-id-1" || rec.Header().Get(requestIDHeader) != "client-id-1" {
+		t.Fatalf("expected the client's ID to be kept, got %q, forwarded %q", seen, forwarded)
+	}
+
+	for _, id := range []string{"", "has space", strings.Repeat("x", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req.Header.Set(requestIDHeader, id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if len(seen) != 32 || seen == id || forwarded != seen || rec.Header().Get(requestIDHeader) != seen {
+			t.Fatalf("expected a generated ID in place of %q, got %q, forwarded %q", id, seen, forwarded)
+		}
+		if req.Header.Get(requestIDHeader) != id {
+			t.Fatalf("expected the caller's request to be left alone")
+		}
+	}
+}
+
+func TestAttemptExemplar(t *testing.T) {
+	observeAttempt("Exemplar Test", 1, nil, 5*time.Millisecond, "abc123")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	var found *dto.Exemplar
+	for _, family := range families {
+		if family.GetName() != "upstream_call_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if !hasLabel(m, "breaker", "Exemplar Test") {
+				continue
+			}
+			for _, bucket := range m.GetHistogram().GetBucket() {
+				if bucket.GetExemplar() != nil {
+					found = bucket.GetExemplar()
+				}
+			}
+		}
+	}
+	if found == nil || len(found.GetLabel()) != 1 || found.GetLabel()[0].GetValue() != "abc123" {
+		t.Fatalf("expected an exemplar with the request ID, got %v", found)
+	}
+}
+
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name && l.GetValue() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLongestRequestIDExemplar(t *testing.T) {
+	id := strings.Repeat("x", maxRequestIDLength)
+	if !validRequestID(id) {
+		t.Fatalf("expected an ID of %d characters to be accepted", maxRequestIDLength)
+	}
+	// The exemplar of the longest ID accepted must fit
+	observeAttempt("Long Exemplar Test", 1, nil, time.Millisecond, id)
+}
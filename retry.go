@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// idempotentMethods are retried by default since repeating them has the
+// same effect as sending them once.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// prepareRetries reports whether req may be sent more than once. Idempotent
+// methods may; POST may when cfg.RetryPOST is set or the client marked the
+// request with an Idempotency-Key header. The body of a retryable request is
+// buffered and req.GetBody set so that every attempt sends the same payload;
+// bodies larger than cfg.MaxBufferedBody are streamed once instead.
+func prepareRetries(req *http.Request, cfg RetryConfig) (bool, error) {
+	retryable := idempotentMethods[req.Method] ||
+		req.Method == http.MethodPost && (cfg.RetryPOST || req.Header.Get("Idempotency-Key") != "")
+	if !retryable || req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return retryable, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, cfg.MaxBufferedBody+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(buf)) > cfg.MaxBufferedBody {
+		// Too large to keep around: send what was read followed by the rest.
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return false, nil
+	}
+
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, nil
+}
+
+// statusError reports an upstream response whose status shows the upstream
+// failing. It makes the breaker count the call as a failure while keeping
+// the response so it can still be relayed to the client.
+type statusError struct {
+	resp *http.Response
+}
+
+func (e *statusError) Error() string {
+	return "upstream responded " + e.resp.Status
+}
+
+// retryableStatuses are the failing statuses worth retrying: gateway
+// errors that usually clear up on their own.
+var retryableStatuses = map[int]bool{
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// shouldRetry reports whether an attempt that ended with resp and err is
+// worth retrying.
+func shouldRetry(resp *http.Response, err error) bool {
+	switch {
+	case err == nil:
+		return isThrottled(resp)
+	case isRejection(err):
+		// Retrying cannot succeed before the breaker lets calls through
+		// again, so fail fast instead of burning the retry budget.
+		return false
+	default:
+		return isRetryableError(err)
+	}
+}
+
+// isRetryableError reports whether a failed call may succeed if repeated.
+// Connection failures and timeouts may, as may the statuses in
+// retryableStatuses; canceled requests, other failing statuses and TLS
+// verification failures never will.
+func isRetryableError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return retryableStatuses[se.resp.StatusCode]
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.As(err, &certErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr):
+		return false
+	}
+	return true
+}
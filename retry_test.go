@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMethodAwareRetries(t *testing.T) {
+	newTransport := func(retry RetryConfig) *breakerTransport {
+		retry.Attempts = 3
+		retry.Backoff = BackoffConstant
+		return &breakerTransport{b: newBreaker(BreakerConfig{
+			Name:                "Method Test",
+			Timeout:             time.Minute,
+			ConsecutiveFailures: 100,
+		}, retry)}
+	}
+
+	// Record the body of every attempt and fail them all
+	var bodies []string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		body := ""
+		if req.Body != nil {
+			b, _ := io.ReadAll(req.Body)
+			body = string(b)
+		}
+		bodies = append(bodies, body)
+		return nil, errors.New("simulated failure")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		method   string
+		header   http.Header
+		retry    RetryConfig
+		body     string
+		attempts int
+	}{
+		{"GET", http.MethodGet, nil, RetryConfig{}, "", 3},
+		{"PUT", http.MethodPut, nil, RetryConfig{MaxBufferedBody: 1024}, `{"id":1}`, 3},
+		{"POST", http.MethodPost, nil, RetryConfig{MaxBufferedBody: 1024}, `{"id":1}`, 1},
+		{"PATCH", http.MethodPatch, nil, RetryConfig{MaxBufferedBody: 1024}, `{"id":1}`, 1},
+		{"POSTWithIdempotencyKey", http.MethodPost, http.Header{"Idempotency-Key": {"abc"}}, RetryConfig{MaxBufferedBody: 1024}, `{"id":1}`, 3},
+		{"POSTAllowed", http.MethodPost, nil, RetryConfig{RetryPOST: true, MaxBufferedBody: 1024}, `{"id":1}`, 3},
+		{"BodyTooLarge", http.MethodPut, nil, RetryConfig{MaxBufferedBody: 4}, `{"id":1}`, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bodies = nil
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			req := httptest.NewRequest(tc.method, "http://upstream.invalid/", body)
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+
+			newTransport(tc.retry).RoundTrip(req)
+
+			if len(bodies) != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d", tc.attempts, len(bodies))
+			}
+			for i, got := range bodies {
+				if got != tc.body {
+					t.Fatalf("expected attempt %d to send %q, got %q", i+1, tc.body, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryBackoffOnClock(t *testing.T) {
+	fake := useFakeClock(t)
+	tr := &breakerTransport{b: newBreaker(BreakerConfig{
+		Name:                "Backoff Clock Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{Attempts: 2, Backoff: BackoffConstant, BackoffMin: time.Hour, BackoffMax: time.Hour})}
+	attempts := 0
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, errors.New("simulated failure")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+		close(done)
+	}()
+	// The hour of backoff passes as soon as the clock is moved
+	fake.waitFor(t, 1)
+	fake.Advance(time.Hour)
+	<-done
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestStatusClassification(t *testing.T) {
+	t.Run("Errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name      string
+			err       error
+			retryable bool
+		}{
+			{"Connection", errors.New("connection refused"), true},
+			{"BadGateway", &statusError{resp: &http.Response{StatusCode: http.StatusBadGateway}}, true},
+			{"Unavailable", &statusError{resp: &http.Response{StatusCode: http.StatusServiceUnavailable}}, true},
+			{"GatewayTimeout", &statusError{resp: &http.Response{StatusCode: http.StatusGatewayTimeout}}, true},
+			{"InternalError", &statusError{resp: &http.Response{StatusCode: http.StatusInternalServerError}}, false},
+			{"Canceled", context.Canceled, false},
+			{"UnknownAuthority", &url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				if got := isRetryableError(tc.err); got != tc.retryable {
+					t.Fatalf("expected retryable %v, got %v", tc.retryable, got)
+				}
+			})
+		}
+	})
+
+	t.Run("Responses", func(t *testing.T) {
+		for _, tc := range []struct {
+			status   int
+			attempts int
+			failures uint32
+		}{
+			{http.StatusBadRequest, 1, 0},
+			{http.StatusUnauthorized, 1, 0},
+			{http.StatusNotFound, 1, 0},
+			{http.StatusUnprocessableEntity, 1, 0},
+			{http.StatusInternalServerError, 1, 1},
+			{http.StatusBadGateway, 3, 3},
+			{http.StatusGatewayTimeout, 3, 3},
+		} {
+			t.Run(http.StatusText(tc.status), func(t *testing.T) {
+				b := newBreaker(BreakerConfig{
+					Name:                "Status Classification Test",
+					Timeout:             time.Minute,
+					ConsecutiveFailures: 100,
+				}, RetryConfig{Attempts: 3, Backoff: BackoffConstant})
+				transport := &breakerTransport{b: b}
+
+				calls := 0
+				callExternalAPI = func(*http.Request) (*http.Response, error) {
+					calls++
+					return &http.Response{
+						StatusCode: tc.status,
+						Status:     http.StatusText(tc.status),
+						Body:       io.NopCloser(strings.NewReader("upstream says no")),
+					}, nil
+				}
+
+				resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+				if err != nil {
+					t.Fatalf("expected the upstream response to be relayed, got %v", err)
+				}
+				if resp.StatusCode != tc.status {
+					t.Fatalf("expected status %d, got %d", tc.status, resp.StatusCode)
+				}
+				if body, _ := io.ReadAll(resp.Body); string(body) != "upstream says no" {
+					t.Fatalf("expected the upstream body, got %q", body)
+				}
+				if calls != tc.attempts {
+					t.Fatalf("expected %d attempts, got %d", tc.attempts, calls)
+				}
+				if failures := b.current().cb.Counts().TotalFailures; failures != tc.failures {
+					t.Fatalf("expected %d breaker failures, got %d", tc.failures, failures)
+				}
+			})
+		}
+	})
+}
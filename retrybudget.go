@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// retryBudgetBuckets is the number of buckets the budget window is split
+// into; older buckets expire one at a time as the window slides.
+const retryBudgetBuckets = 10
+
+// retries is the retry budget shared by every proxied request, so that a
+// failing upstream does not receive several times its normal traffic from
+// everyone retrying at once.
+var retries = &retryBudget{}
+
+// retryBudget allows retries to make up at most a ratio of the requests
+// seen over a sliding window, plus a floor of retries per second so that
+// low traffic can still retry. A ratio of zero disables the budget.
+type retryBudget struct {
+	mu        sync.Mutex
+	ratio     float64
+	minPerSec float64
+	window    time.Duration
+	buckets   [retryBudgetBuckets]budgetBucket
+}
+
+type budgetBucket struct {
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// configure applies the budget settings of cfg, keeping the counts when the
+// window is unchanged.
+func (b *retryBudget) configure(cfg RetryConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cfg.BudgetWindow != b.window {
+		b.buckets = [retryBudgetBuckets]budgetBucket{}
+	}
+	b.ratio = cfg.BudgetRatio
+	b.minPerSec = cfg.BudgetMinPerSecond
+	b.window = cfg.BudgetWindow
+}
+
+// deposit records an original request.
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ratio <= 0 {
+		return
+	}
+	b.current(clock.Now()).requests++
+}
+
+// withdraw reports whether a retry fits in the budget, recording it if so.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ratio <= 0 {
+		return true
+	}
+
+	now := clock.Now()
+	bucket := b.current(now)
+	var requests, retried int
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.window {
+			requests += bk.requests
+			retried += bk.retries
+		}
+	}
+
+	allowed := b.ratio*float64(requests) + b.minPerSec*b.window.Seconds()
+	if float64(retried+1) > allowed {
+		return false
+	}
+	bucket.retries++
+	return true
+}
+
+// current returns the bucket covering now, recycling an expired one.
+func (b *retryBudget) current(now time.Time) *budgetBucket {
+	width := b.window / retryBudgetBuckets
+	if width <= 0 {
+		width = time.Second
+	}
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%retryBudgetBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = budgetBucket{start: start}
+	}
+	return bucket
+}
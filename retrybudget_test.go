@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryBudget(t *testing.T) {
+	t.Run("Ratio", func(t *testing.T) {
+		b := &retryBudget{}
+		b.configure(RetryConfig{BudgetRatio: 0.5, BudgetWindow: 10 * time.Second})
+
+		for i := 0; i < 4; i++ {
+			b.deposit()
+		}
+		for i := 0; i < 2; i++ {
+			if !b.withdraw() {
+				t.Fatalf("expected retry %d to fit in the budget", i+1)
+			}
+		}
+		if b.withdraw() {
+			t.Fatalf("expected a third retry for 4 requests to exceed a 50%% budget")
+		}
+	})
+
+	t.Run("MinPerSecond", func(t *testing.T) {
+		b := &retryBudget{}
+		b.configure(RetryConfig{BudgetRatio: 0.1, BudgetMinPerSecond: 1, BudgetWindow: 2 * time.Second})
+
+		// With no traffic, the floor still allows a retry per second of window
+		for i := 0; i < 2; i++ {
+			if !b.withdraw() {
+				t.Fatalf("expected retry %d to fit in the floor", i+1)
+			}
+		}
+		if b.withdraw() {
+			t.Fatalf("expected the floor to be exhausted")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		b := &retryBudget{}
+		for i := 0; i < 100; i++ {
+			if !b.withdraw() {
+				t.Fatalf("expected a disabled budget to allow every retry")
+			}
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		fake := useFakeClock(t)
+		b := &retryBudget{}
+		b.configure(RetryConfig{BudgetRatio: 1, BudgetWindow: 100 * time.Millisecond})
+
+		b.deposit()
+		if !b.withdraw() || b.withdraw() {
+			t.Fatalf("expected exactly one retry for one request")
+		}
+
+		// Once the window has slid past them, old retries no longer count
+		fake.Advance(150 * time.Millisecond)
+		b.deposit()
+		if !b.withdraw() {
+			t.Fatalf("expected retries to be allowed again after the window")
+		}
+	})
+}
+
+func TestRetryBudgetStopsRetries(t *testing.T) {
+	retries.configure(RetryConfig{BudgetRatio: 0.5, BudgetWindow: time.Minute})
+	defer retries.configure(RetryConfig{})
+	retryBudgetExhausted.Reset()
+
+	b := newBreaker(BreakerConfig{
+		Name:                "Budget Exhausted Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 100,
+	}, RetryConfig{Attempts: 5, Backoff: BackoffConstant})
+	transport := &breakerTransport{b: b}
+
+	calls := 0
+	callExternalAPI = func(*http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("simulated failure")
+	}
+
+	// Two requests earn a single retry between them
+	for i := 0; i < 2; i++ {
+		transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream.invalid/", nil))
+	}
+	if calls != 3 {
+		t.Fatalf("expected 2 attempts and 1 retry, got %d calls", calls)
+	}
+	if got := testutil.ToFloat64(retryBudgetExhausted.WithLabelValues("Budget Exhausted Test")); got != 2 {
+		t.Fatalf("expected 2 retries to be refused, got %v", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// route is a path served by its own upstream and breaker.
+type route struct {
+	cfg RouteConfig
+	t   *breakerTransport
+}
+
+// routeBreaker returns the settings of the breaker guarding r: those of
+// cfg.Breaker named after the path, overridden by the route's own.
+func (cfg Config) routeBreaker(r RouteConfig) (BreakerConfig, error) {
+	breaker := cfg.Breaker
+	breaker.Name += " " + r.Path
+	if !r.Breaker.IsZero() {
+		if err := decodeNode(&r.Breaker, &breaker); err != nil {
+			return breaker, fmt.Errorf("route %s: %w", r.Path, err)
+		}
+	}
+	return breaker, nil
+}
+
+// validateRoutes checks the routes of cfg, normalising their paths.
+func (cfg *Config) validateRoutes() error {
+	for i := range cfg.Routes {
+		r := &cfg.Routes[i]
+		r.Path = strings.TrimSuffix(r.Path, "/")
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("route path %q must start with /", r.Path)
+		}
+		if r.Upstream == "" {
+			return errors.New("route " + r.Path + " has no upstream")
+		}
+		if _, err := cfg.routeBreaker(*r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRoutes builds the routes of cfg, each with its own breaker.
+func newRoutes(cfg Config) ([]*route, error) {
+	var routes []*route
+	for _, rc := range cfg.Routes {
+		target, err := parseUpstream(rc.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", rc.Path, err)
+		}
+		breaker, err := cfg.routeBreaker(rc)
+		if err != nil {
+			return nil, err
+		}
+		t := &breakerTransport{b: newBreaker(breaker, cfg.Retry), target: target}
+		if breaker.SplitMethods {
+			t.splitWrites(cfg.Retry)
+		}
+		routes = append(routes, &route{cfg: rc, t: t})
+	}
+	return routes, nil
+}
+
+// reloadRoutes applies the settings of newCfg to the breakers of routes. Routes
+// added or removed take effect after a restart.
+func reloadRoutes(routes []*route, newCfg Config) {
+	for _, r := range routes {
+		for _, rc := range newCfg.Routes {
+			if rc.Path != r.cfg.Path {
+				continue
+			}
+			if breaker, err := newCfg.routeBreaker(rc); err == nil {
+				r.t.b.reload(breaker, newCfg.Retry)
+				r.t.reloadWrites(breaker, newCfg.Retry)
+				r.t.reloadTenants()
+			}
+		}
+	}
+}
+
+// proxyHandler returns the handler proxying the requests below prefix
+// through t, with the response cache and coalescing configured in cfg.
+func proxyHandler(cfg ProxyConfig, prefix string, t *breakerTransport, fallback FallbackFunc) http.Handler {
+	name := t.b.current().cfg.Name
+	proxy := newProxy(t, fallback)
+	if cfg.Cache || cfg.CacheMaxStale > 0 {
+		cache := newResponseCache(proxy.Transport, name, cfg)
+		cache.metrics = t.b.metrics
+		cache.key = t.shareKey
+		proxy.Transport = cache
+	}
+	if cfg.Coalesce {
+		proxy.Transport = &coalescer{next: proxy.Transport, name: name, maxBody: cfg.CacheMaxBody, metrics: t.b.metrics, key: t.shareKey}
+	}
+	return deadlineHandler(http.StripPrefix(prefix, proxy))
+}
+
+// sameRoute reports whether a and b serve the same path from the same
+// upstream.
+func sameRoute(a, b RouteConfig) bool {
+	return a.Path == b.Path && a.Upstream == b.Upstream
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestRouteConfig(t *testing.T) {
+	t.Run("InheritsBreaker", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", `
+breaker:
+  name: API
+  timeout: 10s
+  consecutive_failures: 7
+routes:
+  - path: /api/users/
+    upstream: "http://users.internal/v1/users"
+    breaker:
+      consecutive_failures: 2
+  - path: /api/orders
+    upstream: "http://orders.internal"
+`)
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(cfg.Routes) != 2 || cfg.Routes[0].Path != "/api/users" {
+			t.Fatalf("expected 2 routes with trailing slashes trimmed, got %+v", cfg.Routes)
+		}
+
+		users, err := cfg.routeBreaker(cfg.Routes[0])
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if users.Name != "API /api/users" || users.ConsecutiveFailures != 2 || users.Timeout != 10*time.Second {
+			t.Fatalf("expected overrides on top of the top-level breaker, got %+v", users)
+		}
+		orders, _ := cfg.routeBreaker(cfg.Routes[1])
+		if orders.Name != "API /api/orders" || orders.ConsecutiveFailures != 7 {
+			t.Fatalf("expected the top-level breaker settings, got %+v", orders)
+		}
+		if cfg.Breaker.ConsecutiveFailures != 7 {
+			t.Fatalf("expected the top-level breaker to be unchanged, got %+v", cfg.Breaker)
+		}
+	})
+
+	for name, contents := range map[string]string{
+		"RelativePath": "routes: [{path: users, upstream: http://users.internal}]",
+		"NoUpstream":   "routes: [{path: /users}]",
+		"BadBreaker":   "routes: [{path: /users, upstream: http://users.internal, breaker: {timeout: soon}}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadConfig(writeConfigFile(t, "config.yaml", contents)); err == nil {
+				t.Fatalf("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestRoutesHaveOwnBreakers(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Breaker.ConsecutiveFailures = 1
+	cfg.Retry.Attempts = 1
+	cfg.Routes = []RouteConfig{
+		{Path: "/api/users", Upstream: "http://users.internal"},
+		{Path: "/api/orders", Upstream: "http://orders.internal"},
+	}
+	routes, err := newRoutes(cfg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "users.internal" {
+			return nil, errors.New("simulated failure")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	defer func(call func(*http.Request) (*http.Response, error)) { callExternalAPI = call }(callExternalAPI)
+
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		h := proxyHandler(cfg.Proxy, r.cfg.Path, r.t, nil)
+		mux.Handle(r.cfg.Path+"/", h)
+	}
+	for _, path := range []string{"/api/users/1", "/api/users/2", "/api/orders/1", "/api/orders/2"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if state := routes[0].t.b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected the failing route's breaker to be open, got %s", state)
+	}
+	if state := routes[1].t.b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the other route's breaker to stay closed, got %s", state)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones are looked up in the binary's own copy of the tz database,
+	// images built from scratch have none
+	_ "time/tzdata"
+)
+
+// Schedule is a cron expression of five fields: minute, hour, day of month,
+// month and day of week, such as "0 2 * * SUN" for 2am every Sunday. Each
+// field is *, a value, a range like 1-5 or a list of them, optionally
+// stepped like */15 or 8-18/2. Months and days of the week may be named by
+// their first three letters, and Sunday is 0 or 7. As with cron, a time
+// matches when either day field does if both are restricted.
+type Schedule string
+
+func (s *Schedule) UnmarshalText(text []byte) error {
+	if _, err := parseSchedule(string(text)); err != nil {
+		return err
+	}
+	*s = Schedule(text)
+	return nil
+}
+
+// cronSchedule is a parsed Schedule, each field a set of the values it
+// matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record whether the day fields are unrestricted.
+	domAny, dowAny bool
+}
+
+// cronField describes the values a field of a Schedule takes.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = [5]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+func parseSchedule(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, fmt.Errorf("schedule %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := cronFields[i].parse(field)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parse returns the set of values expr, one comma-separated field, matches.
+func (f cronField) parse(expr string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, s)
+			}
+			rng, step = r, n
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(from); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(to); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// A stepped value like 5/15 runs to the end of the field
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// value parses a single value of the field, a number or a name.
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+	return v, nil
+}
+
+// matches reports whether the minute of t is one of the schedule's.
+func (s cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := s.dom&(1<<t.Day()) != 0, s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// lastStart returns the latest minute of the schedule that is at most
+// within before t, or false when there is none.
+func (s cronSchedule) lastStart(t time.Time, within time.Duration) (time.Time, bool) {
+	earliest := t.Add(-within)
+	for m := t.Truncate(time.Minute); m.After(earliest); m = m.Add(-time.Minute) {
+		if s.matches(m) {
+			return m, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Timezone is the name of a time zone of the tz database, such as
+// Europe/Berlin, or Local for the system's. Empty means UTC.
+type Timezone string
+
+func (z *Timezone) UnmarshalText(text []byte) error {
+	if _, err := time.LoadLocation(string(text)); err != nil {
+		return fmt.Errorf("unknown time zone %q", text)
+	}
+	*z = Timezone(text)
+	return nil
+}
+
+// location returns the time zone, validated when the config was read.
+func (z Timezone) location() *time.Location {
+	loc, err := time.LoadLocation(string(z))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatalf("invalid time %q: %v", s, err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr    string
+		matches []string
+		misses  []string
+	}{
+		// 2024-06-02 is a Sunday
+		{"0 2 * * SUN", []string{"2024-06-02 02:00", "2024-06-09 02:00"}, []string{"2024-06-02 02:01", "2024-06-03 02:00"}},
+		{"0 2 * * 7", []string{"2024-06-02 02:00"}, []string{"2024-06-01 02:00"}},
+		{"*/15 9-17 * * mon-fri", []string{"2024-06-03 09:00", "2024-06-07 17:45"}, []string{"2024-06-03 09:10", "2024-06-03 18:00", "2024-06-08 10:00"}},
+		{"30 8,20 1 jan,jul *", []string{"2024-01-01 08:30", "2024-07-01 20:30"}, []string{"2024-02-01 08:30", "2024-01-02 08:30"}},
+		// Either day field matches when both are restricted
+		{"0 0 1 * MON", []string{"2024-06-01 00:00", "2024-06-03 00:00"}, []string{"2024-06-04 00:00"}},
+		{"5/20 * * * *", []string{"2024-06-01 00:05", "2024-06-01 00:45"}, []string{"2024-06-01 00:00"}},
+	}
+	for _, tt := range tests {
+		var s Schedule
+		if err := s.UnmarshalText([]byte(tt.expr)); err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.expr, err)
+		}
+		parsed, _ := parseSchedule(string(s))
+		for _, m := range tt.matches {
+			if !parsed.matches(at(m)) {
+				t.Errorf("expected %q to match %s", tt.expr, m)
+			}
+		}
+		for _, m := range tt.misses {
+			if parsed.matches(at(m)) {
+				t.Errorf("expected %q not to match %s", tt.expr, m)
+			}
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * * funday"} {
+		var s Schedule
+		if err := s.UnmarshalText([]byte(expr)); err == nil {
+			t.Errorf("expected %q to be refused", expr)
+		}
+	}
+}
+
+func TestScheduleLastStart(t *testing.T) {
+	schedule, _ := parseSchedule("0 2 * * *")
+	now := time.Date(2024, 6, 2, 3, 30, 0, 0, time.UTC)
+	if start, ok := schedule.lastStart(now, 2*time.Hour); !ok || !start.Equal(time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the window started at 2am, got %v, %v", start, ok)
+	}
+	if _, ok := schedule.lastStart(now, time.Hour); ok {
+		t.Fatalf("expected the hour long window to be over")
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	var z Timezone
+	if err := z.UnmarshalText([]byte("Europe/Berlin")); err != nil {
+		t.Fatalf("failed to parse time zone: %v", err)
+	}
+	if z.location().String() != "Europe/Berlin" {
+		t.Fatalf("expected Europe/Berlin, got %v", z.location())
+	}
+	if err := z.UnmarshalText([]byte("Mars/Olympus_Mons")); err == nil {
+		t.Fatalf("expected an unknown time zone to be refused")
+	}
+	if Timezone("").location() != time.UTC {
+		t.Fatalf("expected UTC by default")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// selfCheckTarget is an upstream probed at startup, named after its
+// breaker.
+type selfCheckTarget struct {
+	name   string
+	target *url.URL
+}
+
+// selfCheck sends the probes of cfg to every upstream at once, bypassing
+// their breakers, and logs and records how each fared. With cfg.Require set
+// it probes at least once and returns an error naming the upstreams no probe
+// got through to.
+func selfCheck(ctx context.Context, cfg SelfCheckConfig, targets []selfCheckTarget) error {
+	probes := cfg.Probes
+	if cfg.Require {
+		probes = max(probes, 1)
+	}
+	if probes == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var unreachable []string
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			passed := 0
+			var lastErr error
+			for i := 0; i < probes; i++ {
+				err := probeUpstream(ctx, cfg, t.target)
+				observeSelfCheck(t.name, err == nil)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				passed++
+			}
+			switch {
+			case passed == probes:
+				slog.Info("Upstream passed the self-check", "breaker", t.name, "upstream", t.target.String(), "probes", probes)
+			case passed > 0:
+				slog.Warn("Upstream failed some self-check probes", "breaker", t.name, "upstream", t.target.String(), "probes", probes, "passed", passed, "error", lastErr)
+			default:
+				slog.Error("Upstream failed the self-check", "breaker", t.name, "upstream", t.target.String(), "probes", probes, "error", lastErr)
+				mu.Lock()
+				unreachable = append(unreachable, t.target.String())
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cfg.Require && len(unreachable) > 0 {
+		return fmt.Errorf("no self-check probe got through to %s", strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+// probeUpstream requests cfg.Path below target. Any answer but a server error
+// within the timeout passes: it shows the URL leads to a live HTTP server.
+func probeUpstream(ctx context.Context, cfg SelfCheckConfig, target *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.JoinPath(cfg.Path).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := callExternalAPI(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSelfCheck(t *testing.T) {
+	up, _ := url.Parse("http://up.invalid/api")
+	down, _ := url.Parse("http://down.invalid/api")
+	broken, _ := url.Parse("http://broken.invalid/api")
+	var mu sync.Mutex
+	var probed []string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		probed = append(probed, req.URL.String())
+		mu.Unlock()
+		switch req.URL.Host {
+		case "down.invalid":
+			return nil, errors.New("simulated failure")
+		case "broken.invalid":
+			return &http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway", Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	targets := []selfCheckTarget{{"Up", up}, {"Down", down}, {"Broken", broken}}
+
+	cfg := SelfCheckConfig{Path: "/health", Timeout: time.Second}
+	if err := selfCheck(context.Background(), cfg, targets); err != nil || len(probed) != 0 {
+		t.Fatalf("expected no probes without a count, got %q, %v", probed, err)
+	}
+
+	cfg.Probes = 2
+	if err := selfCheck(context.Background(), cfg, targets); err != nil {
+		t.Fatalf("expected failures to be reported only, got %v", err)
+	}
+	if len(probed) != 6 || !strings.Contains(strings.Join(probed, " "), "http://up.invalid/api/health") {
+		t.Fatalf("expected two probes of each health endpoint, got %q", probed)
+	}
+
+	cfg.Probes, cfg.Require = 0, true
+	err := selfCheck(context.Background(), cfg, targets)
+	if err == nil || !strings.Contains(err.Error(), "down.invalid") || !strings.Contains(err.Error(), "broken.invalid") || strings.Contains(err.Error(), "up.invalid") {
+		t.Fatalf("expected the unreachable upstreams to be named, got %v", err)
+	}
+	if err := selfCheck(context.Background(), cfg, targets[:1]); err != nil {
+		t.Fatalf("expected a reachable upstream to pass, got %v", err)
+	}
+}
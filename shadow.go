@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// shadowBreaker runs candidate settings alongside a breaker's own without
+// enforcing them. It sees the outcome of every call the breaker lets
+// through and counts those it would have rejected and the times it would
+// have tripped, so operators can compare before applying the settings.
+// Its state is reported under the breaker's name followed by " (shadow)".
+type shadowBreaker struct {
+	b *breaker
+	// wouldReject counts the calls the shadow would have turned away, and
+	// trips the times it opened.
+	wouldReject atomic.Uint64
+	trips       atomic.Uint64
+}
+
+// shadowStatus describes the shadow breaker in /status.
+type shadowStatus struct {
+	State       string        `json:"state"`
+	Counts      breakerCounts `json:"counts"`
+	WouldReject uint64        `json:"would_reject"`
+	Trips       uint64        `json:"trips"`
+	Settings    struct {
+		Trip                TripPolicy `json:"trip"`
+		Timeout             string     `json:"timeout"`
+		ConsecutiveFailures uint32     `json:"consecutive_failures"`
+		FailureRatio        float64    `json:"failure_ratio"`
+	} `json:"settings"`
+}
+
+// configureShadow sets up, reconfigures or removes the shadow of b as
+// described by cfg.
+func configureShadow(b *breaker, cfg Config) error {
+	if !cfg.Shadow.enabled() {
+		b.shadow.Store(nil)
+		return nil
+	}
+	shadowCfg, err := cfg.Shadow.settings(cfg.Breaker)
+	if err != nil {
+		return err
+	}
+	if s := b.shadow.Load(); s != nil {
+		s.b.reload(shadowCfg, cfg.Retry)
+		return nil
+	}
+	s := &shadowBreaker{b: newBreaker(shadowCfg, cfg.Retry)}
+	s.b.onStateChange(func(c stateChange) {
+		if c.From != gobreaker.StateClosed.String() || c.To != gobreaker.StateOpen.String() {
+			return
+		}
+		s.trips.Add(1)
+		name := b.current().cfg.Name
+		observeShadowTrip(name)
+		slog.Info("Shadow breaker would have tripped", "breaker", name, "state", b.current().cb.State().String(),
+			"requests", c.Counts.Requests, "failures", c.Counts.TotalFailures, "consecutive_failures", c.Counts.ConsecutiveFailures)
+	})
+	b.shadow.Store(s)
+	return nil
+}
+
+// enabled reports whether any candidate setting is given.
+func (cfg ShadowConfig) enabled() bool {
+	return len(cfg.Breaker.Content) > 0
+}
+
+// settings returns the shadow settings: base with the candidate settings
+// overlaid.
+func (cfg ShadowConfig) settings(base BreakerConfig) (BreakerConfig, error) {
+	shadow := base
+	if !cfg.enabled() {
+		return shadow, nil
+	}
+	if err := decodeNode(&cfg.Breaker, &shadow); err != nil {
+		return shadow, fmt.Errorf("shadow breaker: %w", err)
+	}
+	shadow.Name = base.Name + " (shadow)"
+	return shadow, nil
+}
+
+// observe feeds the shadow the outcome of a call the breaker let through,
+// err after latency. A nil shadow does nothing.
+func (s *shadowBreaker) observe(name string, err error, latency time.Duration) {
+	if s == nil {
+		return
+	}
+	p := s.b.current()
+	if err == nil && p.cfg.SlowCallThreshold > 0 && latency > p.cfg.SlowCallThreshold {
+		err = errSlowCall
+	}
+	_, rejected := p.cb.Execute(func() (interface{}, error) {
+		if p.tracksCalls() {
+			p.record(isSuccessful(err), latency)
+		}
+		return nil, err
+	})
+	if errors.Is(rejected, gobreaker.ErrOpenState) || errors.Is(rejected, gobreaker.ErrTooManyRequests) {
+		s.wouldReject.Add(1)
+		observeShadowRejection(name)
+	}
+}
+
+// status reports the state of the shadow, nil for a nil shadow.
+func (s *shadowBreaker) status() *shadowStatus {
+	if s == nil {
+		return nil
+	}
+	p := s.b.current()
+	st := &shadowStatus{
+		State:       p.cb.State().String(),
+		Counts:      newBreakerCounts(p.cb.Counts()),
+		WouldReject: s.wouldReject.Load(),
+		Trips:       s.trips.Load(),
+	}
+	st.Settings.Trip = p.cfg.Trip
+	st.Settings.Timeout = p.cfg.Timeout.String()
+	st.Settings.ConsecutiveFailures = p.cfg.ConsecutiveFailures
+	st.Settings.FailureRatio = p.cfg.FailureRatio
+	return st
+}
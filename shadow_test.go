@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestShadowBreaker(t *testing.T) {
+	cfg, err := loadConfig(writeConfigFile(t, "config.yaml", `
+breaker:
+  name: Shadow Test
+  timeout: 1m
+  consecutive_failures: 5
+shadow:
+  breaker:
+    consecutive_failures: 2
+`))
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	b := newBreaker(cfg.Breaker, RetryConfig{Attempts: 1})
+	if err := configureShadow(b, cfg); err != nil {
+		t.Fatalf("failed to set up the shadow: %v", err)
+	}
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	for i := 0; i < 4; i++ {
+		b.execute(b.current(), fail)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the breaker to enforce its own settings, got %v", state)
+	}
+	status := b.status().Shadow
+	if status == nil || status.State != gobreaker.StateOpen.String() || status.Trips != 1 || status.WouldReject != 1 {
+		t.Fatalf("expected the shadow to have tripped past 2 failures and turned the next call away, got %+v", status)
+	}
+	if status.Settings.ConsecutiveFailures != 2 || status.Settings.Timeout != time.Minute.String() {
+		t.Fatalf("expected the candidate settings over the breaker's, got %+v", status.Settings)
+	}
+
+	// The shadow follows reloads and goes away with its settings
+	cfg.Shadow = ShadowConfig{}
+	if err := configureShadow(b, cfg); err != nil || b.status().Shadow != nil {
+		t.Fatalf("expected the shadow to be removed, got %v", err)
+	}
+}
+
+func TestShadowConfigEmpty(t *testing.T) {
+	cfg, err := loadConfig(writeConfigFile(t, "config.yaml", "shadow:\n  breaker: {}\n"))
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	b := newBreaker(cfg.Breaker, cfg.Retry)
+	if err := configureShadow(b, cfg); err != nil || b.shadow.Load() != nil {
+		t.Fatalf("expected no shadow without candidate settings, got %v", err)
+	}
+	if _, err := loadConfig(writeConfigFile(t, "config.yaml", "shadow:\n  breaker:\n    timeout: soon\n")); err == nil {
+		t.Fatalf("expected invalid candidate settings to be refused")
+	}
+}
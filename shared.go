@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// sharedWindow is how long shared counts are kept when the breaker clears
+// its counts only on state changes.
+const sharedWindow = time.Minute
+
+// sharedState makes the replicas of the service act as one circuit per
+// breaker name through Redis. Each replica counts its calls and flushes
+// them every SyncInterval into counts shared for an Interval, which are
+// judged by the consecutive_failures or failure_ratio policy of the
+// breaker. A breaker opening anywhere, by its own calls or the shared ones,
+// sets an open key expiring after Timeout, and every replica rejects calls
+// while it is set. Replicas decide on their own while Redis is unreachable.
+type sharedState struct {
+	client *redisClient
+	prefix string
+	b      *breaker
+
+	mu sync.Mutex
+	// requests and failures are the calls not flushed yet, consecutive the
+	// failures since the last success and succeeded whether there was one.
+	requests, failures, consecutive int64
+	succeeded                       bool
+	// opened and closed are local transitions not shared yet.
+	opened, closed bool
+
+	// openUntil is when the shared open state ends in Unix nanoseconds.
+	openUntil atomic.Int64
+	failing   atomic.Bool
+}
+
+// shareState makes b share its state through client, syncing it until ctx
+// is done.
+func shareState(ctx context.Context, client *redisClient, cfg RedisConfig, b *breaker) {
+	s := &sharedState{client: client, prefix: cfg.KeyPrefix, b: b}
+	b.shared.Store(s)
+	go s.run(ctx, cfg.SyncInterval)
+}
+
+func (s *sharedState) run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			err := s.sync()
+			if err != nil && !s.failing.Swap(true) {
+				slog.Warn("Shared breaker state unavailable, deciding locally", "breaker", s.b.current().cfg.Name, "error", err)
+			} else if err == nil && s.failing.Swap(false) {
+				slog.Info("Shared breaker state available again", "breaker", s.b.current().cfg.Name)
+			}
+		}
+	}
+}
+
+// isOpen reports whether another replica opened the breaker. It is false
+// for a nil sharedState.
+func (s *sharedState) isOpen() bool {
+	return s != nil && clock.Now().UnixNano() < s.openUntil.Load()
+}
+
+// openFor returns how long the shared open state lasts.
+func (s *sharedState) openFor() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return max(time.Unix(0, s.openUntil.Load()).Sub(clock.Now()), 0)
+}
+
+// record counts a call ending with err, ignoring calls the breaker rejected.
+func (s *sharedState) record(err error) {
+	if s == nil || errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if isSuccessful(err) && !errors.Is(err, errSlowCall) {
+		s.consecutive, s.succeeded = 0, true
+		return
+	}
+	s.failures++
+	s.consecutive++
+}
+
+// transition notes a state change of the local breaker to be shared.
+func (s *sharedState) transition(from, to gobreaker.State) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case to == gobreaker.StateOpen:
+		s.opened, s.closed = true, false
+	case from != gobreaker.StateClosed && to == gobreaker.StateClosed:
+		s.opened, s.closed = false, true
+	}
+}
+
+// sync flushes the calls and transitions since the last sync to Redis and
+// reads back the shared state, opening the breaker for every replica when
+// the shared counts call for it.
+func (s *sharedState) sync() error {
+	p := s.b.current()
+	s.mu.Lock()
+	requests, failures, consecutive, succeeded := s.requests, s.failures, s.consecutive, s.succeeded
+	opened, closed := s.opened, s.closed
+	s.requests, s.failures, s.succeeded, s.opened, s.closed = 0, 0, false, false, false
+	s.mu.Unlock()
+
+	window := p.cfg.Interval
+	if window <= 0 {
+		window = sharedWindow
+	}
+	now := clock.Now()
+	key := s.prefix + p.cfg.Name
+	counts := key + ":counts:" + strconv.FormatInt(now.Truncate(window).UnixMilli(), 10)
+	open := key + ":open"
+	timeout := strconv.FormatInt(p.cfg.Timeout.Milliseconds(), 10)
+
+	var cmds [][]string
+	switch {
+	case opened:
+		// Replicas start counting afresh, like the breaker does
+		cmds = append(cmds, []string{"SET", open, "1", "PX", timeout}, []string{"DEL", counts})
+	case closed:
+		cmds = append(cmds, []string{"DEL", open})
+	}
+	if requests > 0 {
+		cmds = append(cmds,
+			[]string{"HINCRBY", counts, "requests", strconv.FormatInt(requests, 10)},
+			[]string{"HINCRBY", counts, "failures", strconv.FormatInt(failures, 10)})
+		if succeeded {
+			cmds = append(cmds, []string{"HSET", counts, "consecutive", strconv.FormatInt(consecutive, 10)})
+		} else {
+			cmds = append(cmds, []string{"HINCRBY", counts, "consecutive", strconv.FormatInt(consecutive, 10)})
+		}
+		cmds = append(cmds, []string{"PEXPIRE", counts, strconv.FormatInt((2 * window).Milliseconds(), 10)})
+	}
+	cmds = append(cmds, []string{"HMGET", counts, "requests", "failures", "consecutive"}, []string{"PTTL", open})
+
+	replies, err := s.client.pipeline(cmds)
+	if err == nil {
+		err = firstError(replies)
+	}
+	if err != nil {
+		// Share the transition on the next sync unless a later one replaced
+		// it; the counts are lost
+		s.mu.Lock()
+		if !s.opened && !s.closed {
+			s.opened, s.closed = opened, closed
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	fields, _ := replies[len(replies)-2].([]interface{})
+	if ttl := replyInt(replies[len(replies)-1]); ttl > 0 {
+		s.setOpenUntil(p, now.Add(time.Duration(ttl)*time.Millisecond))
+		return nil
+	}
+	s.setOpenUntil(p, time.Time{})
+	if len(fields) != 3 || p.cb.State() != gobreaker.StateClosed || override(s.b.override.Load()) != overrideNone {
+		return nil
+	}
+	shared := gobreaker.Counts{
+		Requests:            uint32(replyInt(fields[0])),
+		TotalFailures:       uint32(replyInt(fields[1])),
+		ConsecutiveFailures: uint32(replyInt(fields[2])),
+	}
+	shared.TotalSuccesses = shared.Requests - shared.TotalFailures
+	if !tripPolicy(p.cfg)(shared) {
+		return nil
+	}
+	replies, err = s.client.pipeline([][]string{{"SET", open, "1", "NX", "PX", timeout}, {"DEL", counts}})
+	if err == nil {
+		err = firstError(replies)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Circuit breaker opened by the calls of every replica", "breaker", p.cfg.Name,
+		"requests", shared.Requests, "failures", shared.TotalFailures)
+	s.setOpenUntil(p, now.Add(p.cfg.Timeout))
+	return nil
+}
+
+// setOpenUntil records when the shared open state ends, logging when it
+// starts and ends.
+func (s *sharedState) setOpenUntil(p *breakerPolicy, until time.Time) {
+	var next int64
+	if !until.IsZero() {
+		next = until.UnixNano()
+	}
+	previous := s.openUntil.Swap(next)
+	switch {
+	case previous == 0 && next != 0 && p.cb.State() == gobreaker.StateClosed:
+		slog.Info("Circuit breaker open on another replica", "breaker", p.cfg.Name, "until", until)
+	case previous != 0 && next == 0:
+		slog.Info("Circuit breaker no longer open on any replica", "breaker", p.cfg.Name)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestSharedState(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cfg := RedisConfig{Addr: server.addr(), KeyPrefix: "test:", SyncInterval: time.Hour, Timeout: time.Second}
+	client := newRedisClient(cfg)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Two replicas of the same breaker, each allowing 2 failures in a row
+	replicas := make([]*breaker, 2)
+	for i := range replicas {
+		replicas[i] = newBreaker(BreakerConfig{
+			Name:                "Shared Test",
+			Timeout:             time.Minute,
+			ConsecutiveFailures: 2,
+		}, RetryConfig{Attempts: 1})
+		shareState(ctx, client, cfg, replicas[i])
+	}
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+	sync := func(t *testing.T) {
+		t.Helper()
+		for _, b := range replicas {
+			if err := b.shared.Load().sync(); err != nil {
+				t.Fatalf("failed to sync: %v", err)
+			}
+		}
+	}
+
+	// Neither replica sees enough failures to trip on its own
+	for _, b := range replicas {
+		for i := 0; i < 2; i++ {
+			b.execute(b.current(), fail)
+		}
+		if state := b.current().cb.State(); state != gobreaker.StateClosed {
+			t.Fatalf("expected the replica to stay closed, got %v", state)
+		}
+	}
+	sync(t)
+	sync(t)
+
+	for i, b := range replicas {
+		called := false
+		_, err := b.execute(b.current(), func() (interface{}, error) {
+			called = true
+			return nil, nil
+		})
+		if !errors.Is(err, gobreaker.ErrOpenState) || called {
+			t.Fatalf("expected replica %d to reject calls on the shared failures, got %v", i, err)
+		}
+		if !b.status().SharedOpen || b.reopenDelay() <= 0 {
+			t.Fatalf("expected replica %d to report the shared open state", i)
+		}
+	}
+}
+
+func TestSharedStateLocalTrip(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cfg := RedisConfig{Addr: server.addr(), KeyPrefix: "test:", SyncInterval: time.Hour, Timeout: time.Second}
+	client := newRedisClient(cfg)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tripped := newBreaker(BreakerConfig{Name: "Local Trip Test", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{Attempts: 1})
+	peer := newBreaker(BreakerConfig{Name: "Local Trip Test", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{Attempts: 1})
+	shareState(ctx, client, cfg, tripped)
+	shareState(ctx, client, cfg, peer)
+
+	for i := 0; i < 2; i++ {
+		tripped.execute(tripped.current(), func() (interface{}, error) { return nil, errors.New("simulated failure") })
+	}
+	if err := tripped.shared.Load().sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if peer.shared.Load().isOpen() {
+		t.Fatalf("expected the peer not to know before syncing")
+	}
+	if err := peer.shared.Load().sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if _, err := peer.execute(peer.current(), func() (interface{}, error) { return nil, nil }); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected the peer to reject calls once a replica tripped, got %v", err)
+	}
+}
+
+func TestSharedStateUnavailable(t *testing.T) {
+	cfg := RedisConfig{Addr: "127.0.0.1:1", KeyPrefix: "test:", SyncInterval: time.Hour, Timeout: 100 * time.Millisecond}
+	client := newRedisClient(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := newBreaker(BreakerConfig{Name: "Unavailable Test", Timeout: time.Minute, ConsecutiveFailures: 1}, RetryConfig{Attempts: 1})
+	shareState(ctx, client, cfg, b)
+
+	if err := b.shared.Load().sync(); err == nil {
+		t.Fatalf("expected syncing to fail without a server")
+	}
+	if _, err := b.execute(b.current(), func() (interface{}, error) { return "ok", nil }); err != nil {
+		t.Fatalf("expected the breaker to decide locally, got %v", err)
+	}
+}
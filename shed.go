@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Runtime metrics sampled by the load shedder. The runtime's CPU metrics are
+// only brought up to date by garbage collections, so CPU use is measured
+// from the process's CPU time instead.
+const (
+	heapMetric       = "/memory/classes/heap/objects:bytes"
+	goroutinesMetric = "/sched/goroutines:goroutines"
+)
+
+// loadShedder turns requests away while the process itself is under
+// pressure, before they take up more CPU, memory or goroutines. It samples
+// the process every Interval and sheds while any sample is above its
+// limit.
+type loadShedder struct {
+	cfg  ShedConfig
+	name string
+	// resource is the resource found above its limit by the latest sample,
+	// or empty.
+	resource atomic.Value
+
+	samples []metrics.Sample
+	// cpu and sampled are the CPU time used by the process and the time as
+	// of the previous sample.
+	cpu     time.Duration
+	sampled time.Time
+}
+
+// resourceUsage is one sample of the process's resource use. CPU is the
+// share of the CPU available to Go, GOMAXPROCS CPUs, used since the
+// previous sample.
+type resourceUsage struct {
+	CPU        float64
+	Memory     uint64
+	Goroutines uint64
+}
+
+func newLoadShedder(cfg ShedConfig, name string) *loadShedder {
+	s := &loadShedder{cfg: cfg, name: name, samples: []metrics.Sample{
+		{Name: heapMetric},
+		{Name: goroutinesMetric},
+	}}
+	s.resource.Store("")
+	return s
+}
+
+// run samples the runtime every Interval until ctx is done.
+func (s *loadShedder) run(ctx context.Context) {
+	ticker := clock.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	s.sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s.resource.Store(s.cfg.overLimit(s.sample()))
+		}
+	}
+}
+
+// sample reads the current resource use of the process and the runtime.
+func (s *loadShedder) sample() resourceUsage {
+	var usage resourceUsage
+	now, cpu := clock.Now(), cpuTime()
+	if elapsed := now.Sub(s.sampled); !s.sampled.IsZero() && elapsed > 0 {
+		usage.CPU = float64(cpu-s.cpu) / (float64(elapsed) * float64(runtime.GOMAXPROCS(0)))
+	}
+	s.cpu, s.sampled = cpu, now
+	metrics.Read(s.samples)
+	usage.Memory = s.samples[0].Value.Uint64()
+	usage.Goroutines = s.samples[1].Value.Uint64()
+	return usage
+}
+
+// cpuTime returns the CPU time the process used so far, in user and system
+// mode, or zero when it cannot be told.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// overLimit returns the first resource of u above its limit in cfg, or an
+// empty string.
+func (cfg ShedConfig) overLimit(u resourceUsage) string {
+	switch {
+	case cfg.MaxCPU > 0 && u.CPU > cfg.MaxCPU:
+		return "cpu"
+	case cfg.MaxMemory > 0 && u.Memory > cfg.MaxMemory:
+		return "memory"
+	case cfg.MaxGoroutines > 0 && u.Goroutines > cfg.MaxGoroutines:
+		return "goroutines"
+	}
+	return ""
+}
+
+// handler answers requests with 503 and an X-Shed-Reason header naming the
+// resource under pressure instead of passing them to next while shedding.
+func (s *loadShedder) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if resource := s.resource.Load().(string); resource != "" {
+			observeShed(s.name, resource)
+			w.Header().Set("X-Shed-Reason", resource)
+			http.Error(w, "Service overloaded ("+resource+")", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
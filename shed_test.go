@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShedOverLimit(t *testing.T) {
+	cfg := ShedConfig{MaxCPU: 0.9, MaxMemory: 1 << 30, MaxGoroutines: 1000}
+	tests := []struct {
+		usage resourceUsage
+		want  string
+	}{
+		{resourceUsage{CPU: 0.5, Memory: 1 << 20, Goroutines: 10}, ""},
+		{resourceUsage{CPU: 0.95, Memory: 1 << 20, Goroutines: 10}, "cpu"},
+		{resourceUsage{CPU: 0.5, Memory: 2 << 30, Goroutines: 10}, "memory"},
+		{resourceUsage{CPU: 0.5, Memory: 1 << 20, Goroutines: 5000}, "goroutines"},
+	}
+	for _, tt := range tests {
+		if got := cfg.overLimit(tt.usage); got != tt.want {
+			t.Errorf("overLimit(%+v) = %q, want %q", tt.usage, got, tt.want)
+		}
+	}
+	if got := (ShedConfig{}).overLimit(resourceUsage{CPU: 1, Memory: 1 << 40, Goroutines: 1 << 20}); got != "" {
+		t.Errorf("expected zero limits not to be enforced, got %q", got)
+	}
+}
+
+func TestShedHandler(t *testing.T) {
+	s := newLoadShedder(ShedConfig{MaxGoroutines: 1}, "Shed Test")
+	if usage := s.sample(); usage.Goroutines < 1 {
+		t.Fatalf("expected to sample the goroutines, got %+v", usage)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	s.handler(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected requests to pass before pressure is found, got %d", rec.Code)
+	}
+
+	s.resource.Store(s.cfg.overLimit(s.sample()))
+	rec = httptest.NewRecorder()
+	s.handler(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("X-Shed-Reason") != "goroutines" {
+		t.Fatalf("expected a 503 shed for goroutines, got %d %q", rec.Code, rec.Header().Get("X-Shed-Reason"))
+	}
+}
+
+func TestShedSamplesCPU(t *testing.T) {
+	s := newLoadShedder(ShedConfig{MaxCPU: 0.5}, "Shed CPU Test")
+	s.sample()
+	// Keep a CPU busy with no garbage collection in between
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+	}
+	if usage := s.sample(); usage.CPU <= 0 {
+		t.Fatalf("expected the CPU use since the previous sample, got %+v", usage)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// simulation is the outcome of playing the mock upstream's script against
+// a breaker.
+type simulation struct {
+	Requests  int
+	Succeeded int
+	Failed    int
+	Rejected  int
+	Trips     int
+	State     string
+}
+
+// runSimulate plays the script of the mock_upstream config section against
+// a breaker set up as serve would, printing what happens to every request
+// and every state change, without listening for anything.
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to a YAML or JSON config file")
+	requests := fs.Int("requests", 50, "number of requests to send")
+	interval := fs.Duration("interval", 100*time.Millisecond, "pause between requests, for the breaker's timeout to pass")
+	settings := registerSettingFlags(fs, defaultConfig())
+	fs.Parse(args)
+
+	cfg, err := offlineConfig(*configPath, settings)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Logs go to stderr, keeping stdout for the report
+	slog.SetDefault(newLogger(cfg.Log, os.Stderr))
+	if cfg.Retry.JitterSeed != 0 {
+		jitter = newJitterSource(cfg.Retry.JitterSeed)
+	}
+	if _, err := simulate(cfg, *requests, *interval, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// simulate sends n requests, interval apart, through a breaker configured
+// by cfg to a mock upstream playing cfg.MockUpstream, reporting each to w.
+func simulate(cfg Config, n int, interval time.Duration, w io.Writer) (simulation, error) {
+	mock, err := startMockUpstream(cfg.MockUpstream)
+	if err != nil {
+		return simulation{}, fmt.Errorf("starting mock upstream: %w", err)
+	}
+	defer mock.close()
+	target, err := parseUpstream(mock.url)
+	if err != nil {
+		return simulation{}, err
+	}
+	cfg.Upstream = mock.url
+	transport, err := newUpstreamTransport(cfg)
+	if err != nil {
+		return simulation{}, err
+	}
+	defer transport.CloseIdleConnections()
+	callExternalAPI = transport.RoundTrip
+	retries.configure(cfg.Retry)
+
+	var (
+		mu     sync.Mutex
+		report simulation
+	)
+	b := newBreaker(cfg.Breaker, cfg.Retry)
+	b.onStateChange(func(c stateChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		if c.To == "open" {
+			report.Trips++
+		}
+		fmt.Fprintf(w, "      breaker %s -> %s\n", c.From, c.To)
+	})
+	t := &breakerTransport{b: b, target: target}
+
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			clock.Sleep(interval)
+		}
+		req, err := http.NewRequest(http.MethodGet, mock.url+"/api", nil)
+		if err != nil {
+			return report, err
+		}
+		state := b.current().cb.State().String()
+		start := clock.Now()
+		resp, err := t.RoundTrip(req)
+		took := clock.Now().Sub(start).Round(time.Millisecond)
+
+		result, ok, rejected := callOutcome(resp, err)
+		mu.Lock()
+		switch {
+		case ok:
+			report.Succeeded++
+		case rejected:
+			report.Rejected++
+		default:
+			report.Failed++
+		}
+		fmt.Fprintf(w, "%4d  %-9s  %-40s  %v\n", i, state, result, took)
+		mu.Unlock()
+	}
+
+	report.Requests = n
+	report.State = b.current().cb.State().String()
+	fmt.Fprintf(w, "\n%d requests: %d succeeded, %d failed, %d rejected by the breaker, which tripped %d times and ended %s\n",
+		report.Requests, report.Succeeded, report.Failed, report.Rejected, report.Trips, report.State)
+	return report, nil
+}
+
+// callOutcome describes the outcome of a call through the breaker, closing
+// the response body, and whether it succeeded or the breaker rejected it.
+// Error statuses count as failures, as they do for the breaker.
+func callOutcome(resp *http.Response, err error) (result string, ok, rejected bool) {
+	switch {
+	case err == nil:
+		resp.Body.Close()
+		return resp.Status, resp.StatusCode < http.StatusInternalServerError, false
+	case isRejection(err):
+		return "rejected: " + fallbackReason(err), false, true
+	default:
+		return "failed: " + err.Error(), false, false
+	}
+}
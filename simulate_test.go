@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	defer func(call func(*http.Request) (*http.Response, error)) { callExternalAPI = call }(callExternalAPI)
+	cfg := defaultConfig()
+	cfg.MockUpstream = MockUpstreamConfig{Steps: []MockStep{
+		{Requests: 3, Status: http.StatusInternalServerError},
+		{},
+	}}
+	cfg.Breaker.Trip = TripConsecutiveFailures
+	cfg.Breaker.ConsecutiveFailures = 2
+	cfg.Breaker.Timeout = 50 * time.Millisecond
+	cfg.Retry.Attempts = 1
+
+	var out strings.Builder
+	report, err := simulate(cfg, 12, 20*time.Millisecond, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Trips < 1 || report.Rejected == 0 || report.Failed != 3 || report.Succeeded == 0 {
+		t.Fatalf("expected the breaker to trip on the failures then recover, got %+v\n%s", report, out.String())
+	}
+	if report.Succeeded+report.Failed+report.Rejected != 12 || report.State != "closed" {
+		t.Fatalf("expected every request accounted for and the breaker closed, got %+v", report)
+	}
+	if !strings.Contains(out.String(), "breaker closed -> open") {
+		t.Fatalf("expected the state changes in the report, got\n%s", out.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// burnRate measures how fast the error budget of an SLO is spent over a
+// long and a short window, as multi-window burn-rate alerts do.
+type burnRate struct {
+	long, short *rollingWindow
+}
+
+// newBurnRate returns the windows configured by cfg, each at least a
+// second long.
+func newBurnRate(cfg BreakerConfig) *burnRate {
+	return &burnRate{
+		long:  newRollingWindow(max(cfg.BurnLongWindow, time.Second)),
+		short: newRollingWindow(max(cfg.BurnShortWindow, time.Second)),
+	}
+}
+
+func (r *burnRate) record(success bool) {
+	r.long.record(success, 0)
+	r.short.record(success, 0)
+}
+
+func (r *burnRate) stats() (long, short windowStats) {
+	return r.long.stats(), r.short.stats()
+}
+
+func (r *burnRate) reset() {
+	r.long.reset()
+	r.short.reset()
+}
+
+// burnRateTrip decides whether the error budget left by the SLO target
+// burns more than BurnRate times faster than sustainable over both the long
+// and the short window, once the short window saw MinRequests calls. The
+// long window keeps a short spike from tripping the breaker, and the short
+// one lets it close again soon after the errors stop.
+func burnRateTrip(cfg BreakerConfig, long, short windowStats) bool {
+	budget := 1 - cfg.SLOTarget
+	if budget <= 0 || short.Successes+short.Failures < cfg.MinRequests {
+		return false
+	}
+	return errorRate(long)/budget > cfg.BurnRate && errorRate(short)/budget > cfg.BurnRate
+}
+
+// errorRate returns the share of failed calls in s.
+func errorRate(s windowStats) float64 {
+	n := s.Successes + s.Failures
+	if n == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(n)
+}
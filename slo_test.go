@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestBurnRateTrip(t *testing.T) {
+	// A 99% target leaves a 1% budget, burnt 10 times too fast at 10% errors
+	cfg := BreakerConfig{SLOTarget: 0.99, BurnRate: 10, MinRequests: 10}
+	for _, tc := range []struct {
+		name        string
+		long, short windowStats
+		trip        bool
+	}{
+		{"TooFewCalls", windowStats{Failures: 9}, windowStats{Failures: 9}, false},
+		{"Both", windowStats{Successes: 880, Failures: 120}, windowStats{Successes: 80, Failures: 20}, true},
+		{"ShortOnly", windowStats{Successes: 950, Failures: 50}, windowStats{Successes: 50, Failures: 50}, false},
+		{"LongOnly", windowStats{Successes: 800, Failures: 200}, windowStats{Successes: 100}, false},
+		{"AtRate", windowStats{Successes: 900, Failures: 100}, windowStats{Successes: 90, Failures: 10}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := burnRateTrip(cfg, tc.long, tc.short); got != tc.trip {
+				t.Fatalf("expected trip %v, got %v", tc.trip, got)
+			}
+		})
+	}
+}
+
+func TestBurnRateBreaker(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:            "Burn Rate Test",
+		Timeout:         time.Minute,
+		Trip:            TripBurnRate,
+		MinRequests:     10,
+		SLOTarget:       0.99,
+		BurnRate:        10,
+		BurnLongWindow:  time.Hour,
+		BurnShortWindow: time.Minute,
+	}, RetryConfig{})
+	succeed := func() (interface{}, error) { return nil, nil }
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	for i := 0; i < 100; i++ {
+		b.execute(b.current(), succeed)
+	}
+	for i := 0; i < 5; i++ {
+		b.execute(b.current(), fail)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected a 5%% error rate to stay within a 10x burn rate, got %s", state)
+	}
+	for i := 0; i < 10; i++ {
+		b.execute(b.current(), fail)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected a 13%% error rate to trip the breaker, got %s", state)
+	}
+}
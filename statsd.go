@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsSink is a destination for metrics other than Prometheus.
+// Implementations must be safe for concurrent use and must not block.
+type metricsSink interface {
+	// Count adds delta to the counter called name.
+	Count(name string, delta int64, tags ...string)
+	// Gauge sets the gauge called name to value.
+	Gauge(name string, value float64, tags ...string)
+	// Timing records a duration sample for the timer called name.
+	Timing(name string, d time.Duration, tags ...string)
+}
+
+// sink receives the breaker state, attempt and latency metrics. It discards
+// them unless a sink is configured.
+var sink metricsSink = nopSink{}
+
+// nopSink discards every metric.
+type nopSink struct{}
+
+func (nopSink) Count(string, int64, ...string)          {}
+func (nopSink) Gauge(string, float64, ...string)        {}
+func (nopSink) Timing(string, time.Duration, ...string) {}
+
+// statsdSink sends metrics over UDP in the StatsD line format, with tags in
+// the DogStatsD "|#key:value" extension understood by the Datadog agent.
+type statsdSink struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+func newStatsdSink(addr, prefix string, tags []string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{conn: conn, prefix: prefix, tags: tags}, nil
+}
+
+func (s *statsdSink) Count(name string, delta int64, tags ...string) {
+	s.send(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+func (s *statsdSink) Gauge(name string, value float64, tags ...string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (s *statsdSink) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// send writes a single metric datagram. Delivery is best effort, so write
+// errors are ignored.
+func (s *statsdSink) send(name, value, kind string, tags []string) {
+	line := fmt.Sprintf("%s%s:%s|%s", s.prefix, name, value, kind)
+	if all := append(append([]string{}, s.tags...), tags...); len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	s.conn.Write([]byte(line))
+}
+
+func (s *statsdSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdSink(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer agent.Close()
+
+	s, err := newStatsdSink(agent.LocalAddr().String(), "cb.", []string{"env:test"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	read := func(t *testing.T) string {
+		t.Helper()
+		buf := make([]byte, 512)
+		agent.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to read datagram: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	for _, tc := range []struct {
+		name string
+		emit func()
+		want string
+	}{
+		{"Count", func() { s.Count("attempts", 1, "outcome:success") }, "cb.attempts:1|c|#env:test,outcome:success"},
+		{"Gauge", func() { s.Gauge("state", 2) }, "cb.state:2|g|#env:test"},
+		{"Timing", func() { s.Timing("upstream.latency", 1500*time.Microsecond) }, "cb.upstream.latency:1.5|ms|#env:test"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.emit()
+			if got := read(t); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sony/gobreaker"
+)
+
+// breakerStatus is the JSON document served by /status.
+type breakerStatus struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Override string `json:"override"`
+	// DryRun is whether the breaker lets through the calls it would reject.
+	DryRun bool `json:"dry_run,omitempty"`
+	// SharedOpen is whether another replica opened the breaker.
+	SharedOpen bool `json:"shared_open,omitempty"`
+	// PeerAction is the response to a peer's trip in effect, if any.
+	PeerAction PeerAction `json:"peer_action,omitempty"`
+	// MaintenanceWindow is the action of the maintenance window the
+	// breaker is in, if any.
+	MaintenanceWindow   WindowAction  `json:"maintenance_window,omitempty"`
+	Counts              breakerCounts `json:"counts"`
+	SinceLastTransition string        `json:"since_last_transition"`
+	// Window holds the calls of the last Window, when it is set.
+	Window *windowStatus `json:"window,omitempty"`
+	// Shadow describes the shadow breaker, when there is one.
+	Shadow   *shadowStatus `json:"shadow,omitempty"`
+	Settings struct {
+		MaxRequests         uint32 `json:"max_requests"`
+		Interval            string `json:"interval"`
+		Timeout             string `json:"timeout"`
+		ConsecutiveFailures uint32 `json:"consecutive_failures"`
+	} `json:"settings"`
+}
+
+// windowStatus describes the calls of a breaker's rolling window.
+type windowStatus struct {
+	windowStats
+	FailureRatio float64 `json:"failure_ratio"`
+	MeanLatency  string  `json:"mean_latency"`
+}
+
+// breakerCounts is the JSON form of gobreaker.Counts.
+type breakerCounts struct {
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+}
+
+func newBreakerCounts(c gobreaker.Counts) breakerCounts {
+	return breakerCounts{
+		Requests:             c.Requests,
+		TotalSuccesses:       c.TotalSuccesses,
+		TotalFailures:        c.TotalFailures,
+		ConsecutiveSuccesses: c.ConsecutiveSuccesses,
+		ConsecutiveFailures:  c.ConsecutiveFailures,
+	}
+}
+
+// status reports the current state of b.
+func (b *breaker) status() breakerStatus {
+	p := b.current()
+
+	var s breakerStatus
+	s.Name = p.cfg.Name
+	s.State = p.cb.State().String()
+	s.Override = override(b.override.Load()).String()
+	s.DryRun = p.cfg.DryRun
+	s.SharedOpen = b.shared.Load().isOpen()
+	if r := b.peer.Load(); r.opens() || r.tightens() {
+		s.PeerAction = r.action
+	}
+	if w := b.window.Load(); w != nil {
+		s.MaintenanceWindow = w.action
+	}
+	s.Counts = newBreakerCounts(p.cb.Counts())
+	s.SinceLastTransition = b.sinceTransition().String()
+	s.Shadow = b.shadow.Load().status()
+	if p.window != nil {
+		stats := p.window.stats()
+		s.Window = &windowStatus{windowStats: stats, MeanLatency: stats.meanLatency().String()}
+		if n := stats.Successes + stats.Failures; n > 0 {
+			s.Window.FailureRatio = float64(stats.Failures) / float64(n)
+		}
+	}
+	s.Settings.MaxRequests = p.cfg.MaxRequests
+	s.Settings.Interval = p.cfg.Interval.String()
+	s.Settings.Timeout = p.cfg.Timeout.String()
+	s.Settings.ConsecutiveFailures = p.cfg.ConsecutiveFailures
+	return s
+}
+
+// statusHandler serves the state of b as JSON.
+func statusHandler(b *breaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b.status())
+	}
+}
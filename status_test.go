@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusHandler(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:                "Status Test",
+		MaxRequests:         2,
+		Interval:            time.Minute,
+		Timeout:             30 * time.Second,
+		ConsecutiveFailures: 1,
+	}, RetryConfig{Attempts: 1})
+
+	b.execute(b.current(), func() (interface{}, error) { return "ok", nil })
+	b.execute(b.current(), func() (interface{}, error) {
+		return nil, errors.New("simulated failure")
+	})
+
+	rec := httptest.NewRecorder()
+	statusHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON content type, got %q", ct)
+	}
+
+	var status breakerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+
+	if status.Name != "Status Test" {
+		t.Fatalf("expected name %q, got %q", "Status Test", status.Name)
+	}
+	if status.State != "closed" {
+		t.Fatalf("expected state closed, got %q", status.State)
+	}
+	if status.Override != "none" {
+		t.Fatalf("expected no override, got %q", status.Override)
+	}
+	if status.Counts.Requests != 2 || status.Counts.TotalFailures != 1 || status.Counts.ConsecutiveFailures != 1 {
+		t.Fatalf("expected 2 requests with 1 failure, got %+v", status.Counts)
+	}
+	if _, err := time.ParseDuration(status.SinceLastTransition); err != nil {
+		t.Fatalf("expected a duration since last transition, got %q", status.SinceLastTransition)
+	}
+	if status.Settings.MaxRequests != 2 || status.Settings.Timeout != "30s" || status.Settings.ConsecutiveFailures != 1 {
+		t.Fatalf("expected configured settings, got %+v", status.Settings)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+)
+
+// tenant identifies the tenant whose calls a transport carries.
+type tenant struct {
+	id string
+	// breaker is the name of the breaker the tenant's stands in for, which
+	// labels the tenant's metrics, and metrics whether that breaker records
+	// them. The tenant's own breakers record none, keeping tenants out of
+	// the breaker label of every metric.
+	breaker string
+	metrics bool
+}
+
+// tenants holds the transports of each tenant calling through base, created
+// on their first call.
+type tenants struct {
+	cfg  TenantConfig
+	base *breakerTransport
+	// onNew is called with every breaker created for a tenant.
+	onNew func(*breaker)
+
+	mu   sync.Mutex
+	byID map[string]*breakerTransport
+}
+
+// splitTenants makes t route the calls of each tenant identified by
+// cfg.Header through breakers of the tenant's own, calling onNew with each
+// of them once created.
+func (t *breakerTransport) splitTenants(cfg TenantConfig, onNew func(*breaker)) {
+	t.tenants = &tenants{cfg: cfg, base: t, onNew: onNew, byID: make(map[string]*breakerTransport)}
+}
+
+// maxTenants bounds the tenants given breakers of their own, whose breakers
+// are never dropped and whose IDs label metrics.
+const maxTenants = 10000
+
+// tenantID returns the tenant req is made for, if it names one. Tenants
+// identified by their API key are found by the key the proxy took off, and
+// always by a digest of it.
+func (ts *tenants) tenantID(req *http.Request) (string, bool) {
+	id := req.Header.Get(ts.cfg.Header)
+	key, ok := requestAPIKey(req.Context())
+	byKey := ok && http.CanonicalHeaderKey(key.header) == http.CanonicalHeaderKey(ts.cfg.Header)
+	if byKey && id == "" {
+		id = key.value
+	}
+	if id == "" {
+		return "", false
+	}
+	if ts.cfg.Hash || byKey {
+		sum := sha256.Sum256([]byte(id))
+		id = hex.EncodeToString(sum[:8])
+	}
+	return id, true
+}
+
+// transport returns the transport of the tenant id, creating it unless
+// MaxTenants tenants already have one, in which case it returns nil.
+func (ts *tenants) transport(id string) *breakerTransport {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if t, ok := ts.byID[id]; ok {
+		return t
+	}
+	if len(ts.byID) >= min(ts.cfg.MaxTenants, maxTenants) {
+		return nil
+	}
+
+	base := ts.base
+	p := base.b.current()
+	cfg := tenantBreaker(p.cfg, id)
+	t := &breakerTransport{
+		b:        buildBreaker(cfg.Name, withConfig(cfg), withRetry(p.retry), withMetrics(false)),
+		target:   base.target,
+		balance:  base.balance,
+		outliers: base.outliers,
+		health:   base.health,
+		tenant:   &tenant{id: id, breaker: p.cfg.Name, metrics: base.b.metrics},
+	}
+	for _, u := range base.backups {
+		backup := upstreamBreaker(cfg, u.target)
+		t.backups = append(t.backups, &upstream{
+			target: u.target,
+			b:      buildBreaker(backup.Name, withConfig(backup), withRetry(p.retry), withMetrics(false)),
+		})
+	}
+	if base.writes != nil {
+		t.splitWrites(p.retry)
+	}
+	ts.byID[id] = t
+
+	for _, tt := range []*breakerTransport{t, t.writes} {
+		if tt == nil {
+			continue
+		}
+		tenant := *tt.tenant
+		if tenant.metrics {
+			tt.b.onStateChange(func(change stateChange) {
+				observeTenantState(tenant.breaker, tenant.id, change.To)
+			})
+		}
+		ts.onNew(tt.b)
+		for _, u := range tt.backups {
+			ts.onNew(u.b)
+		}
+	}
+	return t
+}
+
+// reloadTenants applies the settings of t's breakers to those of every
+// tenant calling through t, if calls are split by tenant.
+func (t *breakerTransport) reloadTenants() {
+	ts := t.tenants
+	if ts == nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	p := ts.base.b.current()
+	for id, t := range ts.byID {
+		cfg := tenantBreaker(p.cfg, id)
+		t.b.reload(cfg, p.retry)
+		for _, u := range t.backups {
+			u.b.reload(upstreamBreaker(cfg, u.target), p.retry)
+		}
+		t.reloadWrites(cfg, p.retry)
+	}
+}
+
+// tenantBreaker returns the settings of a tenant's breaker standing in for
+// one with the settings cfg.
+func tenantBreaker(cfg BreakerConfig, id string) BreakerConfig {
+	cfg.Name += " tenant " + id
+	return cfg
+}
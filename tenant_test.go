@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sony/gobreaker"
+)
+
+func TestTenantBreakers(t *testing.T) {
+	target, _ := url.Parse("http://upstream.invalid/api")
+	cfg := BreakerConfig{Name: "Tenant Test", Timeout: time.Minute, ConsecutiveFailures: 1, RejectStatus: http.StatusTooManyRequests}
+	transport := &breakerTransport{b: newBreaker(cfg, RetryConfig{Attempts: 1}), target: target}
+	var created []*breaker
+	transport.splitTenants(TenantConfig{Header: "X-API-Key", MaxTenants: 2}, func(b *breaker) {
+		created = append(created, b)
+	})
+
+	// The noisy tenant's calls fail while the others' succeed
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("X-API-Key") == "noisy" {
+			return nil, errors.New("simulated failure")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	api := http.StripPrefix("/api", newProxy(transport, nil))
+	call := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for range 3 {
+		call("noisy")
+	}
+
+	if code := call("noisy"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the noisy tenant to be rejected, got status %d", code)
+	}
+	if code := call("quiet"); code != http.StatusOK {
+		t.Fatalf("expected other tenants to go through, got status %d", code)
+	}
+	if code := call(""); code != http.StatusOK {
+		t.Fatalf("expected calls without a tenant to go through, got status %d", code)
+	}
+	if state := transport.b.current().cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("expected the shared breaker to stay closed, got %s", state)
+	}
+
+	if len(created) != 2 || created[0].current().cfg.Name != "Tenant Test tenant noisy" {
+		t.Fatalf("expected a breaker per tenant, got %d", len(created))
+	}
+	if created[0].metrics {
+		t.Fatalf("expected tenants' breakers to leave the metrics to the tenant series")
+	}
+	if got := testutil.ToFloat64(tenantBreakerState.WithLabelValues("Tenant Test", "noisy")); got != 2 {
+		t.Fatalf("expected the noisy tenant's state to be open in metrics, got %v", got)
+	}
+	if got := testutil.ToFloat64(tenantRequestCount.WithLabelValues("Tenant Test", "quiet", "success")); got != 1 {
+		t.Fatalf("expected 1 successful request of the quiet tenant, got %v", got)
+	}
+
+	// Tenants beyond MaxTenants share the breaker of calls without one
+	if code := call("third"); code != http.StatusOK || len(created) != 2 {
+		t.Fatalf("expected no breaker for a tenant beyond the limit, got status %d and %d breakers", code, len(created))
+	}
+
+	cfg.Timeout = 2 * time.Minute
+	transport.b.reload(cfg, RetryConfig{Attempts: 1})
+	transport.reloadTenants()
+	if got := created[1].current().cfg.Timeout; got != 2*time.Minute {
+		t.Fatalf("expected tenant breakers to be reloaded, got timeout %s", got)
+	}
+}
+
+func TestTenantID(t *testing.T) {
+	ts := &tenants{cfg: TenantConfig{Header: "X-API-Key", Hash: true}}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := ts.tenantID(req); ok {
+		t.Fatalf("expected no tenant without the header")
+	}
+	req.Header.Set("X-API-Key", "secret")
+	id, ok := ts.tenantID(req)
+	if !ok || id == "secret" || len(id) != 16 {
+		t.Fatalf("expected a digest of the key, got %q", id)
+	}
+	if !defaultConfig().Tenant.Hash {
+		t.Fatalf("expected tenants to be hashed by default")
+	}
+
+	// Tenants identified by the API key are hashed regardless, the key
+	// having been taken off the request forwarded upstream
+	ts.cfg.Hash = false
+	req = req.WithContext(context.WithValue(req.Context(), apiKeyKey{}, apiKey{header: "x-api-key", value: "secret"}))
+	req.Header.Del("X-API-Key")
+	if keyed, ok := ts.tenantID(req); !ok || keyed != id {
+		t.Fatalf("expected the digest of the API key, got %q", keyed)
+	}
+	ts.cfg.Header = "X-Tenant"
+	req.Header.Set("X-Tenant", "acme")
+	if named, ok := ts.tenantID(req); !ok || named != "acme" {
+		t.Fatalf("expected other headers to name tenants as is, got %q", named)
+	}
+}
+
+func TestTenantResponsesNotShared(t *testing.T) {
+	target, _ := url.Parse("http://upstream.invalid/api")
+	cfg := BreakerConfig{Name: "Tenant Cache Test", Timeout: time.Minute}
+	transport := &breakerTransport{b: newBreaker(cfg, RetryConfig{Attempts: 1}), target: target}
+	transport.splitTenants(TenantConfig{Header: "X-Tenant", Hash: true, MaxTenants: 10}, func(*breaker) {})
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		body := io.NopCloser(strings.NewReader("orders of " + req.Header.Get("X-Tenant")))
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}, nil
+	}
+	api := proxyHandler(ProxyConfig{Cache: true, Coalesce: true, CacheTTL: time.Minute, CacheMaxBody: 1024, CacheMaxBytes: 1 << 20}, "/api", transport, nil)
+
+	for _, tenant := range []string{"a", "b", "a"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if body := rec.Body.String(); body != "orders of "+tenant {
+			t.Fatalf("expected tenant %s to get its own response, got %q", tenant, body)
+		}
+	}
+}
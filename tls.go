@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSVersion is a TLS protocol version written as "1.0" to "1.3".
+type TLSVersion uint16
+
+var tlsVersions = map[string]TLSVersion{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (v *TLSVersion) UnmarshalText(text []byte) error {
+	version, ok := tlsVersions[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q", text)
+	}
+	*v = version
+	return nil
+}
+
+// serverConfig returns the TLS settings of the server described by cfg.
+func (cfg TLSConfig) serverConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := cipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   uint16(cfg.MinVersion),
+		CipherSuites: suites,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// cipherSuites returns the IDs of the secure cipher suites with the given
+// names, or nil to use Go's defaults when names is empty.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// clientConfig returns the TLS settings of upstream calls described by cfg,
+// or nil to use Go's defaults when nothing is configured.
+func (cfg UpstreamTLSConfig) clientConfig() (*tls.Config, error) {
+	if cfg == (UpstreamTLSConfig{}) {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: cfg.ServerName}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + cfg.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
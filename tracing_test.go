@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	// Fail the first attempt, then reach the mock server
+	calls := 0
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("simulated failure")
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	b := newBreaker(BreakerConfig{
+		Name:                "Tracing Test",
+		Timeout:             time.Minute,
+		ConsecutiveFailures: 3,
+	}, RetryConfig{Attempts: 2, BackoffMin: time.Millisecond, BackoffMax: time.Millisecond})
+	api := traceHandler(http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil)))
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	spans := recorder.Ended()
+	byName := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range spans {
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+
+	if n := len(byName["GET /api"]); n != 1 {
+		t.Fatalf("expected 1 server span, got %d", n)
+	}
+	serverSpan := byName["GET /api"][0]
+
+	attempts := byName["upstream attempt"]
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempt spans, got %d", len(attempts))
+	}
+	for i, span := range attempts {
+		if span.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+			t.Fatalf("expected attempt span to be a child of the server span")
+		}
+		if !hasAttribute(span, attribute.Int("retry.attempt", i+1)) {
+			t.Fatalf("expected attempt %d attribute, got %v", i+1, span.Attributes())
+		}
+		if !hasAttribute(span, attribute.String("breaker.state", "closed")) {
+			t.Fatalf("expected breaker state attribute, got %v", span.Attributes())
+		}
+	}
+
+	if n := len(byName["upstream GET"]); n != 2 {
+		t.Fatalf("expected 2 upstream call spans, got %d", n)
+	}
+}
+
+func hasAttribute(span sdktrace.ReadOnlySpan, want attribute.KeyValue) bool {
+	for _, kv := range span.Attributes() {
+		if kv == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), TracingConfig{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer shutdown(context.Background())
+
+	var traceparent, tracestate string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		traceparent, tracestate = req.Header.Get("traceparent"), req.Header.Get("tracestate")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	target, _ := url.Parse("http://upstream.invalid")
+	b := newBreaker(BreakerConfig{Name: "Propagation Test", Timeout: time.Minute}, RetryConfig{Attempts: 1})
+	api := traceHandler(http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: target}, nil)))
+
+	// The inbound trace is continued, keeping its sampling decision
+	inbound := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("traceparent", inbound)
+	req.Header.Set("tracestate", "vendor=value")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[1] != "4bf92f3577b34da6a3ce929d0e0e4736" || parts[2] == "00f067aa0ba902b7" || parts[3] != "01" {
+		t.Fatalf("expected the inbound trace to continue in a new span, got %q", traceparent)
+	}
+	if tracestate != "vendor=value" {
+		t.Fatalf("expected tracestate to be forwarded, got %q", tracestate)
+	}
+
+	// A trace is started when the request has none
+	traceparent = ""
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+	parts = strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[1] == "4bf92f3577b34da6a3ce929d0e0e4736" || parts[1] == strings.Repeat("0", 32) {
+		t.Fatalf("expected a new trace context, got %q", traceparent)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// UpstreamProtocol selects the HTTP version upstream calls are made with.
+type UpstreamProtocol string
+
+const (
+	// ProtocolAuto negotiates HTTP/2 over TLS and uses HTTP/1.1 otherwise.
+	ProtocolAuto UpstreamProtocol = "auto"
+	// ProtocolHTTP1 always uses HTTP/1.1.
+	ProtocolHTTP1 UpstreamProtocol = "http1"
+	// ProtocolH2C also speaks HTTP/2 to upstreams over plain http, without
+	// negotiation, for internal services supporting cleartext HTTP/2.
+	ProtocolH2C UpstreamProtocol = "h2c"
+)
+
+func (p *UpstreamProtocol) UnmarshalText(text []byte) error {
+	switch v := UpstreamProtocol(text); v {
+	case ProtocolAuto, ProtocolHTTP1, ProtocolH2C:
+		*p = v
+		return nil
+	default:
+		return fmt.Errorf("unknown upstream protocol %q", text)
+	}
+}
+
+// newUpstreamTransport returns the transport upstream calls are sent with,
+// configured as described by cfg.
+func newUpstreamTransport(cfg Config) (*http.Transport, error) {
+	tlsConfig, err := cfg.UpstreamTLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := cfg.Transport
+	dialer := &net.Dialer{Timeout: t.DialTimeout, KeepAlive: t.KeepAlive}
+	var dns *dnsCache
+	if cfg.DNS.TTL > 0 {
+		dns = newDNSCache(cfg.DNS)
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := socketPath(addr); ok {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		if dns != nil {
+			return dns.dial(ctx, network, addr, dialer.DialContext)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	transport := &http.Transport{
+		Proxy:                 proxyFromEnvironment,
+		DialContext:           dial,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   t.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+		ExpectContinueTimeout: t.ExpectContinueTimeout,
+		MaxIdleConns:          t.MaxIdleConns,
+		MaxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       t.MaxConnsPerHost,
+		IdleConnTimeout:       t.IdleConnTimeout,
+		ForceAttemptHTTP2:     t.Protocol != ProtocolHTTP1,
+	}
+	switch t.Protocol {
+	case ProtocolHTTP1:
+		// A non-nil empty map turns HTTP/2 off
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case ProtocolH2C:
+		transport.RegisterProtocol("http", &http2.Transport{
+			AllowHTTP: true,
+			// Dial plain TCP where HTTP/2 would otherwise require TLS
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+			ReadIdleTimeout: t.KeepAlive,
+		})
+	}
+	return transport, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestUpstreamMutualTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, _ := os.ReadFile(certFile)
+	clients := x509.NewCertPool()
+	clients.AppendCertsFromPEM(pem)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	}
+	server.StartTLS()
+	defer server.Close()
+	// The certificate names 127.0.0.1 only, so localhost needs the override
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name string
+		cfg  UpstreamTLSConfig
+		ok   bool
+	}{
+		{"mutual TLS", UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: certFile, ServerName: "127.0.0.1"}, true},
+		{"no client certificate", UpstreamTLSConfig{CAFile: certFile, ServerName: "127.0.0.1"}, false},
+		{"no server name override", UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: certFile}, false},
+		{"system CAs", UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile, ServerName: "127.0.0.1"}, false},
+	}
+	for _, tt := range tests {
+		transport, err := newUpstreamTransport(Config{UpstreamTLS: tt.cfg})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		resp, err := transport.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected success %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+
+	if _, err := newUpstreamTransport(Config{UpstreamTLS: UpstreamTLSConfig{CAFile: keyFile}}); err == nil {
+		t.Error("expected an error for a CA bundle without certificates")
+	}
+}
+
+func TestUpstreamTransportSettings(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Transport.MaxConnsPerHost = 8
+	cfg.Transport.ResponseHeaderTimeout = 5 * time.Second
+	transport, err := newUpstreamTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.MaxConnsPerHost != 8 || transport.ResponseHeaderTimeout != 5*time.Second ||
+		transport.MaxIdleConns != 100 || transport.TLSHandshakeTimeout != 10*time.Second {
+		t.Fatalf("unexpected transport settings %+v", transport)
+	}
+
+	// Headers slower than the timeout fail the call
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	cfg.Transport.ResponseHeaderTimeout = 20 * time.Millisecond
+	transport, _ = newUpstreamTransport(cfg)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("expected the call to time out waiting for headers")
+	}
+}
+
+func TestUpstreamProtocol(t *testing.T) {
+	var p UpstreamProtocol
+	if err := p.UnmarshalText([]byte("h2c")); err != nil || p != ProtocolH2C {
+		t.Fatalf("expected h2c, got %q, %v", p, err)
+	}
+	if err := p.UnmarshalText([]byte("spdy")); err == nil {
+		t.Fatal("expected an error for an unknown protocol")
+	}
+
+	proto := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Proto)) }
+	cleartext := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(proto), &http2.Server{}))
+	defer cleartext.Close()
+	encrypted := httptest.NewUnstartedServer(http.HandlerFunc(proto))
+	encrypted.EnableHTTP2 = true
+	encrypted.StartTLS()
+	defer encrypted.Close()
+
+	tests := []struct {
+		protocol UpstreamProtocol
+		url      string
+		want     string
+	}{
+		{ProtocolAuto, cleartext.URL, "HTTP/1.1"},
+		{ProtocolAuto, encrypted.URL, "HTTP/2.0"},
+		{ProtocolHTTP1, encrypted.URL, "HTTP/1.1"},
+		{ProtocolH2C, cleartext.URL, "HTTP/2.0"},
+		{ProtocolH2C, encrypted.URL, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.Transport.Protocol = tt.protocol
+		transport, err := newUpstreamTransport(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s to %s: %v", tt.protocol, tt.url, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("%s to %s: expected %s, got %s", tt.protocol, tt.url, tt.want, body)
+		}
+	}
+}
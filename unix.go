@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// socketHostSuffix ends the host names standing for Unix sockets.
+const socketHostSuffix = ".sock"
+
+// parseUpstream parses the URL of an upstream. An upstream at
+// unix:///path/to.sock is an HTTP server listening on that Unix socket: its
+// calls are addressed over http to a host naming the socket, which the
+// upstream transport dials instead of a TCP address.
+func parseUpstream(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "unix" {
+		return u, err
+	}
+	if u.Path == "" {
+		return nil, errors.New("missing socket path in " + raw)
+	}
+	return &url.URL{Scheme: "http", Host: hex.EncodeToString([]byte(u.Path)) + socketHostSuffix}, nil
+}
+
+// socketPath returns the path of the Unix socket named by the host of addr,
+// if it names one.
+func socketPath(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	encoded, ok := strings.CutSuffix(host, socketHostSuffix)
+	if !ok {
+		return "", false
+	}
+	path, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(path), true
+}
+
+// upstreamName returns the name of the upstream at target used in breaker
+// names: its host, or the path of its socket.
+func upstreamName(target *url.URL) string {
+	if path, ok := socketPath(target.Host); ok {
+		return "unix:" + path
+	}
+	return target.Host
+}
+
+// proxyFromEnvironment is http.ProxyFromEnvironment, except that calls to
+// Unix sockets are never proxied.
+func proxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	if _, ok := socketPath(req.URL.Host); ok {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketUpstream(t *testing.T) {
+	// Socket paths are limited in length, so keep it short
+	dir, err := os.MkdirTemp("", "cb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})}
+	go server.Serve(ln)
+	defer server.Close()
+
+	target, err := parseUpstream("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := socketPath(target.Host); !ok || got != path {
+		t.Fatalf("expected the host to name the socket, got %q, %v", got, ok)
+	}
+	if name := upstreamBreaker(BreakerConfig{Name: "API"}, target).Name; name != "API unix:"+path {
+		t.Fatalf("expected the breaker to be named after the socket, got %q", name)
+	}
+
+	transport, err := newUpstreamTransport(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, target.JoinPath("/hello").String(), nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected the call to reach the socket, got %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "/hello" {
+		t.Fatalf("expected /hello, got %q", body)
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	if _, err := parseUpstream("unix://"); err == nil {
+		t.Error("expected an error without a socket path")
+	}
+	target, err := parseUpstream("https://example.com/api")
+	if err != nil || target.Host != "example.com" || target.Path != "/api" {
+		t.Errorf("expected http URLs to be kept, got %v, %v", target, err)
+	}
+	if _, ok := socketPath("example.com:443"); ok {
+		t.Error("expected TCP hosts not to name a socket")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sony/gobreaker"
+)
+
+// upstream is an upstream service guarded by its own breaker.
+type upstream struct {
+	target *url.URL
+	b      *breaker
+}
+
+// Balance names how calls are spread over the upstreams in the config.
+type Balance string
+
+const (
+	// BalanceFailover sends every call to the first upstream whose breaker
+	// is not open.
+	BalanceFailover Balance = "failover"
+	// BalanceRoundRobin spreads calls evenly over the upstreams whose
+	// breakers are not open.
+	BalanceRoundRobin Balance = "round_robin"
+)
+
+// UnmarshalText rejects unknown balance names.
+func (s *Balance) UnmarshalText(text []byte) error {
+	switch balance := Balance(text); balance {
+	case BalanceFailover, BalanceRoundRobin:
+		*s = balance
+		return nil
+	default:
+		return fmt.Errorf("unknown balance %q", text)
+	}
+}
+
+// upstreamBreaker returns the settings of the breaker guarding a further
+// upstream: those of cfg under a name identifying the upstream.
+func upstreamBreaker(cfg BreakerConfig, target *url.URL) BreakerConfig {
+	cfg.Name += " " + upstreamName(target)
+	return cfg
+}
+
+// isOpen reports whether b currently rejects every call.
+func (b *breaker) isOpen() bool {
+	if override(b.override.Load()) == overrideOpen {
+		return true
+	}
+	p := b.current()
+	switch p.cb.State() {
+	case gobreaker.StateOpen:
+		return true
+	case gobreaker.StateHalfOpen:
+		return p.cfg.ProbePath != ""
+	default:
+		return false
+	}
+}
+
+// route picks the upstream the next call goes to, returning its index, its
+// breaker and the target to move the call to, or nil to keep the call on
+// t.target. Upstreams whose breaker is open are skipped, and so are ejected
+// outliers and unhealthy upstreams unless nothing else is left. When every breaker is open the
+// primary rejects the call. With failover the primary is preferred, so
+// traffic returns to it as soon as it recovers.
+func (t *breakerTransport) route() (int, *breaker, *url.URL) {
+	n := len(t.backups) + 1
+	start := 0
+	if t.balance == BalanceRoundRobin {
+		start = int(t.next.Add(1) % uint64(n))
+	}
+	for _, lastResort := range []bool{false, true} {
+		for i := range n {
+			upstream := (start + i) % n
+			b, to := t.upstream(upstream)
+			if b.isOpen() || !lastResort && t.avoid(upstream) {
+				continue
+			}
+			return upstream, b, to
+		}
+	}
+	return 0, t.b, nil
+}
+
+// avoid reports whether the upstream at index i should only be used as a
+// last resort.
+func (t *breakerTransport) avoid(i int) bool {
+	if t.outliers.ejected(i) {
+		return true
+	}
+	return i < len(t.health) && !t.health[i].isHealthy()
+}
+
+// upstream returns the breaker and target of the i-th upstream, counting
+// the primary as 0 with a nil target.
+func (t *breakerTransport) upstream(i int) (*breaker, *url.URL) {
+	if i == 0 {
+		return t.b, nil
+	}
+	u := t.backups[i-1]
+	return u.b, u.target
+}
+
+// retarget moves req, addressed to an upstream at from, to the same path
+// below to. It copies req's URL rather than modifying it.
+func retarget(req *http.Request, from, to *url.URL) {
+	u := *req.URL
+	rel := strings.TrimPrefix(u.Path, from.Path)
+	if rel != "" && !strings.HasPrefix(rel, "/") {
+		rel = "/" + rel
+	}
+	u.Scheme = to.Scheme
+	u.Host = to.Host
+	u.Path = strings.TrimSuffix(to.Path, "/") + rel
+	if rel == "" {
+		u.Path = to.Path
+	}
+	u.RawPath = ""
+	req.URL = &u
+	req.Host = ""
+}
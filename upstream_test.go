@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	fake := useFakeClock(t)
+	primary, _ := url.Parse("http://primary.invalid/api")
+	backup, _ := url.Parse("http://backup.invalid/v2/")
+	cfg := BreakerConfig{Name: "Failover Test", Timeout: 50 * time.Millisecond, ConsecutiveFailures: 0, RejectStatus: http.StatusTooManyRequests}
+	retry := RetryConfig{Attempts: 2, Backoff: BackoffConstant}
+	b := newBreaker(cfg, retry)
+	u := &upstream{target: backup, b: newBreaker(upstreamBreaker(cfg, backup), retry)}
+	api := http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: primary, backups: []*upstream{u}}, nil))
+
+	primaryUp := false
+	var urls []string
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		urls = append(urls, req.URL.String())
+		if req.URL.Host == "primary.invalid" && !primaryUp {
+			return nil, errors.New("simulated failure")
+		}
+		return httptest.NewRecorder().Result(), nil
+	}
+	get := func() int {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users?id=1", nil))
+		return rec.Code
+	}
+
+	// The primary trips on the first attempt and the retry fails over
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("expected the backup to answer, got %d", code)
+	}
+	if want := []string{"http://primary.invalid/api/users?id=1", "http://backup.invalid/v2/users?id=1"}; len(urls) != 2 || urls[0] != want[0] || urls[1] != want[1] {
+		t.Fatalf("expected calls to %v, got %v", want, urls)
+	}
+
+	urls = nil
+	get()
+	if len(urls) != 1 || urls[0] != "http://backup.invalid/v2/users?id=1" {
+		t.Fatalf("expected traffic to stay on the backup while the primary is open, got %v", urls)
+	}
+
+	// Once the primary's open timeout ends traffic returns to it
+	primaryUp = true
+	fake.Advance(50 * time.Millisecond)
+	urls = nil
+	get()
+	if len(urls) != 1 || urls[0] != "http://primary.invalid/api/users?id=1" {
+		t.Fatalf("expected traffic to fail back to the primary, got %v", urls)
+	}
+
+	// With every breaker open the primary rejects the request
+	b.forceOpen()
+	u.b.forceOpen()
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the request to be rejected, got %d", code)
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, in, want string
+	}{
+		{"http://a/api", "https://b:8443/v2", "http://a/api/users", "https://b:8443/v2/users"},
+		{"http://a/api", "http://b/v2/", "http://a/api", "http://b/v2/"},
+		{"http://a/", "http://b", "http://a/users?page=2", "http://b/users?page=2"},
+	} {
+		from, _ := url.Parse(tc.from)
+		to, _ := url.Parse(tc.to)
+		req := httptest.NewRequest(http.MethodGet, tc.in, nil)
+		orig := req.URL.String()
+		out := req.WithContext(req.Context())
+		retarget(out, from, to)
+		if got := out.URL.String(); got != tc.want {
+			t.Fatalf("expected %s to move to %s, got %s", tc.in, tc.want, got)
+		}
+		if req.URL.String() != orig {
+			t.Fatalf("expected the original request to be left alone, got %s", req.URL)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	primary, _ := url.Parse("http://a.invalid/")
+	cfg := BreakerConfig{Name: "Balance Test", Timeout: time.Minute, ConsecutiveFailures: 0, RejectStatus: http.StatusTooManyRequests}
+	retry := RetryConfig{Attempts: 1}
+	b := newBreaker(cfg, retry)
+	var backups []*upstream
+	for _, raw := range []string{"http://b.invalid/", "http://c.invalid/"} {
+		target, _ := url.Parse(raw)
+		backups = append(backups, &upstream{target: target, b: newBreaker(upstreamBreaker(cfg, target), retry)})
+	}
+	api := http.StripPrefix("/api", newProxy(&breakerTransport{b: b, target: primary, backups: backups, balance: BalanceRoundRobin}, nil))
+
+	hosts := map[string]int{}
+	callExternalAPI = func(req *http.Request) (*http.Response, error) {
+		hosts[req.URL.Host]++
+		if req.URL.Host == "c.invalid" {
+			return nil, errors.New("simulated failure")
+		}
+		return httptest.NewRecorder().Result(), nil
+	}
+	for i := 0; i < 9; i++ {
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+	}
+
+	// c trips on its first call and is skipped from then on
+	if hosts["c.invalid"] != 1 {
+		t.Fatalf("expected the failing upstream to get one call, got %d", hosts["c.invalid"])
+	}
+	if hosts["a.invalid"] < 3 || hosts["b.invalid"] < 3 {
+		t.Fatalf("expected the rest to be spread over both, got %v", hosts)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validate checks the settings of cfg make sense together, naming every
+// offending setting by its path in the config file, such as
+// retry.backoff_max, rather than running with nonsense values.
+func (cfg Config) validate() error {
+	var errs []error
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	// Durations are never negative, wherever they are, including the
+	// breaker settings of routes and the shadow, which are checked like
+	// those of the top-level breaker.
+	negativeDurations(reflect.ValueOf(cfg), "", fail)
+	checkBreaker(cfg.Breaker, "breaker", fail)
+	for i, route := range cfg.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		if err := checkUpstream(route.Upstream); err != nil {
+			fail(path+".upstream", "%q: %v", route.Upstream, err)
+		}
+		b, err := cfg.routeBreaker(route)
+		if err != nil {
+			fail(path+".breaker", "%v", err)
+			continue
+		}
+		negativeDurations(reflect.ValueOf(b), path+".breaker", fail)
+		checkBreaker(b, path+".breaker", fail)
+	}
+	if cfg.Shadow.enabled() {
+		if b, err := cfg.Shadow.settings(cfg.Breaker); err != nil {
+			fail("shadow.breaker", "%v", err)
+		} else {
+			negativeDurations(reflect.ValueOf(b), "shadow.breaker", fail)
+			checkBreaker(b, "shadow.breaker", fail)
+		}
+	}
+	if code := cfg.Proxy.FallbackStatus; code != 0 {
+		checkStatus(code, "proxy.fallback_status", fail)
+	}
+
+	if cfg.ListenAddr == "" {
+		fail("listen_addr", "must be set")
+	} else if err := checkListenAddr(cfg.ListenAddr); err != nil {
+		fail("listen_addr", "%q: %v", cfg.ListenAddr, err)
+	}
+	for _, a := range []struct{ field, addr string }{{"admin_addr", cfg.AdminAddr}, {"grpc_addr", cfg.GRPCAddr}} {
+		if a.addr == "" {
+			continue
+		}
+		if err := checkListenAddr(a.addr); err != nil {
+			fail(a.field, "%q: %v", a.addr, err)
+		}
+	}
+
+	if err := checkUpstream(cfg.Upstream); err != nil {
+		fail("upstream", "%q: %v", cfg.Upstream, err)
+	}
+	for i, raw := range cfg.Upstreams {
+		if err := checkUpstream(raw); err != nil {
+			fail(fmt.Sprintf("upstreams[%d]", i), "%q: %v", raw, err)
+		}
+	}
+
+	if rl := cfg.RateLimit; rl.Rate < 0 {
+		fail("rate_limit.rate", "must not be negative, got %v", rl.Rate)
+	} else if rl.Rate > 0 && rl.Burst < 1 {
+		fail("rate_limit.burst", "must be at least 1 for requests to pass the limit, got %d", rl.Burst)
+	}
+	if cfg.RateLimit.TrustedProxies < 0 {
+		fail("rate_limit.trusted_proxies", "must not be negative, got %d", cfg.RateLimit.TrustedProxies)
+	}
+
+	if tc := cfg.Tenant; tc.Header != "" && (tc.MaxTenants < 1 || tc.MaxTenants > maxTenants) {
+		fail("tenant.max_tenants", "must be between 1 and %d, got %d", maxTenants, tc.MaxTenants)
+	}
+
+	r := cfg.Retry
+	if r.Attempts < 1 {
+		fail("retry.attempts", "must be at least 1, counting the first call, got %d", r.Attempts)
+	}
+	if r.Backoff != BackoffConstant && r.BackoffMax < r.BackoffMin {
+		fail("retry.backoff_max", "(%v) must not be below retry.backoff_min (%v)", r.BackoffMax, r.BackoffMin)
+	}
+	if r.JitterFloor < 0 || r.JitterFloor > 1 {
+		fail("retry.jitter_floor", "must be between 0 and 1, got %v", r.JitterFloor)
+	}
+	if r.BudgetRatio < 0 || r.BudgetMinPerSecond < 0 {
+		fail("retry.budget_ratio", "and retry.budget_min_per_second must not be negative")
+	}
+	if r.HedgePercentile <= 0 || r.HedgePercentile >= 1 {
+		fail("retry.hedge_percentile", "must be between 0 and 1, got %v", r.HedgePercentile)
+	}
+	if r.MaxBufferedBody < 0 {
+		fail("retry.max_buffered_body", "must not be negative, got %d", r.MaxBufferedBody)
+	}
+	return errors.Join(errs...)
+}
+
+// checkBreaker reports the settings of b, the breaker at path, out of
+// range. Timeouts are at least a millisecond, the resolution of the open
+// state shared through Redis, which a zero timeout would never let expire.
+func checkBreaker(b BreakerConfig, path string, fail func(field, format string, args ...interface{})) {
+	if b.Timeout >= 0 && b.Timeout < time.Millisecond {
+		fail(path+".timeout", "must be at least 1ms, got %v", b.Timeout)
+	}
+	if b.FailureRatio < 0 || b.FailureRatio > 1 {
+		fail(path+".failure_ratio", "must be between 0 and 1, got %v", b.FailureRatio)
+	}
+	if b.PartialOpen < 0 || b.PartialOpen > 1 {
+		fail(path+".partial_open", "must be between 0 and 1, got %v", b.PartialOpen)
+	}
+	if b.SLOTarget < 0 || b.SLOTarget >= 1 {
+		fail(path+".slo_target", "must be at least 0 and below 1, got %v", b.SLOTarget)
+	}
+	checkStatus(b.RejectStatus, path+".reject_status", fail)
+	checkStatus(b.BulkheadStatus, path+".bulkhead_status", fail)
+	if b.MaxConcurrent < 0 {
+		fail(path+".max_concurrent", "must not be negative, got %d", b.MaxConcurrent)
+	}
+	if b.HalfOpenQueue < 0 {
+		fail(path+".half_open_queue", "must not be negative, got %d", b.HalfOpenQueue)
+	}
+	if b.ConcurrencyMin > b.ConcurrencyMax {
+		fail(path+".concurrency_min", "(%d) must not be above %s.concurrency_max (%d)", b.ConcurrencyMin, path, b.ConcurrencyMax)
+	}
+}
+
+// checkStatus reports code, the setting at path, unless it is an HTTP
+// status code a response can be written with.
+func checkStatus(code int, path string, fail func(field, format string, args ...interface{})) {
+	if code < 100 || code > 599 {
+		fail(path, "must be a status code from 100 to 599, got %d", code)
+	}
+}
+
+// negativeDurations reports every negative duration of v, a struct, slice
+// or field of the config at path.
+func negativeDurations(v reflect.Value, path string, fail func(field, format string, args ...interface{})) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(yaml.Node{}) {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			field := tag
+			if path != "" {
+				field = path + "." + tag
+			}
+			negativeDurations(v.Field(i), field, fail)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			negativeDurations(v.Index(i), path+"["+strconv.Itoa(i)+"]", fail)
+		}
+	case reflect.Int64:
+		if d, ok := v.Interface().(time.Duration); ok && d < 0 {
+			fail(path, "must not be negative, got %v", d)
+		}
+	}
+}
+
+// checkListenAddr checks addr is a host and port. Whether the service can
+// listen on the host is left to listening to tell, as the config may be
+// checked elsewhere than where it runs.
+func checkListenAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// checkUpstream checks raw is a URL calls can be proxied to.
+func checkUpstream(raw string) error {
+	if raw == "" {
+		return errors.New("must be set")
+	}
+	u, err := parseUpstream(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http, https or unix, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
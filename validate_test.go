@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	if err := defaultConfig().validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+	if _, err := loadConfig("config.example.yaml"); err != nil {
+		t.Fatalf("expected the example config to be valid, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		edit  func(*Config)
+		field string
+	}{
+		{"NegativeTimeout", func(c *Config) { c.Breaker.Timeout = -time.Second }, "breaker.timeout must not be negative"},
+		{"ZeroTimeout", func(c *Config) { c.Breaker.Timeout = 0 }, "breaker.timeout must be at least 1ms"},
+		{"ZeroRouteTimeout", func(c *Config) {
+			c.Routes = []RouteConfig{{Path: "/users", Upstream: "http://users.invalid"}}
+			c.Routes[0].Breaker.Encode(map[string]string{"timeout": "0s"})
+		}, "routes[0].breaker.timeout"},
+		{"NegativeRouteDuration", func(c *Config) {
+			c.Routes = []RouteConfig{{Path: "/users", Upstream: "http://users.invalid"}}
+			c.Routes[0].Breaker.Encode(map[string]string{"timeout": "-1s"})
+		}, "routes[0].breaker.timeout"},
+		{"ZeroAttempts", func(c *Config) { c.Retry.Attempts = 0 }, "retry.attempts"},
+		{"BackoffMaxBelowMin", func(c *Config) { c.Retry.BackoffMax = c.Retry.BackoffMin / 2 }, "retry.backoff_max"},
+		{"FailureRatio", func(c *Config) { c.Breaker.FailureRatio = 1.5 }, "breaker.failure_ratio"},
+		{"JitterFloor", func(c *Config) { c.Retry.JitterFloor = -1 }, "retry.jitter_floor"},
+		{"NoListenAddr", func(c *Config) { c.ListenAddr = "" }, "listen_addr must be set"},
+		{"InvalidPort", func(c *Config) { c.ListenAddr = ":99999" }, "listen_addr"},
+		{"ZeroBurst", func(c *Config) { c.RateLimit.Rate, c.RateLimit.Burst = 10, 0 }, "rate_limit.burst"},
+		{"NegativeTrustedProxies", func(c *Config) { c.RateLimit.TrustedProxies = -1 }, "rate_limit.trusted_proxies"},
+		{"MaxTenants", func(c *Config) { c.Tenant.Header, c.Tenant.MaxTenants = "X-Tenant", 0 }, "tenant.max_tenants"},
+		{"RejectStatus", func(c *Config) { c.Breaker.RejectStatus = 42 }, "breaker.reject_status"},
+		{"BulkheadStatus", func(c *Config) { c.Breaker.BulkheadStatus = 0 }, "breaker.bulkhead_status"},
+		{"FallbackStatus", func(c *Config) { c.Proxy.FallbackStatus = 7 }, "proxy.fallback_status"},
+		{"NegativeMaxConcurrent", func(c *Config) { c.Breaker.MaxConcurrent = -1 }, "breaker.max_concurrent"},
+		{"NegativeHalfOpenQueue", func(c *Config) { c.Breaker.HalfOpenQueue = -1 }, "breaker.half_open_queue"},
+		{"ConcurrencyBounds", func(c *Config) { c.Breaker.ConcurrencyMin, c.Breaker.ConcurrencyMax = 10, 5 }, "breaker.concurrency_min"},
+		{"RouteUpstreamScheme", func(c *Config) {
+			c.Routes = []RouteConfig{{Path: "/users", Upstream: "ftp://users.invalid"}}
+		}, "routes[0].upstream"},
+		{"RouteBreaker", func(c *Config) {
+			c.Routes = []RouteConfig{{Path: "/users", Upstream: "http://users.invalid"}}
+			c.Routes[0].Breaker.Encode(map[string]string{"timeout": "soon"})
+		}, "routes[0].breaker"},
+		{"RouteUnknownKey", func(c *Config) {
+			c.Routes = []RouteConfig{{Path: "/users", Upstream: "http://users.invalid"}}
+			c.Routes[0].Breaker.Encode(map[string]string{"timout": "1s"})
+		}, "timout"},
+		{"ShadowUnknownKey", func(c *Config) { c.Shadow.Breaker.Encode(map[string]int{"consecutive_failure": 3}) }, "consecutive_failure"},
+		{"RouteRejectStatus", func(c *Config) {
+			c.Routes = []RouteConfig{{Path: "/users", Upstream: "http://users.invalid"}}
+			c.Routes[0].Breaker.Encode(map[string]int{"reject_status": 1000})
+		}, "routes[0].breaker.reject_status"},
+		{"UpstreamScheme", func(c *Config) { c.Upstream = "ftp://example.com" }, "upstream"},
+		{"BackupUpstream", func(c *Config) { c.Upstreams = []string{"http://"} }, "upstreams[0]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.edit(&cfg)
+			err := cfg.validate()
+			if err == nil || !strings.Contains(err.Error(), tc.field) {
+				t.Fatalf("expected an error naming %s, got %v", tc.field, err)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	cfg := defaultConfig()
+	cfg.Retry.Attempts = 0
+	cfg.Breaker.Interval = -time.Second
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "retry.attempts") || !strings.Contains(err.Error(), "breaker.interval") {
+		t.Fatalf("expected both problems to be reported, got %v", err)
+	}
+
+	for _, addr := range []string{":8111", "127.0.0.1:0", "localhost:http", "[::]:8111"} {
+		if err := checkListenAddr(addr); err != nil {
+			t.Fatalf("expected %s to be a valid listen address, got %v", addr, err)
+		}
+	}
+}
+
+func TestConfigUnknownFields(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "retry:\n  atempts: 3\n")
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "atempts") {
+		t.Fatalf("expected the misspelled setting to be named, got %v", err)
+	}
+
+	cfg := defaultConfig()
+	if err := applyTuning([]byte("breaker:\n  consecutive_failure: 3\n"), "tuning", &cfg); err == nil || !strings.Contains(err.Error(), "consecutive_failure") {
+		t.Fatalf("expected the misspelled tuning setting to be named, got %v", err)
+	}
+}
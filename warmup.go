@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// warmUp opens cfg.Connections connections to each of targets by sending
+// as many requests to each at once, bypassing the breakers, and leaves them
+// idle in the transport's pool, so that the first calls do not pay for TCP
+// and TLS handshakes, nor skew the latencies breakers trip on. It returns
+// once every request is answered or has failed.
+func warmUp(ctx context.Context, cfg WarmUpConfig, targets []*url.URL) {
+	if cfg.Connections <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	var opened atomic.Int64
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		for i := 0; i < cfg.Connections; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.JoinPath(cfg.Path).String(), nil)
+				if err != nil {
+					return
+				}
+				resp, err := callExternalAPI(req)
+				if err != nil {
+					slog.Debug("Warm-up request failed", "upstream", target.String(), "error", err)
+					return
+				}
+				// Read to the end, so the connection goes back to the pool
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				opened.Add(1)
+			}()
+		}
+	}
+	wg.Wait()
+	slog.Info("Warmed up upstream connections", "upstreams", len(targets), "connections", opened.Load(), "wanted", len(targets)*cfg.Connections)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmUp(t *testing.T) {
+	var requests atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			requests.Add(1)
+			<-release
+		}
+	}))
+	var dials atomic.Int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dials.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	transport := &http.Transport{MaxIdleConnsPerHost: 3}
+	callExternalAPI = transport.RoundTrip
+	defer transport.CloseIdleConnections()
+
+	target, _ := url.Parse(server.URL)
+	go func() {
+		// Hold the requests until all are in flight, each on its own
+		// connection
+		for requests.Load() < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+	warmUp(context.Background(), WarmUpConfig{Connections: 3, Path: "/ping", Timeout: 2 * time.Second}, []*url.URL{target})
+	if n := dials.Load(); n != 3 {
+		t.Fatalf("expected 3 connections to be opened, got %d", n)
+	}
+
+	// The calls that follow reuse them
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := callExternalAPI(req)
+		if err != nil {
+			t.Fatalf("failed to call upstream: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if n := dials.Load(); n != 3 {
+		t.Fatalf("expected calls to reuse the warm connections, got %d connections", n)
+	}
+}
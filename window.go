@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// rollingWindow counts call outcomes and latencies over the last few
+// seconds in a ring of per-second buckets, so that decisions based on it
+// reflect recent calls only.
+type rollingWindow struct {
+	mu      sync.Mutex
+	buckets []windowBucket
+}
+
+// windowBucket holds the calls that finished within one second.
+type windowBucket struct {
+	second              int64
+	successes, failures uint32
+	latency             time.Duration
+}
+
+// windowStats sums the buckets of a rollingWindow.
+type windowStats struct {
+	Successes uint32        `json:"successes"`
+	Failures  uint32        `json:"failures"`
+	Latency   time.Duration `json:"-"`
+}
+
+// newRollingWindow returns a window over size, rounded up to whole
+// seconds, or nil when size is not positive.
+func newRollingWindow(size time.Duration) *rollingWindow {
+	if size <= 0 {
+		return nil
+	}
+	seconds := int((size + time.Second - 1) / time.Second)
+	return &rollingWindow{buckets: make([]windowBucket, seconds)}
+}
+
+// record counts a call that finished now after latency.
+func (w *rollingWindow) record(success bool, latency time.Duration) {
+	now := clock.Now().Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	bucket := &w.buckets[now%int64(len(w.buckets))]
+	if bucket.second != now {
+		*bucket = windowBucket{second: now}
+	}
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+	bucket.latency += latency
+}
+
+// stats sums the calls of the buckets still within the window.
+func (w *rollingWindow) stats() windowStats {
+	oldest := clock.Now().Unix() - int64(len(w.buckets)) + 1
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var s windowStats
+	for _, bucket := range w.buckets {
+		if bucket.second >= oldest {
+			s.Successes += bucket.successes
+			s.Failures += bucket.failures
+			s.Latency += bucket.latency
+		}
+	}
+	return s
+}
+
+// reset forgets every call.
+func (w *rollingWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	clear(w.buckets)
+}
+
+// counts returns c with its totals replaced by those of the window.
+func (s windowStats) counts(c gobreaker.Counts) gobreaker.Counts {
+	c.Requests = s.Successes + s.Failures
+	c.TotalSuccesses = s.Successes
+	c.TotalFailures = s.Failures
+	return c
+}
+
+// meanLatency returns the average latency of the calls in the window.
+func (s windowStats) meanLatency() time.Duration {
+	if n := s.Successes + s.Failures; n > 0 {
+		return s.Latency / time.Duration(n)
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestRollingWindow(t *testing.T) {
+	if newRollingWindow(0) != nil {
+		t.Fatalf("expected no window for a zero size")
+	}
+	w := newRollingWindow(2500 * time.Millisecond)
+	if len(w.buckets) != 3 {
+		t.Fatalf("expected the size to round up to 3 buckets, got %d", len(w.buckets))
+	}
+
+	w.record(true, 10*time.Millisecond)
+	w.record(false, 30*time.Millisecond)
+	s := w.stats()
+	if s.Successes != 1 || s.Failures != 1 || s.meanLatency() != 20*time.Millisecond {
+		t.Fatalf("expected 1 success and 1 failure averaging 20ms, got %+v", s)
+	}
+
+	w.reset()
+	if s := w.stats(); s.Successes+s.Failures != 0 {
+		t.Fatalf("expected a reset window to be empty, got %+v", s)
+	}
+
+	// Calls older than the window no longer count
+	fake := useFakeClock(t)
+	for i := 0; i < 5; i++ {
+		w.record(false, 0)
+	}
+	fake.Advance(2 * time.Second)
+	w.record(false, 0)
+	w.record(false, 0)
+	if s := w.stats(); s.Failures != 7 {
+		t.Fatalf("expected every failure within the window to count, got %d", s.Failures)
+	}
+	fake.Advance(time.Second)
+	if s := w.stats(); s.Failures != 2 {
+		t.Fatalf("expected only recent failures to count, got %d", s.Failures)
+	}
+}
+
+func TestWindowedTrip(t *testing.T) {
+	b := newBreaker(BreakerConfig{
+		Name:         "Window Test",
+		Interval:     time.Hour,
+		Timeout:      time.Minute,
+		Trip:         TripFailureRatio,
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       30 * time.Second,
+	}, RetryConfig{})
+	succeed := func() (interface{}, error) { return nil, nil }
+	fail := func() (interface{}, error) { return nil, errors.New("simulated failure") }
+
+	// Old successes in gobreaker's counts do not dilute recent failures
+	for i := 0; i < 10; i++ {
+		b.execute(b.current(), succeed)
+	}
+	b.current().window.reset()
+	b.execute(b.current(), succeed)
+	for i := 0; i < 3; i++ {
+		b.execute(b.current(), fail)
+	}
+	if state := b.current().cb.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected 3 of the last 4 calls failing to trip the breaker, got %s", state)
+	}
+
+	status := b.status()
+	if status.Window == nil || status.Window.Failures != 3 || status.Window.FailureRatio != 0.75 {
+		t.Fatalf("expected the window in the status, got %+v", status.Window)
+	}
+}